@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// alerter raises an incident in an alerting service. The dedup key identifies the failing
+// upload, so that repeated alerts about it are grouped together.
+type alerter interface {
+	alert(dedupKey, summary, details string) error
+}
+
+var alertClient = &http.Client{Timeout: 30 * time.Second}
+
+func postAlert(url string, header http.Header, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Unexpected HTTP response status code: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// pagerDutyAlerter triggers PagerDuty incidents through the Events API v2.
+type pagerDutyAlerter struct {
+	routingKey string
+}
+
+func (p *pagerDutyAlerter) alert(dedupKey, summary, details string) error {
+	source, _ := os.Hostname()
+	return postAlert("https://events.pagerduty.com/v2/enqueue", http.Header{}, map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    dedupKey,
+		"payload": map[string]interface{}{
+			"summary":        summary,
+			"source":         source,
+			"severity":       "error",
+			"component":      "hydra-s3-upload",
+			"custom_details": details,
+		},
+	})
+}
+
+// opsgenieAlerter creates Opsgenie alerts through the Alert API.
+type opsgenieAlerter struct {
+	apiURL string
+	apiKey string
+}
+
+func (o *opsgenieAlerter) alert(dedupKey, summary, details string) error {
+	header := http.Header{}
+	header.Set("Authorization", "GenieKey "+o.apiKey)
+
+	return postAlert(strings.TrimSuffix(o.apiURL, "/")+"/v2/alerts", header, map[string]interface{}{
+		"message":     summary,
+		"alias":       dedupKey,
+		"description": details,
+		"source":      "hydra-s3-upload",
+		"priority":    "P2",
+	})
+}
+
+func addAlertFlags(flags *flag.FlagSet) *stringList {
+	providers := &stringList{}
+	flags.Var(providers, "alert", "Alert through pagerduty (PAGERDUTY_ROUTING_KEY) or opsgenie (OPSGENIE_API_KEY, OPSGENIE_API_URL) when automated uploads keep failing (repeatable)")
+
+	return providers
+}
+
+func newAlerters(providers []string) ([]alerter, error) {
+	var alerters []alerter
+	for _, provider := range providers {
+		switch provider {
+		case "pagerduty":
+			key := os.Getenv("PAGERDUTY_ROUTING_KEY")
+			if key == "" {
+				return nil, fmt.Errorf("PagerDuty alerts require PAGERDUTY_ROUTING_KEY")
+			}
+			alerters = append(alerters, &pagerDutyAlerter{routingKey: key})
+		case "opsgenie":
+			key := os.Getenv("OPSGENIE_API_KEY")
+			if key == "" {
+				return nil, fmt.Errorf("Opsgenie alerts require OPSGENIE_API_KEY")
+			}
+			apiURL := os.Getenv("OPSGENIE_API_URL")
+			if apiURL == "" {
+				apiURL = "https://api.opsgenie.com"
+			}
+			alerters = append(alerters, &opsgenieAlerter{apiURL: apiURL, apiKey: key})
+		default:
+			return nil, fmt.Errorf("Unsupported alerting provider -- %s", provider)
+		}
+	}
+
+	return alerters, nil
+}
+
+// raiseAlert fires the alert through every alerter, logging the ones that fail.
+func raiseAlert(alerters []alerter, dedupKey, summary, details string) {
+	for _, a := range alerters {
+		err := a.alert(dedupKey, summary, details)
+		if err != nil {
+			klog.Errorln("Unable to raise alert --", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"k8s.io/klog"
+)
+
+// errNoAmbientCredentials reports that neither a pod role nor an instance role is available.
+var errNoAmbientCredentials = errors.New("No ambient AWS credentials found (neither IRSA nor an EC2 instance role)")
+
+// imdsTimeout keeps the instance metadata probe short when not running on EC2.
+const imdsTimeout = 2 * time.Second
+
+// ambientSession returns a session using the role of the pod (IRSA) or, failing that,
+// of the EC2 instance. The credentials are retrieved once to validate them.
+func ambientSession(region string) (*session.Session, error) {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	base, err := session.NewSession(&aws.Config{
+		Region:       aws.String(region),
+		Credentials:  credentials.AnonymousCredentials,
+		UseDualStack: aws.Bool(useDualStack()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var creds *credentials.Credentials
+	tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN")
+	if tokenFile != "" && roleARN != "" {
+		klog.Infoln("Using the IRSA role of the pod --", roleARN)
+		creds = stscreds.NewWebIdentityCredentials(base, roleARN, "hydra-s3-upload", tokenFile)
+	} else {
+		metadata := ec2metadata.New(base, &aws.Config{
+			HTTPClient: &http.Client{Timeout: imdsTimeout},
+		})
+		if !metadata.Available() {
+			return nil, errNoAmbientCredentials
+		}
+
+		if region == "" {
+			region, err = metadata.Region()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		klog.Infoln("Using the EC2 instance role")
+		creds = ec2rolecreds.NewCredentialsWithClient(metadata)
+	}
+
+	_, err = creds.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	return base.Copy(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: creds,
+	}), nil
+}
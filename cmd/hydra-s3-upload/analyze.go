@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/klog"
+)
+
+// analyzeBarWidth is the width of the share bars in the analyze output.
+const analyzeBarWidth = 20
+
+// printSizeTree prints the groups below parent, largest first, descending into each of them
+// until the depth is reached.
+func printSizeTree(w io.Writer, entries []sizeEntry, parent string, level, depth, top int, total int64) {
+	var children []sizeEntry
+	for _, entry := range entries {
+		if parent == "" || strings.HasPrefix(entry.name, parent+"/") {
+			children = append(children, entry)
+		}
+	}
+
+	groups := groupSizes(children, level)
+	for i, g := range groups {
+		if i == top {
+			fmt.Fprintf(w, "%s... %d more\t\t\t\t\n", strings.Repeat("  ", level-1), len(groups)-top)
+			break
+		}
+
+		share := float64(g.compressed) / float64(total)
+		ratio := 1.0
+		if g.size > 0 {
+			ratio = float64(g.compressed) / float64(g.size)
+		}
+
+		// Files directly at this level have no children to descend into.
+		name := g.name
+		if parent != "" {
+			name = strings.TrimPrefix(g.name, parent+"/")
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%.0f%%\t%s\n", strings.Repeat("  ", level-1), name,
+			formatByteSize(g.size), formatByteSize(g.compressed), ratio*100,
+			strings.Repeat("#", int(share*analyzeBarWidth+0.5)))
+
+		if level < depth && g.files > 1 {
+			printSizeTree(w, children, g.name, level+1, depth, top, total)
+		}
+	}
+}
+
+func runAnalyze(args []string) {
+	var excludes stringList
+	flags := flag.NewFlagSet(commandName()+" analyze", flag.ExitOnError)
+	depth := flags.Int("depth", 2, "Number of directory levels to break down")
+	top := flags.Int("top", 10, "Number of largest entries shown per directory")
+	flags.Var(&excludes, "exclude", "Leave out the files matching this pattern, to try out exclude rules (repeatable)")
+	flags.Parse(args)
+
+	dir := "./must-gather/"
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	klog.Infoln("Estimating the compressed size of", dir, "...")
+	entries, err := scanSizes(dir, func(name string) bool { return excludedName(excludes, name) }, true)
+	if err != nil {
+		klog.Fatalln("Unable to analyze directory --", err)
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.size
+	}
+	compressed := totalCompressed(entries)
+	if compressed == 0 {
+		fmt.Println("No data to archive")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Directory:\t%s\nFiles:\t%d\nSize:\t%s\nEstimated archive:\t%s (%.0f%%)\n\n",
+		dir, len(entries), formatByteSize(size), formatByteSize(compressed), float64(compressed)*100/float64(size))
+	fmt.Fprintln(w, "PATH\tSIZE\tCOMPRESSED\tRATIO\tSHARE")
+	printSizeTree(w, entries, "", 1, *depth, *top, compressed)
+	w.Flush()
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+)
+
+// tarArchive is a compressed tar stream, or another archive format, that entries from several sources can be appended to.
+type tarArchive struct {
+	format archive.Format
+	// entries writes the entries into the compressed stream, and records them in the index of indexed archives.
+	entries *archive.Writer
+	// filters transform the contents of regular files before they are stored.
+	filters []fileFilter
+	// excludes are the patterns of the local files left out of the archive.
+	excludes []string
+	// requested selects the local files of a selective re-upload.
+	requested *requestedFiles
+	// audit records the archived and excluded files.
+	audit *auditLog
+	// files maps the names of the archived regular files to their SHA-256 checksums, for the embedded manifest
+	// of audited archives.
+	files map[string]string
+	// policy denies or leaves out entries of every source.
+	policy *exportPolicy
+	// portable warns about or renames the entries that cannot be extracted everywhere.
+	portable *portableNames
+	// preview cuts the files down to their first and last bytes.
+	preview *previewFiles
+}
+
+func newTarArchive(rawWriter io.Writer, format archive.Format, codec archive.Codec) (*tarArchive, error) {
+	// Create an entry writer compressing into the raw writer (most likely a file or a buffer).
+	entries, err := archive.NewWriter(rawWriter, format, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarArchive{format: format, entries: entries}, nil
+}
+
+// Close stores the manifests of the renamed, previewed and audited entries, finishes the archive and flushes the compressor.
+func (a *tarArchive) Close() error {
+	err := a.portable.writeManifest(a.entries)
+	if err == nil {
+		err = a.preview.writeManifest(a.entries)
+	}
+	if err == nil {
+		err = writeFilesManifest(a.entries, a.files)
+	}
+	if closeErr := a.entries.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// addDir appends the regular files, directories and symbolic links found in dirPath, naming them relative to dirPath under the given prefix.
+// The excluded and the not requested files are left out, and every entry goes through writeEntry.
+func (a *tarArchive) addDir(dirPath, prefix string) error {
+	return a.entries.AddDirWith(dirPath, prefix, archive.DirHooks{
+		// Skip excluded files and directories, and upload receipts.
+		Exclude: func(relPath string) (bool, error) {
+			if !excludedName(a.excludes, relPath) && !isReceiptFile(relPath) {
+				return false, nil
+			}
+			return true, a.audit.record(auditFileExcluded, map[string]string{"name": path.Join(prefix, relPath)})
+		},
+		// The directories not requested are still descended into.
+		Include: a.requested.includes,
+		Unsupported: func(fullPath string) {
+			klog.Warningf("Skipping %s, only regular files, directories and symbolic links are archived", fullPath)
+		},
+		WriteEntry: a.writeEntry,
+	})
+}
+
+// writeEntry writes the header and the body of an entry. The contents of regular files
+// pass through the filters first, adjusting the size in the header.
+func (a *tarArchive) writeEntry(header *tar.Header, body io.Reader) error {
+	switch action, reason := a.policy.verdict(header.Name, header.Size); action {
+	case policyDeny:
+		return fmt.Errorf("The export policy denies %s: %s", header.Name, reason)
+	case policyExclude:
+		klog.V(1).Infof("Leaving out %s by the export policy: %s", header.Name, reason)
+		return a.audit.record(auditFileExcluded, map[string]string{"name": header.Name, "reason": reason})
+	}
+	header.Name = a.portable.check(header)
+
+	action, originalSize := auditFileIncluded, header.Size
+	if header.Typeflag == tar.TypeReg && len(a.filters) > 0 {
+		action = auditFileRedacted
+		filtered, err := applyFilters(a.filters, header.Name, body)
+		if err != nil {
+			return fmt.Errorf("Unable to filter %s -- %w", header.Name, err)
+		}
+		defer filtered.Close()
+
+		header.Size, err = filtered.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+
+		_, err = filtered.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		body = filtered
+	}
+
+	body = a.preview.cut(header, body)
+	if header.Size != originalSize && action == auditFileIncluded {
+		action = auditFileRedacted
+	}
+
+	if a.audit == nil || header.Typeflag != tar.TypeReg {
+		return a.entries.WriteEntry(header, body)
+	}
+
+	hash := sha256.New()
+	err := a.entries.WriteEntry(header, io.TeeReader(body, hash))
+	if err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	a.files[header.Name] = sum
+	details := map[string]string{
+		"name":   header.Name,
+		"size":   strconv.FormatInt(header.Size, 10),
+		"sha256": sum,
+	}
+	if action == auditFileRedacted {
+		details["originalSize"] = strconv.FormatInt(originalSize, 10)
+	}
+
+	return a.audit.record(action, details)
+}
+
+// streamEntryName returns the name of a tar stream entry under the prefix, rejecting absolute names
+// and the names leaving the stream's tree.
+func streamEntryName(prefix, name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Refusing the tar stream entry %q outside of its tree", name)
+	}
+
+	return path.Join(prefix, clean), nil
+}
+
+// addTarStream copies every entry of an uncompressed tar stream into the archive under the given prefix.
+func (a *tarArchive) addTarStream(r io.Reader, prefix string) error {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Re-root the entry, keeping the trailing slash of directories. The stream comes from another host,
+		// so its entries must not land outside of the prefix when extracted.
+		name, err := streamEntryName(prefix, header.Name)
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname, err = streamEntryName(prefix, header.Linkname)
+			if err != nil {
+				return err
+			}
+		}
+		if header.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		header.Name = name
+
+		err = a.writeEntry(header, tarReader)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// addCommandOutput runs a command and stores its combined output as a file in the archive.
+// A failing command is recorded at the end of the output rather than aborting the archive.
+func (a *tarArchive) addCommandOutput(name, program string, args ...string) error {
+	// Buffer the output in a temporary file since the tar header needs its size up front.
+	tmp, err := ioutil.TempFile("", "hydra-s3-upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = tmp
+	cmd.Stderr = tmp
+	err = cmd.Run()
+	if err != nil {
+		fmt.Fprintf(tmp, "\n%s failed: %v\n", program, err)
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return a.writeEntry(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     0644,
+		ModTime:  info.ModTime(),
+	}, tmp)
+}
+
+// archiveSource is an additional input appended to the archive after the local directory.
+type archiveSource interface {
+	// name identifies the source in error messages.
+	name() string
+	writeTo(archive *tarArchive) error
+}
+
+// dirToTar writes the local Must-Gather directory followed by the additional sources into a single archive.
+// The local directory may be missing when additional sources are given, and is skipped when empty.
+func dirToTar(dirPath string, rawWriter io.Writer, opts *options) error {
+	var a *tarArchive
+	if opts.index != nil || opts.seekable() {
+		a = newIndexedTarArchive(rawWriter, opts.format, opts.codec, opts.index, opts.seekable())
+	} else {
+		var err error
+		a, err = newTarArchive(rawWriter, opts.format, opts.codec)
+		if err != nil {
+			return err
+		}
+	}
+	a.filters = opts.filters
+	a.excludes = opts.excludes
+	a.requested = opts.requested
+	a.audit = opts.audit
+	if a.audit != nil {
+		a.files = map[string]string{}
+	}
+	a.policy = opts.policy
+	a.portable = newPortableNames(opts.portableNames)
+	a.preview = newPreviewFiles(opts.preview)
+
+	_, err := os.Stat(dirPath)
+	if dirPath != "" && (err == nil || len(opts.sources) == 0) {
+		err = a.addDir(dirPath, "")
+		if err != nil {
+			a.Close()
+			return err
+		}
+		opts.requested.warnUnmatched()
+	}
+
+	for _, source := range opts.sources {
+		err = source.writeTo(a)
+		if err != nil {
+			a.Close()
+			return fmt.Errorf("Unable to collect %s -- %w", source.name(), err)
+		}
+	}
+
+	return a.Close()
+}
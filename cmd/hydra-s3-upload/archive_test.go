@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"s3upload_test/pkg/archive"
+)
+
+// tarStream returns an uncompressed tar stream of the given entries without content, regular files by default.
+func tarStream(t *testing.T, headers ...*tar.Header) io.Reader {
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	for _, header := range headers {
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		header.Mode = 0644
+		err := w.WriteHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	return buf
+}
+
+func TestAddTarStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		header *tar.Header
+		// stored is the name of the entry in the archive, empty when the stream is rejected.
+		stored string
+	}{
+		{name: "relative", header: &tar.Header{Name: "./var/log/messages"}, stored: "node1/var/log/messages"},
+		{name: "directory", header: &tar.Header{Name: "./var/", Typeflag: tar.TypeDir}, stored: "node1/var/"},
+		{name: "dot dot within the tree", header: &tar.Header{Name: "var/../etc/hosts"}, stored: "node1/etc/hosts"},
+		{name: "absolute", header: &tar.Header{Name: "/etc/passwd"}},
+		{name: "parent", header: &tar.Header{Name: "../outside"}},
+		{name: "cleaned to parent", header: &tar.Header{Name: "var/../../outside"}},
+		{name: "only dot dot", header: &tar.Header{Name: "..", Typeflag: tar.TypeDir}},
+		{name: "hard link outside", header: &tar.Header{Name: "link", Linkname: "../../etc/shadow", Typeflag: tar.TypeLink}},
+	}
+
+	format, _ := archive.LookupFormat("tar")
+	codec, _ := archive.LookupCodec("none")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			a, err := newTarArchive(out, format, codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = a.addTarStream(tarStream(t, test.header), "node1")
+			a.Close()
+			if (err != nil) != (test.stored == "") {
+				t.Fatalf("Expected rejected: %v, got %v", test.stored == "", err)
+			}
+			if err != nil {
+				return
+			}
+
+			header, err := tar.NewReader(out).Next()
+			if err != nil || header.Name != test.stored {
+				t.Errorf("Expected the entry %s, got %+v, %v", test.stored, header, err)
+			}
+		})
+	}
+}
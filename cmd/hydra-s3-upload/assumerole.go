@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// assumeRoleOptions configures an optional AssumeRole hop exchanging the Hydra credentials
+// for a scoped role before any S3 request is made.
+type assumeRoleOptions struct {
+	arn         string
+	externalID  string
+	sessionName string
+}
+
+func addAssumeRoleFlags(flags *flag.FlagSet) *assumeRoleOptions {
+	role := &assumeRoleOptions{}
+	flags.StringVar(&role.arn, "assume-role-arn", "", "Exchange the Hydra credentials for this IAM role before accessing S3")
+	flags.StringVar(&role.externalID, "external-id", "", "External ID required by the trust policy of --assume-role-arn")
+	flags.StringVar(&role.sessionName, "role-session-name", "hydra-s3-upload", "Session name of the assumed role")
+
+	return role
+}
+
+// assume returns a session acting as the role, with the base session making the STS call.
+func (r *assumeRoleOptions) assume(base *session.Session) *session.Session {
+	creds := stscreds.NewCredentials(base, r.arn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = r.sessionName
+		if r.externalID != "" {
+			p.ExternalID = aws.String(r.externalID)
+		}
+	})
+
+	return base.Copy(&aws.Config{Credentials: creds})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditFileIncluded   = "file-included"
+	auditFileRedacted   = "file-redacted"
+	auditFileExcluded   = "file-excluded"
+	auditUploadStarted  = "upload-started"
+	auditUploadFinished = "upload-finished"
+	auditUploadFailed   = "upload-failed"
+)
+
+// filesManifestName is the entry of audited archives listing the SHA-256 checksums of the archived files,
+// which verify-archive --manifest compares the files with.
+const filesManifestName = "hydra-s3-upload-files.json"
+
+// filesManifest is the content of the filesManifestName entry.
+type filesManifest struct {
+	Files map[string]string `json:"files"`
+}
+
+// writeFilesManifest stores the checksums of the archived files in the archive, unless the archive is not audited.
+// The manifest is covered by the archive checksum, which the upload-started record of the audit log holds.
+func writeFilesManifest(w archive.EntryWriter, files map[string]string) error {
+	if files == nil {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(&filesManifest{Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	err = w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filesManifestName,
+		Size:     int64(len(content)),
+		Mode:     0644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+	return err
+}
+
+// auditRecord is a line of the audit log. Every record includes the hash of the previous one,
+// so that modifying or removing a record breaks the chain up to the head.
+type auditRecord struct {
+	Seq     int64             `json:"seq"`
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Details map[string]string `json:"details,omitempty"`
+	Prev    string            `json:"prev"`
+	Hash    string            `json:"hash"`
+}
+
+// chainHash returns the SHA-256 hash of the record without its own hash.
+func (r *auditRecord) chainHash() string {
+	unhashed := *r
+	unhashed.Hash = ""
+	content, _ := json.Marshal(&unhashed)
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// auditLog is an append-only, hash-chained record of every file that went into the archives
+// and of every upload. Its head hash is stored in the metadata of the uploaded objects.
+type auditLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	lock *fileLock
+	seq  int64
+	head string
+}
+
+// verifyAuditLog checks the chain of the audit log and returns its last record, nil when it is empty.
+func verifyAuditLog(filePath string) (*auditRecord, error) {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last *auditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		record := &auditRecord{}
+		err = json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return nil, fmt.Errorf("Audit log line %d is not a record -- %w", line, err)
+		}
+
+		switch {
+		case last == nil && (record.Seq != 1 || record.Prev != ""):
+			return nil, fmt.Errorf("Audit log line %d does not start the chain", line)
+		case last != nil && (record.Seq != last.Seq+1 || record.Prev != last.Hash):
+			return nil, fmt.Errorf("Audit log line %d does not follow record %d", line, last.Seq)
+		case record.Hash != record.chainHash():
+			return nil, fmt.Errorf("Audit log line %d was modified", line)
+		}
+		last = record
+	}
+
+	return last, scanner.Err()
+}
+
+// openAuditLog verifies the audit log and opens it for appending. Runs using the same log
+// wait for each other, so that the chain does not fork.
+func openAuditLog(filePath string) (*auditLog, error) {
+	lock, err := lockFile(filePath+".lock", true)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := verifyAuditLog(filePath)
+	if err != nil {
+		lock.unlock()
+		return nil, fmt.Errorf("Refusing to extend a broken audit log -- %w", err)
+	}
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		lock.unlock()
+		return nil, fmt.Errorf("Unable to open audit log -- %w", err)
+	}
+
+	l := &auditLog{f: f, lock: lock}
+	if last != nil {
+		l.seq, l.head = last.Seq, last.Hash
+	}
+
+	return l, nil
+}
+
+// record appends an action to the log and syncs it to the disk. A nil log records nothing.
+func (l *auditLog) record(action string, details map[string]string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record := &auditRecord{Seq: l.seq + 1, Time: time.Now().UTC(), Action: action, Details: details, Prev: l.head}
+	record.Hash = record.chainHash()
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.f.Write(append(content, '\n'))
+	if err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to write audit log -- %w", err)
+	}
+
+	l.seq, l.head = record.Seq, record.Hash
+	return nil
+}
+
+// headHash returns the hash of the last record.
+func (l *auditLog) headHash() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.head
+}
+
+func runAuditVerify(args []string) {
+	flags := flag.NewFlagSet(commandName()+" audit-verify", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		klog.Fatalln("Expected the audit log file as the only argument")
+	}
+
+	last, err := verifyAuditLog(flags.Arg(0))
+	if err == nil && last == nil {
+		err = errors.New("The audit log is empty or missing")
+	}
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	fmt.Printf("Audit log verified: %d records, head %s\n", last.Seq, last.Hash)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Authentication methods of the Hydra credentials request.
+const (
+	authBasic     = "basic"
+	authNegotiate = "negotiate"
+	authSession   = "session"
+)
+
+var authMethods = []string{authBasic, authNegotiate, authSession}
+
+// hydraAuthMethod selects how requests to Hydra authenticate, from HSU_HYDRA_AUTH_METHOD or --auth-method.
+var hydraAuthMethod = getenv(envHydraAuthMethod)
+
+func addAuthFlags(flags *flag.FlagSet) {
+	if hydraAuthMethod == "" {
+		hydraAuthMethod = authBasic
+	}
+	addNetworkFlags(flags)
+	addRetryFlags(flags)
+	addTimezoneFlag(flags)
+	flags.StringVar(&hydraAuthMethod, "auth-method", hydraAuthMethod, "Hydra authentication: basic (HSU_HYDRA_USER and HSU_HYDRA_PASS, the login session, or the netrc entry of the Hydra host) negotiate (Kerberos/SPNEGO with the ticket cache, needs curl), or session (logs into HSU_HYDRA_LOGIN_URL with the basic credentials and sends the session cookie and CSRF token)")
+}
+
+// hydraPost sends a JSON request to Hydra with the selected authentication method.
+func hydraPost(url string, body []byte) (*http.Response, error) {
+	return hydraDo("POST", url, body)
+}
+
+// hydraDo sends a request to Hydra with the selected authentication method, and a JSON body unless it is nil.
+func hydraDo(method, url string, body []byte) (*http.Response, error) {
+	if resp := chaos.hydraResponse(url); resp != nil {
+		return resp, nil
+	}
+
+	switch hydraAuthMethod {
+	case "", authBasic:
+		return basicRequest(method, url, body)
+	case authNegotiate:
+		return negotiateRequest(method, url, body)
+	case authSession:
+		return sessionRequest(method, url, body)
+	}
+
+	return nil, fmt.Errorf("Unsupported authentication method %q, expected one of: %s", hydraAuthMethod, strings.Join(authMethods, ", "))
+}
+
+// hydraTransport returns the transport of requests to Hydra, through the configured proxy and resolver.
+func hydraTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:           proxyFor,
+		DialContext:     dialContext,
+		TLSClientConfig: hydraTLSConfig(),
+	}
+}
+
+func basicRequest(method, url string, body []byte) (*http.Response, error) {
+	insecureClient := &http.Client{Transport: hydraTransport()}
+
+	req, err := newHydraRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	setHydraAuth(req, url)
+
+	return insecureClient.Do(req)
+}
+
+// newHydraRequest creates a request to Hydra, with a JSON body unless body is nil.
+func newHydraRequest(method, url string, body []byte) (*http.Request, error) {
+	if body == nil {
+		return http.NewRequest(method, url, nil)
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// negotiateRequest sends the request through curl, whose GSS-API support negotiates
+// Kerberos/SPNEGO authentication with the host's ticket cache.
+func negotiateRequest(method, url string, body []byte) (*http.Response, error) {
+	stderr := &bytes.Buffer{}
+	port := "443"
+	if strings.HasPrefix(url, "http://") {
+		port = "80"
+	}
+	args := append(append(append(curlProxyArgs(), curlResolveArgs(port)...), curlTLSArgs()...), "--silent", "--show-error", "--insecure", "--negotiate", "--user", ":",
+		"--request", method)
+	if body != nil {
+		args = append(args, "--header", "Content-Type: application/json", "--data-binary", "@-")
+	}
+	args = append(args, "--write-out", "\n%{http_code}", url)
+	cmd := exec.Command("curl", args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("curl failed -- %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// The status code follows the response body on the last line.
+	i := bytes.LastIndexByte(out, '\n')
+	if i < 0 {
+		return nil, fmt.Errorf("Unexpected curl output")
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(out[i+1:])))
+	if err != nil {
+		return nil, fmt.Errorf("Unexpected curl output -- %w", err)
+	}
+
+	return &http.Response{
+		StatusCode: code,
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(out[:i])),
+	}, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+)
+
+// Goals of --auto-compress.
+const (
+	goalSpeed = "speed"
+	goalSize  = "size"
+)
+
+var compressionGoals = []string{goalSpeed, goalSize}
+
+// The sample compressed by every candidate codec: the beginnings of the largest files
+// and of an even spread of the others.
+const (
+	autoCompressSampleFiles    = 64
+	autoCompressSampleFileSize = 256 * 1024
+)
+
+// compressionTrial is the result of compressing the sample with a codec.
+type compressionTrial struct {
+	name  string
+	ratio float64
+	// rate is the compression throughput in input bytes per second.
+	rate float64
+}
+
+// sampleFiles picks the files of dirs sampled to choose the compression.
+func sampleFiles(dirs []string) ([]string, error) {
+	type file struct {
+		path string
+		size int64
+	}
+
+	var files []file
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && info.Size() > 0 && !isReceiptFile(info.Name()) {
+				files = append(files, file{fullPath, info.Size()})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].size > files[j].size
+	})
+
+	// Half of the sample are the largest files, which dominate the archive size.
+	var paths []string
+	largest := autoCompressSampleFiles / 2
+	for i := 0; i < len(files) && i < largest; i++ {
+		paths = append(paths, files[i].path)
+	}
+	if rest := files[len(paths):]; len(rest) > 0 {
+		step := int(math.Ceil(float64(len(rest)) / float64(autoCompressSampleFiles-largest)))
+		for i := 0; i < len(rest); i += step {
+			paths = append(paths, rest[i].path)
+		}
+	}
+
+	return paths, nil
+}
+
+// readSample concatenates the beginnings of the files.
+func readSample(paths []string) []byte {
+	var sample []byte
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+
+		buf := make([]byte, autoCompressSampleFileSize)
+		n, _ := io.ReadFull(f, buf)
+		f.Close()
+		sample = append(sample, buf[:n]...)
+	}
+
+	return sample
+}
+
+func trialCompression(name string, codec archive.Codec, sample []byte) (*compressionTrial, error) {
+	counter := &byteCounter{}
+	start := time.Now()
+
+	w, err := codec.NewWriter(counter)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(sample)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1e-9
+	}
+
+	return &compressionTrial{
+		name:  name,
+		ratio: float64(counter.n) / float64(len(sample)),
+		rate:  float64(len(sample)) / elapsed,
+	}, nil
+}
+
+// cost ranks the trial for the goal, lower is better. The speed goal estimates the time
+// to compress and upload a byte of the gather over an uplink of the given bytes per second.
+func (t *compressionTrial) cost(goal string, uplink int64) float64 {
+	if goal == goalSize {
+		return t.ratio
+	}
+
+	return 1/t.rate + t.ratio/float64(uplink)
+}
+
+// chooseCompression compresses a sample of dirs with the available codecs
+// and returns the name of the one best meeting the goal.
+func chooseCompression(dirs []string, goal string, uplink int64) (string, error) {
+	paths, err := sampleFiles(dirs)
+	if err != nil {
+		return "", err
+	}
+
+	sample := readSample(paths)
+	if len(sample) == 0 {
+		return "gzip", nil
+	}
+
+	candidates := []string{"none", "gzip"}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		candidates = append(candidates, "zstd")
+	}
+
+	var best *compressionTrial
+	for _, name := range candidates {
+		codec, err := archive.LookupCodec(name)
+		if err != nil {
+			return "", err
+		}
+
+		trial, err := trialCompression(name, codec, sample)
+		if err != nil {
+			klog.Warningln("Unable to try the", name, "compression --", err)
+			continue
+		}
+		klog.Infof("Compression %s: %.1f%% of the sample size at %s/s", name, trial.ratio*100, formatByteSize(int64(trial.rate)))
+
+		if best == nil || trial.cost(goal, uplink) < best.cost(goal, uplink) {
+			best = trial
+		}
+	}
+	if best == nil {
+		return "gzip", nil
+	}
+
+	klog.Infof("Chose the %s compression for the %s goal from a %s sample of %d files", best.name, goal, formatByteSize(int64(len(sample))), len(paths))
+	return best.name, nil
+}
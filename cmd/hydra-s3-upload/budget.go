@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// uploadBudget limits the number and volume of uploads within sliding hourly and daily windows,
+// so that a misbehaving trigger cannot flood the bucket or the uplink. A zero limit is unlimited.
+type uploadBudget struct {
+	maxCountHour int
+	maxCountDay  int
+	maxBytesHour byteSize
+	maxBytesDay  byteSize
+
+	mu     sync.Mutex
+	events []budgetEvent
+}
+
+type budgetEvent struct {
+	time  time.Time
+	bytes int64
+}
+
+func addBudgetFlags(flags *flag.FlagSet) *uploadBudget {
+	b := &uploadBudget{}
+	flags.IntVar(&b.maxCountHour, "max-uploads-per-hour", 0, "Maximum number of uploads per hour, further uploads are deferred (0 = unlimited)")
+	flags.IntVar(&b.maxCountDay, "max-uploads-per-day", 0, "Maximum number of uploads per day, further uploads are deferred (0 = unlimited)")
+	flags.Var(&b.maxBytesHour, "max-bytes-per-hour", "Maximum uploaded volume per hour, e.g. 10GB (0 = unlimited)")
+	flags.Var(&b.maxBytesDay, "max-bytes-per-day", "Maximum uploaded volume per day, e.g. 100GB (0 = unlimited)")
+
+	return b
+}
+
+// windowDelay returns how long to wait until an upload of size bytes fits into the window.
+// The events must be sorted by time.
+func windowDelay(events []budgetEvent, now time.Time, window time.Duration, maxCount int, maxBytes, size int64) time.Duration {
+	// Keep only the events within the window.
+	start := 0
+	for start < len(events) && now.Sub(events[start].time) >= window {
+		start++
+	}
+	events = events[start:]
+
+	count, bytes := len(events), size
+	for _, e := range events {
+		bytes += e.bytes
+	}
+
+	// Expire the oldest events until both limits are met.
+	var delay time.Duration
+	for i := 0; (maxCount > 0 && count >= maxCount) || (maxBytes > 0 && bytes > maxBytes); i++ {
+		delay = events[i].time.Add(window).Sub(now)
+		count--
+		bytes -= events[i].bytes
+	}
+
+	return delay
+}
+
+func (b *uploadBudget) delay(size int64, now time.Time) time.Duration {
+	hourly := windowDelay(b.events, now, time.Hour, b.maxCountHour, int64(b.maxBytesHour), size)
+	daily := windowDelay(b.events, now, 24*time.Hour, b.maxCountDay, int64(b.maxBytesDay), size)
+	if daily > hourly {
+		return daily
+	}
+
+	return hourly
+}
+
+// acquire blocks until an upload of size bytes fits into the budget and records it.
+func (b *uploadBudget) acquire(name string, size int64) error {
+	if (b.maxBytesHour > 0 && size > int64(b.maxBytesHour)) || (b.maxBytesDay > 0 && size > int64(b.maxBytesDay)) {
+		return fmt.Errorf("%s (%s) exceeds the upload byte budget", name, formatByteSize(size))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		now := time.Now()
+		delay := b.delay(size, now)
+		if delay <= 0 {
+			// Forget the events that no window looks at anymore.
+			for len(b.events) > 0 && now.Sub(b.events[0].time) >= 24*time.Hour {
+				b.events = b.events[1:]
+			}
+
+			b.events = append(b.events, budgetEvent{time: now, bytes: size})
+			return nil
+		}
+
+		klog.Warningf("Upload budget exhausted, deferring %s for %s", name, delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+// cancelOnSignal returns a channel that is never closed, there is no SIGUSR1 to cancel uploads with.
+func cancelOnSignal() <-chan struct{} {
+	return nil
+}
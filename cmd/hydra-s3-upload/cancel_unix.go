@@ -0,0 +1,28 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog"
+)
+
+// cancelOnSignal returns a channel closed once the process receives SIGUSR1,
+// letting operators stop an accidental transfer while its multipart upload is aborted cleanly.
+func cancelOnSignal() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	cancel := make(chan struct{})
+	go func() {
+		<-signals
+		klog.Warningln("Received SIGUSR1, canceling the upload")
+		close(cancel)
+	}()
+
+	return cancel
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// errCaseNotFound is returned when the case API does not know the case number.
+var errCaseNotFound = errors.New("Case not found")
+
+// caseInfo is the case metadata shown before uploading an attachment to the case.
+type caseInfo struct {
+	Number string
+	Title  string
+	Owner  string
+	Status string
+}
+
+func (c *caseInfo) String() string {
+	msg := c.Number
+	if c.Title != "" {
+		msg += " -- " + c.Title
+	}
+
+	var details []string
+	if c.Owner != "" {
+		details = append(details, "owner "+c.Owner)
+	}
+	if c.Status != "" {
+		details = append(details, "status "+c.Status)
+	}
+	if len(details) > 0 {
+		msg += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	return msg
+}
+
+// closed reports whether the case no longer accepts attachments.
+func (c *caseInfo) closed() bool {
+	return strings.EqualFold(c.Status, "closed")
+}
+
+// caseURL returns the case API URL of a case from HSU_HYDRA_CASE_URL, replacing its {case} placeholder
+// or appending the case number as the last path element. It is empty when the lookup is not configured.
+func caseURL(caseNumber string) string {
+	template := getenv(envHydraCaseURL)
+	if template == "" {
+		return ""
+	}
+
+	if strings.Contains(template, "{case}") {
+		return strings.Replace(template, "{case}", url.PathEscape(caseNumber), -1)
+	}
+
+	return strings.TrimSuffix(template, "/") + "/" + url.PathEscape(caseNumber)
+}
+
+// lookupCase fetches the title, owner and status of a case with the Hydra credentials.
+// The field names of the Hydra and Customer Portal case APIs are both understood.
+func lookupCase(caseNumber string) (*caseInfo, error) {
+	resp, err := hydraDo("GET", caseURL(caseNumber), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errCaseNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected HTTP response status code: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal(body, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the case -- %w", err)
+	}
+
+	field := func(names ...string) string {
+		for _, name := range names {
+			if value, err := lookupPath(doc, name); err == nil && value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	return &caseInfo{
+		Number: caseNumber,
+		Title:  field("summary", "title", "subject"),
+		Owner:  field("owner", "ownerName", "contactName", "owner.name"),
+		Status: field("status", "state"),
+	}, nil
+}
+
+// checkCase looks up the case of the upload when HSU_HYDRA_CASE_URL is set, failing for unknown and
+// closed cases. The lookup is best effort otherwise, e.g. for credentials without access to the case API.
+func (o *options) checkCase() error {
+	if o.caseID == "" || !o.usesHydra() || caseURL(o.caseID) == "" {
+		return nil
+	}
+
+	info, err := lookupCase(o.caseID)
+	if errors.Is(err, errCaseNotFound) {
+		return fmt.Errorf("Case %s not found, check --case-id", o.caseID)
+	}
+	if err != nil {
+		klog.Warningln("Unable to look up the case --", err)
+		return nil
+	}
+	if info.closed() {
+		return fmt.Errorf("Case %s is closed and cannot receive attachments", info)
+	}
+
+	klog.Infoln("Uploading to case", info)
+	o.caseInfo = info
+	return nil
+}
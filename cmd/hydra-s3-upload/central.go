@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// centralSource is the admin-managed central.json, pointing at the signed configuration
+// that a central server distributes to all hosts.
+type centralSource struct {
+	URL string `json:"url"`
+	// PublicKey is the base64 Ed25519 key verifying the signature served at URL + ".sig".
+	PublicKey string `json:"publicKey"`
+	// Refresh is how long a fetched configuration is used before fetching it again, e.g. "1h".
+	Refresh string `json:"refresh,omitempty"`
+}
+
+// centralConfig is the configuration distributed by the central server. The Hydra endpoints
+// are defaults that the environment variables override, the policy replaces the local one.
+type centralConfig struct {
+	Hydra struct {
+		URL         string `json:"url,omitempty"`
+		HealthURL   string `json:"healthURL,omitempty"`
+		TokenURL    string `json:"tokenURL,omitempty"`
+		CompleteURL string `json:"completeURL,omitempty"`
+		AuthMethod  string `json:"authMethod,omitempty"`
+		// ResponseMapping adapts the credentials responses of a Hydra-like service, see responseMapping.
+		ResponseMapping responseMapping `json:"responseMapping,omitempty"`
+	} `json:"hydra"`
+	Policy   json.RawMessage           `json:"policy,omitempty"`
+	Profiles map[string]*gatherProfile `json:"profiles,omitempty"`
+}
+
+// central is the configuration fetched from the central server, nil without one.
+var central *centralConfig
+
+var centralClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchSigned downloads a document and verifies its Ed25519 signature, served base64-encoded at url + ".sig".
+func fetchSigned(url, publicKey string) ([]byte, []byte, error) {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("Invalid Ed25519 public key for %s", url)
+	}
+
+	get := func(url string) ([]byte, error) {
+		resp, err := centralClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Unexpected HTTP response status code for %s: %s", url, resp.Status)
+		}
+
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	content, err := get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := get(url + ".sig")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = verifySigned(content, sig, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s -- %w", url, err)
+	}
+
+	return content, sig, nil
+}
+
+func verifySigned(content, sig []byte, key ed25519.PublicKey) error {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(key, content, decoded) {
+		return fmt.Errorf("Signature verification failed")
+	}
+
+	return nil
+}
+
+// loadCentralConfig returns the signed central configuration. It is fetched again once the cached copy
+// is older than the refresh period, and the cached copy is used while the server is unreachable.
+// The signature of the cached copy is verified as well, so the cache cannot be tampered with.
+func loadCentralConfig() (*centralConfig, error) {
+	content, err := ioutil.ReadFile(filepath.Join(adminConfigDir(), "central.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	source := &centralSource{}
+	err = json.Unmarshal(content, source)
+	if err != nil || source.URL == "" {
+		return nil, fmt.Errorf("Invalid central configuration source -- %v", err)
+	}
+
+	refresh := time.Hour
+	if source.Refresh != "" {
+		refresh, err = time.ParseDuration(source.Refresh)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid central configuration refresh period -- %w", err)
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(source.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Invalid Ed25519 public key of the central configuration")
+	}
+
+	cachePath := filepath.Join(appDir(dirCache, "central"), "config.json")
+	cached, cacheErr := ioutil.ReadFile(cachePath)
+	cachedSig, sigErr := ioutil.ReadFile(cachePath + ".sig")
+	if cacheErr == nil && sigErr == nil && verifySigned(cached, cachedSig, key) != nil {
+		klog.Warningln("Ignoring the cached central configuration with an invalid signature")
+		cacheErr = os.ErrNotExist
+	}
+
+	info, err := os.Stat(cachePath)
+	if cacheErr != nil || sigErr != nil || err != nil || time.Since(info.ModTime()) >= refresh {
+		fetched, sig, err := fetchSigned(source.URL, source.PublicKey)
+		switch {
+		case err == nil:
+			cached, cacheErr = fetched, nil
+			if os.MkdirAll(filepath.Dir(cachePath), 0700) == nil {
+				ioutil.WriteFile(cachePath, fetched, 0600)
+				ioutil.WriteFile(cachePath+".sig", sig, 0600)
+			}
+		case cacheErr == nil && sigErr == nil:
+			klog.Warningln("Unable to fetch the central configuration, using the cached one --", err)
+		default:
+			return nil, fmt.Errorf("Unable to fetch the central configuration -- %w", err)
+		}
+	}
+
+	config := &centralConfig{}
+	err = json.Unmarshal(cached, config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the central configuration -- %w", err)
+	}
+
+	return config, nil
+}
+
+// applyCentralConfig loads the central configuration, making its Hydra endpoints
+// the defaults of the environment variables they correspond to. A configured central configuration
+// that cannot be loaded fails the run, since it carries the export policy of the administrators.
+func applyCentralConfig() error {
+	var err error
+	central, err = loadCentralConfig()
+	if err != nil {
+		return err
+	}
+	if central == nil {
+		return nil
+	}
+
+	defaults := map[string]string{
+		envHydraURL:         central.Hydra.URL,
+		envHydraHealthURL:   central.Hydra.HealthURL,
+		envHydraTokenURL:    central.Hydra.TokenURL,
+		envHydraCompleteURL: central.Hydra.CompleteURL,
+	}
+	for name, value := range defaults {
+		if value != "" && getenv(name) == "" {
+			os.Setenv(name, value)
+		}
+	}
+
+	if central.Hydra.AuthMethod != "" && getenv(envHydraAuthMethod) == "" {
+		hydraAuthMethod = central.Hydra.AuthMethod
+	}
+	if central.Hydra.ResponseMapping != nil && hydraResponseMapping == nil {
+		hydraResponseMapping = central.Hydra.ResponseMapping
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchSigned(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte(`{"hydra":{"url":"https://hydra.example.com"}}`)
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, content))
+
+	tests := []struct {
+		name      string
+		publicKey string
+		sig       string
+		err       bool
+	}{
+		{name: "valid", publicKey: base64.StdEncoding.EncodeToString(publicKey), sig: sig + "\n"},
+		{name: "other key", publicKey: base64.StdEncoding.EncodeToString(otherKey), sig: sig, err: true},
+		{name: "tampered signature", publicKey: base64.StdEncoding.EncodeToString(publicKey), sig: base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize)), err: true},
+		{name: "missing signature", publicKey: base64.StdEncoding.EncodeToString(publicKey), err: true},
+		{name: "invalid key", publicKey: "c2hvcnQ=", sig: sig, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/config.json":
+					w.Write(content)
+				case "/config.json.sig":
+					if test.sig == "" {
+						http.NotFound(w, r)
+						return
+					}
+					w.Write([]byte(test.sig))
+				}
+			}))
+			defer server.Close()
+
+			fetched, _, err := fetchSigned(server.URL+"/config.json", test.publicKey)
+			if (err != nil) != test.err {
+				t.Fatalf("Expected an error: %v, got %v", test.err, err)
+			}
+			if err == nil && string(fetched) != string(content) {
+				t.Errorf("Expected %s, got %s", content, fetched)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"k8s.io/klog"
+)
+
+// Failures injected by --chaos.
+const (
+	chaosHydraFail    = "hydra-fail"
+	chaosStall        = "stall"
+	chaosExpiredToken = "expired-token"
+)
+
+var chaosFailures = []string{chaosHydraFail, chaosStall, chaosExpiredToken}
+
+// chaosStallDefault is how long an upload stalls when --chaos stall gives no duration.
+const chaosStallDefault = 5 * time.Minute
+
+// chaosConfig injects failures into the runs, so that the automation built around the tool
+// can test its retry and alerting logic without real outages. The zero value injects nothing.
+type chaosConfig struct {
+	mu sync.Mutex
+	// hydraFailures is the number of Hydra requests still to be answered with 503 Service Unavailable.
+	hydraFailures int
+	// expiredTokens is the number of S3 uploads still to be rejected with ExpiredToken.
+	expiredTokens int
+	// The upload phase stalls for stallFor once it reaches stallAt percent, once per run.
+	stallAt  float64
+	stallFor time.Duration
+	stalled  bool
+	spec     []string
+}
+
+// chaos is the failure injection of the run, set by --chaos.
+var chaos = &chaosConfig{}
+
+func (c *chaosConfig) String() string {
+	return strings.Join(c.spec, ",")
+}
+
+// Set parses failures such as "hydra-fail=3", "stall=50%" or "stall=50%:2m", and "expired-token=1".
+func (c *chaosConfig) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		i := strings.IndexByte(item, '=')
+		if i < 0 {
+			return fmt.Errorf("Expected failure=value, got %q", item)
+		}
+		failure, arg := item[:i], item[i+1:]
+
+		var err error
+		switch failure {
+		case chaosHydraFail:
+			c.hydraFailures, err = strconv.Atoi(arg)
+		case chaosExpiredToken:
+			c.expiredTokens, err = strconv.Atoi(arg)
+		case chaosStall:
+			err = c.setStall(arg)
+		default:
+			return fmt.Errorf("Unsupported failure %q, expected one of: %s", failure, strings.Join(chaosFailures, ", "))
+		}
+		if err != nil || c.hydraFailures < 0 || c.expiredTokens < 0 {
+			return fmt.Errorf("Invalid value of %s -- %q", failure, arg)
+		}
+		c.spec = append(c.spec, item)
+	}
+
+	return nil
+}
+
+func (c *chaosConfig) setStall(arg string) error {
+	percent, duration := arg, ""
+	if i := strings.IndexByte(arg, ':'); i >= 0 {
+		percent, duration = arg[:i], arg[i+1:]
+	}
+
+	var err error
+	c.stallAt, err = strconv.ParseFloat(strings.TrimSuffix(percent, "%"), 64)
+	if err != nil || c.stallAt < 0 || c.stallAt >= 100 {
+		return fmt.Errorf("Invalid stall percentage %q", percent)
+	}
+
+	c.stallFor = chaosStallDefault
+	if duration != "" {
+		c.stallFor, err = time.ParseDuration(duration)
+		if err != nil || c.stallFor <= 0 {
+			return fmt.Errorf("Invalid stall duration %q", duration)
+		}
+	}
+
+	return nil
+}
+
+// enabled reports whether any failure is injected.
+func (c *chaosConfig) enabled() bool {
+	return len(c.spec) > 0
+}
+
+// hydraResponse returns the injected failure response of a Hydra request, or nil to send the request.
+func (c *chaosConfig) hydraResponse(url string) *http.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hydraFailures == 0 {
+		return nil
+	}
+	c.hydraFailures--
+	klog.Warningf("Injected failure: answering the Hydra request to %s with 503 Service Unavailable (%d left)", url, c.hydraFailures)
+
+	return &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Status:     "503 Service Unavailable",
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte("Injected by --chaos"))),
+	}
+}
+
+// expiredToken returns the injected rejection of an S3 upload whose credentials expired, or nil to upload.
+func (c *chaosConfig) expiredToken() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.expiredTokens == 0 {
+		return nil
+	}
+	c.expiredTokens--
+	klog.Warningf("Injected failure: rejecting the S3 upload with ExpiredToken (%d left)", c.expiredTokens)
+
+	return awserr.New("ExpiredToken", "The provided token has expired. (injected by --chaos)", nil)
+}
+
+// stall holds the upload phase tracked by t once it reaches the stall percentage,
+// until the stall duration passes or the run is canceled.
+func (c *chaosConfig) stall(t *progressTracker) {
+	if c.stallFor == 0 || t.phase != "upload" || t.total <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	t.mu.Lock()
+	reached := float64(t.bytes)*100/float64(t.total) >= c.stallAt
+	t.mu.Unlock()
+	if c.stalled || !reached {
+		c.mu.Unlock()
+		return
+	}
+	c.stalled = true
+	c.mu.Unlock()
+
+	klog.Warningf("Injected failure: stalling the upload of %s at %.0f%% for %s", t.archive, c.stallAt, c.stallFor)
+	select {
+	case <-time.After(c.stallFor):
+	case <-t.reporter.cancel:
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// claimsDirName is the directory of the watched directory where replicas claim gathers.
+const claimsDirName = ".hydra-s3-upload-claims"
+
+// claimDone is the content of the claims of uploaded gathers.
+const claimDone = "done"
+
+// claims shares the gathers of a watched directory between the replicas watching it,
+// so that every gather is uploaded by a single replica. A replica renews the claims it holds,
+// and the claims of a replica that stopped doing so are taken over once they expire.
+type claims struct {
+	dir     string
+	replica string
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	owned map[string]bool
+}
+
+func newClaims(parent, replica string, ttl time.Duration) (*claims, error) {
+	c := &claims{dir: filepath.Join(parent, claimsDirName), replica: replica, ttl: ttl, owned: map[string]bool{}}
+	err := os.MkdirAll(c.dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create claims directory -- %w", err)
+	}
+
+	go c.heartbeat()
+	return c, nil
+}
+
+func (c *claims) path(dir string) string {
+	return filepath.Join(c.dir, filepath.Base(dir))
+}
+
+// claim reports whether this replica holds the gather, claiming it when it is free.
+func (c *claims) claim(dir string) bool {
+	claimPath := c.path(dir)
+
+	content, err := ioutil.ReadFile(claimPath)
+	if err == nil {
+		owner := strings.TrimSpace(string(content))
+		if owner == claimDone {
+			return false
+		}
+		if owner != c.replica && !c.takeOver(claimPath, owner) {
+			return false
+		}
+		if owner == c.replica {
+			c.own(dir)
+			return true
+		}
+	}
+
+	f, err := os.OpenFile(claimPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		// Another replica claimed the gather in the meantime.
+		return false
+	}
+	_, err = f.WriteString(c.replica + "\n")
+	f.Close()
+	if err != nil {
+		os.Remove(claimPath)
+		return false
+	}
+
+	c.own(dir)
+	return true
+}
+
+// pending reports whether the gather is claimed but not uploaded yet.
+func (c *claims) pending(dir string) bool {
+	content, err := ioutil.ReadFile(c.path(dir))
+	return err == nil && strings.TrimSpace(string(content)) != claimDone
+}
+
+// takeOver removes an expired claim. The claim is renamed first, so that of the replicas
+// noticing the expiry at the same time only one removes it, and a claim renewed or recreated
+// in the meantime is put back.
+func (c *claims) takeOver(claimPath, owner string) bool {
+	info, err := os.Stat(claimPath)
+	if err != nil || time.Since(info.ModTime()) < c.ttl {
+		return false
+	}
+
+	stale := claimPath + "." + c.replica
+	if os.Rename(claimPath, stale) != nil {
+		return false
+	}
+
+	info, err = os.Stat(stale)
+	if err != nil || time.Since(info.ModTime()) < c.ttl {
+		os.Rename(stale, claimPath)
+		return false
+	}
+	os.Remove(stale)
+
+	klog.Warningf("Taking over the expired claim of %s on %s", owner, filepath.Base(claimPath))
+	return true
+}
+
+func (c *claims) own(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.owned[dir] = true
+}
+
+// release gives up the claim, letting another replica try the gather.
+func (c *claims) release(dir string) {
+	c.mu.Lock()
+	delete(c.owned, dir)
+	c.mu.Unlock()
+
+	os.Remove(c.path(dir))
+}
+
+// done marks the gather as uploaded for all replicas.
+func (c *claims) done(dir string) {
+	c.mu.Lock()
+	delete(c.owned, dir)
+	c.mu.Unlock()
+
+	err := ioutil.WriteFile(c.path(dir), []byte(claimDone+"\n"), 0600)
+	if err != nil {
+		klog.Warningln("Unable to mark", dir, "as uploaded --", err)
+	}
+}
+
+// heartbeat renews the held claims well before they expire, also while uploads are running.
+func (c *claims) heartbeat() {
+	for range time.Tick(c.ttl / 3) {
+		c.mu.Lock()
+		now := time.Now()
+		for dir := range c.owned {
+			err := os.Chtimes(c.path(dir), now, now)
+			if err != nil {
+				klog.Warningln("Unable to renew the claim on", dir, "--", err)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
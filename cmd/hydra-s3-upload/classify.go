@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/klog"
+)
+
+// Classification modes of --classification.
+const (
+	classificationOff    = "off"
+	classificationReport = "report"
+	classificationStrict = "strict"
+)
+
+var classificationModes = []string{classificationOff, classificationReport, classificationStrict}
+
+// Content categories of the classification report, in the order they are reported.
+var dataCategories = []string{"secrets", "certificates", "binaries", "manifests", "logs", "other"}
+
+// classifyScanLimit bounds the part of a file searched for secrets.
+const classifyScanLimit = 16 * 1024 * 1024
+
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`^kind:\s*Secret\s*$`),
+	regexp.MustCompile(`"kind":\s*"Secret"`),
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`(?i)\b(password|passwd|secret_?key|api_?key|bearer)\b\s*[:=]\s*\S{6,}`),
+}
+
+// classifyFile assigns a file to one of the data categories, from its name and content.
+func classifyFile(fullPath, name string) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8*1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	head = head[:n]
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "binaries", nil
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(f, classifyScanLimit))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	certificate := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, pattern := range secretPatterns {
+			if pattern.MatchString(line) {
+				return "secrets", nil
+			}
+		}
+		if strings.Contains(line, "-----BEGIN CERTIFICATE-----") {
+			certificate = true
+		}
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case certificate || ext == ".crt" || ext == ".cer" || ext == ".pem":
+		return "certificates", nil
+	case ext == ".yaml" || ext == ".yml" || bytes.HasPrefix(head, []byte("apiVersion:")):
+		return "manifests", nil
+	case isLogFile(name) || strings.Contains(name, "/logs/"):
+		return "logs", nil
+	}
+
+	return "other", nil
+}
+
+type dataCategory struct {
+	files    int
+	size     int64
+	examples []string
+}
+
+// classifyDir categorizes the files of dir that are not excluded.
+func classifyDir(dir string, excludes []string) (map[string]*dataCategory, error) {
+	categories := map[string]*dataCategory{}
+	for _, name := range dataCategories {
+		categories[name] = &dataCategory{}
+	}
+
+	err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, fullPath)
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(relPath)
+
+		if name != "." && excludedName(excludes, name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		category, err := classifyFile(fullPath, name)
+		if err != nil {
+			return err
+		}
+
+		c := categories[category]
+		c.files++
+		c.size += info.Size()
+		c.examples = append(c.examples, name)
+		return nil
+	})
+
+	return categories, err
+}
+
+func printClassification(w io.Writer, categories map[string]*dataCategory) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CATEGORY\tFILES\tSIZE")
+	for _, name := range dataCategories {
+		c := categories[name]
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", name, c.files, formatByteSize(c.size))
+	}
+	tw.Flush()
+
+	// Secrets need a closer look, so name the files.
+	if secrets := categories["secrets"].examples; len(secrets) > 0 {
+		fmt.Fprintln(w, "\nFiles with possible secrets:")
+		for i, name := range secrets {
+			if i == 20 {
+				fmt.Fprintf(w, "  ... %d more\n", len(secrets)-i)
+				break
+			}
+			fmt.Fprintln(w, " ", name)
+		}
+	}
+}
+
+// checkClassification reports the categories of the data about to be uploaded. In the strict mode,
+// the upload only proceeds once the report has been acknowledged with --ack-classification.
+func (o *options) checkClassification(srcDir string) error {
+	if o.classification == "" || o.classification == classificationOff {
+		return nil
+	}
+
+	categories, err := classifyDir(srcDir, o.excludes)
+	if err != nil {
+		return fmt.Errorf("Unable to classify %s -- %w", srcDir, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Data classification of %s:\n", srcDir)
+	printClassification(os.Stderr, categories)
+
+	if o.classification == classificationStrict && !o.ackClassification {
+		return fmt.Errorf("Review the data classification and rerun with --ack-classification to upload")
+	}
+
+	klog.Infoln("Data classification reported")
+	return nil
+}
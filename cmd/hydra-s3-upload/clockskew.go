@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"k8s.io/klog"
+)
+
+// clockSkew is the offset of the S3 clock from the local clock in nanoseconds, learned from
+// the Date header of responses rejecting requests signed with a skewed time.
+var clockSkew int64
+
+// skewErrorCodes are the errors S3 and STS report for requests signed too far from their time.
+var skewErrorCodes = []string{"RequestTimeTooSkewed", "RequestExpired", "SignatureDoesNotMatch"}
+
+// correctClockSkew makes the session sign requests with the server time, retrying the requests
+// rejected for a skewed signing time once the offset is known. Hosts with a bad NTP setup
+// would otherwise fail with an obscure signature error.
+func correctClockSkew(s *session.Session) {
+	s.Handlers.Sign.PushFront(func(r *request.Request) {
+		if skew := time.Duration(atomic.LoadInt64(&clockSkew)); skew != 0 {
+			r.Time = time.Now().Add(skew)
+			r.LastSignedAt = time.Time{}
+		}
+	})
+
+	s.Handlers.Retry.PushFront(func(r *request.Request) {
+		aerr, ok := r.Error.(awserr.Error)
+		if !ok || !containsString(skewErrorCodes, aerr.Code()) || r.HTTPResponse == nil {
+			return
+		}
+
+		serverTime, err := http.ParseTime(r.HTTPResponse.Header.Get("Date"))
+		if err != nil {
+			return
+		}
+
+		// Retry only when the clocks are far enough apart to explain the rejection.
+		skew := time.Until(serverTime)
+		if skew > -time.Minute && skew < time.Minute {
+			return
+		}
+
+		if time.Duration(atomic.SwapInt64(&clockSkew, int64(skew))) != skew {
+			klog.Warningf("Local clock is off by %s from S3, signing requests with the S3 time", skew.Round(time.Second))
+		}
+		r.Retryable = aws.Bool(true)
+	})
+}
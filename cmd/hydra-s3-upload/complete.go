@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// completionRequest is the body of the Hydra completion callback.
+type completionRequest struct {
+	FileName  string   `json:"fileName"`
+	Keys      []string `json:"keys"`
+	Checksum  string   `json:"checksum,omitempty"`
+	Algorithm string   `json:"algorithm,omitempty"`
+	Size      int64    `json:"size"`
+}
+
+// completeUpload confirms the uploaded attachment to the Hydra completion endpoint in HSU_HYDRA_COMPLETE_URL,
+// for the Hydra workflows that require it after the objects are stored.
+func (o *options) completeUpload(name string, r *uploadReceipt) error {
+	completeURL := getenv(envHydraCompleteURL)
+	if completeURL == "" || !o.usesHydra() {
+		return nil
+	}
+
+	body, err := json.Marshal(&completionRequest{FileName: name, Keys: r.Keys, Checksum: r.Checksum, Algorithm: r.Algorithm, Size: r.Size})
+	if err != nil {
+		return err
+	}
+
+	// A missed callback leaves the attachment pending on the Hydra side.
+	err = retries.do("Confirming the upload to Hydra", func(int) (bool, error) {
+		return postCompletion(completeURL, body)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to confirm the upload to Hydra, the attachment may stay pending -- %w", err)
+	}
+	klog.Infoln("Attachment upload confirmed to Hydra")
+
+	return nil
+}
+
+// postCompletion sends the completion callback and reports whether a failure is worth retrying.
+func postCompletion(completeURL string, body []byte) (bool, error) {
+	resp, err := hydraPost(completeURL, body)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("Unexpected HTTP response status code: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return transientStatus(resp.StatusCode), err
+}
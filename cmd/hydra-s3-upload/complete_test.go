@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompleteUploadRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		err      bool
+	}{
+		{name: "confirmed", statuses: []int{http.StatusOK}, attempts: 1},
+		{name: "server error retried", statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusNoContent}, attempts: 3},
+		{name: "client error not retried", statuses: []int{http.StatusBadRequest}, attempts: 1, err: true},
+		{name: "out of attempts", statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}, attempts: 3, err: true},
+	}
+
+	oldURL, oldRetries := os.Getenv(envHydraCompleteURL), retries
+	defer func() {
+		os.Setenv(envHydraCompleteURL, oldURL)
+		retries = oldRetries
+	}()
+	retries = &retryPolicy{attempts: 3}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statuses[attempts])
+				attempts++
+			}))
+			defer server.Close()
+			os.Setenv(envHydraCompleteURL, server.URL)
+
+			err := (&options{}).completeUpload("must-gather.tar.gz", &uploadReceipt{Keys: []string{"attachments/must-gather.tar.gz"}})
+			if attempts != test.attempts {
+				t.Errorf("Expected %d attempts, got %d", test.attempts, attempts)
+			}
+			if (err != nil) != test.err || err != nil && !strings.Contains(err.Error(), "may stay pending") {
+				t.Errorf("Unexpected error %v", err)
+			}
+		})
+	}
+}
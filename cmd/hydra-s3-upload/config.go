@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/klog"
+)
+
+// launchEnv holds the environment variables set when the tool started,
+// before the selected context and the central configuration amended them.
+var launchEnv = func() map[string]string {
+	env := map[string]string{}
+	for _, item := range os.Environ() {
+		if i := strings.IndexByte(item, '='); i > 0 {
+			env[item[:i]] = item[i+1:]
+		}
+	}
+
+	return env
+}()
+
+// maskedValue replaces secrets in the output of config view.
+const maskedValue = "xxxxx"
+
+// configSetting is a setting of the effective configuration, which comes from the first of
+// its flag, the selected context, its environment variable, the central configuration, and its default.
+type configSetting struct {
+	name    string
+	flag    string
+	env     string
+	secret  bool
+	context func(c *hydraContext) string
+	central func(c *centralConfig) string
+	// value returns the effective value, after all of the sources were applied.
+	value func() string
+}
+
+func envValue(name string) func() string {
+	return func() string { return getenv(name) }
+}
+
+var configSettings = []configSetting{
+	{name: "hydra.url", env: envHydraURL, value: envValue(envHydraURL),
+		context: func(c *hydraContext) string { return c.HydraURL },
+		central: func(c *centralConfig) string { return c.Hydra.URL }},
+	{name: "hydra.healthURL", env: envHydraHealthURL, value: envValue(envHydraHealthURL),
+		context: func(c *hydraContext) string { return c.HealthURL },
+		central: func(c *centralConfig) string { return c.Hydra.HealthURL }},
+	{name: "hydra.tokenURL", env: envHydraTokenURL, value: tokenURLDefault,
+		context: func(c *hydraContext) string { return c.TokenURL },
+		central: func(c *centralConfig) string { return c.Hydra.TokenURL }},
+	{name: "hydra.completeURL", env: envHydraCompleteURL, value: envValue(envHydraCompleteURL),
+		context: func(c *hydraContext) string { return c.CompleteURL },
+		central: func(c *centralConfig) string { return c.Hydra.CompleteURL }},
+	{name: "hydra.authMethod", flag: "auth-method", env: envHydraAuthMethod, value: func() string { return hydraAuthMethod },
+		context: func(c *hydraContext) string { return c.AuthMethod },
+		central: func(c *centralConfig) string { return c.Hydra.AuthMethod }},
+	{name: "hydra.caseURL", env: envHydraCaseURL, value: envValue(envHydraCaseURL)},
+	{name: "hydra.downloadURL", env: envHydraDownloadURL, value: envValue(envHydraDownloadURL)},
+	{name: "hydra.loginURL", env: envHydraLoginURL, value: envValue(envHydraLoginURL)},
+	{name: "hydra.csrfHeader", env: envHydraCSRFHeader, value: func() string { return envDefault(envHydraCSRFHeader, defaultCSRFHeader) }},
+	{name: "hydra.csrfCookie", env: envHydraCSRFCookie, value: func() string { return envDefault(envHydraCSRFCookie, defaultCSRFCookie) }},
+	{name: "hydra.responseMapping", env: envHydraResponseMapping, value: func() string { return mappingSummary(hydraResponseMapping) },
+		context: func(c *hydraContext) string { return mappingSummary(c.ResponseMapping) },
+		central: func(c *centralConfig) string { return mappingSummary(c.Hydra.ResponseMapping) }},
+	{name: "hydra.user", env: envHydraUser, value: envValue(envHydraUser),
+		context: func(c *hydraContext) string { return c.User }},
+	{name: "hydra.password", env: envHydraPass, secret: true, value: envValue(envHydraPass)},
+	{name: "hydra.offlineToken", env: envHydraOfflineToken, secret: true, value: envValue(envHydraOfflineToken)},
+	{name: "proxy", flag: "proxy", env: envProxy, value: func() string { return hydraProxy },
+		context: func(c *hydraContext) string { return c.Proxy }},
+	{name: "timezone", flag: "timezone", env: envTimezone, value: displayZone.String},
+	{name: "aws.region", env: "AWS_REGION", value: envValue("AWS_REGION")},
+	{name: "aws.profile", env: "AWS_PROFILE", value: envValue("AWS_PROFILE")},
+	{name: "vault.addr", env: "VAULT_ADDR", value: envValue("VAULT_ADDR")},
+	{name: "vault.token", env: "VAULT_TOKEN", secret: true, value: envValue("VAULT_TOKEN")},
+	{name: "smtp.user", env: "SMTP_USER", value: envValue("SMTP_USER")},
+	{name: "smtp.password", env: "SMTP_PASS", secret: true, value: envValue("SMTP_PASS")},
+}
+
+func mappingSummary(m responseMapping) string {
+	if m == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d fields", len(m))
+}
+
+// source returns where the effective value of the setting comes from.
+func (s *configSetting) source(set map[string]bool) string {
+	switch {
+	case s.flag != "" && set[s.flag]:
+		return "flag --" + s.flag
+	case s.context != nil && activeContext != nil && s.context(activeContext) != "":
+		return "context " + activeContextName
+	case s.env != "" && envSource(s.env, launchEnv) != "":
+		return "env " + envSource(s.env, launchEnv)
+	case s.central != nil && central != nil && s.central(central) != "":
+		return "central config"
+	case s.value() != "":
+		return "default"
+	}
+
+	return "unset"
+}
+
+// maskSecret hides secrets and the passwords of URLs.
+func maskSecret(value string, secret bool) string {
+	if value == "" {
+		return ""
+	}
+	if secret {
+		return maskedValue
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), maskedValue)
+	}
+
+	return u.String()
+}
+
+// runConfig prints the effective configuration of an upload with the given flags.
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "view" {
+		klog.Fatalf("Usage: %s config view [upload flags]", commandName())
+	}
+
+	flags := flag.NewFlagSet(commandName()+" config view", flag.ExitOnError)
+	addUploadFlags(flags)
+	flags.Parse(args[1:])
+
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	covered := map[string]bool{}
+	for i := range configSettings {
+		s := &configSettings[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.name, maskSecret(s.value(), s.secret), s.source(set))
+		covered[s.flag] = true
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if covered[f.Name] {
+			return
+		}
+		source := "default"
+		if set[f.Name] {
+			source = "flag --" + f.Name
+		}
+		fmt.Fprintf(w, "--%s\t%s\t%s\n", f.Name, maskSecret(f.Value.String(), false), source)
+	})
+	w.Flush()
+}
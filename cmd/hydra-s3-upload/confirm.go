@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// errNotConfirmed is returned when the user declines the upload summary.
+var errNotConfirmed = errors.New("Upload not confirmed")
+
+// confirmUpload shows what is about to be uploaded where and asks for confirmation on a terminal.
+// It runs before any credentials are requested or anything is archived, so that an upload meant for another case
+// or environment costs nothing. Only the read-only case lookup of checkCase comes first, to show the case it finds.
+func (o *options) confirmUpload(items []*uploadItem, destination string) error {
+	if !stdinIsTerminal() {
+		return nil
+	}
+
+	source := items[0].path
+	if source == "" {
+		source = "merged gathers"
+	}
+	if len(items) > 1 {
+		source = fmt.Sprintf("%d items", len(items))
+	}
+	if len(o.sources) > 0 {
+		source += fmt.Sprintf(" and %d remote source(s)", len(o.sources))
+	}
+
+	size := "unknown"
+	var total int64
+	for _, item := range items {
+		info, err := os.Stat(item.path)
+		if err != nil {
+			total = -1
+			break
+		}
+		if !item.dir {
+			total += info.Size()
+			continue
+		}
+		entries, err := scanSizes(item.path, func(name string) bool { return excludedName(o.excludes, name) }, o.codec.Extension() != "")
+		if err != nil {
+			total = -1
+			break
+		}
+		total += totalCompressed(entries)
+	}
+	if total >= 0 {
+		size = "~" + formatByteSize(total) + " after exclusions, before filters"
+	}
+
+	caseNumber := o.attachment.CaseNumber
+	if o.caseInfo != nil {
+		caseNumber = o.caseInfo.String()
+	} else if caseNumber == "" {
+		caseNumber = "none"
+	}
+	private := o.attachment.IsPrivate
+	if o.presigned != nil || o.direct {
+		private = "n/a"
+	}
+
+	var redaction []string
+	for _, f := range o.filters {
+		if exec, ok := f.(*execFilter); ok {
+			redaction = append(redaction, exec.command)
+		}
+	}
+	if o.policy != nil {
+		redaction = append(redaction, "export policy "+exportPolicyPath())
+	}
+	if len(redaction) == 0 {
+		redaction = []string{"none"}
+	}
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Source:\t%s\n", source)
+	fmt.Fprintf(w, "Archive size:\t%s\n", size)
+	for _, item := range items {
+		if len(items) == 1 {
+			fmt.Fprintf(w, "Attachment:\t%s\n", item.objectName)
+		} else {
+			fmt.Fprintf(w, "Attachment:\t%s (%s)\n", item.objectName, item.path)
+		}
+	}
+	fmt.Fprintf(w, "Destination:\t%s\n", destination)
+	fmt.Fprintf(w, "Case:\t%s\n", caseNumber)
+	fmt.Fprintf(w, "Private:\t%s\n", private)
+	if o.attachment.Description != "" {
+		fmt.Fprintf(w, "Description:\t%s\n", o.attachment.Description)
+	}
+	fmt.Fprintf(w, "Redaction:\t%s\n", strings.Join(redaction, "; "))
+	w.Flush()
+
+	fmt.Fprint(os.Stderr, "Upload? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+		return errNotConfirmed
+	}
+
+	return nil
+}
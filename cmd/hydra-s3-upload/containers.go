@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+)
+
+// containerSource collects the logs and inspect output of a container on the local host.
+type containerSource struct {
+	runtime   string
+	container string
+}
+
+func (c *containerSource) name() string {
+	return "container " + c.container
+}
+
+func (c *containerSource) writeTo(archive *tarArchive) error {
+	dir := path.Join("containers", sanitizeName(c.container))
+
+	err := archive.addCommandOutput(path.Join(dir, "logs.txt"), c.runtime, "logs", "--timestamps", c.container)
+	if err != nil {
+		return err
+	}
+
+	return archive.addCommandOutput(path.Join(dir, "inspect.json"), c.runtime, "inspect", c.container)
+}
+
+// runtimeSource collects the container runtime information and daemon logs of the local host.
+type runtimeSource struct {
+	runtime string
+	since   string
+}
+
+func (r *runtimeSource) name() string {
+	return r.runtime + " runtime"
+}
+
+func (r *runtimeSource) writeTo(archive *tarArchive) error {
+	dir := path.Join("containers", "runtime")
+
+	err := archive.addCommandOutput(path.Join(dir, "info.txt"), r.runtime, "info")
+	if err != nil {
+		return err
+	}
+
+	// Docker runs as a systemd service, podman logs under its syslog identifier.
+	journalArgs := []string{"--no-pager", "--since", r.since, "-u", "docker"}
+	if r.runtime == "podman" {
+		journalArgs = []string{"--no-pager", "--since", r.since, "-t", "podman"}
+	}
+
+	return archive.addCommandOutput(path.Join(dir, r.runtime+".log"), "journalctl", journalArgs...)
+}
+
+// detectContainerRuntime returns the first available container runtime, preferring podman.
+func detectContainerRuntime() (string, error) {
+	for _, runtime := range []string{"podman", "docker"} {
+		if _, err := exec.LookPath(runtime); err == nil {
+			return runtime, nil
+		}
+	}
+
+	return "", fmt.Errorf("Neither podman nor docker was found in PATH")
+}
+
+// containerSources returns the sources collecting the given containers and the runtime daemon logs.
+func containerSources(containers []string, runtime, since string) ([]archiveSource, error) {
+	var err error
+	switch runtime {
+	case "":
+		runtime, err = detectContainerRuntime()
+		if err != nil {
+			return nil, err
+		}
+	case "docker", "podman":
+	default:
+		return nil, fmt.Errorf("Unsupported container runtime %q, expected docker or podman", runtime)
+	}
+
+	sources := []archiveSource{&runtimeSource{runtime: runtime, since: since}}
+	for _, container := range containers {
+		sources = append(sources, &containerSource{runtime: runtime, container: container})
+	}
+
+	return sources, nil
+}
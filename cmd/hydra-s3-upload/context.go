@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// hydraContext is a named configuration, such as the Hydra endpoints and redaction of one organization,
+// analogous to a kubeconfig context.
+type hydraContext struct {
+	HydraURL    string `json:"hydraURL,omitempty"`
+	HealthURL   string `json:"healthURL,omitempty"`
+	TokenURL    string `json:"tokenURL,omitempty"`
+	CompleteURL string `json:"completeURL,omitempty"`
+	AuthMethod  string `json:"authMethod,omitempty"`
+	User        string `json:"user,omitempty"`
+	Proxy       string `json:"proxy,omitempty"`
+	// CaseID is the default of --case-id.
+	CaseID string `json:"caseID,omitempty"`
+	// Filters are the filter commands applied after those of --filter-cmd, e.g. the redaction of the organization.
+	Filters  []string `json:"filters,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+	// ResponseMapping adapts the credentials responses of a Hydra-like service, see responseMapping.
+	ResponseMapping responseMapping `json:"responseMapping,omitempty"`
+}
+
+// contextsFile holds the named contexts and the one used when none is selected.
+type contextsFile struct {
+	Current  string                   `json:"current,omitempty"`
+	Contexts map[string]*hydraContext `json:"contexts"`
+}
+
+// activeContext is the context selected with --context, HSU_CONTEXT, or the current one, nil without any.
+var (
+	activeContext     *hydraContext
+	activeContextName string
+)
+
+func contextsPath() string {
+	return appDir(dirConfig, "contexts.json")
+}
+
+func loadContexts() (*contextsFile, error) {
+	file := &contextsFile{Contexts: map[string]*hydraContext{}}
+	content, err := ioutil.ReadFile(contextsPath())
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s -- %w", contextsPath(), err)
+	}
+	if file.Contexts == nil {
+		file.Contexts = map[string]*hydraContext{}
+	}
+
+	return file, nil
+}
+
+func (f *contextsFile) save() error {
+	content, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(contextsPath()), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(contextsPath(), append(content, '\n'), 0600)
+}
+
+// selectContext removes a leading --context flag from the arguments and activates the selected context.
+// Its values take precedence over the environment, since selecting the context is explicit.
+func selectContext(args []string) []string {
+	name := os.Getenv(envContext)
+	if len(args) > 1 && (args[0] == "--context" || args[0] == "-context") {
+		name, args = args[1], args[2:]
+	} else if len(args) > 0 && (strings.HasPrefix(args[0], "--context=") || strings.HasPrefix(args[0], "-context=")) {
+		name, args = args[0][strings.Index(args[0], "=")+1:], args[1:]
+	}
+
+	file, err := loadContexts()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	if name == "" {
+		name = file.Current
+	}
+	if name == "" {
+		return args
+	}
+
+	activeContext, activeContextName = file.Contexts[name], name
+	if activeContext == nil {
+		klog.Fatalf("Unknown context %q, see %s context list", name, commandName())
+	}
+
+	values := map[string]string{
+		envHydraURL:         activeContext.HydraURL,
+		envHydraHealthURL:   activeContext.HealthURL,
+		envHydraTokenURL:    activeContext.TokenURL,
+		envHydraCompleteURL: activeContext.CompleteURL,
+		envHydraUser:        activeContext.User,
+	}
+	for env, value := range values {
+		if value != "" {
+			os.Setenv(env, value)
+		}
+	}
+	if activeContext.AuthMethod != "" {
+		hydraAuthMethod = activeContext.AuthMethod
+	}
+	if activeContext.Proxy != "" {
+		hydraProxy = activeContext.Proxy
+	}
+	if activeContext.ResponseMapping != nil {
+		hydraResponseMapping = activeContext.ResponseMapping
+	}
+
+	return args
+}
+
+// runContext lists, shows, selects, and edits the named contexts.
+func runContext(args []string) {
+	usage := fmt.Sprintf("Usage: %s context list | current | use NAME | set NAME [flags] | delete NAME", commandName())
+	if len(args) == 0 {
+		klog.Fatalln(usage)
+	}
+
+	file, err := loadContexts()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	switch args[0] {
+	case "list":
+		names := make([]string, 0, len(file.Contexts))
+		for name := range file.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			marker := " "
+			if name == file.Current {
+				marker = "*"
+			}
+			fmt.Println(marker, name, file.Contexts[name].HydraURL)
+		}
+		return
+	case "current":
+		if file.Current == "" {
+			klog.Fatalln("No current context is set")
+		}
+		fmt.Println(file.Current)
+		return
+	}
+
+	if len(args) < 2 {
+		klog.Fatalln(usage)
+	}
+	name := args[1]
+
+	switch args[0] {
+	case "use":
+		if file.Contexts[name] == nil {
+			klog.Fatalf("Unknown context %q", name)
+		}
+		file.Current = name
+	case "delete":
+		delete(file.Contexts, name)
+		if file.Current == name {
+			file.Current = ""
+		}
+	case "set":
+		c := file.Contexts[name]
+		if c == nil {
+			c = &hydraContext{}
+		}
+		var filters, excludes stringList
+		flags := flag.NewFlagSet(commandName()+" context set", flag.ExitOnError)
+		flags.StringVar(&c.HydraURL, "hydra-url", c.HydraURL, "Hydra credentials endpoint of the context")
+		flags.StringVar(&c.HealthURL, "health-url", c.HealthURL, "Hydra health check endpoint of the context")
+		flags.StringVar(&c.TokenURL, "token-url", c.TokenURL, "SSO token endpoint of the context")
+		flags.StringVar(&c.CompleteURL, "complete-url", c.CompleteURL, "Hydra upload completion endpoint of the context")
+		flags.StringVar(&c.AuthMethod, "auth-method", c.AuthMethod, "Hydra authentication of the context: "+strings.Join(authMethods, ", "))
+		flags.StringVar(&c.User, "user", c.User, "Hydra username of the context")
+		flags.StringVar(&c.Proxy, "proxy", c.Proxy, "Proxy of the context, e.g. socks5://localhost:1080")
+		flags.StringVar(&c.CaseID, "case-id", c.CaseID, "Default support case number of the context")
+		flags.Var(&filters, "filter-cmd", "Filter command of the context, e.g. its redaction (repeatable, replaces the previous ones)")
+		flags.Var(&excludes, "exclude", "Exclusion pattern of the context (repeatable, replaces the previous ones)")
+		flags.Parse(args[2:])
+
+		if c.AuthMethod != "" && !containsString(authMethods, c.AuthMethod) {
+			klog.Fatalln("Unsupported authentication method --", c.AuthMethod)
+		}
+		if c.Proxy != "" {
+			_, err = parseProxy(c.Proxy)
+			if err != nil {
+				klog.Fatalln(err)
+			}
+		}
+		if len(filters) > 0 {
+			c.Filters = filters
+		}
+		if len(excludes) > 0 {
+			c.Excludes = excludes
+		}
+		file.Contexts[name] = c
+	default:
+		klog.Fatalln(usage)
+	}
+
+	err = file.save()
+	if err != nil {
+		klog.Fatalln("Unable to save the contexts --", err)
+	}
+}
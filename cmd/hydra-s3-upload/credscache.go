@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Credentials without an expiration are assumed to last credsCacheTTL, the shortest STS session,
+// and cached credentials are only reused until credsCacheMargin before they expire.
+const (
+	credsCacheTTL    = 15 * time.Minute
+	credsCacheMargin = 5 * time.Minute
+)
+
+// credsCacheDisabled bypasses the credentials cache, set by --no-cache.
+var credsCacheDisabled bool
+
+// credsCacheEntry is a cached credentials response.
+type credsCacheEntry struct {
+	ExpiresAt time.Time       `json:"expiresAt"`
+	Response  json.RawMessage `json:"response"`
+}
+
+func credsCacheDir() string {
+	return appDir(dirState, "credentials")
+}
+
+// credsCachePath returns the cache file of a credentials request. Only the very same request reuses
+// the cached credentials, see requestCredsAt for the requests that are cached.
+func credsCachePath(hydraURL string, reqData []byte) string {
+	sum := sha256.Sum256(append([]byte(hydraURL+"\n"), reqData...))
+	return filepath.Join(credsCacheDir(), hex.EncodeToString(sum[:])+".json.enc")
+}
+
+// credsCacheKeyAccount names the keyring entry holding the key of the credentials cache.
+const credsCacheKeyAccount = "credentials-cache-key"
+
+// credsCacheKeyring keeps the cache key in the OS keyring, disabled in the tests.
+var credsCacheKeyring = true
+
+// machineKey returns the key the cached credentials are encrypted with: a random key kept in the OS keyring,
+// apart from the cache, bound to the machine ID where there is one. Without a keyring the random key is kept
+// in a private file of the cache, which only keeps a copied cache unreadable: whoever can read the cache
+// directory on this machine can decrypt it, so the fallback is obfuscation rather than protection.
+func machineKey() ([]byte, error) {
+	var secret []byte
+	err := errors.New("The OS keyring is disabled")
+	if credsCacheKeyring {
+		secret, err = keyringCacheKey()
+	}
+	if err != nil {
+		klog.V(1).Infoln("Keeping the credentials cache key in a file, the OS keyring is unavailable --", err)
+		secret, err = fileCacheKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	machineID := ""
+	for _, idPath := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if content, err := ioutil.ReadFile(idPath); err == nil {
+			machineID = strings.TrimSpace(string(content))
+			break
+		}
+	}
+
+	key := sha256.Sum256(append(secret, machineID...))
+	return key[:], nil
+}
+
+// keyringCacheKey returns the random cache key stored in the OS keyring, storing a new one when there is none.
+func keyringCacheKey() ([]byte, error) {
+	content, err := keyringGet(credsCacheKeyAccount)
+	if errors.Is(err, errKeyringNotFound) {
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		return secret, keyringSet(credsCacheKeyAccount, hex.EncodeToString(secret))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(content)
+}
+
+// fileCacheKey returns the random cache key stored in a private file of the cache, storing a new one when
+// there is none.
+func fileCacheKey() ([]byte, error) {
+	keyPath := filepath.Join(credsCacheDir(), ".key")
+	secret, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		secret = make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(filepath.Dir(keyPath), 0700)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(keyPath, secret, 0600)
+	}
+
+	return secret, err
+}
+
+// cachedCreds returns the cached response of a credentials request, nil when there is none still valid
+// or the request is not cached, with an empty cachePath.
+func cachedCreds(cachePath string) []byte {
+	if credsCacheDisabled || cachePath == "" {
+		return nil
+	}
+
+	sealed, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil
+	}
+
+	entry, err := openCredsCacheEntry(sealed)
+	if err != nil {
+		klog.V(1).Infoln("Ignoring unreadable cached credentials --", err)
+		os.Remove(cachePath)
+		return nil
+	}
+	if time.Now().Add(credsCacheMargin).After(entry.ExpiresAt) {
+		os.Remove(cachePath)
+		return nil
+	}
+
+	return entry.Response
+}
+
+func openCredsCacheEntry(sealed []byte) (*credsCacheEntry, error) {
+	key, err := machineKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("The cache entry is truncated")
+	}
+
+	content, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &credsCacheEntry{}
+	return entry, json.Unmarshal(content, entry)
+}
+
+// forgetCached removes the credentials from the cache, e.g. once S3 reports them expired.
+func (c *credsResponse) forgetCached() {
+	if c.cachePath != "" {
+		os.Remove(c.cachePath)
+	}
+}
+
+// cacheCreds stores a credentials response until the credentials expire, unless cachePath is empty.
+func cacheCreds(cachePath string, creds *credsResponse, response []byte) error {
+	if credsCacheDisabled || cachePath == "" {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(credsCacheTTL)
+	if creds.Expiration != "" {
+		expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+		if err != nil {
+			return err
+		}
+		expiresAt = expiration
+	}
+
+	content, err := json.Marshal(&credsCacheEntry{ExpiresAt: expiresAt, Response: response})
+	if err != nil {
+		return err
+	}
+
+	key, err := machineKey()
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(cachePath, aead.Seal(nonce, nonce, content, nil), 0600)
+}
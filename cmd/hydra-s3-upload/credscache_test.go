@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3upload_test/internal/s3test"
+	"s3upload_test/pkg/hydra"
+)
+
+// setupCredsCacheTest enables the credentials cache in a temporary state directory, with the key in a file.
+func setupCredsCacheTest(t *testing.T) (cleanup func()) {
+	dir, err := ioutil.TempDir("", "credscache-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldState, oldDisabled, oldKeyring := os.Getenv("XDG_STATE_HOME"), credsCacheDisabled, credsCacheKeyring
+	os.Setenv("XDG_STATE_HOME", dir)
+	credsCacheDisabled, credsCacheKeyring = false, false
+
+	return func() {
+		os.Setenv("XDG_STATE_HOME", oldState)
+		credsCacheDisabled, credsCacheKeyring = oldDisabled, oldKeyring
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCredsCache(t *testing.T) {
+	defer setupCredsCacheTest(t)()
+
+	tests := []struct {
+		name       string
+		expiration string
+		// corrupt overwrites the cache entry after it is written.
+		corrupt []byte
+		cached  bool
+	}{
+		{name: "without expiration", cached: true},
+		{name: "expiring later", expiration: timestamp(time.Now().Add(time.Hour)), cached: true},
+		{name: "expiring within the margin", expiration: timestamp(time.Now().Add(credsCacheMargin / 2))},
+		{name: "expired", expiration: timestamp(time.Now().Add(-time.Minute))},
+		{name: "corrupted", corrupt: []byte("not sealed by the machine key")},
+		{name: "truncated", corrupt: []byte{1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cachePath := credsCachePath("https://hydra.example.com", []byte(test.name))
+			response := []byte(`{"accessKey":"AK"}`)
+			err := cacheCreds(cachePath, &credsResponse{Credentials: hydra.Credentials{Expiration: test.expiration}}, response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.corrupt != nil {
+				err = ioutil.WriteFile(cachePath, test.corrupt, 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cached := cachedCreds(cachePath)
+			if test.cached != (string(cached) == string(response)) {
+				t.Errorf("Expected cached: %v, got %q", test.cached, cached)
+			}
+			if _, err := os.Stat(cachePath); test.cached == os.IsNotExist(err) {
+				t.Errorf("Expected the cache entry to be kept: %v, got %v", test.cached, err)
+			}
+		})
+	}
+}
+
+func TestCredsCacheIsBoundToKey(t *testing.T) {
+	defer setupCredsCacheTest(t)()
+
+	cachePath := credsCachePath("https://hydra.example.com", []byte("request"))
+	if cachePath == credsCachePath("https://other.example.com", []byte("request")) {
+		t.Error("Expected the requests to other Hydra instances to be cached apart")
+	}
+	err := cacheCreds(cachePath, &credsResponse{}, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cache copied without its key, or next to another key, cannot be read.
+	err = os.Remove(filepath.Join(credsCacheDir(), ".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached := cachedCreds(cachePath); cached != nil {
+		t.Errorf("Expected the entry sealed by another key to be ignored, got %q", cached)
+	}
+
+	credsCacheDisabled = true
+	err = cacheCreds(cachePath, &credsResponse{}, []byte("{}"))
+	if err != nil || cachedCreds(cachePath) != nil {
+		t.Errorf("Expected the disabled cache to neither store nor return credentials, got %v", err)
+	}
+}
+
+func TestRequestCredsForCachesOnlyResumedUploads(t *testing.T) {
+	defer setupCredsCacheTest(t)()
+	oldHydra := os.Getenv(envHydraURL)
+	defer os.Setenv(envHydraURL, oldHydra)
+
+	s3 := s3test.NewServer()
+	defer s3.Close()
+	h := newFakeHydra(s3)
+	defer h.Close()
+	os.Setenv(envHydraURL, h.URL)
+
+	// Another upload under the same name gets its own credentials rather than overwriting the first object.
+	first, err := requestCredsFor(&hydra.Request{FileName: "gather.tar.gz", IsPrivate: "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := requestCredsFor(&hydra.Request{FileName: "gather.tar.gz", IsPrivate: "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := h.requestCount(); n != 2 || first.AccessKey == second.AccessKey {
+		t.Errorf("Expected fresh credentials for every new attachment, got %d credentials requests", n)
+	}
+
+	// A resumed upload is bound to its object, so its credentials are reused.
+	resumed := &hydra.Request{FileName: "gather.tar.gz", IsPrivate: "false", Key: first.Key}
+	for i := 0; i < 2; i++ {
+		_, err = requestCredsFor(resumed)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := h.requestCount(); n != 3 {
+		t.Errorf("Expected the credentials of the resumed upload to be reused, got %d credentials requests", n)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"k8s.io/klog"
+)
+
+// diagnosticTimeout bounds every step of the connectivity diagnostics.
+const diagnosticTimeout = 10 * time.Second
+
+// diagnoseAfter is the number of failed attempts of a spooled upload after which connectivity is diagnosed.
+const diagnoseAfter = 3
+
+// diagnosticStep is a step of the connectivity diagnostics of an endpoint.
+type diagnosticStep struct {
+	name     string
+	detail   string
+	err      error
+	duration time.Duration
+}
+
+// isNetworkError reports whether an upload failed to reach its endpoints, rather than being refused by them.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code() == "RequestError"
+	}
+
+	return false
+}
+
+// connectivityEndpoints returns the Hydra endpoints and the S3 endpoint of AWS_REGION, us-east-1 by default,
+// in its dual-stack variant when the uploads use that.
+func connectivityEndpoints() []string {
+	var endpoints []string
+	for _, env := range []string{envHydraURL, envHydraHealthURL} {
+		if u := getenv(env); u != "" {
+			endpoints = append(endpoints, u)
+		}
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	s3URL := "https://s3." + region + ".amazonaws.com/"
+	if useDualStack() {
+		s3URL = "https://s3.dualstack." + region + ".amazonaws.com/"
+	}
+
+	return append(endpoints, s3URL)
+}
+
+// diagnoseEndpoint resolves, connects to and shakes hands with an endpoint, stopping at the first failing step.
+// Through a proxy, the proxy itself is resolved and connected to, since it resolves the endpoint.
+func diagnoseEndpoint(rawURL string) []diagnosticStep {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return []diagnosticStep{{name: "url", err: err}}
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	var steps []diagnosticStep
+	run := func(name string, step func() (string, error)) bool {
+		start := time.Now()
+		detail, err := step()
+		steps = append(steps, diagnosticStep{name: name, detail: detail, err: err, duration: time.Since(start)})
+		return err == nil
+	}
+
+	req, _ := http.NewRequest("GET", rawURL, nil)
+	proxy, err := proxyFor(req)
+	ok := run("proxy", func() (string, error) {
+		if proxy == nil {
+			return "none", err
+		}
+		// The proxy credentials stay out of the report.
+		return proxy.Scheme + "://" + proxy.Host, nil
+	})
+	if !ok {
+		return steps
+	}
+
+	dialAddr := addr
+	if proxy != nil {
+		dialAddr = proxy.Host
+	}
+	host, _, _ := net.SplitHostPort(dialAddr)
+
+	ok = run("dns", func() (string, error) {
+		addrs, err := lookupHost(context.Background(), host)
+		return host + " -> " + strings.Join(addrs, ", "), err
+	})
+	if !ok {
+		return steps
+	}
+
+	ok = run("tcp", func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+		defer cancel()
+		conn, err := dialContext(ctx, "tcp", dialAddr)
+		if err != nil {
+			return dialAddr, err
+		}
+		conn.Close()
+		return dialAddr, nil
+	})
+	if !ok || proxy != nil || u.Scheme == "http" {
+		return steps
+	}
+
+	run("tls", func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+		defer cancel()
+		raw, err := dialContext(ctx, "tcp", addr)
+		if err != nil {
+			return addr, err
+		}
+		config, err := newTLSConfig(false)
+		if err != nil {
+			raw.Close()
+			return addr, err
+		}
+		config.ServerName = u.Hostname()
+		conn := tls.Client(raw, config)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(diagnosticTimeout))
+		err = conn.Handshake()
+		if err != nil {
+			return addr, err
+		}
+
+		// An unexpected issuer points at TLS interception by a firewall.
+		certs := conn.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			return addr, nil
+		}
+		return "issued by " + certs[0].Issuer.String(), nil
+	})
+
+	return steps
+}
+
+// logConnectivityReport diagnoses the connectivity to the Hydra and S3 endpoints and logs the outcome
+// of every step, telling network and firewall issues apart from credential issues.
+func logConnectivityReport(endpoints []string) bool {
+	klog.Infoln("Diagnosing connectivity to", strings.Join(endpoints, ", "), "...")
+	healthy := true
+	for _, endpoint := range endpoints {
+		for _, step := range diagnoseEndpoint(endpoint) {
+			if step.err != nil {
+				healthy = false
+				klog.Warningf("connectivity endpoint=%s step=%s result=failed duration=%s detail=%q error=%q",
+					endpoint, step.name, step.duration.Round(time.Millisecond), step.detail, step.err)
+				continue
+			}
+			klog.Infof("connectivity endpoint=%s step=%s result=ok duration=%s detail=%q",
+				endpoint, step.name, step.duration.Round(time.Millisecond), step.detail)
+		}
+	}
+
+	if healthy {
+		klog.Infoln("All endpoints are reachable, check the credentials and the permissions of the upload")
+	} else {
+		klog.Warningln("Some endpoints are unreachable, check the DNS, firewall and proxy configuration")
+	}
+
+	return healthy
+}
+
+func runDiagnose(args []string) {
+	var endpoints stringList
+	flags := flag.NewFlagSet(commandName()+" diagnose", flag.ExitOnError)
+	flags.Var(&endpoints, "endpoint", "Endpoint URL to diagnose instead of HSU_HYDRA_URL, HSU_HYDRA_HEALTH_URL and the S3 endpoint of AWS_REGION (repeatable)")
+	addNetworkFlags(flags)
+	flags.Parse(args)
+
+	err := applyTLSConfig()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	if len(endpoints) == 0 {
+		endpoints = connectivityEndpoints()
+	}
+	if !logConnectivityReport(endpoints) {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"s3upload_test/pkg/hydra"
+)
+
+// credsSource returns the destination and credentials for a piece of the named archive split into count objects.
+// key is the object key of a resumed upload, which the credentials are bound to, and empty for a new object.
+type credsSource func(name string, piece, count int64, key string) (*credsResponse, error)
+
+// hydraCreds requests a fresh set of credentials from Hydra for every piece,
+// with the case metadata of the template request.
+func hydraCreds(template hydra.Request) credsSource {
+	return func(name string, piece, count int64, key string) (*credsResponse, error) {
+		if count > 1 {
+			name = fmt.Sprintf("%s.part%03d", name, piece+1)
+		}
+
+		req := template
+		req.FileName = name
+		req.Key = key
+		return requestCredsFor(&req)
+	}
+}
+
+// directOptions selects the Hydra-bypass mode, which uploads to a bucket of the user's choice
+// with the AWS credentials already configured on the machine.
+type directOptions struct {
+	bucket  string
+	key     string
+	region  string
+	profile string
+	ambient bool
+}
+
+func addDirectFlags(flags *flag.FlagSet) *directOptions {
+	d := &directOptions{}
+	flags.StringVar(&d.bucket, "bucket", "", "Upload directly to this S3 bucket with the local AWS configuration instead of requesting credentials from Hydra")
+	flags.StringVar(&d.key, "key", "", "Object key in --bucket mode (defaults to the archive file name)")
+	flags.StringVar(&d.region, "region", "", "Region of --bucket (defaults to the profile region)")
+	flags.StringVar(&d.profile, "profile", "", "AWS shared config profile used in --bucket mode (defaults to AWS_PROFILE), SSO profiles included")
+	flags.BoolVar(&d.ambient, "ambient-credentials", false, "Use the pod (IRSA) or EC2 instance role in --bucket mode, falling back to Hydra when there is none")
+
+	return d
+}
+
+func (d *directOptions) enabled() bool {
+	return d.bucket != ""
+}
+
+// source returns the credentials source of the direct mode. The key defaults to the archive name,
+// split archives are stored under the key suffixed with the piece number.
+func (d *directOptions) source() (credsSource, error) {
+	s, err := d.createSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string, piece, count int64, resumedKey string) (*credsResponse, error) {
+		key := d.key
+		if key == "" {
+			key = name
+		}
+
+		pieceKey := key
+		if count > 1 {
+			pieceKey = fmt.Sprintf("%s.part%03d", key, piece+1)
+		}
+		if resumedKey != "" {
+			pieceKey = resumedKey
+		}
+
+		return &credsResponse{
+			Credentials: hydra.Credentials{BucketName: d.bucket, Key: pieceKey, Region: aws.StringValue(s.Config.Region)},
+			session:     s,
+		}, nil
+	}, nil
+}
+
+// createSession loads the shared AWS configuration of the selected profile.
+func (d *directOptions) createSession() (*session.Session, error) {
+	if d.ambient {
+		return ambientSession(d.region)
+	}
+
+	profile := d.profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	config := aws.Config{UseDualStack: aws.Bool(useDualStack())}
+	if d.region != "" {
+		config.Region = aws.String(d.region)
+	}
+
+	// This SDK version predates SSO support, so SSO profiles are resolved here.
+	sso, err := loadSSOProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if sso != nil {
+		config.Credentials = credentials.NewCredentials(sso)
+		if config.Region == nil && sso.profileRegion != "" {
+			config.Region = aws.String(sso.profileRegion)
+		}
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:                  config,
+		Profile:                 profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	})
+}
+
+// readINI parses an AWS shared config file into its sections.
+func readINI(filePath string) (map[string]map[string]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := map[string]map[string]string{}
+	var current map[string]string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			current = map[string]string{}
+			sections[name] = current
+		case current != nil && strings.Contains(line, "="):
+			i := strings.Index(line, "=")
+			current[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+
+	return sections, scanner.Err()
+}
+
+func awsConfigPath() string {
+	if p := os.Getenv("AWS_CONFIG_FILE"); p != "" {
+		return p
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".aws", "config")
+}
+
+// ssoProvider retrieves role credentials through AWS SSO, using the access token
+// cached by `aws sso login`.
+type ssoProvider struct {
+	credentials.Expiry
+
+	startURL      string
+	ssoRegion     string
+	accountID     string
+	roleName      string
+	cacheKey      string
+	profileName   string
+	profileRegion string
+}
+
+// loadSSOProfile returns the SSO provider of the profile, or nil when it is not an SSO profile.
+func loadSSOProfile(profile string) (*ssoProvider, error) {
+	sections, err := readINI(awsConfigPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	section := sections["profile "+profile]
+	if profile == "default" && section == nil {
+		section = sections["default"]
+	}
+	if section == nil || section["sso_account_id"] == "" {
+		return nil, nil
+	}
+
+	p := &ssoProvider{
+		startURL:      section["sso_start_url"],
+		ssoRegion:     section["sso_region"],
+		accountID:     section["sso_account_id"],
+		roleName:      section["sso_role_name"],
+		cacheKey:      section["sso_start_url"],
+		profileName:   profile,
+		profileRegion: section["region"],
+	}
+
+	// Newer configurations share the SSO settings through an sso-session section,
+	// whose name is the token cache key.
+	if name := section["sso_session"]; name != "" {
+		ssoSession := sections["sso-session "+name]
+		if ssoSession == nil {
+			return nil, fmt.Errorf("Profile %q references missing sso-session %q", profile, name)
+		}
+		p.startURL = ssoSession["sso_start_url"]
+		p.ssoRegion = ssoSession["sso_region"]
+		p.cacheKey = name
+	}
+
+	if p.startURL == "" || p.ssoRegion == "" || p.roleName == "" {
+		return nil, fmt.Errorf("Profile %q has an incomplete SSO configuration", profile)
+	}
+
+	return p, nil
+}
+
+// cachedToken reads the SSO access token cached by the AWS CLI.
+func (p *ssoProvider) cachedToken() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(p.cacheKey))
+	data, err := ioutil.ReadFile(filepath.Join(home, ".aws", "sso", "cache", hex.EncodeToString(sum[:])+".json"))
+	if err != nil {
+		return "", fmt.Errorf("No cached SSO token, run `aws sso login --profile %s` -- %w", p.profileName, err)
+	}
+
+	token := struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresAt   string `json:"expiresAt"`
+	}{}
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil || time.Now().After(expiresAt) {
+		return "", fmt.Errorf("The cached SSO token has expired, run `aws sso login --profile %s`", p.profileName)
+	}
+
+	return token.AccessToken, nil
+}
+
+func (p *ssoProvider) Retrieve() (credentials.Value, error) {
+	token, err := p.cachedToken()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	query := url.Values{
+		"account_id": []string{p.accountID},
+		"role_name":  []string{p.roleName},
+	}
+	req, err := http.NewRequest("GET", "https://portal.sso."+p.ssoRegion+".amazonaws.com/federation/credentials?"+query.Encode(), nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	req.Header.Set("x-amz-sso_bearer_token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return credentials.Value{}, fmt.Errorf("Unexpected SSO response status code: %s", resp.Status)
+	}
+
+	result := struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			Expiration      int64  `json:"expiration"`
+		} `json:"roleCredentials"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	rc := result.RoleCredentials
+	if rc.AccessKeyID == "" {
+		return credentials.Value{}, errors.New("SSO response contains no role credentials")
+	}
+
+	// The expiration is given in milliseconds since the epoch.
+	p.SetExpiration(time.Unix(0, rc.Expiration*int64(time.Millisecond)), time.Minute)
+
+	return credentials.Value{
+		AccessKeyID:     rc.AccessKeyID,
+		SecretAccessKey: rc.SecretAccessKey,
+		SessionToken:    rc.SessionToken,
+		ProviderName:    "SSOProvider(" + strconv.Quote(p.profileName) + ")",
+	}, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// Kinds of per-user directories, following the XDG base directory specification:
+// the cache holds data that can be downloaded again, the state holds the token cache,
+// the spool queue and resume files, and the config holds the user settings.
+const (
+	dirCache  = "cache"
+	dirState  = "state"
+	dirConfig = "config"
+)
+
+// errLocked is returned when a lock is held by another process and waiting was not requested.
+var errLocked = errors.New("Locked by another process")
+
+// userStateDir returns XDG_STATE_HOME, ~/.local/state on Unix systems, or the
+// configuration directory where the specification does not apply.
+func userStateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return dir, nil
+	}
+
+	switch runtime.GOOS {
+	case "windows", "darwin", "ios", "plan9":
+		return os.UserConfigDir()
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// appDir returns the named directory of this tool in the per-user directory of the given kind,
+// falling back to the temporary directory of the user when the latter is unavailable.
+func appDir(kind, name string) string {
+	var base string
+	var err error
+	switch kind {
+	case dirCache:
+		base, err = os.UserCacheDir()
+	case dirConfig:
+		base, err = os.UserConfigDir()
+	default:
+		base, err = userStateDir()
+	}
+
+	// Arbitrary UIDs often have no writable home directory.
+	if err != nil || os.MkdirAll(base, 0700) != nil || !dirWritable(base) {
+		return filepath.Join(tempAppDir(), name)
+	}
+
+	return filepath.Join(base, "hydra-s3-upload", name)
+}
+
+var (
+	tempAppDirOnce sync.Once
+	tempAppDirPath string
+)
+
+// tempAppDir returns the directory of this tool in the temporary directory, hydra-s3-upload-<uid>, which holds
+// the cached tokens and credentials of users without a home directory. The temporary directory is shared with
+// the other local users, so the directory is only used when it is owned by the user and private to them, and
+// not e.g. a symbolic link created by another user first. Otherwise a new private directory is used for the
+// run, and nothing is kept across runs.
+func tempAppDir() string {
+	tempAppDirOnce.Do(func() {
+		name := "hydra-s3-upload"
+		if uid := os.Getuid(); uid >= 0 {
+			name += "-" + strconv.Itoa(uid)
+		}
+		dir := filepath.Join(os.TempDir(), name)
+
+		err := privateUserDir(dir)
+		if err == nil {
+			tempAppDirPath = dir
+			return
+		}
+
+		klog.Warningf("Unable to use %s, nothing is cached across runs -- %v", dir, err)
+		tempAppDirPath, err = ioutil.TempDir("", name+"-")
+		if err != nil {
+			// Without any private directory, the state is written into a path that cannot be created.
+			tempAppDirPath = dir + string(filepath.Separator) + "unavailable"
+		}
+	})
+
+	return tempAppDirPath
+}
+
+// privateUserDir creates dir private to the user, or checks that the existing dir is.
+func privateUserDir(dir string) error {
+	err := os.Mkdir(dir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return checkPrivateDir(info)
+}
+
+// fileLock is an advisory lock on a file, shared by the processes using the same directories.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile locks the file at lockPath, creating it when needed. Unless wait is set,
+// errLocked is returned right away when another process holds the lock.
+func lockFile(lockPath string, wait bool) (*fileLock, error) {
+	err := os.MkdirAll(filepath.Dir(lockPath), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open lock file -- %w", err)
+	}
+
+	err = lockFD(f, wait)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) unlock() {
+	unlockFD(l.f)
+	l.f.Close()
+}
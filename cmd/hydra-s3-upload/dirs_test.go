@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPrivateUserDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The temporary directory is private to the user on Windows")
+	}
+
+	tests := []struct {
+		name  string
+		setup func(dir string) error
+		err   bool
+	}{
+		{name: "created", setup: func(string) error { return nil }},
+		{name: "private", setup: func(dir string) error { return os.Mkdir(dir, 0700) }},
+		{name: "accessible to others", setup: func(dir string) error {
+			err := os.Mkdir(dir, 0700)
+			if err != nil {
+				return err
+			}
+			return os.Chmod(dir, 0777)
+		}, err: true},
+		{name: "symbolic link", setup: func(dir string) error {
+			target := dir + "-target"
+			err := os.Mkdir(target, 0700)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dir)
+		}, err: true},
+		{name: "file", setup: func(dir string) error { return ioutil.WriteFile(dir, nil, 0600) }, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "dirs-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			dir := filepath.Join(tmp, "hydra-s3-upload-1000")
+			err = test.setup(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = privateUserDir(dir)
+			if (err != nil) != test.err {
+				t.Fatalf("Expected an error: %v, got %v", test.err, err)
+			}
+			if err == nil {
+				info, err := os.Lstat(dir)
+				if err != nil || !info.IsDir() || info.Mode().Perm()&0077 != 0 {
+					t.Errorf("Expected a private directory, got %v, %v", info.Mode(), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// gatherDirPrefix names the directories "oc adm must-gather" creates without --dest-dir.
+const gatherDirPrefix = "must-gather.local."
+
+// gatherCompleteMarker is written by "oc adm must-gather" once a gather has finished.
+const gatherCompleteMarker = "timestamp"
+
+// discoverGather returns the newest completed gather directory found in parent or its subdirectories.
+func discoverGather(parent string) (string, error) {
+	var candidates []string
+	for _, pattern := range []string{gatherDirPrefix + "*", filepath.Join("*", gatherDirPrefix+"*")} {
+		matches, err := filepath.Glob(filepath.Join(parent, pattern))
+		if err != nil {
+			return "", err
+		}
+		candidates = append(candidates, matches...)
+	}
+
+	var latest string
+	var latestTime time.Time
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		if !gatherComplete(candidate) {
+			klog.Infoln("Skipping incomplete gather", candidate)
+			continue
+		}
+
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest, latestTime = candidate, info.ModTime()
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("No completed %s* gather found in %s", gatherDirPrefix, parent)
+	}
+
+	return latest, nil
+}
+
+// gatherComplete reports whether the gather directory, or one of its per-image subdirectories, holds the completion marker.
+func gatherComplete(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, gatherCompleteMarker)); err == nil {
+		return true
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			if _, err := os.Stat(filepath.Join(dir, entry.Name(), gatherCompleteMarker)); err == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
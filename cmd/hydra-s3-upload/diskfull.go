@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// diskFullError explains a full filesystem met while writing an archive file.
+type diskFullError struct {
+	err error
+	// mount is the mount point of the filesystem that filled up.
+	mount string
+	// written is the size of the partial archive.
+	written int64
+	// estimated is the estimated archive size, 0 when unknown.
+	estimated int64
+	// available is the space left for unprivileged users, -1 when unknown.
+	available int64
+	// canStream suggests streaming the upload, when the options allow it but it was disabled.
+	canStream bool
+}
+
+func (e *diskFullError) Error() string {
+	msg := fmt.Sprintf("The filesystem at %s ran out of space after %s of the archive were written", e.mount, formatByteSize(e.written))
+	if e.estimated > 0 {
+		msg += fmt.Sprintf(", the archive needs about %s", formatByteSize(e.estimated))
+	}
+	if e.available >= 0 {
+		msg += fmt.Sprintf(" and %s are available", formatByteSize(e.available))
+	}
+
+	msg += ". Rerun with --tmp-dir on a filesystem with more space"
+	if e.canStream {
+		msg += " or without --no-stream to upload without a temporary archive file"
+	}
+
+	return msg + " -- " + e.err.Error()
+}
+
+func (e *diskFullError) Unwrap() error {
+	return e.err
+}
+
+// diagnoseDiskFull turns a full disk error met while writing the archive file f into a diskFullError,
+// telling how much space the archive needs compared to the available space. Other errors are returned as they are.
+func (o *options) diagnoseDiskFull(err error, f *os.File, srcDir string) error {
+	if !isDiskFull(err) {
+		return err
+	}
+
+	dir := filepath.Dir(f.Name())
+	e := &diskFullError{err: err, mount: mountPoint(dir), available: -1, canStream: !o.stream && o.canStream()}
+	if info, statErr := f.Stat(); statErr == nil {
+		e.written = info.Size()
+	}
+	if free, freeErr := diskFree(dir); freeErr == nil {
+		// The partial archive is removed, freeing its space again.
+		e.available = free + e.written
+	}
+	if srcDir != "" {
+		entries, scanErr := scanSizes(srcDir, func(name string) bool { return excludedName(o.excludes, name) }, o.codec.Extension() != "")
+		if scanErr == nil {
+			e.estimated = totalCompressed(entries)
+		}
+	}
+
+	return e
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestDiskFullError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *diskFullError
+		expected string
+	}{
+		{
+			name:     "unknown space",
+			err:      &diskFullError{err: syscall.ENOSPC, mount: "/tmp", written: 1 << 20, available: -1},
+			expected: "The filesystem at /tmp ran out of space after 1.0 MiB of the archive were written. Rerun with --tmp-dir on a filesystem with more space -- ",
+		},
+		{
+			name:     "streaming disabled",
+			err:      &diskFullError{err: syscall.ENOSPC, mount: "/", written: 1 << 20, estimated: 3 << 20, available: 2 << 20, canStream: true},
+			expected: "The filesystem at / ran out of space after 1.0 MiB of the archive were written, the archive needs about 3.0 MiB and 2.0 MiB are available. Rerun with --tmp-dir on a filesystem with more space or without --no-stream to upload without a temporary archive file -- ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if msg := test.err.Error(); !strings.HasPrefix(msg, test.expected) {
+				t.Errorf("Expected %q, got %q", test.expected, msg)
+			}
+			if !errors.Is(test.err, syscall.ENOSPC) {
+				t.Error("Expected the error to wrap ENOSPC")
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// diskFree is not supported, the available space is left out of the disk full errors.
+func diskFree(dir string) (int64, error) {
+	return 0, errors.New("Free space lookup is not supported")
+}
+
+func mountPoint(dir string) string {
+	return dir
+}
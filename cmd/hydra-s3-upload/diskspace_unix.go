@@ -0,0 +1,59 @@
+//go:build darwin || dragonfly || freebsd || linux
+// +build darwin dragonfly freebsd linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// diskFree returns the space of the filesystem of dir available to unprivileged users.
+func diskFree(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// mountPoint returns the top directory of the filesystem holding dir,
+// the last one up the tree that is still on the same device.
+func mountPoint(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return dir
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return dir
+	}
+
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		info, err := os.Stat(parent)
+		if err != nil {
+			return dir
+		}
+		if parentSt, ok := info.Sys().(*syscall.Stat_t); !ok || parentSt.Dev != st.Dev {
+			return dir
+		}
+		dir = parent
+	}
+}
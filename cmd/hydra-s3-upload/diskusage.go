@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// diskUsageWarnRatio is the fraction of a size limit above which a directory is reported as filling up.
+const diskUsageWarnRatio = 0.8
+
+// defaultStateMaxSize is the default limit of the state directory besides the spool queue,
+// which mostly holds small resume files, cached credentials and tokens.
+const defaultStateMaxSize = 1 << 30
+
+// usedFile is a file counted against the size limit of a directory.
+type usedFile struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// pruneDir evicts the least recently used files of dir, those that evictable accepts by their path relative
+// to dir, until the total size of the files is within maxSize. The files last modified the longest ago are
+// evicted first, so the users of a directory touch the files they reuse. A file is evicted with remove,
+// os.Remove when nil, which keeps a file still in use by returning errLocked. A warning is logged when the
+// directory fills up, or stays above the limit with the files that cannot be evicted. skip leaves out whole
+// subdirectories, e.g. ones with their own limit. The remaining total size is returned.
+func pruneDir(what, dir string, maxSize int64, evictable func(relPath string) bool, remove func(fullPath string) error, skip ...string) int64 {
+	if remove == nil {
+		remove = os.Remove
+	}
+
+	var candidates []usedFile
+	var total int64
+	err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files removed concurrently are no longer used.
+			return nil
+		}
+		if info.IsDir() {
+			if containsString(skip, fullPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		total += info.Size()
+		relPath, err := filepath.Rel(dir, fullPath)
+		if err == nil && info.Mode().IsRegular() && evictable(filepath.ToSlash(relPath)) {
+			candidates = append(candidates, usedFile{path: fullPath, size: info.Size(), used: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil || maxSize <= 0 {
+		return total
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].used.Before(candidates[j].used) })
+	var evicted int
+	var freed int64
+	for _, file := range candidates {
+		if total <= maxSize {
+			break
+		}
+		err := remove(file.path)
+		if err == errLocked {
+			klog.V(1).Infoln("Not evicting", file.path, "in use by another process")
+			continue
+		}
+		if err != nil {
+			klog.Warningln("Unable to evict", file.path, "--", err)
+			continue
+		}
+		klog.V(1).Infof("Evicted %s, last used %s", file.path, displayTime(file.used))
+		total -= file.size
+		freed += file.size
+		evicted++
+	}
+	if evicted > 0 {
+		klog.Warningf("%s %s exceeded its limit of %s, evicted the %d least recently used file(s) (%s)",
+			what, dir, formatByteSize(maxSize), evicted, formatByteSize(freed))
+	}
+
+	switch {
+	case total > maxSize:
+		klog.Warningf("%s %s uses %s, above its limit of %s, and holds no more files that can be evicted",
+			what, dir, formatByteSize(total), formatByteSize(maxSize))
+	case float64(total) > diskUsageWarnRatio*float64(maxSize):
+		klog.Warningf("%s %s uses %s of its %s limit", what, dir, formatByteSize(total), formatByteSize(maxSize))
+	}
+
+	return total
+}
+
+// abandonedUploadAge is the age after which the multipart upload of an evicted resume file is left to the
+// abort-incomplete-multipart-upload lifecycle rule of the bucket when it cannot be aborted, 7 days as most
+// buckets configure it.
+const abandonedUploadAge = 7 * 24 * time.Hour
+
+// pruneState evicts the least recently used resume files and cached credentials once the state directory,
+// not counting the spool queue, exceeds --state-max-size. The tokens, locks and key files are never evicted.
+// The multipart upload of an evicted resume file is aborted first, so that its parts do not stay stored.
+func (o *options) pruneState() {
+	if o.stateMaxSize <= 0 {
+		return
+	}
+
+	pruneDir("The state directory", appDir(dirState, ""), o.stateMaxSize, func(relPath string) bool {
+		switch {
+		case strings.HasPrefix(relPath, "uploads/"):
+			return strings.HasSuffix(relPath, ".json")
+		case strings.HasPrefix(relPath, "credentials/"):
+			return strings.HasSuffix(relPath, ".json.enc")
+		}
+		return false
+	}, func(fullPath string) error {
+		if filepath.Base(filepath.Dir(fullPath)) != "uploads" {
+			return os.Remove(fullPath)
+		}
+
+		err := o.abortStateUpload(fullPath)
+		if err != nil {
+			info, statErr := os.Stat(fullPath)
+			if statErr != nil || time.Since(info.ModTime()) < abandonedUploadAge {
+				return fmt.Errorf("Unable to abort its multipart upload -- %w", err)
+			}
+			klog.Warningf("Unable to abort the multipart upload of %s, leaving it to the bucket lifecycle -- %v", fullPath, err)
+		}
+		return os.Remove(fullPath)
+	}, appDir(dirState, "spool"))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"s3upload_test/internal/s3test"
+	"s3upload_test/pkg/hydra"
+)
+
+func TestPruneDir(t *testing.T) {
+	type testFile struct {
+		name string
+		size int
+		// age is how long ago the file was last used.
+		age time.Duration
+	}
+	files := []testFile{
+		{name: "a", size: 100, age: 3 * time.Hour},
+		{name: "b", size: 100, age: 2 * time.Hour},
+		{name: "c", size: 100, age: time.Hour},
+		{name: "c.lock", size: 0, age: 4 * time.Hour},
+		{name: "d.partial", size: 100, age: 5 * time.Hour},
+	}
+	skipSuffixes := func(relPath string) bool {
+		return !strings.HasSuffix(relPath, ".lock") && !strings.HasSuffix(relPath, ".partial")
+	}
+
+	tests := []struct {
+		name      string
+		maxSize   int64
+		evictable func(string) bool
+		// locked are the files in use by another process.
+		locked    []string
+		remaining []string
+		total     int64
+	}{
+		{name: "within the limit", maxSize: 400, evictable: skipSuffixes, remaining: []string{"a", "b", "c", "c.lock", "d.partial"}, total: 400},
+		{name: "least recently used first", maxSize: 300, evictable: skipSuffixes, remaining: []string{"b", "c", "c.lock", "d.partial"}, total: 300},
+		{name: "down to the unevictable files", maxSize: 50, evictable: skipSuffixes, remaining: []string{"c.lock", "d.partial"}, total: 100},
+		{name: "locked files kept", maxSize: 250, evictable: skipSuffixes, locked: []string{"a"}, remaining: []string{"a", "c.lock", "d.partial"}, total: 200},
+		{name: "unlimited", maxSize: 0, evictable: skipSuffixes, remaining: []string{"a", "b", "c", "c.lock", "d.partial"}, total: 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "prune-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for _, file := range files {
+				filePath := filepath.Join(dir, file.name)
+				err = ioutil.WriteFile(filePath, make([]byte, file.size), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				used := time.Now().Add(-file.age)
+				os.Chtimes(filePath, used, used)
+			}
+
+			total := pruneDir("The test directory", dir, test.maxSize, test.evictable, func(fullPath string) error {
+				if containsString(test.locked, filepath.Base(fullPath)) {
+					return errLocked
+				}
+				return os.Remove(fullPath)
+			})
+
+			infos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var remaining []string
+			for _, info := range infos {
+				remaining = append(remaining, info.Name())
+			}
+			sort.Strings(remaining)
+			if !reflect.DeepEqual(remaining, test.remaining) || total != test.total {
+				t.Errorf("Expected %v of %d bytes to remain, got %v of %d bytes", test.remaining, test.total, remaining, total)
+			}
+		})
+	}
+}
+
+func TestEvictCacheEntrySkipsLockedEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	entry := filepath.Join(dir, "sha256-abc")
+	err = ioutil.WriteFile(entry, []byte("archive"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := lockFile(entry+".lock", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = evictCacheEntry(entry)
+	lock.unlock()
+	if err != errLocked {
+		t.Errorf("Expected the locked entry to be kept, got %v", err)
+	}
+	if _, err := os.Stat(entry); err != nil {
+		t.Fatalf("Expected the locked entry to be kept, got %v", err)
+	}
+
+	err = evictCacheEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(entry); !os.IsNotExist(err) {
+		t.Errorf("Expected the unlocked entry to be evicted, got %v", err)
+	}
+}
+
+func TestPruneStateAbortsEvictedUploads(t *testing.T) {
+	tests := []struct {
+		name string
+		// credsErr fails the credentials request of the abort.
+		credsErr error
+		// age is how long ago the upload state was last saved.
+		age     time.Duration
+		evicted bool
+		aborted bool
+	}{
+		{name: "aborted", evicted: true, aborted: true},
+		{name: "recent upload kept when the abort fails", credsErr: errors.New("Hydra unavailable")},
+		{name: "abandoned upload left to the lifecycle", credsErr: errors.New("Hydra unavailable"), age: 8 * 24 * time.Hour, evicted: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, archivePath, _, cleanup := setupResumableTest(t)
+			defer cleanup()
+			s3 := s3test.NewServer()
+			defer s3.Close()
+			h := newFakeHydra(s3)
+			defer h.Close()
+
+			// The upload fails at the second part, leaving the multipart upload open.
+			s3.FailPart = 2
+			err := uploadTestArchive(t, archivePath, "first.tar.gz", h.URL, s3.URL)
+			if err == nil {
+				t.Fatal("Expected the upload to fail")
+			}
+			statePath := testStatePath(t, archivePath)
+			used := time.Now().Add(-test.age)
+			os.Chtimes(statePath, used, used)
+
+			request := hydraCreds(hydra.Request{IsPrivate: "false"})
+			opts := &options{stateMaxSize: 1, credsSource: func(name string, piece, count int64, key string) (*credsResponse, error) {
+				if test.credsErr != nil {
+					return nil, test.credsErr
+				}
+				c, err := request(name, piece, count, key)
+				if err == nil {
+					c.session = s3test.Session(s3.URL, c.AWSCredentials())
+				}
+				return c, err
+			}}
+			opts.pruneState()
+
+			if _, err := os.Stat(statePath); os.IsNotExist(err) != test.evicted {
+				t.Errorf("Expected the state file to be evicted: %v, got %v", test.evicted, err)
+			}
+			open := s3.OpenUploads()
+			if aborted := open == 0; aborted != test.aborted {
+				t.Errorf("Expected the multipart upload to be aborted: %v, %d upload(s) open", test.aborted, open)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"k8s.io/klog"
+)
+
+// metadataValue looks up user metadata case-insensitively, since S3 returns canonicalized header names.
+func metadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+
+	return ""
+}
+
+// downloadCacheKey derives the content address of an object, preferring the archive checksum
+// stored at upload time over the ETag.
+func downloadCacheKey(head *s3.HeadObjectOutput) string {
+	if algorithm, checksum := metadataChecksum(head.Metadata); checksum != "" {
+		return algorithm + "-" + sanitizeName(checksum)
+	}
+
+	return "etag-" + sanitizeName(strings.Trim(aws.StringValue(head.ETag), `"`))
+}
+
+// fetchObject downloads the object into dest. An interrupted download left in dest is resumed
+// with a range request as long as the object still has the same ETag.
+func fetchObject(client *s3.S3, bucket, key string, head *s3.HeadObjectOutput, dest string) error {
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset, size := info.Size(), aws.Int64Value(head.ContentLength)
+	if offset == size {
+		return nil
+	}
+	if offset > size {
+		return fmt.Errorf("Partial download %s is larger than the object", dest)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		IfMatch: head.ETag,
+	}
+	if offset > 0 {
+		klog.Infof("Resuming download at byte %d of %d", offset, size)
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	obj, err := client.GetObject(input)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	_, err = io.Copy(f, obj.Body)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// copyFile copies src to dst. Download cache entries are never hard-linked to the output,
+// where changes of the output would corrupt the cached object.
+func copyFile(src, dst string) error {
+	os.Remove(dst)
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// evictCacheEntry removes a download cache entry unless another process holds its lock.
+func evictCacheEntry(entryPath string) error {
+	lock, err := lockFile(entryPath+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	return os.Remove(entryPath)
+}
+
+func runDownload(args []string) {
+	flags := flag.NewFlagSet(commandName()+" download", flag.ExitOnError)
+	key := flags.String("key", "", "Key of the object to download (required)")
+	out := flags.String("out", "", "Output file (defaults to the base name of the key)")
+	cacheDir := flags.String("cache-dir", appDir(dirCache, "downloads"), "Directory of the content-addressed download cache")
+	cacheMax := byteSize(10 << 30)
+	flags.Var(&cacheMax, "cache-max-size", "Maximum size of the download cache, above which the least recently used objects are evicted (0 = unlimited)")
+	noCache := flags.Bool("no-cache", false, "Always download the object and request fresh credentials, bypassing the download and credentials caches")
+	role := addAssumeRoleFlags(flags)
+	addAuthFlags(flags)
+	flags.Parse(args)
+
+	err := applyTLSConfig()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	if *key == "" {
+		klog.Fatalln("Missing required --key flag")
+	}
+	credsCacheDisabled = *noCache
+	if *out == "" {
+		*out = path.Base(*key)
+	}
+
+	klog.Infoln("Requesting AWS S3 read credentials from Hydra...")
+	creds, err := requestReadCreds(*key)
+	if err != nil {
+		klog.Fatalln("Credentials request failed --", err)
+	} else {
+		klog.Infoln("S3 credentials received")
+	}
+
+	s, err := creds.createSession(role)
+	if err != nil {
+		klog.Fatalln("Unable to create AWS session --", err)
+	}
+	client := s3.New(s)
+
+	head, err := client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(creds.BucketName),
+		Key:    aws.String(*key),
+	})
+	if err != nil {
+		klog.Fatalln("Unable to look up object --", err)
+	}
+
+	// Without the cache, the object is downloaded next to the output file.
+	cached := filepath.Join(*cacheDir, downloadCacheKey(head))
+	if *noCache {
+		cached = *out + ".download"
+	} else {
+		err = os.MkdirAll(*cacheDir, 0700)
+		if err != nil {
+			klog.Fatalln("Unable to create cache directory --", err)
+		}
+
+		// Concurrent downloads of the same object wait for the first one to fill the cache.
+		lock, err := lockFile(cached+".lock", true)
+		if err != nil {
+			klog.Fatalln("Unable to lock cache entry --", err)
+		}
+		defer lock.unlock()
+	}
+
+	if _, err := os.Stat(cached); err == nil && !*noCache {
+		klog.Infoln("Serving object from the download cache --", cached)
+		// Mark the object as recently used for the eviction.
+		now := time.Now()
+		os.Chtimes(cached, now, now)
+	} else {
+		klog.Infoln("Downloading object...")
+		partial := cached + ".partial"
+		err = fetchObject(client, creds.BucketName, *key, head, partial)
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 412 {
+			// The object changed since the partial download, start over.
+			os.Remove(partial)
+			err = fetchObject(client, creds.BucketName, *key, head, partial)
+		}
+		if err != nil {
+			klog.Fatalln("Could not download object --", err)
+		}
+
+		// Never cache content that does not match the checksum recorded at upload time.
+		if algorithm, expected := metadataChecksum(head.Metadata); expected != "" {
+			checksum, err := fileChecksum(partial, algorithm)
+			if err != nil {
+				klog.Fatalln("Unable to compute checksum --", err)
+			} else if checksum != expected {
+				os.Remove(partial)
+				klog.Fatalf("Checksum mismatch, expected %s but got %s", expected, checksum)
+			}
+		}
+
+		err = os.Rename(partial, cached)
+		if err != nil {
+			klog.Fatalln("Unable to finalize download --", err)
+		}
+		klog.Infoln("Object downloaded")
+	}
+
+	if *noCache {
+		err = os.Rename(cached, *out)
+	} else {
+		err = copyFile(cached, *out)
+	}
+	if err != nil {
+		klog.Fatalln("Unable to write output file --", err)
+	}
+	klog.Infoln("Object saved to", *out)
+	if auditHead := metadataValue(head.Metadata, "audit-head"); auditHead != "" {
+		klog.Infof("The upload is recorded at %s in the audit log of the uploader, verify it with: %s verify-archive --manifest --audit-log <log> --audit-head %s %s",
+			auditHead, commandName(), auditHead, *out)
+	}
+
+	if !*noCache {
+		// Partial downloads and the entries locked by other processes are still being used.
+		pruneDir("The download cache", *cacheDir, int64(cacheMax), func(relPath string) bool {
+			return !strings.HasSuffix(relPath, ".lock") && !strings.HasSuffix(relPath, ".partial") && filepath.Join(*cacheDir, relPath) != cached
+		}, evictCacheEntry)
+	}
+}
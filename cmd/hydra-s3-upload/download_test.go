@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"s3upload_test/pkg/hydra"
+)
+
+func TestRequestReadCreds(t *testing.T) {
+	oldHydra, oldDownload, oldCache := os.Getenv(envHydraURL), os.Getenv(envHydraDownloadURL), credsCacheDisabled
+	defer func() {
+		os.Setenv(envHydraURL, oldHydra)
+		os.Setenv(envHydraDownloadURL, oldDownload)
+		credsCacheDisabled = oldCache
+	}()
+	credsCacheDisabled = true
+
+	// The upload credentials endpoint must not be asked, it would register a new attachment.
+	uploads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Requested upload credentials for a download")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer uploads.Close()
+	os.Setenv(envHydraURL, uploads.URL)
+
+	var requested hydra.ReadRequest
+	downloads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&requested)
+		json.NewEncoder(w).Encode(&hydra.Credentials{BucketName: "bucket", AccessKey: "AK", SecretKey: "secret", Region: "us-east-1", Key: requested.Key})
+	}))
+	defer downloads.Close()
+
+	os.Setenv(envHydraDownloadURL, "")
+	if _, err := requestReadCreds("attachments/must-gather.tar.gz"); err == nil {
+		t.Error("Expected an error without the download endpoint")
+	}
+
+	os.Setenv(envHydraDownloadURL, downloads.URL)
+	creds, err := requestReadCreds("attachments/must-gather.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested.Key != "attachments/must-gather.tar.gz" || creds.BucketName != "bucket" {
+		t.Errorf("Unexpected request %+v or credentials %+v", requested, creds)
+	}
+}
+
+func TestCopyFileKeepsCacheEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cached, out := filepath.Join(dir, "sha256-abc"), filepath.Join(dir, "must-gather.tar.gz")
+	err = ioutil.WriteFile(cached, []byte("archive"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = copyFile(cached, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes of the output must not reach the cache entry served to later downloads.
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(" modified")
+	f.Close()
+	if content, err := ioutil.ReadFile(cached); err != nil || string(content) != "archive" {
+		t.Errorf("Expected the cache entry unchanged, got %q, %v", content, err)
+	}
+}
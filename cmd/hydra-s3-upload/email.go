@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// emailNotifier emails upload receipts through an SMTP server.
+// SMTP_USER and SMTP_PASS authenticate against the server when set.
+type emailNotifier struct {
+	server string
+	from   string
+	to     stringList
+}
+
+func addEmailFlags(flags *flag.FlagSet) *emailNotifier {
+	e := &emailNotifier{}
+	flags.StringVar(&e.server, "smtp-server", "", "SMTP server (host:port) sending the upload receipts")
+	flags.StringVar(&e.from, "email-from", "", "Sender address of the upload receipts")
+	flags.Var(&e.to, "email-to", "Recipient of an upload receipt email (repeatable)")
+
+	return e
+}
+
+func (e *emailNotifier) enabled() bool {
+	return len(e.to) > 0
+}
+
+func (e *emailNotifier) validate() error {
+	if e.server == "" || e.from == "" {
+		return fmt.Errorf("The --email-to flag requires --smtp-server and --email-from")
+	}
+
+	return nil
+}
+
+func (e *emailNotifier) send(subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		host, _, err := net.SplitHostPort(e.server)
+		if err != nil {
+			return fmt.Errorf("Invalid SMTP server address -- %w", err)
+		}
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)
+	}
+
+	return smtp.SendMail(e.server, auth, e.from, e.to, msg.Bytes())
+}
+
+func (e *emailNotifier) notify(r *uploadReceipt) error {
+	return e.send(trf("Upload receipt for case %s", r.Case), r.String())
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"k8s.io/klog"
+)
+
+// envelopeFormat identifies the encrypted archive layout: a JSON envelope header line
+// followed by AES-256-GCM sealed chunks of envelopeChunkSize plaintext bytes.
+const (
+	envelopeFormat    = "hsu-envelope-v1"
+	envelopeChunkSize = 64 * 1024
+)
+
+// envelopeHeader describes how the data key of an encrypted archive is wrapped.
+type envelopeHeader struct {
+	Format     string `json:"format"`
+	Provider   string `json:"provider"`
+	KeyID      string `json:"keyId"`
+	WrappedKey string `json:"wrappedKey"`
+	Nonce      string `json:"nonce"`
+}
+
+// keyWrapper generates data keys wrapped by a key that never leaves the key management service.
+type keyWrapper interface {
+	provider() string
+	keyID() string
+	// generateDataKey returns a fresh 256-bit data key in plaintext and in wrapped form.
+	generateDataKey() (plaintext, wrapped []byte, err error)
+	unwrapDataKey(wrapped []byte) ([]byte, error)
+}
+
+// kmsKeyWrapper wraps data keys with an AWS KMS key, using the ambient AWS credentials
+// rather than the upload credentials issued by Hydra.
+type kmsKeyWrapper struct {
+	client *kms.KMS
+	key    string
+}
+
+func newKMSKeyWrapper(key, region string) (*kmsKeyWrapper, error) {
+	// Key ARNs carry their region, e.g. arn:aws:kms:eu-west-1:123456789012:key/...
+	if parts := strings.Split(key, ":"); region == "" && len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+
+	config := aws.Config{}
+	if region != "" {
+		config.Region = aws.String(region)
+	}
+
+	s, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &kmsKeyWrapper{client: kms.New(s), key: key}, nil
+}
+
+func (k *kmsKeyWrapper) provider() string {
+	return "kms"
+}
+
+func (k *kmsKeyWrapper) keyID() string {
+	return k.key
+}
+
+func (k *kmsKeyWrapper) generateDataKey() ([]byte, []byte, error) {
+	out, err := k.client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(k.key),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+func (k *kmsKeyWrapper) unwrapDataKey(wrapped []byte) ([]byte, error) {
+	out, err := k.client.Decrypt(&kms.DecryptInput{
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Plaintext, nil
+}
+
+// vaultKeyWrapper wraps data keys with a Vault transit key. The server address and token
+// are taken from the standard VAULT_ADDR, VAULT_TOKEN, and VAULT_NAMESPACE variables.
+type vaultKeyWrapper struct {
+	addr      string
+	token     string
+	namespace string
+	key       string
+}
+
+func newVaultKeyWrapper(key string) (*vaultKeyWrapper, error) {
+	v := &vaultKeyWrapper{
+		addr:      strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"),
+		token:     os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		key:       key,
+	}
+	if v.addr == "" || v.token == "" {
+		return nil, errors.New("VAULT_ADDR and VAULT_TOKEN must be set to use a Vault transit key")
+	}
+
+	return v, nil
+}
+
+func (v *vaultKeyWrapper) provider() string {
+	return "vault"
+}
+
+func (v *vaultKeyWrapper) keyID() string {
+	return v.key
+}
+
+// call posts a request to the transit secrets engine and decodes the data of the response.
+func (v *vaultKeyWrapper) call(operation string, reqData, respData interface{}) error {
+	body, err := json.Marshal(reqData)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", v.addr+"/v1/transit/"+operation+"/"+v.key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected Vault response status code: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(&struct {
+		Data interface{} `json:"data"`
+	}{respData})
+}
+
+func (v *vaultKeyWrapper) generateDataKey() ([]byte, []byte, error) {
+	data := struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}{}
+
+	err := v.call("datakey/plaintext", map[string]int{"bits": 256}, &data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(data.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plaintext, []byte(data.Ciphertext), nil
+}
+
+func (v *vaultKeyWrapper) unwrapDataKey(wrapped []byte) ([]byte, error) {
+	data := struct {
+		Plaintext string `json:"plaintext"`
+	}{}
+
+	err := v.call("decrypt", map[string]string{"ciphertext": string(wrapped)}, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(data.Plaintext)
+}
+
+// newKeyWrapper returns the wrapper of the selected key, or nil when encryption is disabled.
+func newKeyWrapper(kmsKey, kmsRegion, vaultKey string) (keyWrapper, error) {
+	switch {
+	case kmsKey != "" && vaultKey != "":
+		return nil, errors.New("Only one of --kms-key-id and --vault-transit-key can be used")
+	case kmsKey != "":
+		return newKMSKeyWrapper(kmsKey, kmsRegion)
+	case vaultKey != "":
+		return newVaultKeyWrapper(vaultKey)
+	default:
+		return nil, nil
+	}
+}
+
+// chunkNonce derives the nonce of a chunk by mixing its index into the base nonce.
+func chunkNonce(base []byte, index uint64) []byte {
+	nonce := append([]byte(nil), base...)
+	counter := binary.BigEndian.Uint64(nonce[len(nonce)-8:]) ^ index
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+
+	return nonce
+}
+
+// chunkAAD marks the last chunk, so that a truncated archive fails to decrypt.
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptingWriter seals everything written into it chunk by chunk.
+type encryptingWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	index uint64
+	buf   []byte
+}
+
+// newEncryptingWriter generates a data key, writes the envelope header into w, and returns
+// a writer encrypting into w. Close must be called to write the final chunk.
+func newEncryptingWriter(w io.Writer, wrapper keyWrapper) (io.WriteCloser, error) {
+	key, wrapped, err := wrapper.generateDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate data key -- %w", err)
+	}
+
+	aead, err := newGCM(key)
+	// Drop the plaintext data key as soon as the cipher is set up.
+	for i := range key {
+		key[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(&envelopeHeader{
+		Format:     envelopeFormat,
+		Provider:   wrapper.provider(),
+		KeyID:      wrapper.keyID(),
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(append(header, '\n'))
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{w: w, aead: aead, nonce: nonce}, nil
+}
+
+func (e *encryptingWriter) seal(chunk []byte, final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.nonce, e.index), chunk, chunkAAD(final))
+	e.index++
+
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+
+	// Keep at least one byte buffered so that the last chunk is only sealed on Close.
+	for len(e.buf) > envelopeChunkSize {
+		err := e.seal(e.buf[:envelopeChunkSize], false)
+		if err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[envelopeChunkSize:]
+	}
+
+	return len(p), nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+// decryptArchive reads an encrypted archive from r, unwraps its data key with the
+// matching key management service, and writes the plaintext into w.
+func decryptArchive(r io.Reader, w io.Writer, kmsRegion string) error {
+	br := bufio.NewReaderSize(r, envelopeChunkSize*2)
+
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("Unable to read envelope header -- %w", err)
+	}
+
+	header := &envelopeHeader{}
+	err = json.Unmarshal(line, header)
+	if err != nil || header.Format != envelopeFormat {
+		return errors.New("Not an encrypted archive")
+	}
+
+	var wrapper keyWrapper
+	switch header.Provider {
+	case "kms":
+		wrapper, err = newKMSKeyWrapper(header.KeyID, kmsRegion)
+	case "vault":
+		wrapper, err = newVaultKeyWrapper(header.KeyID)
+	default:
+		err = fmt.Errorf("Unsupported key provider %q", header.Provider)
+	}
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(header.WrappedKey)
+	if err != nil {
+		return err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(header.Nonce)
+	if err != nil {
+		return err
+	}
+
+	key, err := wrapper.unwrapDataKey(wrapped)
+	if err != nil {
+		return fmt.Errorf("Unable to unwrap data key -- %w", err)
+	}
+	aead, err := newGCM(key)
+	for i := range key {
+		key[i] = 0
+	}
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]byte, envelopeChunkSize+aead.Overhead())
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(br, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("Encrypted archive is truncated -- %w", err)
+		}
+
+		// The last chunk is short or followed by the end of the stream.
+		_, peekErr := br.Peek(1)
+		final := err == io.ErrUnexpectedEOF || peekErr == io.EOF
+
+		chunk, err := aead.Open(nil, chunkNonce(nonce, index), sealed[:n], chunkAAD(final))
+		if err != nil {
+			return errors.New("Encrypted archive is corrupted or truncated")
+		}
+
+		_, err = w.Write(chunk)
+		if err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+func runDecrypt(args []string) {
+	flags := flag.NewFlagSet(commandName()+" decrypt", flag.ExitOnError)
+	in := flags.String("in", "", "Encrypted archive to decrypt (required)")
+	out := flags.String("out", "", "Output file (defaults to the input without its .enc suffix)")
+	kmsRegion := flags.String("kms-region", "", "Region of the KMS key when it is not given as an ARN")
+	flags.Parse(args)
+
+	if *in == "" {
+		klog.Fatalln("Missing required --in flag")
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(*in, ".enc")
+		if *out == *in {
+			*out += ".dec"
+		}
+	}
+
+	inFile, err := os.Open(*in)
+	if err != nil {
+		klog.Fatalln("Unable to open encrypted archive --", err)
+	}
+	defer inFile.Close()
+
+	outFile, err := os.Create(*out)
+	if err != nil {
+		klog.Fatalln("Unable to create output file --", err)
+	}
+	defer outFile.Close()
+
+	klog.Infoln("Decrypting archive...")
+	err = decryptArchive(inFile, outFile, *kmsRegion)
+	if err != nil {
+		os.Remove(*out)
+		klog.Fatalln("Unable to decrypt archive --", err)
+	}
+	klog.Infoln("Archive decrypted to", *out)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newFakeVault serves the transit datakey and decrypt operations of Vault, wrapping data keys by prefixing them.
+func newFakeVault() func() {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/transit/datakey/plaintext/archives":
+			key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": key, "ciphertext": "vault:v1:" + key}})
+		case "/v1/transit/decrypt/archives":
+			req := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": strings.TrimPrefix(req["ciphertext"], "vault:v1:")}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	oldAddr, oldToken := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	os.Setenv("VAULT_ADDR", vault.URL)
+	os.Setenv("VAULT_TOKEN", "token")
+	return func() {
+		os.Setenv("VAULT_ADDR", oldAddr)
+		os.Setenv("VAULT_TOKEN", oldToken)
+		vault.Close()
+	}
+}
+
+// encryptTestData encrypts data with the transit key of the fake Vault, in writes of at most writeSize bytes.
+func encryptTestData(t *testing.T, data []byte, writeSize int) []byte {
+	wrapper, err := newVaultKeyWrapper("archives")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted bytes.Buffer
+	w, err := newEncryptingWriter(&encrypted, wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(data) > 0 {
+		n := writeSize
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err = w.Write(data[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted.Bytes()
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	cleanup := newFakeVault()
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		size      int
+		writeSize int
+	}{
+		{name: "empty", size: 0, writeSize: 1},
+		{name: "single byte", size: 1, writeSize: 1},
+		{name: "exactly one chunk", size: envelopeChunkSize, writeSize: 1000},
+		{name: "one chunk and a byte", size: envelopeChunkSize + 1, writeSize: envelopeChunkSize + 1},
+		{name: "several chunks in small writes", size: 3*envelopeChunkSize + 100, writeSize: 4096},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := make([]byte, test.size)
+			rand.New(rand.NewSource(1)).Read(data)
+			encrypted := encryptTestData(t, data, test.writeSize)
+
+			header := &envelopeHeader{}
+			err := json.Unmarshal(encrypted[:bytes.IndexByte(encrypted, '\n')], header)
+			if err != nil || header.Format != envelopeFormat || header.Provider != "vault" || header.KeyID != "archives" {
+				t.Fatalf("Unexpected envelope header %+v, %v", header, err)
+			}
+
+			var decrypted bytes.Buffer
+			err = decryptArchive(bytes.NewReader(encrypted), &decrypted, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), data) {
+				t.Error("The decrypted archive does not match the data")
+			}
+		})
+	}
+}
+
+func TestEnvelopeRejectsTampering(t *testing.T) {
+	cleanup := newFakeVault()
+	defer cleanup()
+
+	data := make([]byte, 2*envelopeChunkSize+10)
+	rand.New(rand.NewSource(1)).Read(data)
+	encrypted := encryptTestData(t, data, len(data))
+	headerEnd := bytes.IndexByte(encrypted, '\n') + 1
+	sealedChunk := envelopeChunkSize + 16
+
+	tests := []struct {
+		name   string
+		tamper func(encrypted []byte) []byte
+	}{
+		{name: "not encrypted", tamper: func([]byte) []byte { return []byte("plain tar\n") }},
+		{name: "flipped byte", tamper: func(encrypted []byte) []byte {
+			encrypted[headerEnd+10] ^= 1
+			return encrypted
+		}},
+		{name: "last chunk dropped", tamper: func(encrypted []byte) []byte { return encrypted[:headerEnd+2*sealedChunk] }},
+		{name: "chunk truncated", tamper: func(encrypted []byte) []byte { return encrypted[:headerEnd+sealedChunk+100] }},
+		{name: "chunks swapped", tamper: func(encrypted []byte) []byte {
+			swapped := append([]byte(nil), encrypted[:headerEnd]...)
+			swapped = append(swapped, encrypted[headerEnd+sealedChunk:headerEnd+2*sealedChunk]...)
+			swapped = append(swapped, encrypted[headerEnd:headerEnd+sealedChunk]...)
+			return append(swapped, encrypted[headerEnd+2*sealedChunk:]...)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := test.tamper(append([]byte(nil), encrypted...))
+			err := decryptArchive(bytes.NewReader(tampered), &bytes.Buffer{}, "")
+			if err == nil {
+				t.Error("Expected the tampered archive to fail decrypting")
+			}
+		})
+	}
+}
+
+func TestChunkNonce(t *testing.T) {
+	base := bytes.Repeat([]byte{0xff}, 12)
+	seen := map[string]bool{}
+	for index := uint64(0); index < 1000; index++ {
+		nonce := string(chunkNonce(base, index))
+		if seen[nonce] {
+			t.Fatalf("The nonce of chunk %d repeats", index)
+		}
+		seen[nonce] = true
+	}
+	if !bytes.Equal(chunkNonce(base, 0), base) {
+		t.Error("Expected the first chunk to use the base nonce")
+	}
+}
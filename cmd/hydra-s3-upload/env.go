@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Environment variables of the tool. The Hydra settings are also read from their legacy HYDRA_ names.
+const (
+	envHydraURL             = "HSU_HYDRA_URL"
+	envHydraHealthURL       = "HSU_HYDRA_HEALTH_URL"
+	envHydraTokenURL        = "HSU_HYDRA_TOKEN_URL"
+	envHydraCompleteURL     = "HSU_HYDRA_COMPLETE_URL"
+	envHydraUser            = "HSU_HYDRA_USER"
+	envHydraPass            = "HSU_HYDRA_PASS"
+	envHydraOfflineToken    = "HSU_HYDRA_OFFLINE_TOKEN"
+	envHydraAuthMethod      = "HSU_HYDRA_AUTH_METHOD"
+	envHydraResponseMapping = "HSU_HYDRA_RESPONSE_MAPPING"
+	envHydraLoginURL        = "HSU_HYDRA_LOGIN_URL"
+	envHydraCSRFHeader      = "HSU_HYDRA_CSRF_HEADER"
+	envHydraCSRFCookie      = "HSU_HYDRA_CSRF_COOKIE"
+	envHydraCaseURL         = "HSU_HYDRA_CASE_URL"
+	envHydraDownloadURL     = "HSU_HYDRA_DOWNLOAD_URL"
+	envTimezone             = "HSU_TIMEZONE"
+	envContext              = "HSU_CONTEXT"
+	envProxy                = "HSU_PROXY"
+	envServeToken           = "HSU_SERVE_TOKEN"
+	envSrcDir               = "HSU_SRC_DIR"
+	envArchiveName          = "HSU_ARCHIVE_NAME"
+	envKeepArchive          = "HSU_KEEP_ARCHIVE"
+	// Set by the tool for the filter commands and the webhook gather commands it runs.
+	envFilterPath = "HSU_FILTER_PATH"
+	envGatherDir  = "HSU_GATHER_DIR"
+)
+
+// legacyEnv holds the legacy names of the variables, accepted when the variables themselves are unset.
+var legacyEnv = map[string]string{
+	envHydraURL:             "HYDRA_URL",
+	envHydraHealthURL:       "HYDRA_HEALTH_URL",
+	envHydraTokenURL:        "HYDRA_TOKEN_URL",
+	envHydraCompleteURL:     "HYDRA_COMPLETE_URL",
+	envHydraUser:            "HYDRA_USER",
+	envHydraPass:            "HYDRA_PASS",
+	envHydraOfflineToken:    "HYDRA_OFFLINE_TOKEN",
+	envHydraAuthMethod:      "HYDRA_AUTH_METHOD",
+	envHydraResponseMapping: "HYDRA_RESPONSE_MAPPING",
+}
+
+// knownEnv lists every HSU_ variable, so that misspelled ones are reported rather than ignored.
+var knownEnv = []string{
+	envHydraURL, envHydraHealthURL, envHydraTokenURL, envHydraCompleteURL, envHydraUser, envHydraPass,
+	envHydraOfflineToken, envHydraAuthMethod, envHydraResponseMapping, envHydraLoginURL, envHydraCSRFHeader,
+	envHydraCSRFCookie, envHydraCaseURL, envHydraDownloadURL, envTimezone, envContext, envProxy, envServeToken,
+	envSrcDir, envArchiveName, envKeepArchive, envFilterPath, envGatherDir,
+}
+
+// boolEnv lists the variables holding booleans, such as "true" or "1".
+var boolEnv = []string{envKeepArchive}
+
+// urlEnv lists the variables holding URLs.
+var urlEnv = []string{envHydraURL, envHydraHealthURL, envHydraTokenURL, envHydraCompleteURL, envHydraLoginURL, envHydraCaseURL, envHydraDownloadURL}
+
+// getenv returns the value of the variable, or of its legacy name when the variable is unset.
+func getenv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	if legacy, ok := legacyEnv[name]; ok {
+		return os.Getenv(legacy)
+	}
+
+	return ""
+}
+
+// envDefault returns the value of the variable, or fallback when it is unset.
+func envDefault(name, fallback string) string {
+	if value := getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// envBool returns the boolean value of the variable, false when it is unset. The value is checked by validateEnv.
+func envBool(name string) bool {
+	value, _ := strconv.ParseBool(getenv(name))
+	return value
+}
+
+// envSource returns the name of the variable the value of getenv comes from, empty when unset.
+func envSource(name string, env map[string]string) string {
+	if env[name] != "" {
+		return name
+	}
+	if legacy, ok := legacyEnv[name]; ok && env[legacy] != "" {
+		return legacy
+	}
+
+	return ""
+}
+
+// validateEnv checks the environment variables of the tool before they are used, and reports
+// the unknown HSU_ variables and the legacy names overridden by the HSU_ ones.
+func validateEnv() error {
+	var unknown []string
+	for _, item := range os.Environ() {
+		name := item[:strings.IndexByte(item+"=", '=')]
+		if strings.HasPrefix(name, "HSU_") && !containsString(knownEnv, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		klog.Warningln("Ignoring unknown environment variables:", strings.Join(unknown, ", "))
+	}
+
+	for name, legacy := range legacyEnv {
+		value, legacyValue := os.Getenv(name), os.Getenv(legacy)
+		if value != "" && legacyValue != "" && value != legacyValue {
+			klog.Warningf("%s overrides the legacy %s", name, legacy)
+		} else if value == "" && legacyValue != "" {
+			klog.V(1).Infof("%s is deprecated, use %s instead", legacy, name)
+		}
+	}
+
+	for _, name := range urlEnv {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute URL, got %q", envSource(name, launchEnv), value)
+		}
+	}
+
+	for _, name := range boolEnv {
+		if value := os.Getenv(name); value != "" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("%s must be a boolean, got %q", name, value)
+			}
+		}
+	}
+
+	if proxy := os.Getenv(envProxy); proxy != "" {
+		if _, err := parseProxy(proxy); err != nil {
+			return fmt.Errorf("%s -- %w", envProxy, err)
+		}
+	}
+
+	if name := os.Getenv(envTimezone); name != "" {
+		if _, err := time.LoadLocation(name); err != nil {
+			return fmt.Errorf("%s must be UTC, Local or a timezone name such as Europe/Prague, got %q", envTimezone, name)
+		}
+	}
+
+	// The authentication method may also come from the selected context or the central configuration.
+	if method := hydraAuthMethod; method != "" && !containsString(authMethods, method) {
+		source := "Hydra authentication method of the context or the central configuration"
+		if getenv(envHydraAuthMethod) == method {
+			source = envSource(envHydraAuthMethod, launchEnv)
+		}
+		return fmt.Errorf("Unsupported %s %q, expected one of: %s", source, method, strings.Join(authMethods, ", "))
+	}
+
+	return nil
+}
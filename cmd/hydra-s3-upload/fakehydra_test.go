@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"s3upload_test/internal/s3test"
+	"s3upload_test/pkg/hydra"
+)
+
+// fakeHydra issues credentials scoped to "attachments/<fileName>" in the fake S3, or to the requested key.
+type fakeHydra struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []hydra.Request
+}
+
+func newFakeHydra(s3 *s3test.Server) *fakeHydra {
+	h := &fakeHydra{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := hydra.Request{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		h.mu.Lock()
+		h.requests = append(h.requests, req)
+		accessKey := "AK" + strconv.Itoa(len(h.requests))
+		h.mu.Unlock()
+
+		key := "attachments/" + req.FileName
+		if req.Key != "" {
+			key = req.Key
+		}
+		s3.Scope(accessKey, key)
+		json.NewEncoder(w).Encode(&hydra.Credentials{BucketName: "bucket", AccessKey: accessKey, SecretKey: "secret", Region: "us-east-1", Key: key})
+	}))
+	return h
+}
+
+func (h *fakeHydra) requestCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.requests)
+}
+
+func (h *fakeHydra) lastRequest() hydra.Request {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests[len(h.requests)-1]
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// fileFilter transforms the contents of a file as it is added to the archive,
+// e.g. for custom redaction or format conversion.
+type fileFilter interface {
+	// filter writes the transformed contents of the file stored under name in the archive into w.
+	filter(name string, r io.Reader, w io.Writer) error
+}
+
+// execFilter runs a shell command per file, feeding the contents on stdin and reading the result from stdout.
+type execFilter struct {
+	command string
+}
+
+func (f *execFilter) filter(name string, r io.Reader, w io.Writer) error {
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.Command("sh", "-c", f.command)
+	cmd.Env = append(os.Environ(), envFilterPath+"="+name)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(lastLines(stderr.String(), 5)))
+	}
+
+	return nil
+}
+
+// tempFile is a temporary file removed on Close.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+
+	return err
+}
+
+// applyFilters runs the file contents through the filters in order and returns the
+// result in a temporary file, which the caller must close.
+func applyFilters(filters []fileFilter, name string, r io.Reader) (*tempFile, error) {
+	var current *tempFile
+	for _, filter := range filters {
+		f, err := ioutil.TempFile("", "hydra-s3-upload-filter-")
+		if err != nil {
+			return nil, err
+		}
+		next := &tempFile{f}
+
+		err = filter.filter(name, r, next)
+		if current != nil {
+			current.Close()
+		}
+		if err != nil {
+			next.Close()
+			return nil, err
+		}
+
+		_, err = next.Seek(0, io.SeekStart)
+		if err != nil {
+			next.Close()
+			return nil, err
+		}
+
+		current, r = next, next
+	}
+
+	return current, nil
+}
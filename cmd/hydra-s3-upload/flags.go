@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+	"s3upload_test/pkg/hydra"
+)
+
+// Upload tuning of the artifact mode.
+const (
+	artifactPartSize    = 64 * 1024 * 1024
+	artifactConcurrency = 16
+)
+
+// uploadFlags are the flags shared by the commands archiving and uploading directories.
+type uploadFlags struct {
+	storageClass string
+	format       string
+	compression  string
+	artifact     bool
+	splitSize    byteSize
+	partSize     byteSize
+	concurrency  int
+	maxMemory    byteSize
+	lowPriority  bool
+	ioRate       byteSize
+	kmsKey       string
+	kmsRegion    string
+	vaultKey     string
+	filterCmds   stringList
+	caseID       string
+	isPrivate    bool
+	description  string
+	progressJSON string
+	progress     string
+	telemetryURL string
+	verify       string
+	hash         string
+	auditLog     string
+	portable     string
+	sidecar      bool
+	index        bool
+	seekable     bool
+	stream       bool
+	noStream     bool
+	resumable    bool
+	window       string
+	excludes     stringList
+	maxArchive   byteSize
+	stateMax     byteSize
+	trim         stringList
+	logTail      byteSize
+	classify     string
+	ackClassify  bool
+	writeReceipt bool
+	force        bool
+	preview      byteSize
+	filesFrom    string
+	autoSplit    bool
+	assumeRole   *assumeRoleOptions
+	direct       *directOptions
+	email        *emailNotifier
+}
+
+func addUploadFlags(flags *flag.FlagSet) *uploadFlags {
+	f := &uploadFlags{}
+	flags.StringVar(&f.storageClass, "storage-class", "", "S3 storage class of the uploaded archive, e.g. GLACIER or DEEP_ARCHIVE for long-term retention")
+	flags.StringVar(&f.format, "format", "tar", "Archive format: "+strings.Join(archive.FormatNames(), ", ")+" (cpio writes the newc format, without hard links and files over 4 GiB, zip deflates every file, without hard links)")
+	flags.StringVar(&f.compression, "compression", "", "Archive compression: "+strings.Join(archive.CodecNames(), ", ")+" (default gzip, none in artifact mode and for zip archives)")
+	flags.BoolVar(&f.artifact, "artifact", false, "Tune for large binary artifacts such as pcaps or core dumps: no compression and maximum upload concurrency")
+	flags.Var(&f.splitSize, "split-size", "Split archives larger than this size (e.g. 5GB) into several objects, set to the attachment limit")
+	flags.BoolVar(&f.autoSplit, "auto-split", false, "Retry archives rejected for exceeding a size limit in the split-archive mode")
+	flags.Var(&f.partSize, "part-size", "Multipart upload part size (default 5MiB, 64MiB in artifact mode and when streaming)")
+	flags.IntVar(&f.concurrency, "concurrency", 0, "Number of parts uploaded in parallel (default 5, 16 in artifact mode)")
+	flags.Var(&f.maxMemory, "max-memory", "Memory ceiling (e.g. 256MiB) that the part size and concurrency are reduced to fit in")
+	flags.BoolVar(&f.lowPriority, "low-priority", false, "Yield to other workloads while archiving: one CPU, lowest CPU and IO priority, and paced IO")
+	flags.Var(&f.ioRate, "io-rate", "Maximum archiving rate per second, e.g. 20MiB (default 32MiB in low priority mode, unlimited otherwise)")
+	flags.StringVar(&f.kmsKey, "kms-key-id", "", "Encrypt the archive with a data key wrapped by this AWS KMS key (ID, ARN, or alias)")
+	flags.StringVar(&f.kmsRegion, "kms-region", "", "Region of the KMS key when it is not given as an ARN")
+	flags.StringVar(&f.vaultKey, "vault-transit-key", "", "Encrypt the archive with a data key wrapped by this Vault transit key (uses VAULT_ADDR and VAULT_TOKEN)")
+	flags.Var(&f.filterCmds, "filter-cmd", "Shell command transforming every archived file from stdin to stdout, with the archive path in $HSU_FILTER_PATH (repeatable, applied in order)")
+	flags.StringVar(&f.caseID, "case-id", "", "Support case number the upload is attached to in the Hydra request, also reported in upload receipts")
+	flags.StringVar(&f.caseID, "case", "", "Alias of --case-id")
+	flags.BoolVar(&f.isPrivate, "is-private", false, "Attach the upload to the case as a private attachment, visible to support only")
+	flags.StringVar(&f.description, "description", "", "Description of the attachment shown in the support case")
+	flags.StringVar(&f.progress, "progress", progressLog, "Human-readable progress of the archiving and upload: "+strings.Join(progressModes, ", ")+" (log writes a line every 10s, bar redraws a bar on stderr)")
+	flags.StringVar(&f.progressJSON, "progress-json", "", "Emit JSON lines progress events (phase, percent, bytes, eta) to this file descriptor number or file")
+	flags.StringVar(&f.hash, "hash", defaultHashAlgorithm, "Hash algorithm of the archive checksums, receipts and verification: "+strings.Join(hashAlgorithms, ", ")+" (blake3 is the fastest on large archives, sha256 and sha512 meet compliance requirements)")
+	flags.StringVar(&f.auditLog, "audit-log", "", "Append every archived, redacted and excluded file and every upload to this hash-chained audit log, whose head hash is stored in the object metadata, and embed the file checksums in the archive for verify-archive --manifest")
+	flags.StringVar(&f.portable, "portable-names", portableWarn, "Entries colliding case-insensitively or exceeding the Windows path limit: "+strings.Join(portableModes, ", ")+" (rename records the original names in "+manifestName+")")
+	flags.BoolVar(&f.sidecar, "checksum-sidecar", false, "Also upload the archive checksum as a <name>.sha256 (or .sha512, .b3) attachment of its own, for verification with standard tools, failing the upload when it cannot be stored")
+	flags.BoolVar(&f.index, "index", false, "Compress the archive in independently decompressible blocks and upload a <name>.index.json locating every file, for extracting single files")
+	flags.BoolVar(&f.seekable, "seekable", false, "Write zstd archives in the seekable format, indexed blocks followed by a seek table, so that support tooling can fetch single files with range requests (starts a zstd process for every 32 MiB of the archive)")
+	flags.StringVar(&f.verify, "verify", verifyNone, "Read uploaded objects back and compare them with the archive, when permitted: "+strings.Join(verifyModes, ", "))
+	flags.BoolVar(&f.stream, "stream", true, "Stream the archive into the upload without a temporary file when the other options allow (through Hydra only with --checksum-sidecar), falling back to the file when streaming fails")
+	flags.BoolVar(&f.noStream, "no-stream", false, "Always write the archive into a temporary file before uploading it, as --stream=false")
+	flags.BoolVar(&f.resumable, "resumable", false, "Checkpoint the uploaded parts of archive files and resume a failed upload of the same archive from the last uploaded part, e.g. with --keep-archive (never streams)")
+	flags.StringVar(&f.window, "upload-window", "", "Archive right away but start transfers only within this daily local time window, e.g. 22:00-06:00 (resumable uploads also start no parts outside of it)")
+	flags.Var(&f.excludes, "exclude", "Leave out the files matching this pattern, as a path, leading directory, or path element relative to the gather (repeatable)")
+	flags.Var(&f.maxArchive, "max-archive-size", "Trim the archive when its estimated size exceeds this, e.g. 2GB, or fail with its largest contributors")
+	flags.Var(&f.trim, "trim", "Trimming policy applied above --max-archive-size: "+strings.Join(trimPolicies, ", ")+" (repeatable)")
+	f.logTail = 10 << 20
+	flags.Var(&f.logTail, "trim-log-tail", "Size of the log file ends kept by the tail-logs trimming policy")
+	flags.StringVar(&f.classify, "classification", classificationOff, "Report the categories of the uploaded data (logs, manifests, secrets, binaries, certificates): "+strings.Join(classificationModes, ", "))
+	flags.BoolVar(&f.ackClassify, "ack-classification", false, "Acknowledge the data classification report, required to upload in the strict mode")
+	flags.BoolVar(&f.writeReceipt, "write-receipt", false, "Record successful uploads in a .uploaded-<timestamp>.json receipt inside the source directory")
+	flags.Var(&f.preview, "preview", "Upload a preview archive keeping only the first and last this many bytes of every file (e.g. 64KiB) plus a manifest of all files, for triage before the full upload")
+	flags.StringVar(&f.filesFrom, "files-from", "", "Archive only the paths listed in this file, one per line relative to the gather directory (directories and wildcards select everything they match), for a selective re-upload")
+	flags.BoolVar(&f.force, "force", false, "Upload directories even when an upload receipt shows their current content was already submitted")
+	flags.Var(chaos, "chaos", "Inject failures to test the automation around uploads: "+chaosHydraFail+"=N (fail N Hydra requests), "+chaosStall+"=X%[:duration] (stall the upload at X%), "+chaosExpiredToken+"=N (reject N S3 uploads with expired credentials) (repeatable)")
+	flags.StringVar(&f.telemetryURL, "telemetry-url", "", "Opt in to reporting anonymous run outcomes (version, phase durations, error category) to this endpoint")
+	f.stateMax = defaultStateMaxSize
+	flags.Var(&f.stateMax, "state-max-size", "Maximum size of the state directory besides the spool queue, above which the least recently used resume files and cached credentials are evicted (0 = unlimited)")
+	flags.BoolVar(&credsCacheDisabled, "no-cache", false, "Always request fresh credentials from Hydra instead of reusing the unexpired ones cached for a resumed upload of the same object")
+	addAuthFlags(flags)
+	f.assumeRole = addAssumeRoleFlags(flags)
+	f.direct = addDirectFlags(flags)
+	f.email = addEmailFlags(flags)
+
+	return f
+}
+
+// options validates the parsed flags and turns them into the options of a run.
+func (f *uploadFlags) options() (*options, error) {
+	opts := &options{
+		storageClass:    f.storageClass,
+		splitSize:       int64(f.splitSize),
+		partSize:        int64(f.partSize),
+		concurrency:     f.concurrency,
+		caseID:          f.caseID,
+		assumeRole:      f.assumeRole,
+		telemetry:       newTelemetry(f.telemetryURL),
+		verify:          f.verify,
+		hash:            f.hash,
+		portableNames:   f.portable,
+		checksumSidecar: f.sidecar,
+		indexed:         f.index,
+		seekableZstd:    f.seekable,
+		stream:          f.stream && !f.noStream,
+		resumable:       f.resumable,
+		writeReceipt:    f.writeReceipt,
+		force:           f.force,
+		preview:         int64(f.preview),
+		autoSplit:       f.autoSplit,
+		excludes:        f.excludes,
+
+		maxArchiveSize: int64(f.maxArchive),
+		trimPolicies:   f.trim,
+		logTail:        int64(f.logTail),
+
+		stateMaxSize: int64(f.stateMax),
+
+		classification:    f.classify,
+		ackClassification: f.ackClassify,
+	}
+
+	if !containsString(classificationModes, opts.classification) {
+		return nil, fmt.Errorf("Unsupported classification mode -- %s", opts.classification)
+	}
+
+	for _, policy := range opts.trimPolicies {
+		if !containsString(trimPolicies, policy) {
+			return nil, fmt.Errorf("Unsupported trimming policy -- %s", policy)
+		}
+	}
+	if opts.logTail <= 0 {
+		return nil, fmt.Errorf("The --trim-log-tail value must be positive")
+	}
+
+	if !containsString(hashAlgorithms, opts.hash) {
+		return nil, fmt.Errorf("Unsupported hash algorithm -- %s", opts.hash)
+	}
+
+	err := retries.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if chaos.enabled() {
+		klog.Warningln("Failure injection enabled --", chaos)
+	}
+
+	err = applyTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.window, err = parseUploadWindow(f.window)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.requested, err = loadRequestedFiles(f.filesFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	// Previews and selective re-uploads neither count as uploads of the directory nor are skipped after one.
+	if opts.preview > 0 || opts.requested != nil {
+		opts.writeReceipt, opts.force = false, true
+	}
+
+	if !containsString(dualStackModes, dualStack) {
+		return nil, fmt.Errorf("Unsupported --dual-stack mode -- %s", dualStack)
+	}
+
+	if !containsString(portableModes, opts.portableNames) {
+		return nil, fmt.Errorf("Unsupported --portable-names mode -- %s", opts.portableNames)
+	}
+
+	if !containsString(verifyModes, opts.verify) {
+		return nil, fmt.Errorf("Unsupported verification mode -- %s", opts.verify)
+	}
+
+	if opts.storageClass != "" && !isValidStorageClass(opts.storageClass) {
+		return nil, fmt.Errorf("Unsupported storage class -- %s", opts.storageClass)
+	}
+
+	// Binary artifacts hardly compress and are large, so favour throughput.
+	compression := f.compression
+	if f.artifact {
+		if compression == "" {
+			compression = "none"
+		}
+		if opts.partSize == 0 {
+			opts.partSize = artifactPartSize
+		}
+		if opts.concurrency == 0 {
+			opts.concurrency = artifactConcurrency
+		}
+	}
+
+	// The entries of zip archives are compressed on their own.
+	if compression == "" && f.format == "zip" {
+		compression = "none"
+	}
+	if compression == "" {
+		compression = "gzip"
+	}
+
+	codec, err := archive.LookupCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	opts.codec = codec
+
+	opts.format, err = archive.LookupFormat(f.format)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.partSize != 0 && opts.partSize < s3manager.MinUploadPartSize {
+		return nil, fmt.Errorf("The --part-size value must be at least %d bytes", s3manager.MinUploadPartSize)
+	}
+
+	if f.lowPriority {
+		opts.applyLowPriority(int64(f.ioRate))
+	} else if f.ioRate > 0 {
+		opts.codec = pacedCodec{Codec: opts.codec, rate: int64(f.ioRate)}
+	}
+
+	if f.maxMemory > 0 {
+		err = opts.applyMemoryCeiling(int64(f.maxMemory))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts.keyWrapper, err = newKeyWrapper(f.kmsKey, f.kmsRegion, f.vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to set up archive encryption -- %w", err)
+	}
+	if opts.keyWrapper != nil && opts.indexed {
+		return nil, fmt.Errorf("Encrypted archives cannot be indexed, their blocks cannot be decrypted separately")
+	}
+	if opts.seekableZstd && (opts.codec.Extension() != ".zst" || opts.keyWrapper != nil) {
+		return nil, fmt.Errorf("Only unencrypted zstd archives can be written in the seekable format, give --compression zstd")
+	}
+
+	for _, command := range f.filterCmds {
+		opts.filters = append(opts.filters, &execFilter{command: command})
+	}
+
+	if activeContext != nil {
+		for _, command := range activeContext.Filters {
+			opts.filters = append(opts.filters, &execFilter{command: command})
+		}
+		opts.excludes = append(opts.excludes, activeContext.Excludes...)
+		if opts.caseID == "" {
+			opts.caseID = activeContext.CaseID
+		}
+	}
+
+	opts.attachment = hydra.Request{CaseNumber: opts.caseID, Description: f.description, IsPrivate: strconv.FormatBool(f.isPrivate)}
+	opts.credsSource = hydraCreds(opts.attachment)
+
+	opts.policy, err = loadPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if opts.policy != nil {
+		for _, command := range opts.policy.Redact {
+			opts.filters = append(opts.filters, &execFilter{command: command})
+		}
+	}
+
+	if f.auditLog != "" {
+		opts.audit, err = openAuditLog(f.auditLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !containsString(progressModes, f.progress) {
+		return nil, fmt.Errorf("Unsupported --progress mode -- %s", f.progress)
+	}
+	opts.progress, err = openProgressReporter(f.progressJSON, f.progress)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.email.enabled() {
+		err = f.email.validate()
+		if err != nil {
+			return nil, err
+		}
+		opts.notifiers = append(opts.notifiers, f.email)
+	}
+
+	if f.direct.enabled() {
+		source, err := f.direct.source()
+		if errors.Is(err, errNoAmbientCredentials) {
+			klog.Warningln(err, "-- falling back to Hydra credentials, --bucket is ignored")
+		} else if err != nil {
+			return nil, fmt.Errorf("Unable to load AWS configuration -- %w", err)
+		} else {
+			opts.credsSource = source
+			opts.direct = true
+		}
+	}
+
+	return opts, nil
+}
+
+// stringList is a flag.Value collecting values from repeated or comma-separated flags.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
+
+// byteSize is a flag.Value parsing sizes such as "512MiB" or "5GB".
+type byteSize int64
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// Longer suffixes first, so that "MiB" is not mistaken for "B".
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+func parseByteSize(value string) (int64, error) {
+	number, multiplier := strings.TrimSpace(value), int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(strings.ToUpper(number), strings.ToUpper(unit.suffix)) {
+			number = strings.TrimSpace(number[:len(number)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size %q", value)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	n, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+
+	*b = byteSize(n)
+	return nil
+}
+
+// formatByteSize renders a size with a binary unit, e.g. "1.5 GiB".
+func formatByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		size  int64
+		err   bool
+	}{
+		{value: "0", size: 0},
+		{value: "1024", size: 1024},
+		{value: "512B", size: 512},
+		{value: "1.5 KiB", size: 1536},
+		{value: "10MiB", size: 10 << 20},
+		{value: "2 GB", size: 2000 * 1000 * 1000},
+		{value: "1k", size: 1 << 10},
+		{value: "5G", size: 5 << 30},
+		{value: "1 TiB", size: 1 << 40},
+		{value: "", err: true},
+		{value: "-1 MiB", err: true},
+		{value: "ten MiB", err: true},
+	}
+
+	for _, test := range tests {
+		size, err := parseByteSize(test.value)
+		if (err != nil) != test.err || size != test.size {
+			t.Errorf("%q: expected %d (error: %v), got %d, %v", test.value, test.size, test.err, size, err)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		size      int64
+		formatted string
+	}{
+		{size: 0, formatted: "0 B"},
+		{size: 1023, formatted: "1023 B"},
+		{size: 1024, formatted: "1.0 KiB"},
+		{size: 1536, formatted: "1.5 KiB"},
+		{size: 10 << 20, formatted: "10.0 MiB"},
+		{size: 5 << 40, formatted: "5.0 TiB"},
+	}
+
+	for _, test := range tests {
+		if formatted := formatByteSize(test.size); formatted != test.formatted {
+			t.Errorf("%d: expected %q, got %q", test.size, test.formatted, formatted)
+		}
+	}
+}
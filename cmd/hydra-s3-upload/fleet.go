@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// fleetTarget is a single cluster to collect from, identified by a kubeconfig file and/or a context within it.
+type fleetTarget struct {
+	name       string
+	kubeconfig string
+	context    string
+}
+
+func (t *fleetTarget) ocArgs() []string {
+	var args []string
+	if t.kubeconfig != "" {
+		args = append(args, "--kubeconfig", t.kubeconfig)
+	}
+	if t.context != "" {
+		args = append(args, "--context", t.context)
+	}
+
+	return args
+}
+
+// fleetTargets builds the target list from the given kubeconfigs and contexts.
+// Every context is collected from every kubeconfig; either list may be empty.
+func fleetTargets(kubeconfigs, contexts []string) []fleetTarget {
+	if len(kubeconfigs) == 0 {
+		kubeconfigs = []string{""}
+	}
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+
+	var targets []fleetTarget
+	for _, kc := range kubeconfigs {
+		for _, ctx := range contexts {
+			var name []string
+			if kc != "" {
+				name = append(name, strings.TrimSuffix(filepath.Base(kc), filepath.Ext(kc)))
+			}
+			if ctx != "" {
+				name = append(name, ctx)
+			}
+
+			targets = append(targets, fleetTarget{
+				name:       sanitizeName(strings.Join(name, "-")),
+				kubeconfig: kc,
+				context:    ctx,
+			})
+		}
+	}
+
+	return targets
+}
+
+// sanitizeName replaces characters that are unsafe in file names and object tags.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// gatherCluster runs `oc adm must-gather` against the target, writing its output into destDir.
+func gatherCluster(oc string, t *fleetTarget, extraArgs []string, destDir string) error {
+	args := append(t.ocArgs(), "adm", "must-gather", "--dest-dir", destDir)
+	args = append(args, extraArgs...)
+
+	cmd := exec.Command(oc, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(lastLines(string(output), 5)))
+	}
+
+	return nil
+}
+
+// lastLines returns at most the n last lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func runFleet(args []string) {
+	var kubeconfigs, contexts stringList
+
+	flags := flag.NewFlagSet(commandName()+" fleet", flag.ExitOnError)
+	flags.Var(&kubeconfigs, "kubeconfig", "Kubeconfig file of a cluster to collect from (repeatable or comma-separated)")
+	flags.Var(&contexts, "context", "Kubeconfig context of a cluster to collect from (repeatable or comma-separated)")
+	parallel := flags.Int("parallel", 4, "Maximum number of clusters processed at the same time")
+	workDir := flags.String("work-dir", filepath.Join(writableDir("."), "must-gather-fleet"), "Directory receiving the per-cluster gathers and archives")
+	oc := flags.String("oc", "oc", "Path to the oc binary used to run must-gather")
+	gatherArgs := flags.String("gather-args", "", "Additional space-separated arguments passed to oc adm must-gather")
+	uploadFlags := addUploadFlags(flags)
+	flags.Parse(args)
+
+	if len(kubeconfigs) == 0 && len(contexts) == 0 {
+		klog.Fatalln("At least one --kubeconfig or --context is required")
+	}
+
+	if *parallel < 1 {
+		klog.Fatalln("The --parallel value must be positive")
+	}
+
+	opts, err := uploadFlags.options()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	targets := fleetTargets(kubeconfigs, contexts)
+	errs := make([]error, len(targets))
+
+	err = os.MkdirAll(*workDir, 0755)
+	if err != nil {
+		klog.Fatalln("Unable to create work directory --", err)
+	}
+
+	// Process the clusters in parallel, bounded by the semaphore capacity.
+	sem := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			t := &targets[i]
+			destDir := filepath.Join(*workDir, t.name)
+
+			klog.Infof("[%s] Running must-gather...", t.name)
+			err := gatherCluster(*oc, t, strings.Fields(*gatherArgs), destDir)
+			if err != nil {
+				errs[i] = fmt.Errorf("must-gather failed -- %w", err)
+				return
+			}
+			klog.Infof("[%s] Must-gather finished", t.name)
+
+			// Tag the object with the cluster it came from.
+			clusterOpts := *opts
+			clusterOpts.tags = map[string]string{"cluster": t.name}
+
+			errs[i] = uploadDir(destDir, destDir+opts.archiveExtension(), &clusterOpts)
+		}(i)
+	}
+	wg.Wait()
+
+	// Summarize the per-cluster outcome.
+	failed := 0
+	for i, t := range targets {
+		if errs[i] != nil {
+			failed++
+			klog.Errorf("[%s] Failed -- %v", t.name, errs[i])
+		} else {
+			klog.Infof("[%s] Uploaded", t.name)
+		}
+	}
+
+	if failed > 0 {
+		klog.Fatalf("%d of %d clusters failed", failed, len(targets))
+	}
+}
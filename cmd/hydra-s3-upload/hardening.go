@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"k8s.io/klog"
+)
+
+// dirWritable reports whether files can be created in dir, which is not the case
+// on a read-only root filesystem or in directories owned by another UID.
+func dirWritable(dir string) bool {
+	f, err := ioutil.TempFile(dir, ".hydra-s3-upload-probe-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	return true
+}
+
+// writableDir returns dir when it is writable and the temporary directory otherwise.
+func writableDir(dir string) string {
+	if dirWritable(dir) {
+		return dir
+	}
+
+	klog.V(1).Infof("Directory %s is not writable, using %s instead", dir, os.TempDir())
+	return os.TempDir()
+}
+
+// warnIfRoot warns about running as root, which none of the functionality requires.
+func warnIfRoot() {
+	if os.Geteuid() == 0 {
+		klog.Warningln("Running as root is not required, consider running as an unprivileged user")
+	}
+}
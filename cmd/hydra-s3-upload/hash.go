@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Hash algorithms of the archive checksums, the content hashes of receipts,
+// the deduplication of merged gathers and the verification of uploads.
+const (
+	hashBLAKE3 = "blake3"
+	hashSHA256 = "sha256"
+	hashSHA512 = "sha512"
+)
+
+var hashAlgorithms = []string{hashBLAKE3, hashSHA256, hashSHA512}
+
+// defaultHashAlgorithm is BLAKE3, considerably faster than SHA-256 on multi-GB trees. It is computed in Go,
+// so the checksums do not depend on the tools installed on the host, and recipients verify them with b3sum.
+const defaultHashAlgorithm = hashBLAKE3
+
+// hashName returns the display name of the algorithm, e.g. "SHA-256".
+func hashName(algorithm string) string {
+	switch algorithm {
+	case hashBLAKE3:
+		return "BLAKE3"
+	case hashSHA512:
+		return "SHA-512"
+	}
+
+	return "SHA-256"
+}
+
+// checksummer computes the hex-encoded checksum of the data written into it.
+// The checksum method ends the hashing and must also be called when giving up on it.
+type checksummer interface {
+	io.Writer
+	checksum() (string, error)
+}
+
+func newChecksummer(algorithm string) (checksummer, error) {
+	switch algorithm {
+	case hashSHA256, "":
+		return &stdChecksummer{sha256.New()}, nil
+	case hashSHA512:
+		return &stdChecksummer{sha512.New()}, nil
+	case hashBLAKE3:
+		return &stdChecksummer{blake3.New(32, nil)}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported hash algorithm %q, expected one of: %s", algorithm, strings.Join(hashAlgorithms, ", "))
+}
+
+type stdChecksummer struct {
+	hash.Hash
+}
+
+func (c *stdChecksummer) checksum() (string, error) {
+	return hex.EncodeToString(c.Sum(nil)), nil
+}
+
+// fileChecksum returns the hex-encoded checksum of a file.
+func fileChecksum(filePath, algorithm string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash, err := newChecksummer(algorithm)
+	if err != nil {
+		return "", err
+	}
+	defer hash.checksum()
+
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hash.checksum()
+}
+
+// metadataChecksum returns the archive checksum recorded in the object metadata and its algorithm.
+func metadataChecksum(metadata map[string]*string) (string, string) {
+	for _, algorithm := range hashAlgorithms {
+		if checksum := metadataValue(metadata, algorithm); checksum != "" {
+			return algorithm, checksum
+		}
+	}
+
+	return "", ""
+}
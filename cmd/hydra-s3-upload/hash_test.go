@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestChecksummers(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		checksum  string
+		extension string
+		err       bool
+	}{
+		{algorithm: "", checksum: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", extension: "."},
+		{algorithm: hashSHA256, checksum: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", extension: ".sha256"},
+		{algorithm: hashSHA512, checksum: "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f", extension: ".sha512"},
+		{algorithm: hashBLAKE3, checksum: "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85", extension: ".b3"},
+		{algorithm: "md5", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			c, err := newChecksummer(test.algorithm)
+			if (err != nil) != test.err {
+				t.Fatalf("Expected an error: %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			io.WriteString(c, "abc")
+			checksum, err := c.checksum()
+			if err != nil || checksum != test.checksum {
+				t.Errorf("Expected %s, got %s, %v", test.checksum, checksum, err)
+			}
+			if test.algorithm != "" && sidecarExtension(test.algorithm) != test.extension {
+				t.Errorf("Expected the sidecar extension %s, got %s", test.extension, sidecarExtension(test.algorithm))
+			}
+		})
+	}
+}
+
+func TestDefaultHashAlgorithm(t *testing.T) {
+	// The default is computed in Go, without depending on the tools installed on the host.
+	if defaultHashAlgorithm != hashBLAKE3 {
+		t.Errorf("Expected BLAKE3 as the default hash algorithm, got %s", defaultHashAlgorithm)
+	}
+}
+
+func TestMetadataChecksum(t *testing.T) {
+	tests := []struct {
+		name      string
+		metadata  map[string]*string
+		algorithm string
+		checksum  string
+	}{
+		{name: "canonicalized key", metadata: map[string]*string{"Sha256": aws.String("abc"), "Envelope-Provider": aws.String("kms")}, algorithm: hashSHA256, checksum: "abc"},
+		{name: "blake3", metadata: map[string]*string{"blake3": aws.String("def")}, algorithm: hashBLAKE3, checksum: "def"},
+		{name: "none", metadata: map[string]*string{"split-part": aws.String("1")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			algorithm, checksum := metadataChecksum(test.metadata)
+			if algorithm != test.algorithm || checksum != test.checksum {
+				t.Errorf("Expected %s %s, got %s %s", test.algorithm, test.checksum, algorithm, checksum)
+			}
+		})
+	}
+}
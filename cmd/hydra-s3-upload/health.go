@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// hydraHealth is the response of the Hydra health and quota endpoint. All fields are optional.
+type hydraHealth struct {
+	Status           string    `json:"status"`
+	Message          string    `json:"message"`
+	MaintenanceUntil time.Time `json:"maintenanceUntil"`
+	QuotaRemaining   *int64    `json:"quotaRemaining"`
+}
+
+// checkHydraHealth asks the Hydra health endpoint in HSU_HYDRA_HEALTH_URL, when configured, whether uploads
+// are currently accepted. It detects maintenance windows and exhausted attachment quotas before the
+// archive is built.
+func checkHydraHealth() error {
+	healthURL := getenv(envHydraHealthURL)
+	if healthURL == "" {
+		return nil
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           proxyFor,
+			DialContext:     dialContext,
+			TLSClientConfig: hydraTLSConfig(),
+		},
+	}
+
+	req, err := http.NewRequest("GET", healthURL, nil)
+	if err != nil {
+		return err
+	}
+	setHydraAuth(req, getenv(envHydraURL))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The endpoint is advisory, the credentials request reports real outages.
+		klog.Warningln("Unable to check Hydra health --", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	health := &hydraHealth{}
+	json.NewDecoder(resp.Body).Decode(health)
+
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable || health.Status == "maintenance":
+		msg := "Hydra is in a maintenance window"
+		if !health.MaintenanceUntil.IsZero() {
+			msg += " until " + displayTime(health.MaintenanceUntil)
+		} else if retry := resp.Header.Get("Retry-After"); retry != "" {
+			msg += ", retry after " + retry
+		}
+		if health.Message != "" {
+			msg += ": " + health.Message
+		}
+		return fmt.Errorf("%s, please try again later", msg)
+	case resp.StatusCode == http.StatusTooManyRequests || health.Status == "quota-exceeded" ||
+		(health.QuotaRemaining != nil && *health.QuotaRemaining <= 0):
+		msg := "The attachment quota is exceeded"
+		if health.Message != "" {
+			msg += ": " + health.Message
+		}
+		return fmt.Errorf("%s, please remove old attachments or contact support", msg)
+	case resp.StatusCode != http.StatusOK:
+		klog.Warningln("Unexpected Hydra health response status:", resp.Status)
+	case health.QuotaRemaining != nil:
+		klog.Infoln("Remaining attachment quota:", formatByteSize(*health.QuotaRemaining))
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// messages holds the translations of user-facing messages, keyed by locale and by the English message.
+// Messages without a translation are shown in English.
+var messages = map[string]map[string]string{
+	"de": {
+		"Creating a temporary archive file...":                           "Temporäre Archivdatei wird erstellt...",
+		"Temporary archive file created":                                 "Temporäre Archivdatei erstellt",
+		"Archiving the Must-Gather directory into the temporary file...": "Must-Gather-Verzeichnis wird in die temporäre Datei archiviert...",
+		"Must-Gather directory archived":                                 "Must-Gather-Verzeichnis archiviert",
+		"Uploading Must-Gather archive through presigned URLs...":        "Must-Gather-Archiv wird über vorsignierte URLs hochgeladen...",
+		"Uploading Must-Gather archive...":                               "Must-Gather-Archiv wird hochgeladen...",
+		"Must-Gather archive uploaded":                                   "Must-Gather-Archiv hochgeladen",
+		"Uploading archive piece %d of %d":                               "Archivteil %d von %d wird hochgeladen",
+		"Requesting AWS S3 credentials...":                               "AWS-S3-Zugangsdaten werden angefordert...",
+		"S3 credentials received":                                        "S3-Zugangsdaten erhalten",
+		"Upload receipt for case %s":                                     "Upload-Bestätigung für Fall %s",
+		"Case":                                                           "Fall",
+		"Object":                                                         "Objekt",
+		"Size":                                                           "Größe",
+	},
+	"es": {
+		"Creating a temporary archive file...":                           "Creando un archivo temporal...",
+		"Temporary archive file created":                                 "Archivo temporal creado",
+		"Archiving the Must-Gather directory into the temporary file...": "Archivando el directorio Must-Gather en el archivo temporal...",
+		"Must-Gather directory archived":                                 "Directorio Must-Gather archivado",
+		"Uploading Must-Gather archive through presigned URLs...":        "Subiendo el archivo Must-Gather mediante URL prefirmadas...",
+		"Uploading Must-Gather archive...":                               "Subiendo el archivo Must-Gather...",
+		"Must-Gather archive uploaded":                                   "Archivo Must-Gather subido",
+		"Uploading archive piece %d of %d":                               "Subiendo la parte %d de %d del archivo",
+		"Requesting AWS S3 credentials...":                               "Solicitando credenciales de AWS S3...",
+		"S3 credentials received":                                        "Credenciales de S3 recibidas",
+		"Upload receipt for case %s":                                     "Comprobante de subida del caso %s",
+		"Case":                                                           "Caso",
+		"Object":                                                         "Objeto",
+		"Size":                                                           "Tamaño",
+	},
+}
+
+// locale is the catalog selected by the usual locale environment variables.
+var locale = detectLocale()
+
+func detectLocale() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		// Drop the encoding and modifier, e.g. "de_DE.UTF-8@euro" becomes "de_de".
+		if i := strings.IndexAny(value, ".@"); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.ToLower(strings.Replace(value, "-", "_", -1))
+
+		if _, ok := messages[value]; ok {
+			return value
+		}
+		if i := strings.Index(value, "_"); i >= 0 {
+			value = value[:i]
+		}
+
+		return value
+	}
+
+	return ""
+}
+
+// tr returns the message translated to the user locale.
+func tr(msg string) string {
+	if translated, ok := messages[locale][msg]; ok {
+		return translated
+	}
+
+	return msg
+}
+
+// trf formats the translated message.
+func trf(format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(format), args...)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+)
+
+// seekable reports whether the archives are written in the seekable zstd format, so that support tooling
+// can fetch and decompress only the frames holding a file with range requests. It is opted into with --seekable,
+// checked to be given for unencrypted zstd archives only.
+func (o *options) seekable() bool {
+	return o.seekableZstd
+}
+
+// newIndexedTarArchive returns an archive compressed in independent blocks, recording its entries
+// into index when set. A seekable archive ends with the seek table of its blocks.
+func newIndexedTarArchive(rawWriter io.Writer, format archive.Format, codec archive.Codec, index *archive.Index, seekable bool) *tarArchive {
+	return &tarArchive{format: format, entries: archive.NewIndexedWriter(rawWriter, format, codec, index, seekable)}
+}
+
+// uploadIndexSidecar uploads the archive index next to the uploaded object as <key>.index.json.
+func (c *credsResponse) uploadIndexSidecar(index *archive.Index, opts *options) {
+	content, err := json.Marshal(index)
+	if err != nil {
+		klog.Warningln("Unable to encode the archive index --", err)
+		return
+	}
+
+	sidecar := *c
+	sidecar.Key += ".index.json"
+	_, err = sidecar.uploadFile(strings.NewReader(string(content)), nil, opts)
+	if err != nil {
+		klog.Warningln("Unable to upload the archive index --", err)
+		return
+	}
+	klog.Infoln("Archive index uploaded to", sidecar.Key)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestSeekableFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		seekable bool
+		err      bool
+	}{
+		{args: []string{"--compression", "zstd"}},
+		{args: []string{"--compression", "zstd", "--seekable"}, seekable: true},
+		{args: []string{"--seekable"}, err: true},
+		{args: []string{"--compression", "xz", "--seekable"}, err: true},
+	}
+
+	for _, test := range tests {
+		flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+		f := addUploadFlags(flags)
+		if err := flags.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		opts, err := f.options()
+		if (err != nil) != test.err {
+			t.Errorf("%v: expected an error: %v, got %v", test.args, test.err, err)
+			continue
+		}
+		if err == nil && opts.seekable() != test.seekable {
+			t.Errorf("%v: expected seekable %v, got %v", test.args, test.seekable, opts.seekable())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// uploadItem is a path given on the command line: a directory archived on its own,
+// or a file such as an sosreport tarball or a standalone log, uploaded as it is.
+type uploadItem struct {
+	path string
+	dir  bool
+	// objectName is the attachment name of the item.
+	objectName string
+	// err is the outcome of the upload, errItemSkipped when it was not attempted.
+	err error
+}
+
+// errItemSkipped marks the items left after the upload was canceled.
+var errItemSkipped = errors.New("Skipped")
+
+// parseUploadItems checks the positional paths of an upload.
+func parseUploadItems(paths []string) ([]*uploadItem, error) {
+	var items []*uploadItem
+	for _, itemPath := range paths {
+		info, err := os.Stat(itemPath)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to upload %s -- %w", itemPath, err)
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("Unable to upload %s, only directories and regular files can be uploaded", itemPath)
+		}
+		items = append(items, &uploadItem{path: itemPath, dir: info.IsDir()})
+	}
+
+	return items, nil
+}
+
+// nameItems names the attachments of several items: directories after their base name and the upload time,
+// e.g. "sosreport-node1-20201231T235959Z.tar.gz", and files after their base name.
+func (o *options) nameItems(items []*uploadItem) error {
+	now := time.Now()
+	suffix := ""
+	if o.preview > 0 {
+		suffix = "-preview"
+	} else if o.requested != nil {
+		suffix = "-selection"
+	}
+
+	named := map[string]string{}
+	for _, item := range items {
+		base := filepath.Base(filepath.Clean(item.path))
+		item.objectName = base
+		if item.dir {
+			item.objectName = base + suffix + "-" + fileTimestamp(now) + o.archiveExtension()
+		}
+
+		if other, ok := named[item.objectName]; ok {
+			return fmt.Errorf("Both %s and %s would be uploaded as %s, rename one of them", other, item.path, item.objectName)
+		}
+		named[item.objectName] = item.path
+	}
+
+	return nil
+}
+
+// checkItems rejects the options that apply to a single archive only, or would be ignored for the files
+// uploaded as they are.
+func (o *options) checkItems(items []*uploadItem) error {
+	if len(o.sources) > 0 {
+		return errors.New("The remote sources (--from-pod, --from-node, --collect-node, --collect-container) are only collected with a single gather directory")
+	}
+	if o.presigned != nil {
+		return errors.New("Presigned URLs upload a single object, give a single gather directory")
+	}
+
+	for _, item := range items {
+		if item.dir {
+			continue
+		}
+		if o.keyWrapper != nil || len(o.filters) > 0 || o.policy != nil || o.preview > 0 || o.requested != nil {
+			return fmt.Errorf("The file %s would be uploaded as it is, without the encryption, filters, export policy, preview or selection, move it into a directory to archive it", item.path)
+		}
+	}
+
+	return nil
+}
+
+// uploadStandaloneFile uploads a file as it is, without archiving it.
+func uploadStandaloneFile(filePath string, opts *options) error {
+	opts.pruneState()
+	if opts.usesHydra() {
+		err := checkHydraHealth()
+		if err != nil {
+			return err
+		}
+	}
+
+	checksum, err := fileChecksum(filePath, opts.hash)
+	if err != nil {
+		return fmt.Errorf("Unable to compute checksum -- %w", err)
+	}
+
+	return uploadArchiveFile(filePath, checksum, opts)
+}
+
+// uploadItems uploads the items one after another, every one with its own Hydra credentials, archiving the
+// directories into archiveDir. A failed item does not stop the others, only a cancellation does.
+func uploadItems(items []*uploadItem, archiveDir string, cancel <-chan struct{}, opts *options) {
+	for i, item := range items {
+		select {
+		case <-cancel:
+			for _, skipped := range items[i:] {
+				skipped.err = errItemSkipped
+			}
+			return
+		default:
+		}
+
+		klog.Infof("Uploading %s as %s (%d of %d)", item.path, item.objectName, i+1, len(items))
+		itemOpts := *opts
+		itemOpts.objectName = item.objectName
+		if item.dir {
+			archivePath := filepath.Join(archiveDir, filepath.Base(filepath.Clean(item.path)))
+			item.err = uploadDir(item.path, archivePath+opts.archiveExtension(), &itemOpts)
+		} else {
+			item.err = uploadStandaloneFile(item.path, &itemOpts)
+		}
+		if item.err != nil {
+			klog.Errorln("Unable to upload", item.path, "--", item.err)
+		}
+	}
+}
+
+// printItemSummary reports the outcome of every item, and returns the number of items not uploaded.
+func printItemSummary(items []*uploadItem) int {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tATTACHMENT\tRESULT")
+	for _, item := range items {
+		result := "Uploaded"
+		if item.err != nil {
+			failed++
+			result = strings.Replace(item.err.Error(), "\n", " ", -1)
+			if item.err != errItemSkipped {
+				result = "Failed: " + result
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", item.path, item.objectName, result)
+	}
+	w.Flush()
+
+	return failed
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"s3upload_test/pkg/archive"
+)
+
+func TestTimestamps(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	if err != nil {
+		t.Skip("No timezone database")
+	}
+	at := time.Date(2021, 1, 1, 0, 59, 59, 0, prague)
+
+	if got := timestamp(at); got != "2020-12-31T23:59:59Z" {
+		t.Errorf("Expected the RFC 3339 timestamp in UTC, got %s", got)
+	}
+	if got := fileTimestamp(at); got != "20201231T235959Z" {
+		t.Errorf("Expected the basic timestamp in UTC, got %s", got)
+	}
+}
+
+func TestNameItems(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "items-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, dir := range []string{"a/sosreport-node1", "b/sosreport-node1", "must-gather"} {
+		os.MkdirAll(filepath.Join(tmp, dir), 0700)
+	}
+	for _, file := range []string{"kubelet.log", "a/kubelet.log"} {
+		ioutil.WriteFile(filepath.Join(tmp, file), nil, 0600)
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		// names match the attachment names, with TIMESTAMP standing for the timestamp of the upload.
+		names   []string
+		preview int64
+		err     bool
+	}{
+		{name: "directories and files", paths: []string{"must-gather", "a/sosreport-node1/", "kubelet.log"},
+			names: []string{"must-gather-TIMESTAMP.tar.gz", "sosreport-node1-TIMESTAMP.tar.gz", "kubelet.log"}},
+		{name: "preview", paths: []string{"must-gather"}, preview: 10, names: []string{"must-gather-preview-TIMESTAMP.tar.gz"}},
+		{name: "same directory names", paths: []string{"a/sosreport-node1", "b/sosreport-node1"}, err: true},
+		{name: "same file names", paths: []string{"kubelet.log", "a/kubelet.log"}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var items []*uploadItem
+			for _, itemPath := range test.paths {
+				fullPath := filepath.Join(tmp, itemPath)
+				info, err := os.Stat(fullPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				items = append(items, &uploadItem{path: fullPath, dir: info.IsDir()})
+			}
+
+			opts := &options{preview: test.preview}
+			opts.format, _ = archive.LookupFormat("tar")
+			opts.codec, _ = archive.LookupCodec("gzip")
+			err := opts.nameItems(items)
+			if (err != nil) != test.err {
+				t.Fatalf("Expected an error: %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			for i, item := range items {
+				pattern := "^" + strings.Replace(regexp.QuoteMeta(test.names[i]), "TIMESTAMP", `\d{8}T\d{6}Z`, 1) + "$"
+				if !regexp.MustCompile(pattern).MatchString(item.objectName) {
+					t.Errorf("Expected %s to be named %s, got %s", item.path, test.names[i], item.objectName)
+				}
+				if strings.ContainsAny(item.objectName, `:\/`) {
+					t.Errorf("The name %s is not a portable file name", item.objectName)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// keyringService names the entries of this tool in the OS credential store.
+const keyringService = "hydra-s3-upload"
+
+// errKeyringNotFound is returned when the credential store holds no entry for the account.
+var errKeyringNotFound = errors.New("No credentials stored in the OS keyring")
+
+// keyringSecret is the content of a keyring entry, stored per Hydra host.
+type keyringSecret struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// hydraHost returns the host of HSU_HYDRA_URL, which the keyring entries are stored under.
+func hydraHost() (string, error) {
+	u, err := url.Parse(getenv(envHydraURL))
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("HSU_HYDRA_URL must be set to a valid URL")
+	}
+
+	return u.Hostname(), nil
+}
+
+// keyringCredentials returns the Hydra credentials stored in the OS keyring for the host.
+func keyringCredentials(host string) (*keyringSecret, error) {
+	content, err := keyringGet(host)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &keyringSecret{}
+	err = json.Unmarshal([]byte(content), secret)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse keyring entry -- %w", err)
+	}
+
+	return secret, nil
+}
+
+// readPassword reads a line from the terminal without echoing it where stty is available.
+func readPassword(in *bufio.Reader) (string, error) {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, err := in.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func runLogin(args []string) {
+	flags := flag.NewFlagSet(commandName()+" login", flag.ExitOnError)
+	username := flags.String("username", getenv(envHydraUser), "Hydra username (prompted for when empty)")
+	offlineToken := flags.String("offline-token", getenv(envHydraOfflineToken), "Log in with this SSO offline token instead of a username and password")
+	tokenURL := flags.String("token-url", tokenURLDefault(), "OpenID Connect token endpoint exchanging the credentials (defaults to HSU_HYDRA_TOKEN_URL or the Red Hat SSO)")
+	clientID := flags.String("client-id", "rhsm-api", "OpenID Connect client ID of the token requests")
+	savePassword := flags.Bool("save-password", false, "Store the username and password in the OS keyring instead of exchanging them for a session token")
+	flags.Parse(args)
+
+	host, err := hydraHost()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	if *offlineToken != "" {
+		cache, err := loginWithOfflineToken(*tokenURL, *clientID, *offlineToken)
+		if err != nil {
+			klog.Fatalln("Login failed --", err)
+		}
+		saveLogin(host, cache)
+		return
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		fmt.Fprintf(os.Stderr, "Hydra username for %s: ", host)
+		line, err := in.ReadString('\n')
+		if err != nil {
+			klog.Fatalln("Unable to read username --", err)
+		}
+		*username = strings.TrimSpace(line)
+	}
+
+	password := getenv(envHydraPass)
+	if password == "" {
+		fmt.Fprintf(os.Stderr, "Hydra password for %s: ", *username)
+		password, err = readPassword(in)
+		if err != nil {
+			klog.Fatalln("Unable to read password --", err)
+		}
+	}
+
+	if !*savePassword {
+		cache, err := loginWithPassword(*tokenURL, *clientID, *username, password)
+		if err != nil {
+			klog.Fatalln("Login failed --", err)
+		}
+		saveLogin(host, cache)
+		return
+	}
+
+	content, err := json.Marshal(&keyringSecret{Username: *username, Password: password})
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	err = keyringSet(host, string(content))
+	if err != nil {
+		klog.Fatalln("Unable to store credentials in the OS keyring --", err)
+	}
+	klog.Infoln("Credentials for", host, "stored in the OS keyring")
+}
+
+// saveLogin caches the session of a login.
+func saveLogin(host string, cache *tokenCache) {
+	err := saveTokenCache(host, cache)
+	if err != nil {
+		klog.Fatalln("Unable to cache the Hydra token --", err)
+	}
+
+	if cache.RefreshExpiresAt.IsZero() {
+		klog.Infoln("Logged in to", host)
+	} else {
+		klog.Infoln("Logged in to", host, "until", displayTime(cache.RefreshExpiresAt))
+	}
+}
+
+func runLogout(args []string) {
+	flags := flag.NewFlagSet(commandName()+" logout", flag.ExitOnError)
+	flags.Parse(args)
+
+	host, err := hydraHost()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	loggedOut := deleteTokenCache(host)
+
+	err = keyringDelete(host)
+	if err != nil && !errors.Is(err, errKeyringNotFound) {
+		klog.Fatalln("Unable to remove credentials from the OS keyring --", err)
+	}
+	if err != nil && !loggedOut {
+		klog.Infoln("No credentials stored for", host)
+		return
+	}
+	klog.Infoln("Logged out of", host)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The macOS Keychain is reached through the security tool.
+
+func keyringGet(account string) (string, error) {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "could not be found") {
+			return "", errKeyringNotFound
+		}
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// keyringSet stores the secret, which must be a single line. The secret is given on the standard input
+// rather than on the command line, where the other local users could read it. Without a value, -w prompts
+// for the secret and then to retype it.
+func keyringSet(account, secret string) error {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w")
+	cmd.Stdin = strings.NewReader(secret + "\n" + secret + "\n")
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func keyringDelete(account string) error {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account)
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if strings.Contains(stderr.String(), "could not be found") {
+			return errKeyringNotFound
+		}
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
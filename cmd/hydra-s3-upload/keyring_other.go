@@ -0,0 +1,25 @@
+//go:build !linux && !dragonfly && !freebsd && !netbsd && !openbsd && !darwin && !windows
+// +build !linux,!dragonfly,!freebsd,!netbsd,!openbsd,!darwin,!windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func keyringUnsupported() error {
+	return fmt.Errorf("The OS keyring is not supported on %s", runtime.GOOS)
+}
+
+func keyringGet(account string) (string, error) {
+	return "", keyringUnsupported()
+}
+
+func keyringSet(account, secret string) error {
+	return keyringUnsupported()
+}
+
+func keyringDelete(account string) error {
+	return keyringUnsupported()
+}
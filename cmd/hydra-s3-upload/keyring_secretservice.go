@@ -0,0 +1,49 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+// +build linux dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The Secret Service (GNOME Keyring, KWallet) is reached through secret-tool from libsecret.
+
+func keyringGet(account string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "account", account).Output()
+	if err != nil {
+		// secret-tool exits with 1 and no output when there is no such entry.
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) == 0 {
+			return "", errKeyringNotFound
+		}
+		return "", err
+	}
+
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func keyringSet(account, secret string) error {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("secret-tool", "store", "--label", keyringService+" "+account, "service", keyringService, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func keyringDelete(account string) error {
+	_, err := keyringGet(account)
+	if err != nil {
+		return err
+	}
+
+	return exec.Command("secret-tool", "clear", "service", keyringService, "account", account).Run()
+}
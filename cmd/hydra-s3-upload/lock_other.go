@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import "os"
+
+// Locking is not supported, concurrent runs are not protected against each other.
+
+func lockFD(f *os.File, wait bool) error {
+	return nil
+}
+
+func unlockFD(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,946 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+	"s3upload_test/pkg/hydra"
+	"s3upload_test/pkg/upload"
+)
+
+// credsResponse is a credentials response of Hydra, or the credentials of the direct mode or presigned URLs.
+type credsResponse struct {
+	hydra.Credentials
+
+	// session is preset in the direct mode, where no credentials come from Hydra.
+	session *session.Session
+	// presigned is set when the response holds presigned URLs instead of credentials.
+	presigned *presignedUpload
+	// cachePath is the credentials cache file the response is stored in, if any.
+	cachePath string
+}
+
+func (c *credsResponse) createSession(role *assumeRoleOptions) (*session.Session, error) {
+	var s *session.Session
+	if c.session != nil {
+		// The preset session is shared, so its copy gets the handlers.
+		s = c.session.Copy()
+	} else {
+		var err error
+		s, err = session.NewSession(&aws.Config{
+			Region:       aws.String(c.Region),
+			Credentials:  c.AWSCredentials(),
+			UseDualStack: aws.Bool(useDualStack()),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	correctClockSkew(s)
+
+	if role == nil || role.arn == "" {
+		return s, nil
+	}
+
+	return role.assume(s), nil
+}
+
+// options holds the user-selected settings of an archive-and-upload run.
+type options struct {
+	storageClass  string
+	hash          string
+	portableNames string
+	// checksumSidecar uploads the checksum next to the object, see uploadChecksumSidecar.
+	checksumSidecar bool
+	// indexed compresses the archives in independent blocks, recorded in the index of every archive.
+	indexed bool
+	// seekableZstd writes the zstd archives in the seekable format, see seekable.
+	seekableZstd bool
+	index        *archive.Index
+	audit        *auditLog
+	policy       *exportPolicy
+	tags         map[string]string
+	sources      []archiveSource
+	format       archive.Format
+	codec        archive.Codec
+	filters      []fileFilter
+	keyWrapper   keyWrapper
+	presigned    *presignedUpload
+	assumeRole   *assumeRoleOptions
+	credsSource  credsSource
+	budget       *uploadBudget
+	notifiers    []notifier
+	progress     *progressReporter
+	telemetry    *telemetry
+	verify       string
+	stream       bool
+	excludes     []string
+	caseID       string
+	objectName   string
+	writeReceipt bool
+	force        bool
+	direct       bool
+	autoSplit    bool
+	splitSize    int64
+	partSize     int64
+	concurrency  int
+
+	// resumable checkpoints multipart uploads of archive files in the state directory to resume them after a failure.
+	resumable bool
+
+	// caseInfo is the looked up case of caseID, nil when not looked up.
+	caseInfo *caseInfo
+
+	// attachment holds the case metadata of the Hydra credentials requests, without the file name.
+	attachment hydra.Request
+
+	// window defers the transfers to a daily time window, nil to transfer right away.
+	window *uploadWindow
+
+	// keepArchive keeps the archive file at the archive path after the upload, rather than a temporary file.
+	keepArchive bool
+
+	// requested selects the files of a selective re-upload, nil for all of them.
+	requested *requestedFiles
+
+	// preview is the size of the file beginnings and ends kept in preview archives, zero for full archives.
+	preview int64
+
+	// The archive is trimmed with the trimPolicies when estimated above maxArchiveSize.
+	maxArchiveSize int64
+	trimPolicies   []string
+	logTail        int64
+
+	classification    string
+	ackClassification bool
+
+	// stateMaxSize limits the state directory besides the spool queue, see pruneState.
+	stateMaxSize int64
+}
+
+func (c *credsResponse) uploadFile(body io.Reader, metadata map[string]string, opts *options) (*s3manager.UploadOutput, error) {
+	if err := chaos.expiredToken(); err != nil {
+		return nil, err
+	}
+
+	s, err := c.createSession(opts.assumeRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return uploadFileToS3(s, c, body, metadata, opts)
+}
+
+// requestCreds requests S3 credentials from Hydra for a public attachment with the given file name.
+func requestCreds(fileName string) (*credsResponse, error) {
+	return requestCredsFor(&hydra.Request{FileName: fileName, IsPrivate: "false"})
+}
+
+// requestCredsFor requests S3 credentials from Hydra for the attachment described by req.
+func requestCredsFor(req *hydra.Request) (*credsResponse, error) {
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Credentials of a new attachment are never reused: a later upload under the same name would overwrite
+	// the object uploaded with them. Only a resumed upload, bound to the key of its object, reuses them.
+	return requestCredsAt(getenv(envHydraURL), req.FileName, reqData, req.Key != "")
+}
+
+// requestReadCreds requests S3 credentials for reading the object at key, or listing the objects under the
+// key prefix, from the Hydra endpoint in HSU_HYDRA_DOWNLOAD_URL. Unlike the upload credentials, they do not
+// register a new attachment.
+func requestReadCreds(key string) (*credsResponse, error) {
+	downloadURL := getenv(envHydraDownloadURL)
+	if downloadURL == "" {
+		return nil, fmt.Errorf("%s is not set, downloads, restores and reports need the Hydra endpoint issuing read credentials", envHydraDownloadURL)
+	}
+
+	reqData, err := json.Marshal(&hydra.ReadRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	return requestCredsAt(downloadURL, key, reqData, true)
+}
+
+// requestCredsAt posts the credentials request to the Hydra endpoint at hydraURL. The credentials of cacheable
+// requests, which must name an existing object, are reused by the same request until they expire.
+// name identifies the requested object in the messages.
+func requestCredsAt(hydraURL, name string, reqData []byte, cacheable bool) (*credsResponse, error) {
+	cachePath := ""
+	if cacheable {
+		cachePath = credsCachePath(hydraURL, reqData)
+	}
+	if cached := cachedCreds(cachePath); cached != nil {
+		klog.V(1).Infoln("Reusing the cached credentials of", name)
+		creds, err := decodeCreds(cached, hydraResponseMapping)
+		if err == nil {
+			creds.cachePath = cachePath
+		}
+		return creds, err
+	}
+
+	client := &hydra.Client{URL: hydraURL, Send: hydraPost}
+	var body []byte
+	err := retries.do("Hydra credentials request", func(int) (bool, error) {
+		var err error
+		body, err = client.Exchange(reqData)
+		var statusErr *hydra.StatusError
+		switch {
+		case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestEntityTooLarge:
+			return false, &sizeLimitError{err: fmt.Errorf("Hydra rejected the attachment: %s", statusErr.Status)}
+		case errors.As(err, &statusErr):
+			return transientStatus(statusErr.StatusCode), err
+		}
+		return isNetworkError(err), err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := decodeCreds(body, hydraResponseMapping)
+	if err != nil {
+		return nil, err
+	}
+	creds.cachePath = cachePath
+	if err := cacheCreds(cachePath, creds, body); err != nil {
+		klog.Warningln("Unable to cache the credentials --", err)
+	}
+
+	return creds, nil
+}
+
+func uploadFileToS3(s *session.Session, creds *credsResponse, body io.Reader, metadata map[string]string, opts *options) (*s3manager.UploadOutput, error) {
+	for {
+		uploader := &upload.Uploader{
+			Session:      s,
+			Bucket:       creds.BucketName,
+			Key:          creds.Key,
+			PartSize:     opts.partSize,
+			Concurrency:  opts.concurrency,
+			StorageClass: opts.storageClass,
+			Tags:         opts.tags,
+		}
+
+		// Near the memory limit the upload is canceled and, when its body can be read again,
+		// restarted with smaller parts rather than letting the pod be OOM-killed.
+		ctx, cancel := context.WithCancel(context.Background())
+		stop := watchMemory(cancel)
+		output, err := uploader.Upload(ctx, body, metadata)
+		pressure := stop()
+		cancel()
+		if !pressure {
+			return output, err
+		}
+
+		seeker, ok := body.(io.Seeker)
+		if !ok || !opts.downgradeMemory() {
+			return nil, errMemoryPressure
+		}
+		_, err = seeker.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// usesHydra reports whether the upload requests its credentials from Hydra.
+func (o *options) usesHydra() bool {
+	return o.presigned == nil && !o.direct
+}
+
+// nameOf returns the attachment name of an archive, the archive file name unless set by --name.
+func (o *options) nameOf(archivePath string) string {
+	if o.objectName != "" {
+		return o.objectName
+	}
+
+	return filepath.Base(archivePath)
+}
+
+// archiveExtension returns the file name suffix of archives, e.g. ".tar.gz".
+func (o *options) archiveExtension() string {
+	ext := o.format.Extension() + o.codec.Extension()
+	if o.keyWrapper != nil {
+		ext += ".enc"
+	}
+
+	return ext
+}
+
+// subcommands maps the optional first argument to its handler.
+// Without a known subcommand, the Must-Gather directory is uploaded.
+var subcommands = map[string]func(args []string){
+	"restore":        runRestore,
+	"fleet":          runFleet,
+	"download":       runDownload,
+	"report":         runReport,
+	"decrypt":        runDecrypt,
+	"watch":          runWatch,
+	"self-update":    runSelfUpdate,
+	"analyze":        runAnalyze,
+	"login":          runLogin,
+	"logout":         runLogout,
+	"install-plugin": runInstallPlugin,
+	"serve":          runServe,
+	"audit-verify":   runAuditVerify,
+	"verify-archive": runVerifyArchive,
+	"context":        runContext,
+	"config":         runConfig,
+	"diagnose":       runDiagnose,
+}
+
+func main() {
+	warnIfRoot()
+	// The selected context takes precedence over the central configuration,
+	// and both are validated with the environment.
+	args := selectContext(os.Args[1:])
+	err := applyCentralConfig()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	err = validateEnv()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	err = loadResponseMapping()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	installProxy()
+	installResolver()
+
+	if len(args) > 0 {
+		if cmd, ok := subcommands[args[0]]; ok {
+			cmd(args[1:])
+			return
+		}
+	}
+
+	runUpload(args)
+}
+
+// uploadDir streams the archive of srcDir into the upload when the options allow, and otherwise archives
+// srcDir into a temporary archive file for tmpTar, which is gone once the upload ends unless the archive
+// is kept at tmpTar, and uploads the archive using freshly requested Hydra credentials.
+// Streaming uploads fall back to the archive file when they fail. An archive file that does not fit on the disk
+// fails the upload with a diskFullError, since streaming was disabled or has failed already.
+func uploadDir(srcDir, tmpTar string, opts *options) error {
+	if opts.skipUploaded(srcDir) {
+		return nil
+	}
+	opts = opts.withReceipt(srcDir)
+	opts.pruneState()
+	if opts.indexed || opts.seekable() {
+		withIndex := *opts
+		withIndex.index = &archive.Index{}
+		opts = &withIndex
+	}
+
+	if opts.usesHydra() {
+		err := checkHydraHealth()
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(srcDir); err == nil {
+		if opts.maxArchiveSize > 0 {
+			opts, err = opts.withSizeBudget(srcDir)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = opts.checkClassification(srcDir)
+		if err != nil {
+			return err
+		}
+
+		err = opts.checkPolicy(srcDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.keepArchive {
+		checksum, err := archiveDir(srcDir, tmpTar, opts)
+		if err != nil {
+			return err
+		}
+		klog.Infoln("Keeping the archive file", tmpTar)
+		return uploadArchiveFile(tmpTar, checksum, opts)
+	}
+
+	if opts.stream && opts.usesHydra() && !opts.checksumSidecar {
+		klog.V(1).Infoln("Writing a temporary archive file, streaming through Hydra needs --checksum-sidecar to store the checksum")
+	} else if opts.stream && !opts.canStream() {
+		klog.V(1).Infoln("Writing a temporary archive file, the options need the archive size or content before the upload")
+	}
+	if opts.stream && opts.canStream() {
+		err := streamDirWithRetries(srcDir, opts.nameOf(tmpTar), opts)
+		if err == nil {
+			return nil
+		}
+		klog.Warningln("Streaming upload failed, falling back to a temporary archive file --", err)
+	}
+
+	klog.Infoln(tr("Creating a temporary archive file..."))
+	f, err := createTempArchive(tmpTar)
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary archive file -- %w", err)
+	}
+	klog.Infoln(tr("Temporary archive file created"))
+	defer f.Close()
+
+	checksum, err := writeArchiveFile(srcDir, f.File, opts)
+	if err != nil {
+		return err
+	}
+
+	return uploadOpenArchive(f.File, checksum, opts)
+}
+
+// writeArchive writes the archive of srcDir and the extra sources into w, encrypted when requested.
+func writeArchive(srcDir string, w io.Writer, opts *options) error {
+	// Encrypt the compressed archive with an envelope data key when requested.
+	var encrypter io.WriteCloser
+	if opts.keyWrapper != nil {
+		var err error
+		encrypter, err = newEncryptingWriter(w, opts.keyWrapper)
+		if err != nil {
+			return fmt.Errorf("Unable to set up archive encryption -- %w", err)
+		}
+		w = encrypter
+	}
+
+	err := dirToTar(srcDir, w, opts)
+	if err == nil && encrypter != nil {
+		err = encrypter.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to archive Must-Gather directory -- %w", err)
+	}
+
+	return nil
+}
+
+// archiveDir writes the archive of srcDir and the extra sources to archivePath and returns its checksum.
+// The archive is written to archivePath with the partialSuffix and only renamed once complete and synced,
+// so that a crash or a full disk never leaves a truncated archive at archivePath.
+func archiveDir(srcDir, archivePath string, opts *options) (string, error) {
+	klog.Infoln(tr("Creating a temporary archive file..."))
+	partialPath := archivePath + partialSuffix
+	f, err := os.Create(partialPath)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create temporary archive file -- %w", err)
+	}
+	klog.Infoln(tr("Temporary archive file created"))
+
+	checksum, err := writeArchiveFile(srcDir, f, opts)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Unable to write archive file -- %w", closeErr)
+	}
+	if err == nil {
+		err = os.Rename(partialPath, archivePath)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return "", err
+	}
+	syncDir(filepath.Dir(archivePath))
+
+	return checksum, nil
+}
+
+// writeArchiveFile writes the archive of srcDir and the extra sources into f and returns its checksum.
+func writeArchiveFile(srcDir string, f *os.File, opts *options) (checksum string, err error) {
+	defer opts.telemetry.record("archive", time.Now(), &err)
+
+	klog.Infoln(tr("Archiving the Must-Gather directory into the temporary file..."))
+	hash, err := newChecksummer(opts.hash)
+	if err != nil {
+		return "", err
+	}
+	defer hash.checksum()
+	tracker := opts.progress.track(filepath.Base(f.Name()), "archive", 0)
+
+	err = writeArchive(srcDir, tracker.writer(io.MultiWriter(f, hash)), opts)
+	if err != nil {
+		return "", opts.diagnoseDiskFull(err, f, srcDir)
+	}
+	checksum, err = hash.checksum()
+	if err != nil {
+		return "", fmt.Errorf("Unable to compute archive checksum -- %w", err)
+	}
+	// Errors of delayed writes, such as a full disk, only surface here.
+	err = f.Sync()
+	if err != nil {
+		return "", opts.diagnoseDiskFull(fmt.Errorf("Unable to write archive file -- %w", err), f, srcDir)
+	}
+	klog.Infoln(tr("Must-Gather directory archived")+",", hashName(opts.hash)+":", checksum)
+	tracker.finish()
+
+	return checksum, nil
+}
+
+// uploadArchiveFile uploads an archive previously written by archiveDir.
+func uploadArchiveFile(archivePath, checksum string, opts *options) error {
+	if strings.HasSuffix(archivePath, partialSuffix) {
+		return fmt.Errorf("Refusing to upload %s, which is an incomplete archive", archivePath)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("Unable to open archive file -- %w", err)
+	}
+	defer f.Close()
+
+	return uploadOpenArchive(f, checksum, opts)
+}
+
+// uploadOpenArchive uploads an archive file written by writeArchiveFile.
+func uploadOpenArchive(f *os.File, checksum string, opts *options) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Unable to read archive file size -- %w", err)
+	}
+
+	if opts.budget != nil {
+		err = opts.budget.acquire(f.Name(), info.Size())
+		if err != nil {
+			return err
+		}
+	}
+
+	return uploadArchive(f, info.Size(), checksum, opts)
+}
+
+// uploadArchive uploads the archive, split into consecutive objects of at most opts.splitSize bytes
+// when it is larger than that. Every object is uploaded with its own set of Hydra credentials.
+func uploadArchive(f *os.File, size int64, checksum string, opts *options) (err error) {
+	defer opts.telemetry.record("upload", time.Now(), &err)
+
+	receipt := &uploadReceipt{Case: opts.caseID, Checksum: checksum, Algorithm: opts.hash, Size: size}
+	if receipt.Case == "" {
+		receipt.Case = "unknown"
+	}
+
+	err = opts.policy.checkArchiveSize(size)
+	if err != nil {
+		return err
+	}
+
+	name := opts.nameOf(f.Name())
+	if opts.audit != nil {
+		err = opts.audit.record(auditUploadStarted, map[string]string{"name": name, "size": strconv.FormatInt(size, 10), opts.hash: checksum})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				opts.audit.record(auditUploadFailed, map[string]string{"name": name, "error": err.Error()})
+			} else {
+				err = opts.audit.record(auditUploadFinished, map[string]string{"name": name, "keys": strings.Join(receipt.Keys, ",")})
+			}
+		}()
+	}
+
+	tracker := opts.progress.track(name, "upload", size)
+	if tracker != nil && opts.resumable && opts.presigned == nil {
+		tracker.pausesBetweenParts = true
+	}
+	body := tracker.readerAt(f)
+
+	// Presigned URLs need neither Hydra nor AWS credentials.
+	if opts.presigned != nil {
+		if err := waitToTransfer(opts.window, opts.progress.cancellation()); err != nil {
+			return err
+		}
+		klog.Infoln(tr("Uploading Must-Gather archive through presigned URLs..."))
+		err := opts.presigned.upload(body, size, opts.concurrency)
+		if limitErr, ok := asSizeLimit(err); ok {
+			return opts.handleSizeLimit(f, size, checksum, limitErr)
+		}
+		if err != nil {
+			return fmt.Errorf("Could not upload file -- %w", err)
+		}
+		klog.Infoln(tr("Must-Gather archive uploaded"))
+
+		receipt.Keys = append(receipt.Keys, presignedKey(opts.presigned.objectURL()))
+		if opts.verify != "" && opts.verify != verifyNone {
+			klog.Warningln("Presigned uploads cannot be read back, skipping verification")
+		}
+		tracker.finish()
+		opts.notifyUploaded(receipt)
+
+		return nil
+	}
+
+	count, pieceSize := int64(1), size
+	if opts.splitSize > 0 && size > opts.splitSize {
+		count = (size + opts.splitSize - 1) / opts.splitSize
+		pieceSize = opts.splitSize
+	}
+
+	for i := int64(0); i < count; i++ {
+		// Every object carries the checksum of the whole archive.
+		metadata := map[string]string{opts.hash: checksum}
+		if opts.audit != nil {
+			metadata["audit-head"] = opts.audit.headHash()
+		}
+		if opts.keyWrapper != nil {
+			metadata["envelope-provider"] = opts.keyWrapper.provider()
+			metadata["envelope-key-id"] = opts.keyWrapper.keyID()
+		}
+		if count > 1 {
+			metadata["split-part"] = strconv.FormatInt(i+1, 10)
+			metadata["split-count"] = strconv.FormatInt(count, 10)
+			klog.Infoln(trf("Uploading archive piece %d of %d", i+1, count))
+		}
+
+		offset := i * pieceSize
+		length := pieceSize
+		if offset+length > size {
+			length = size - offset
+		}
+
+		// Credentials are requested once the window opens, so that they do not expire while waiting.
+		if err := waitToTransfer(opts.window, opts.progress.cancellation()); err != nil {
+			return err
+		}
+		statePath := uploadStatePath(checksum, i)
+		requestName := name
+		var creds *credsResponse
+		err := retries.do("Upload", func(int) (bool, error) {
+			if creds == nil {
+				// A resumed upload keeps the attachment name and the object key it was started with, possibly
+				// in an earlier run, also when its credentials expire.
+				key := ""
+				if opts.resumable {
+					requestName, key = resumedObject(statePath, name)
+				}
+				klog.Infoln(tr("Requesting AWS S3 credentials..."))
+				var err error
+				creds, err = opts.credsSource(requestName, i, count, key)
+				if err != nil {
+					// The credentials request retries on its own.
+					return false, fmt.Errorf("Credentials request failed -- %w", err)
+				}
+				klog.Infoln(tr("S3 credentials received"))
+			}
+
+			klog.Infoln(tr("Uploading Must-Gather archive..."))
+			var err error
+			if creds.presigned != nil {
+				// Metadata cannot be added to presigned uploads, their signature covers the headers.
+				err = creds.presigned.upload(io.NewSectionReader(body, offset, length), length, opts.concurrency)
+			} else if opts.resumable {
+				err = creds.uploadResumable(io.NewSectionReader(body, offset, length), length, metadata, statePath, requestName, opts)
+			} else {
+				_, err = creds.uploadFile(io.NewSectionReader(body, offset, length), metadata, opts)
+			}
+			if err == nil {
+				return false, nil
+			}
+
+			if isExpiredToken(err) {
+				klog.Warningln("The S3 credentials expired, requesting fresh ones")
+				creds.forgetCached()
+				creds = nil
+			}
+			return transientUploadError(err), fmt.Errorf("Could not upload file -- %w", err)
+		})
+		if limitErr, ok := asSizeLimit(err); ok && i == 0 {
+			return opts.handleSizeLimit(f, size, checksum, limitErr)
+		}
+		if err != nil {
+			return err
+		}
+		klog.Infoln(tr("Must-Gather archive uploaded"))
+		if creds.presigned != nil {
+			if creds.Key == "" {
+				creds.Key = presignedKey(creds.presigned.objectURL())
+			}
+			receipt.Keys = append(receipt.Keys, creds.Key)
+			if opts.verify != "" && opts.verify != verifyNone {
+				klog.Warningln("Presigned uploads cannot be read back, skipping verification")
+			}
+			continue
+		}
+		receipt.Keys = append(receipt.Keys, creds.Key)
+		if opts.checksumSidecar && i == 0 {
+			err = creds.uploadChecksumSidecar(name, checksum, opts)
+			if err != nil {
+				return err
+			}
+		}
+		if opts.index != nil && i == 0 {
+			creds.uploadIndexSidecar(opts.index, opts)
+		}
+
+		if opts.verify != "" && opts.verify != verifyNone {
+			err = creds.verifyObject(io.NewSectionReader(f, offset, length), opts)
+			if err != nil {
+				return fmt.Errorf("Upload verification failed -- %w", err)
+			}
+		}
+	}
+
+	tracker.finish()
+	err = opts.completeUpload(name, receipt)
+	if err != nil {
+		return err
+	}
+	opts.notifyUploaded(receipt)
+	return nil
+}
+
+func runUpload(args []string) {
+	var podSources, nodeSources, logNodes, containers, mergeDirs stringList
+	flags := flag.NewFlagSet(commandName(), flag.ExitOnError)
+	uploadFlags := addUploadFlags(flags)
+	srcDir := flags.String("src-dir", envDefault(envSrcDir, "./must-gather/"), "Gather directory to archive and upload when no directories or files are given as arguments (defaults to "+envSrcDir+", then ./must-gather/)")
+	archiveName := flags.String("archive-name", envDefault(envArchiveName, "must-gather"), "Path of the local archive file, without the extension (defaults to "+envArchiveName+", then must-gather)")
+	keepArchive := flags.Bool("keep-archive", envBool(envKeepArchive), "Keep the local archive file after the upload instead of a temporary one, never streaming (defaults to "+envKeepArchive+")")
+	flags.Var(&podSources, "from-pod", "Also collect files from a pod, as namespace/pod[/container][:path] (repeatable)")
+	flags.Var(&nodeSources, "from-node", "Also collect files from a node through a debug pod, as node[:path] (repeatable)")
+	flags.Var(&logNodes, "collect-node", "Also collect journal, kubelet/crio logs, and sysctl/network state of a node (repeatable)")
+	nodeAccess := flags.String("node-access", "debug", "How --collect-node reaches the nodes: debug (oc debug pod) or ssh")
+	sshUser := flags.String("ssh-user", "core", "User for --node-access ssh")
+	journalSince := flags.String("journal-since", "-24h", "Start of the collected journal window, in journalctl --since syntax")
+	flags.Var(&containers, "collect-container", "Also collect logs and inspect output of a local docker/podman container (repeatable)")
+	containerRuntime := flags.String("container-runtime", "", "Container runtime for --collect-container: docker or podman (detected when empty)")
+	oc := flags.String("oc", "oc", "Path to the oc binary used for remote collection")
+	presignedURL := flags.String("presigned-url", "", "Upload with a single HTTPS PUT to this presigned URL instead of requesting credentials from Hydra")
+	presignedURLs := flags.String("presigned-urls", "", "Upload through the presigned multipart URL set in this JSON file (partSize, partUrls, completeUrl)")
+	flags.Var(&mergeDirs, "merge", "Merge this gather directory into the archive under its run timestamp instead of uploading --src-dir, storing identical files once (repeatable)")
+	name := flags.String("name", "", "Attachment name of the archive (defaults to must-gather-<clusterID>-<timestamp>.tar.gz)")
+	tmpDir := flags.String("tmp-dir", "", "Directory of the temporary archive file (defaults to the working directory, or the temporary directory when it is read-only)")
+	latest := flags.Bool("latest", false, "Upload the newest completed must-gather.local.* gather found in the working directory instead of --src-dir")
+	yes := flags.Bool("yes", false, "Upload without showing the upload summary and asking for confirmation on a terminal")
+	autoCompress := flags.String("auto-compress", "", "Choose the compression from a sample of the gather for a goal: "+strings.Join(compressionGoals, ", "))
+	uplink := byteSize(10 << 20)
+	flags.Var(&uplink, "auto-compress-uplink", "Upload bandwidth per second assumed by the speed goal of --auto-compress")
+	flags.Parse(args)
+
+	dir := *srcDir
+	items, err := parseUploadItems(flags.Args())
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	if len(items) > 0 && (*latest || len(mergeDirs) > 0) {
+		klog.Fatalln("Paths to upload cannot be combined with --latest or --merge")
+	}
+	// A single directory is uploaded as --src-dir, with the remote sources and --name.
+	if len(items) == 1 && items[0].dir {
+		dir = items[0].path
+		items = nil
+	}
+
+	if *latest && len(mergeDirs) == 0 {
+		var err error
+		dir, err = discoverGather(".")
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		klog.Infoln("Uploading the latest gather", dir)
+	}
+
+	if *autoCompress != "" {
+		if !containsString(compressionGoals, *autoCompress) {
+			klog.Fatalln("Unsupported --auto-compress goal --", *autoCompress)
+		}
+		if uploadFlags.compression != "" {
+			klog.Fatalln("Only one of --compression and --auto-compress can be used")
+		}
+		if uplink <= 0 {
+			klog.Fatalln("The --auto-compress-uplink value must be positive")
+		}
+
+		sampled := []string{dir}
+		if len(mergeDirs) > 0 {
+			sampled = mergeDirs
+		} else if len(items) > 0 {
+			sampled = nil
+			for _, item := range items {
+				if item.dir {
+					sampled = append(sampled, item.path)
+				}
+			}
+		}
+		if len(sampled) > 0 {
+			compression, err := chooseCompression(sampled, *autoCompress, int64(uplink))
+			if err != nil {
+				klog.Fatalln("Unable to sample the gather --", err)
+			}
+			uploadFlags.compression = compression
+		}
+	}
+
+	opts, err := uploadFlags.options()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	opts.keepArchive = *keepArchive
+
+	for _, spec := range podSources {
+		source, err := parsePodSource(spec, *oc)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		opts.sources = append(opts.sources, source)
+	}
+
+	for _, spec := range nodeSources {
+		source, err := parseNodeSource(spec, *oc)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		opts.sources = append(opts.sources, source)
+	}
+
+	for _, node := range logNodes {
+		source, err := nodeLogSource(node, *nodeAccess, *sshUser, *journalSince, *oc)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		opts.sources = append(opts.sources, source)
+	}
+
+	if len(containers) > 0 {
+		sources, err := containerSources(containers, *containerRuntime, *journalSince)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		opts.sources = append(opts.sources, sources...)
+	}
+
+	switch {
+	case *presignedURL != "" && *presignedURLs != "":
+		klog.Fatalln("Only one of --presigned-url and --presigned-urls can be used")
+	case *presignedURL != "":
+		opts.presigned = &presignedUpload{URL: *presignedURL}
+	case *presignedURLs != "":
+		opts.presigned, err = loadPresignedUpload(*presignedURLs)
+		if err != nil {
+			klog.Fatalln("Unable to load presigned URLs --", err)
+		}
+	}
+
+	if opts.presigned != nil && (opts.splitSize > 0 || uploadFlags.direct.enabled()) {
+		klog.Fatalln("Presigned URLs cannot be combined with --split-size or --bucket")
+	}
+
+	if *name != "" {
+		opts.objectName = objectNameWithExtension(*name, opts.archiveExtension())
+	} else if opts.preview > 0 {
+		opts.objectName = defaultObjectName(*oc, "-preview"+opts.archiveExtension())
+	} else if opts.requested != nil {
+		opts.objectName = defaultObjectName(*oc, "-selection"+opts.archiveExtension())
+	} else {
+		opts.objectName = defaultObjectName(*oc, opts.archiveExtension())
+	}
+
+	if len(items) > 0 {
+		if *name != "" {
+			klog.Fatalln("The --name flag names a single upload, the items are named after their paths")
+		}
+		err = opts.checkItems(items)
+		if err == nil {
+			err = opts.nameItems(items)
+		}
+		if err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
+	if len(mergeDirs) > 0 {
+		opts.sources = append([]archiveSource{&mergeSource{dirs: mergeDirs, hash: opts.hash}}, opts.sources...)
+		dir = ""
+	}
+
+	// Keep the archive next to the gather unless the working directory is read-only.
+	tmpArchive := strings.TrimSuffix(*archiveName, opts.archiveExtension())
+	archivePath := filepath.Join(writableDir(filepath.Dir(tmpArchive)), filepath.Base(tmpArchive))
+	if *tmpDir != "" {
+		archivePath = filepath.Join(*tmpDir, filepath.Base(tmpArchive))
+	}
+	err = opts.checkCase()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	if !*yes {
+		destination := "Hydra " + getenv(envHydraURL)
+		switch {
+		case opts.presigned != nil:
+			destination = "presigned URL"
+			if u, err := url.Parse(opts.presigned.objectURL()); err == nil {
+				destination += " on " + u.Host
+			}
+		case opts.direct:
+			destination = "s3://" + uploadFlags.direct.bucket + " with the local AWS configuration"
+		case activeContextName != "":
+			destination += " (context " + activeContextName + ")"
+		}
+		confirmed := items
+		if len(confirmed) == 0 {
+			confirmed = []*uploadItem{{path: dir, dir: true, objectName: opts.objectName}}
+		}
+		err = opts.confirmUpload(confirmed, destination)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
+	cancel := cancelOnSignal()
+	pauseOnSignal()
+	opts.progress = opts.progress.withCancel(cancel)
+	if len(items) > 0 {
+		uploadItems(items, filepath.Dir(archivePath), cancel, opts)
+		failed := printItemSummary(items)
+		if failed == 0 {
+			return
+		}
+		for _, item := range items {
+			if isNetworkError(item.err) {
+				logConnectivityReport(connectivityEndpoints())
+				break
+			}
+		}
+		klog.Fatalf("%d of %d items were not uploaded", failed, len(items))
+	}
+	err = uploadDir(dir, archivePath+opts.archiveExtension(), opts)
+	if err != nil {
+		select {
+		case <-cancel:
+			klog.Fatalln("Upload canceled --", err)
+		default:
+		}
+		// The SDK retries failed requests, so a network error means repeated failures.
+		if isNetworkError(err) {
+			logConnectivityReport(connectivityEndpoints())
+		}
+		klog.Fatalln(err)
+	}
+}
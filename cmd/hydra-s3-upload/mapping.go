@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// responseMapping maps the fields of credsResponse to their paths in the response of a Hydra-like service
+// with differently named fields, e.g. {"accessKey": "data.aws.access_key_id"}. Path elements are object keys
+// or array indices, and the unmapped fields keep their names.
+type responseMapping map[string]string
+
+// hydraResponseMapping is the mapping of the credentials responses, from the JSON file named by
+// HSU_HYDRA_RESPONSE_MAPPING, the selected context, or the central configuration.
+var hydraResponseMapping responseMapping
+
+func (m responseMapping) validate() error {
+	for field := range m {
+		if m.target(&credsResponse{}, field) == nil {
+			return fmt.Errorf("Unknown credentials response field %q in the response mapping", field)
+		}
+	}
+
+	return nil
+}
+
+func (m responseMapping) target(c *credsResponse, field string) *string {
+	switch field {
+	case "bucketName":
+		return &c.BucketName
+	case "secretKey":
+		return &c.SecretKey
+	case "accessKey":
+		return &c.AccessKey
+	case "sessionToken":
+		return &c.SessionToken
+	case "region":
+		return &c.Region
+	case "key":
+		return &c.Key
+	case "expiration":
+		return &c.Expiration
+	case "url", "completeUrl":
+		if c.presigned == nil {
+			c.presigned = &presignedUpload{}
+		}
+		if field == "url" {
+			return &c.presigned.URL
+		}
+		return &c.presigned.CompleteURL
+	}
+
+	return nil
+}
+
+// loadResponseMapping reads the mapping file named by HSU_HYDRA_RESPONSE_MAPPING, if any.
+func loadResponseMapping() error {
+	filePath := getenv(envHydraResponseMapping)
+	if filePath == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	mapping := responseMapping{}
+	err = json.Unmarshal(content, &mapping)
+	if err != nil {
+		return fmt.Errorf("Unable to parse the response mapping %s -- %w", filePath, err)
+	}
+
+	hydraResponseMapping = mapping
+	return mapping.validate()
+}
+
+// decodeCreds decodes a credentials response, following the response mapping. Responses with
+// presigned URLs instead of credentials switch the upload to the presigned URLs.
+func decodeCreds(body []byte, mapping responseMapping) (*credsResponse, error) {
+	creds := &credsResponse{}
+	err := json.Unmarshal(body, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	presigned := &presignedUpload{}
+	err = json.Unmarshal(body, presigned)
+	if err != nil {
+		return nil, err
+	}
+	if presigned.URL != "" || len(presigned.PartURLs) > 0 {
+		creds.presigned = presigned
+	}
+	if len(mapping) == 0 {
+		return creds, creds.validatePresigned()
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(body, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for field, path := range mapping {
+		target := mapping.target(creds, field)
+		if target == nil {
+			return nil, fmt.Errorf("Unknown credentials response field %q in the response mapping", field)
+		}
+
+		value, err := lookupPath(doc, path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to map the %s credentials response field -- %w", field, err)
+		}
+		*target = value
+	}
+
+	return creds, creds.validatePresigned()
+}
+
+func (c *credsResponse) validatePresigned() error {
+	if c.presigned == nil {
+		return nil
+	}
+
+	err := c.presigned.validate()
+	if err != nil {
+		return fmt.Errorf("Invalid presigned URLs in the credentials response -- %w", err)
+	}
+
+	return nil
+}
+
+// lookupPath returns the string or number at a dot-separated path of a decoded JSON document.
+func lookupPath(doc interface{}, path string) (string, error) {
+	value := doc
+	for _, element := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			value, ok = v[element]
+			if !ok {
+				return "", fmt.Errorf("%s has no %q element", path, element)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(element)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("%s has no %q element", path, element)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("%s has no %q element", path, element)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+
+	return "", fmt.Errorf("%s is not a string", path)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"k8s.io/klog"
+)
+
+// memoryGCPercent keeps the heap growth between collections small under a memory ceiling.
+// The Go runtime this tool targets has no soft memory limit (GOMEMLIMIT), so a more
+// aggressive collector is the closest equivalent.
+const memoryGCPercent = 50
+
+// applyMemoryCeiling sizes the upload part buffers and their concurrency to stay under maxMemory.
+// A quarter of the ceiling is left to the runtime, the archive writers, and TLS buffers.
+func (o *options) applyMemoryCeiling(maxMemory int64) error {
+	budget := maxMemory * 3 / 4
+	if budget < s3manager.MinUploadPartSize {
+		return fmt.Errorf("The --max-memory value must be at least %s", formatByteSize(s3manager.MinUploadPartSize*4/3+1))
+	}
+
+	partSize, concurrency := o.partSize, o.concurrency
+	if partSize == 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	if concurrency == 0 {
+		concurrency = s3manager.DefaultUploadConcurrency
+	}
+
+	// Give up parallelism first, then part size.
+	if partSize*int64(concurrency) > budget {
+		concurrency = int(budget / partSize)
+		if concurrency < 1 {
+			concurrency = 1
+			partSize = budget
+		}
+		klog.Warningf("Limiting uploads to %d part(s) of %s in parallel to stay under --max-memory", concurrency, formatByteSize(partSize))
+	}
+
+	o.partSize, o.concurrency = partSize, concurrency
+	debug.SetGCPercent(memoryGCPercent)
+
+	return nil
+}
+
+// memoryPressureRatio is the share of the cgroup memory limit above which an upload is
+// restarted with smaller parts, before the kernel OOM-kills the pod.
+const memoryPressureRatio = 0.9
+
+// memoryCheckInterval is how often the memory usage is sampled during an upload.
+const memoryCheckInterval = time.Second
+
+// errMemoryPressure aborts an upload approaching the memory limit.
+var errMemoryPressure = errors.New("The memory usage approached the memory limit, rerun with a lower --max-memory")
+
+// cgroupRoot is where the cgroup filesystem of the process is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupMemory returns the memory usage and limit of the cgroup the process runs in, cgroup v2 first.
+// The usage leaves out the inactive page cache, which the kernel reclaims before it OOM-kills the pod,
+// and which archiving large directories fills up to the limit.
+// ok is false outside of a memory-limited cgroup, e.g. on other platforms.
+func cgroupMemory() (usage, limit int64, ok bool) {
+	for _, files := range [][4]string{
+		{"memory.current", "memory.max", "memory.stat", "inactive_file"},
+		{"memory/memory.usage_in_bytes", "memory/memory.limit_in_bytes", "memory/memory.stat", "total_inactive_file"},
+	} {
+		usage, err := readCgroupValue(filepath.Join(cgroupRoot, files[0]))
+		if err != nil {
+			continue
+		}
+		limit, err := readCgroupValue(filepath.Join(cgroupRoot, files[1]))
+		// cgroup v1 reports no limit as a huge number rather than "max".
+		if err != nil || limit <= 0 || limit >= 1<<62 {
+			return 0, 0, false
+		}
+		inactive, err := readCgroupStat(filepath.Join(cgroupRoot, files[2]), files[3])
+		if err == nil && inactive < usage {
+			usage -= inactive
+		}
+
+		return usage, limit, true
+	}
+
+	return 0, 0, false
+}
+
+// readCgroupStat returns the value of the key in a memory.stat file.
+func readCgroupStat(path, key string) (int64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("No %s in %s", key, path)
+}
+
+func readCgroupValue(path string) (int64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return -1, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// watchMemory cancels the upload context when the cgroup memory usage passes memoryPressureRatio of its limit.
+// The returned function stops watching and reports whether the upload was canceled for it.
+func watchMemory(cancel context.CancelFunc) func() bool {
+	if _, _, ok := cgroupMemory(); !ok {
+		return func() bool { return false }
+	}
+
+	done := make(chan struct{})
+	var pressure int32
+	go func() {
+		ticker := time.NewTicker(memoryCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			usage, limit, ok := cgroupMemory()
+			if ok && float64(usage) > float64(limit)*memoryPressureRatio {
+				klog.Warningf("Memory usage %s is close to the limit of %s", formatByteSize(usage), formatByteSize(limit))
+				atomic.StoreInt32(&pressure, 1)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		return atomic.LoadInt32(&pressure) == 1
+	}
+}
+
+// downgradeMemory halves the upload concurrency, then the part size, down to a single part of
+// s3manager.MinUploadPartSize. It returns false when the upload cannot use any less memory.
+func (o *options) downgradeMemory() bool {
+	partSize, concurrency := o.partSize, o.concurrency
+	if partSize == 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	if concurrency == 0 {
+		concurrency = s3manager.DefaultUploadConcurrency
+	}
+
+	switch {
+	case concurrency > 1:
+		concurrency /= 2
+	case partSize > s3manager.MinUploadPartSize:
+		partSize /= 2
+		if partSize < s3manager.MinUploadPartSize {
+			partSize = s3manager.MinUploadPartSize
+		}
+	default:
+		return false
+	}
+
+	klog.Warningf("Retrying the upload with %d part(s) of %s in parallel to reduce the memory usage", concurrency, formatByteSize(partSize))
+	o.partSize, o.concurrency = partSize, concurrency
+	debug.SetGCPercent(memoryGCPercent)
+	debug.FreeOSMemory()
+
+	return true
+}
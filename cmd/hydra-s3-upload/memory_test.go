@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupMemory(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		usage int64
+		limit int64
+		ok    bool
+	}{
+		{
+			name: "v2 without the inactive page cache",
+			files: map[string]string{
+				"memory.current": "900\n",
+				"memory.max":     "1000\n",
+				"memory.stat":    "anon 300\nfile 600\nactive_file 100\ninactive_file 500\n",
+			},
+			usage: 400, limit: 1000, ok: true,
+		},
+		{
+			name: "v2 without a limit",
+			files: map[string]string{
+				"memory.current": "900\n",
+				"memory.max":     "max\n",
+			},
+		},
+		{
+			name: "v1 without the inactive page cache",
+			files: map[string]string{
+				"memory/memory.usage_in_bytes": "900\n",
+				"memory/memory.limit_in_bytes": "1000\n",
+				"memory/memory.stat":           "cache 600\ninactive_file 50\ntotal_inactive_file 500\n",
+			},
+			usage: 400, limit: 1000, ok: true,
+		},
+		{
+			name: "v1 without a limit",
+			files: map[string]string{
+				"memory/memory.usage_in_bytes": "900\n",
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+		},
+		{
+			name: "v1 without memory.stat",
+			files: map[string]string{
+				"memory/memory.usage_in_bytes": "900\n",
+				"memory/memory.limit_in_bytes": "1000\n",
+			},
+			usage: 900, limit: 1000, ok: true,
+		},
+		{
+			name: "no cgroup",
+		},
+	}
+
+	oldRoot := cgroupRoot
+	defer func() { cgroupRoot = oldRoot }()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cgroup-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range test.files {
+				os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+				err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cgroupRoot = dir
+			usage, limit, ok := cgroupMemory()
+			if usage != test.usage || limit != test.limit || ok != test.ok {
+				t.Errorf("Expected %d, %d, %v, got %d, %d, %v", test.usage, test.limit, test.ok, usage, limit, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// mergeSource consolidates several gather runs into the archive, each under its run timestamp.
+// Files identical to one already stored are recorded as hard links to it, in the formats supporting them.
+type mergeSource struct {
+	dirs []string
+	hash string
+}
+
+func (m *mergeSource) name() string {
+	return "merged gathers"
+}
+
+// runPrefix names the directory a gather run is stored under, e.g. "20201231T235959Z".
+func runPrefix(dir string, used map[string]bool) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := fileTimestamp(info.ModTime())
+	if used[prefix] {
+		prefix += "-" + filepath.Base(dir)
+	}
+	used[prefix] = true
+
+	return prefix, nil
+}
+
+func (m *mergeSource) writeTo(a *tarArchive) error {
+	// Archive names of the stored file contents by their checksum.
+	stored := map[string]string{}
+	used := map[string]bool{}
+
+	for _, dir := range m.dirs {
+		prefix, err := runPrefix(dir, used)
+		if err != nil {
+			return err
+		}
+
+		err = filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(dir, fullPath)
+			if err != nil {
+				return nil
+			}
+			name := path.Join(prefix, filepath.ToSlash(relPath))
+
+			checksum, err := fileChecksum(fullPath, m.hash)
+			if err != nil {
+				return err
+			}
+
+			if first, ok := stored[checksum]; ok && a.format.HardLinks() {
+				err = a.audit.record(auditFileIncluded, map[string]string{"name": name, "link": first})
+				if err != nil {
+					return err
+				}
+				return a.entries.WriteHeader(&tar.Header{
+					Typeflag: tar.TypeLink,
+					Name:     name,
+					Linkname: first,
+					Mode:     int64(info.Mode()),
+					ModTime:  info.ModTime(),
+				})
+			}
+			stored[checksum] = name
+
+			file, err := os.Open(fullPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			return a.writeEntry(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Size:     info.Size(),
+				Mode:     int64(info.Mode()),
+				ModTime:  info.ModTime(),
+			}, file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
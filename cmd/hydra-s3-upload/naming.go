@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// clusterID returns the ID of the cluster oc is logged into, or "" when there is none.
+func clusterID(oc string) string {
+	out, err := exec.Command(oc, "get", "clusterversion", "version", "--request-timeout=10s",
+		"-o", "jsonpath={.spec.clusterID}").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// defaultObjectName names an archive after the cluster it was gathered from and the time of the upload,
+// e.g. "must-gather-<clusterID>-20201231T235959Z.tar.gz". Without access to the cluster version,
+// the cluster of the current kubeconfig context identifies the cluster.
+func defaultObjectName(oc, extension string) string {
+	name := "must-gather"
+	if id := clusterID(oc); id != "" {
+		name += "-" + id
+	} else if cluster := contextClusterName(oc); cluster != "" {
+		name += "-" + cluster
+	}
+
+	return name + "-" + fileTimestamp(time.Now()) + extension
+}
+
+// objectNameWithExtension appends the archive extension to a user-provided name lacking it.
+func objectNameWithExtension(name, extension string) string {
+	if strings.HasSuffix(name, extension) {
+		return name
+	}
+
+	return name + extension
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// netrcPath returns the netrc file from NETRC, or the default one in the home directory.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+
+	return filepath.Join(home, name)
+}
+
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc returns the entries of a netrc file, the default entry having no machine.
+func parseNetrc(content string) []*netrcEntry {
+	// Macro definitions run until an empty line and hold no credentials.
+	var tokens []string
+	inMacro := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if inMacro {
+			inMacro = len(fields) > 0
+			continue
+		}
+		if len(fields) > 0 && fields[0] == "macdef" {
+			inMacro = true
+			continue
+		}
+		tokens = append(tokens, fields...)
+	}
+
+	var entries []*netrcEntry
+	var current *netrcEntry
+	for i := 0; i < len(tokens); i++ {
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+
+		switch tokens[i] {
+		case "machine":
+			current = &netrcEntry{machine: next}
+			entries = append(entries, current)
+			i++
+		case "default":
+			current = &netrcEntry{}
+			entries = append(entries, current)
+		case "login", "password", "account":
+			if current != nil && tokens[i] == "login" {
+				current.login = next
+			} else if current != nil && tokens[i] == "password" {
+				current.password = next
+			}
+			i++
+		}
+	}
+
+	return entries
+}
+
+// netrcLogin returns the login and password of the netrc entry for host, falling back to the default entry.
+func netrcLogin(content, host string) (string, string, bool) {
+	var fallback *netrcEntry
+	for _, entry := range parseNetrc(content) {
+		if entry.machine == host {
+			return entry.login, entry.password, true
+		}
+		if entry.machine == "" && fallback == nil {
+			fallback = entry
+		}
+	}
+
+	if fallback != nil {
+		return fallback.login, fallback.password, true
+	}
+
+	return "", "", false
+}
+
+// hydraBasicCredentials returns the Hydra username and password from HSU_HYDRA_USER and HSU_HYDRA_PASS,
+// or when those are not set, from the OS keyring or the netrc entry of the Hydra host.
+func hydraBasicCredentials(hydraURL string) (string, string) {
+	username, password := getenv(envHydraUser), getenv(envHydraPass)
+	if username != "" || password != "" {
+		return username, password
+	}
+
+	u, err := url.Parse(hydraURL)
+	if err != nil {
+		return "", ""
+	}
+
+	secret, err := keyringCredentials(u.Hostname())
+	if err == nil {
+		return secret.Username, secret.Password
+	}
+	if !errors.Is(err, errKeyringNotFound) {
+		klog.V(1).Infoln("Unable to read the OS keyring --", err)
+	}
+
+	path := netrcPath()
+	if path == "" {
+		return "", ""
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+
+	username, password, _ = netrcLogin(string(content), u.Hostname())
+	return username, password
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// nodeCollectScript gathers the node diagnostics into a temporary directory and writes it to stdout as a tar.
+// The journal time window is passed as the first argument. Individual failures are recorded in the output files.
+const nodeCollectScript = `
+dir=$(mktemp -d)
+journalctl --no-pager --since "$1" > "$dir/journal.log" 2>&1
+journalctl --no-pager --since "$1" -u kubelet > "$dir/kubelet.log" 2>&1
+journalctl --no-pager --since "$1" -u crio > "$dir/crio.log" 2>&1
+sysctl -a > "$dir/sysctl.txt" 2>&1
+ip addr show > "$dir/ip-addr.txt" 2>&1
+ip route show > "$dir/ip-route.txt" 2>&1
+ss -tunap > "$dir/sockets.txt" 2>&1
+tar cf - -C "$dir" .
+rm -rf "$dir"
+`
+
+// shellQuote quotes s for use as a single word in a POSIX shell command line.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// nodeLogSource returns the source collecting journal, kubelet/crio logs, and sysctl/network state of a node.
+// The node is reached either through an oc debug pod or over SSH, where passwordless sudo is required.
+func nodeLogSource(node, access, sshUser, since, oc string) (*remoteSource, error) {
+	source := &remoteSource{
+		prefix: path.Join("nodes", node),
+	}
+
+	switch access {
+	case "debug":
+		source.program = oc
+		source.args = []string{"debug", "node/" + node, "--", "chroot", "/host", "sh", "-c", nodeCollectScript, "sh", since}
+	case "ssh":
+		target := node
+		if sshUser != "" {
+			target = sshUser + "@" + node
+		}
+
+		source.program = "ssh"
+		source.args = []string{target, "sudo -n sh -c " + shellQuote(nodeCollectScript) + " sh " + shellQuote(since)}
+	default:
+		return nil, fmt.Errorf("Unsupported node access method %q, expected debug or ssh", access)
+	}
+
+	return source, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// uploadReceipt describes a completed upload to the people and systems notified about it.
+type uploadReceipt struct {
+	Case      string   `json:"case"`
+	Keys      []string `json:"keys"`
+	Checksum  string   `json:"checksum"`
+	Algorithm string   `json:"algorithm"`
+	Size      int64    `json:"size"`
+}
+
+// notifier delivers upload receipts.
+type notifier interface {
+	notify(r *uploadReceipt) error
+}
+
+func (r *uploadReceipt) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s\n", tr("Case"), r.Case)
+	for _, key := range r.Keys {
+		fmt.Fprintf(&b, "%s: %s\n", tr("Object"), key)
+	}
+	fmt.Fprintf(&b, "%s: %s\n", hashName(r.Algorithm), r.Checksum)
+	fmt.Fprintf(&b, "%s: %s (%d B)\n", tr("Size"), formatByteSize(r.Size), r.Size)
+
+	return b.String()
+}
+
+// presignedKey returns the object path of a presigned URL, without the signature.
+func presignedKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// notifyUploaded sends the receipt to every configured notifier.
+// A failed notification does not fail the upload, which has already succeeded.
+func (o *options) notifyUploaded(r *uploadReceipt) {
+	for _, n := range o.notifiers {
+		err := n.notify(r)
+		if err != nil {
+			klog.Warningln("Unable to send upload receipt --", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+)
+
+// pauseGate holds the data flows of uploads while an operator pauses them, e.g. during a traffic spike.
+// A nil gate never pauses.
+type pauseGate struct {
+	mu sync.Mutex
+	// resumed is closed on resume, nil while not paused.
+	resumed chan struct{}
+}
+
+// uploadPause is the gate of the running uploads, installed by pauseOnSignal.
+var uploadPause *pauseGate
+
+// pause holds the data flows until resume, reporting whether they were running.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.resumed != nil {
+		return false
+	}
+	g.resumed = make(chan struct{})
+
+	return true
+}
+
+// resume releases the held data flows, reporting whether they were paused.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.resumed == nil {
+		return false
+	}
+	close(g.resumed)
+	g.resumed = nil
+
+	return true
+}
+
+// paused reports whether the data flows are held.
+func (g *pauseGate) paused() bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.resumed != nil
+}
+
+// wait blocks while paused, returning errCanceled once cancel is closed.
+func (g *pauseGate) wait(cancel <-chan struct{}) error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-cancel:
+			return errCanceled
+		}
+	}
+
+	return nil
+}
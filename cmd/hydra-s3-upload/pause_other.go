@@ -0,0 +1,7 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+// pauseOnSignal does nothing, there are no SIGTSTP and SIGCONT to pause uploads with.
+func pauseOnSignal() {}
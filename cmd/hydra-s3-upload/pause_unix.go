@@ -0,0 +1,33 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog"
+)
+
+// pauseOnSignal installs the upload gate paused by SIGTSTP and resumed by SIGCONT.
+// The process keeps running while paused, so that the multipart upload and its credentials are kept.
+func pauseOnSignal() {
+	uploadPause = &pauseGate{}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTSTP, syscall.SIGCONT)
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTSTP {
+				if uploadPause.pause() {
+					klog.Warningf("Received SIGTSTP, pausing the upload until SIGCONT (kill -CONT %d)", os.Getpid())
+				}
+			} else if uploadPause.resume() {
+				klog.Infoln("Received SIGCONT, resuming the upload")
+			}
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// pluginFileName is the name the binary is installed under as an oc/kubectl plugin.
+// The plugin lookup maps dashes within a command word to underscores, so this file
+// runs as `oc must-gather-upload`, while oc-must-gather-upload would run as `oc must gather upload`.
+const pluginFileName = "oc-must_gather_upload"
+
+// pluginHost returns "oc" or "kubectl" when the binary runs as their plugin, and "" otherwise.
+func pluginHost() string {
+	base := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	for _, host := range []string{"oc", "kubectl"} {
+		if strings.HasPrefix(base, host+"-") {
+			return host
+		}
+	}
+
+	return ""
+}
+
+// commandName returns the name the user invoked the tool with, e.g. "oc must-gather-upload" in the plugin mode.
+func commandName() string {
+	host := pluginHost()
+	if host == "" {
+		return os.Args[0]
+	}
+
+	base := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	return host + " " + strings.Join(strings.Split(strings.TrimPrefix(base, host+"-"), "_"), "-")
+}
+
+// contextClusterName returns the cluster of the current kubeconfig context, or "" when there is none.
+func contextClusterName(oc string) string {
+	out, err := exec.Command(oc, "config", "view", "--minify", "-o", "jsonpath={.contexts[0].context.cluster}").Output()
+	if err != nil {
+		return ""
+	}
+
+	return sanitizeName(strings.TrimSpace(string(out)))
+}
+
+func runInstallPlugin(args []string) {
+	flags := flag.NewFlagSet(commandName()+" install-plugin", flag.ExitOnError)
+	dir := flags.String("dir", "", "Directory on the PATH receiving the plugin (defaults to the directory of this binary)")
+	flags.Parse(args)
+
+	self, err := os.Executable()
+	if err != nil {
+		klog.Fatalln("Unable to locate the executable --", err)
+	}
+	self, err = filepath.EvalSymlinks(self)
+	if err != nil {
+		klog.Fatalln("Unable to locate the executable --", err)
+	}
+
+	if *dir == "" {
+		*dir = filepath.Dir(self)
+	}
+
+	target := filepath.Join(*dir, pluginFileName)
+	if runtime.GOOS == "windows" {
+		target += ".exe"
+	}
+
+	// A symlink follows self-updates of the binary, copies are the fallback where links are not permitted.
+	os.Remove(target)
+	err = os.Symlink(self, target)
+	if err != nil {
+		err = copyFile(self, target)
+		if err == nil {
+			err = os.Chmod(target, 0755)
+		}
+	}
+	if err != nil {
+		klog.Fatalln("Unable to install the plugin --", err)
+	}
+
+	klog.Infof("Installed %s, run it as `oc must-gather-upload` or `kubectl must-gather-upload` once it is on the PATH", target)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// policyPath overrides the location of the admin-managed export policy at build time
+// (-ldflags "-X main.policyPath=..."). It is never taken from flags or environment variables,
+// so that users cannot bypass the policy.
+var policyPath string
+
+func exportPolicyPath() string {
+	if policyPath != "" {
+		return policyPath
+	}
+
+	return filepath.Join(adminConfigDir(), "policy.json")
+}
+
+// adminConfigDir holds the configuration managed by the administrators of the host.
+func adminConfigDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "hydra-s3-upload")
+	}
+
+	return "/etc/hydra-s3-upload"
+}
+
+// Actions of the export policy rules.
+const (
+	policyDeny    = "deny"
+	policyExclude = "exclude"
+)
+
+// policyRule matches archive paths like --exclude does. Denied paths refuse the upload,
+// excluded ones are left out of the archive.
+type policyRule struct {
+	Pattern string `json:"pattern"`
+	Action  string `json:"action,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// exportPolicy holds the organizational export rules enforced on every upload.
+type exportPolicy struct {
+	// URL is where the policy is fetched from, instead of the local file.
+	URL string `json:"url,omitempty"`
+	// PublicKey is the base64 Ed25519 key verifying the fetched policy against its signature at URL + ".sig".
+	PublicKey      string       `json:"publicKey,omitempty"`
+	Rules          []policyRule `json:"rules,omitempty"`
+	MaxFileSize    string       `json:"maxFileSize,omitempty"`
+	MaxArchiveSize string       `json:"maxArchiveSize,omitempty"`
+	// Redact are the filter commands applied to every archived file, after those of --filter-cmd.
+	Redact []string `json:"redact,omitempty"`
+
+	maxFileSize    int64
+	maxArchiveSize int64
+}
+
+func parsePolicy(content []byte) (*exportPolicy, error) {
+	p := &exportPolicy{}
+	err := json.Unmarshal(content, p)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Action == "" {
+			rule.Action = policyDeny
+		}
+		if rule.Action != policyDeny && rule.Action != policyExclude {
+			return nil, fmt.Errorf("Unsupported action %q of the rule %q", rule.Action, rule.Pattern)
+		}
+	}
+
+	if p.MaxFileSize != "" {
+		p.maxFileSize, err = parseByteSize(p.MaxFileSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.MaxArchiveSize != "" {
+		p.maxArchiveSize, err = parseByteSize(p.MaxArchiveSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// loadPolicy reads the export policy of the central configuration or the local one, following
+// its URL when it has one. It returns nil without a policy, and fails rather than uploading
+// without the rules when they cannot be loaded.
+func loadPolicy() (*exportPolicy, error) {
+	if central != nil && len(central.Policy) > 0 {
+		p, err := parsePolicy(central.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse the export policy of the central configuration -- %w", err)
+		}
+		return p, nil
+	}
+
+	filePath := exportPolicyPath()
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the export policy -- %w", err)
+	}
+
+	p, err := parsePolicy(content)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the export policy %s -- %w", filePath, err)
+	}
+	if p.URL == "" {
+		return p, nil
+	}
+
+	if p.PublicKey != "" {
+		content, _, err = fetchSigned(p.URL, p.PublicKey)
+	} else {
+		content, err = fetchPolicy(p.URL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch the export policy -- %w", err)
+	}
+
+	fetched, err := parsePolicy(content)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the export policy of %s -- %w", p.URL, err)
+	}
+
+	return fetched, nil
+}
+
+func fetchPolicy(url string) ([]byte, error) {
+	resp, err := centralClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected HTTP response status code for %s: %s", url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// verdict returns the action of the policy for an archive path, "" when the path is allowed,
+// and the reason for the action. A nil policy allows everything.
+func (p *exportPolicy) verdict(name string, size int64) (string, string) {
+	if p == nil {
+		return "", ""
+	}
+
+	for _, rule := range p.Rules {
+		if excludedName([]string{rule.Pattern}, name) {
+			reason := rule.Reason
+			if reason == "" {
+				reason = "matches " + rule.Pattern
+			}
+			return rule.Action, reason
+		}
+	}
+
+	if p.maxFileSize > 0 && size > p.maxFileSize {
+		return policyDeny, fmt.Sprintf("larger than %s", formatByteSize(p.maxFileSize))
+	}
+
+	return "", ""
+}
+
+// checkArchiveSize refuses archives above the size limit of the policy.
+func (p *exportPolicy) checkArchiveSize(size int64) error {
+	if p == nil || p.maxArchiveSize == 0 || size <= p.maxArchiveSize {
+		return nil
+	}
+
+	return fmt.Errorf("The export policy limits archives to %s, this one is %s", formatByteSize(p.maxArchiveSize), formatByteSize(size))
+}
+
+// checkPolicy reports the files of srcDir denied by the export policy before anything is archived.
+func (o *options) checkPolicy(srcDir string) error {
+	if o.policy == nil {
+		return nil
+	}
+
+	var violations []string
+	err := filepath.Walk(srcDir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcDir, fullPath)
+		if err != nil || relPath == "." {
+			return nil
+		}
+		name := filepath.ToSlash(relPath)
+		if excludedName(o.excludes, name) || isReceiptFile(name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		if action, reason := o.policy.verdict(name, info.Size()); action == policyDeny {
+			violations = append(violations, name+": "+reason)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to check %s against the export policy -- %w", srcDir, err)
+	}
+
+	if len(violations) > 0 {
+		fmt.Fprintf(os.Stderr, "Export policy violations in %s:\n  %s\n", srcDir, strings.Join(violations, "\n  "))
+		return fmt.Errorf("The export policy denies %d file(s), leave them out with --exclude to upload", len(violations))
+	}
+
+	klog.Infoln("Export policy checked")
+	return nil
+}
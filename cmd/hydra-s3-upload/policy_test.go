@@ -0,0 +1,109 @@
+package main
+
+import "testing"
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		actions        []string
+		maxFileSize    int64
+		maxArchiveSize int64
+		err            bool
+	}{
+		{name: "empty", content: `{}`},
+		{
+			name:    "default action",
+			content: `{"rules": [{"pattern": "*.key"}, {"pattern": "core.*", "action": "exclude"}]}`,
+			actions: []string{policyDeny, policyExclude},
+		},
+		{name: "size limits", content: `{"maxFileSize": "10 MiB", "maxArchiveSize": "2GB"}`, maxFileSize: 10 << 20, maxArchiveSize: 2000 * 1000 * 1000},
+		{name: "unknown action", content: `{"rules": [{"pattern": "*.key", "action": "redact"}]}`, err: true},
+		{name: "invalid size", content: `{"maxFileSize": "large"}`, err: true},
+		{name: "invalid JSON", content: `{"rules": `, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := parsePolicy([]byte(test.content))
+			if (err != nil) != test.err {
+				t.Fatalf("Expected an error: %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			var actions []string
+			for _, rule := range p.Rules {
+				actions = append(actions, rule.Action)
+			}
+			if len(actions) != len(test.actions) {
+				t.Fatalf("Expected the actions %v, got %v", test.actions, actions)
+			}
+			for i := range actions {
+				if actions[i] != test.actions[i] {
+					t.Errorf("Expected the actions %v, got %v", test.actions, actions)
+				}
+			}
+			if p.maxFileSize != test.maxFileSize || p.maxArchiveSize != test.maxArchiveSize {
+				t.Errorf("Expected the limits %d and %d, got %d and %d", test.maxFileSize, test.maxArchiveSize, p.maxFileSize, p.maxArchiveSize)
+			}
+		})
+	}
+}
+
+func TestPolicyVerdict(t *testing.T) {
+	p, err := parsePolicy([]byte(`{
+		"rules": [
+			{"pattern": "*.key", "reason": "private keys"},
+			{"pattern": "namespaces/*/secrets", "action": "exclude"},
+			{"pattern": "core.*", "action": "exclude"}
+		],
+		"maxFileSize": "1 KiB"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy *exportPolicy
+		name   string
+		size   int64
+		action string
+		reason string
+	}{
+		{policy: p, name: "etc/tls/server.key", size: 10, action: policyDeny, reason: "private keys"},
+		{policy: p, name: "namespaces/app/secrets/token", size: 10, action: policyExclude, reason: "matches namespaces/*/secrets"},
+		{policy: p, name: "nodes/node1/core.1234", size: 1 << 20, action: policyExclude, reason: "matches core.*"},
+		{policy: p, name: "logs/big.log", size: 1025, action: policyDeny, reason: "larger than 1.0 KiB"},
+		{policy: p, name: "logs/small.log", size: 1024},
+		{policy: nil, name: "etc/tls/server.key", size: 1 << 30},
+	}
+
+	for _, test := range tests {
+		action, reason := test.policy.verdict(test.name, test.size)
+		if action != test.action || reason != test.reason {
+			t.Errorf("%s: expected %q (%s), got %q (%s)", test.name, test.action, test.reason, action, reason)
+		}
+	}
+}
+
+func TestPolicyArchiveSize(t *testing.T) {
+	tests := []struct {
+		policy *exportPolicy
+		size   int64
+		err    bool
+	}{
+		{policy: nil, size: 1 << 40},
+		{policy: &exportPolicy{}, size: 1 << 40},
+		{policy: &exportPolicy{maxArchiveSize: 1 << 20}, size: 1 << 20},
+		{policy: &exportPolicy{maxArchiveSize: 1 << 20}, size: 1<<20 + 1, err: true},
+	}
+
+	for _, test := range tests {
+		err := test.policy.checkArchiveSize(test.size)
+		if (err != nil) != test.err {
+			t.Errorf("%+v, %d bytes: expected an error: %v, got %v", test.policy, test.size, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+)
+
+// Handling of entry names that cannot be extracted everywhere, by --portable-names.
+const (
+	portableOff    = "off"
+	portableWarn   = "warn"
+	portableRename = "rename"
+)
+
+var portableModes = []string{portableOff, portableWarn, portableRename}
+
+// portablePathLimit is the longest entry name extracted within the Windows path limit of 260 characters,
+// leaving room for the directory the archive is extracted into.
+const portablePathLimit = 200
+
+// manifestName is the archive entry mapping the renamed entries to their original names.
+const manifestName = "hydra-s3-upload-manifest.json"
+
+// portableNames finds the entries that collide case-insensitively or exceed the Windows path limit,
+// which would be lost or fail to extract on macOS and Windows, and renames them when requested.
+type portableNames struct {
+	rename bool
+	// seen holds the lowercase names of the archived entries.
+	seen map[string]bool
+	// renames maps the new names of the renamed entries to their original names.
+	renames map[string]string
+	// renamed maps the original names to the new ones, for the links pointing at renamed entries.
+	renamed map[string]string
+}
+
+func newPortableNames(mode string) *portableNames {
+	if mode == portableOff {
+		return nil
+	}
+
+	return &portableNames{rename: mode == portableRename, seen: map[string]bool{}, renames: map[string]string{}, renamed: map[string]string{}}
+}
+
+// check warns about a problematic entry name, and returns the name it is stored under.
+func (p *portableNames) check(header *tar.Header) string {
+	if p == nil {
+		return header.Name
+	}
+
+	if target, ok := p.renamed[header.Linkname]; ok && header.Typeflag == tar.TypeLink {
+		header.Linkname = target
+	}
+
+	name := header.Name
+	var problem string
+	if len(name) > portablePathLimit {
+		problem = "exceeds the Windows path limit"
+		if p.rename {
+			name = shortName(name)
+		}
+	}
+	if p.seen[strings.ToLower(name)] && header.Typeflag != tar.TypeDir {
+		problem = "collides with another entry on case-insensitive filesystems"
+		if p.rename {
+			name = p.uniqueName(name)
+		}
+	}
+	p.seen[strings.ToLower(name)] = true
+
+	if problem == "" {
+		return name
+	}
+	if !p.rename {
+		klog.Warningf("Archive entry %s %s, rerun with --portable-names %s to rename it", header.Name, problem, portableRename)
+		return name
+	}
+
+	klog.Warningf("Archive entry %s %s, storing it as %s", header.Name, problem, name)
+	p.renames[name] = header.Name
+	p.renamed[header.Name] = name
+	return name
+}
+
+// shortName replaces the directories of a long name with a hash of them, and truncates its base name.
+func shortName(name string) string {
+	sum := sha256.Sum256([]byte(path.Dir(name)))
+	base := path.Base(name)
+	if ext := path.Ext(base); len(base) > 64 {
+		base = base[:64-len(ext)] + ext
+	}
+
+	return path.Join("long-paths", hex.EncodeToString(sum[:8]), base)
+}
+
+// uniqueName appends a number to the base name, before its extension, until it collides with nothing.
+func (p *portableNames) uniqueName(name string) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		candidate := stem + "~" + strconv.Itoa(i) + ext
+		if !p.seen[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
+
+// writeManifest stores the map of the renamed entries in the archive, if any were renamed.
+func (p *portableNames) writeManifest(w archive.EntryWriter) error {
+	if p == nil || len(p.renames) == 0 {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(map[string]interface{}{"renames": p.renames}, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	err = w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestName,
+		Size:     int64(len(content)),
+		Mode:     0644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+	return err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// presignedUpload is a set of presigned S3 URLs: either a single PUT URL, or the
+// UploadPart URLs and the CompleteMultipartUpload URL of a multipart upload.
+type presignedUpload struct {
+	URL         string   `json:"url,omitempty"`
+	PartSize    int64    `json:"partSize,omitempty"`
+	PartURLs    []string `json:"partUrls,omitempty"`
+	CompleteURL string   `json:"completeUrl,omitempty"`
+}
+
+// loadPresignedUpload reads a multipart URL set from a JSON file.
+func loadPresignedUpload(filePath string) (*presignedUpload, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &presignedUpload{}
+	err = json.Unmarshal(data, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, p.validate()
+}
+
+func (p *presignedUpload) validate() error {
+	if p.URL != "" {
+		return nil
+	}
+
+	if len(p.PartURLs) == 0 || p.CompleteURL == "" || p.PartSize <= 0 {
+		return errors.New("A presigned upload needs either a url, or partUrls, partSize, and completeUrl")
+	}
+
+	return nil
+}
+
+// objectURL returns the presigned URL naming the uploaded object.
+func (p *presignedUpload) objectURL() string {
+	if p.URL != "" {
+		return p.URL
+	}
+
+	return p.CompleteURL
+}
+
+// presignedPut sends the body to a presigned URL and returns the ETag of the stored data.
+func presignedPut(url string, body io.Reader, size int64) (string, error) {
+	req, err := http.NewRequest("PUT", url, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("Unexpected HTTP response status code: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		if limitErr := newSizeLimitError(resp.StatusCode, string(msg), err); limitErr != nil {
+			return "", limitErr
+		}
+		return "", err
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// upload sends the archive through the presigned URLs, uploading up to concurrency parts at once.
+func (p *presignedUpload) upload(f io.ReaderAt, size int64, concurrency int) error {
+	if p.URL != "" {
+		_, err := presignedPut(p.URL, io.NewSectionReader(f, 0, size), size)
+		return err
+	}
+
+	count := int((size + p.PartSize - 1) / p.PartSize)
+	if count == 0 {
+		count = 1
+	}
+	if count > len(p.PartURLs) {
+		return fmt.Errorf("Archive needs %d parts of %d bytes but only %d part URLs were provided", count, p.PartSize, len(p.PartURLs))
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	parts := make([]completedPart, count)
+	errs := make([]error, count)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			offset := int64(i) * p.PartSize
+			length := p.PartSize
+			if offset+length > size {
+				length = size - offset
+			}
+
+			etag, err := presignedPut(p.PartURLs[i], io.NewSectionReader(f, offset, length), length)
+			parts[i] = completedPart{PartNumber: i + 1, ETag: etag}
+			errs[i] = err
+			if err == nil {
+				klog.V(1).Infof("Uploaded part %d of %d", i+1, count)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("Part %d failed -- %w", i+1, err)
+		}
+	}
+
+	body, err := xml.Marshal(&completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(p.CompleteURL, "application/xml", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 reports completion errors in the body of a 200 response.
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || bytes.Contains(respBody, []byte("<Error>")) {
+		return fmt.Errorf("Completing the multipart upload failed: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
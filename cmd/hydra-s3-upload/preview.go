@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"s3upload_test/pkg/archive"
+)
+
+// previewManifestName is the entry of preview archives listing every file with its full size.
+const previewManifestName = "hydra-s3-upload-preview.json"
+
+// previewEntry is a file of the preview manifest.
+type previewEntry struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// previewFiles cuts the archived files down to their first and last bytes, for a tiny preview archive
+// that support can triage before requesting the full upload. A nil preview keeps the files whole.
+type previewFiles struct {
+	bytes   int64
+	entries []previewEntry
+}
+
+func newPreviewFiles(bytes int64) *previewFiles {
+	if bytes <= 0 {
+		return nil
+	}
+
+	return &previewFiles{bytes: bytes}
+}
+
+// cut records the file in the manifest and returns the body stored in its place,
+// adjusting the size in the header. Files up to twice the preview size are stored whole.
+func (p *previewFiles) cut(header *tar.Header, body io.Reader) io.Reader {
+	if p == nil || header.Typeflag != tar.TypeReg {
+		return body
+	}
+
+	entry := previewEntry{Name: header.Name, Size: header.Size}
+	p.entries = append(p.entries, entry)
+	omitted := header.Size - 2*p.bytes
+	if omitted <= 0 {
+		return body
+	}
+	p.entries[len(p.entries)-1].Truncated = true
+
+	marker := fmt.Sprintf("\n[... %d bytes omitted from the preview ...]\n", omitted)
+	header.Size = 2*p.bytes + int64(len(marker))
+
+	return io.MultiReader(io.LimitReader(body, p.bytes), strings.NewReader(marker), &tailReader{r: body, skip: omitted, n: p.bytes})
+}
+
+// writeManifest stores the manifest of the previewed files.
+func (p *previewFiles) writeManifest(w archive.EntryWriter) error {
+	if p == nil {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(map[string]interface{}{"previewBytes": p.bytes, "files": p.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	err = w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     previewManifestName,
+		Size:     int64(len(content)),
+		Mode:     0644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+	return err
+}
+
+// tailReader reads n bytes of r after skipping the first skip ones, seeking over them when possible.
+type tailReader struct {
+	r    io.Reader
+	skip int64
+	n    int64
+}
+
+func (t *tailReader) Read(b []byte) (int, error) {
+	if t.skip > 0 {
+		var err error
+		if s, ok := t.r.(io.Seeker); ok {
+			_, err = s.Seek(t.skip, io.SeekCurrent)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, t.r, t.skip)
+		}
+		if err != nil {
+			return 0, err
+		}
+		t.skip = 0
+	}
+
+	if t.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > t.n {
+		b = b[:t.n]
+	}
+
+	n, err := t.r.Read(b)
+	t.n -= int64(n)
+	return n, err
+}
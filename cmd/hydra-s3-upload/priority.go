@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"runtime"
+	"time"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+)
+
+// lowPriorityIORate paces archiving in the low priority mode unless --io-rate says otherwise.
+const lowPriorityIORate = 32 * 1024 * 1024
+
+// pacedCodec limits the rate at which the tar stream enters the compressor,
+// which paces both the reads of the archived files and the compression work.
+type pacedCodec struct {
+	archive.Codec
+	rate int64
+}
+
+func (c pacedCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	compressor, err := c.Codec.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pacedWriter{WriteCloser: compressor, rate: c.rate, start: time.Now()}, nil
+}
+
+type pacedWriter struct {
+	io.WriteCloser
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+func (w *pacedWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.written += int64(n)
+
+	// Sleep off any lead over the target rate.
+	due := w.start.Add(time.Duration(float64(w.written) / float64(w.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+// applyLowPriority makes archiving yield to the other workloads of the host: a single CPU,
+// single-threaded external compressors, the lowest process priority, and paced IO.
+func (o *options) applyLowPriority(ioRate int64) {
+	runtime.GOMAXPROCS(1)
+
+	if c, ok := o.codec.(*archive.CommandCodec); ok {
+		o.codec = c.SingleThreaded()
+	}
+
+	// External tools such as oc and the compressors inherit the priority.
+	err := lowerProcessPriority()
+	if err != nil {
+		klog.Warningln("Unable to lower the process priority --", err)
+	}
+
+	if ioRate == 0 {
+		ioRate = lowPriorityIORate
+	}
+	o.codec = pacedCodec{Codec: o.codec, rate: ioRate}
+}
@@ -0,0 +1,13 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"syscall"
+)
+
+// lowerProcessPriority sets the lowest CPU nice value. These systems have no IO priorities.
+func lowerProcessPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"syscall"
+)
+
+// Constants of ioprio_set(2), which the syscall package does not define.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowerProcessPriority sets the lowest CPU nice value and the idle IO scheduling class.
+func lowerProcessPriority() error {
+	err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassIdle<<ioprioClassShift)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func lowerProcessPriority() error {
+	return fmt.Errorf("Process priorities are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// progressInterval throttles the events of a single phase.
+const progressInterval = 500 * time.Millisecond
+
+// progressEvent is a line of the --progress-json side channel.
+type progressEvent struct {
+	Time    time.Time `json:"time"`
+	Archive string    `json:"archive"`
+	Phase   string    `json:"phase"`
+	Bytes   int64     `json:"bytes"`
+	Total   int64     `json:"total,omitempty"`
+	Percent float64   `json:"percent,omitempty"`
+	ETA     float64   `json:"etaSeconds,omitempty"`
+	Rate    float64   `json:"bytesPerSecond,omitempty"`
+	Done    bool      `json:"done,omitempty"`
+}
+
+// progressReporter writes progress events as JSON lines, separately from the human-readable logs,
+// and displays them as selected by --progress. A nil reporter discards the events.
+type progressReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// display is the mode of the human-readable progress, none for no display.
+	display string
+
+	// cancel aborts the tracked data flows with errCanceled once closed.
+	cancel <-chan struct{}
+}
+
+// errCanceled is returned by the tracked data flows of a canceled run.
+var errCanceled = errors.New("Canceled")
+
+// openProgressReporter opens the target of --progress-json, either a file descriptor number or a file path,
+// and displays the progress in the given mode.
+func openProgressReporter(target, display string) (*progressReporter, error) {
+	if target == "" {
+		if display == progressNone {
+			return nil, nil
+		}
+		return &progressReporter{display: display}, nil
+	}
+
+	var w io.Writer
+	if fd, err := strconv.Atoi(target); err == nil {
+		w = os.NewFile(uintptr(fd), "progress")
+	} else {
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open progress file -- %w", err)
+		}
+		w = f
+	}
+
+	return &progressReporter{enc: json.NewEncoder(w), display: display}, nil
+}
+
+// withCancel returns a reporter whose tracked data flows abort once cancel is closed,
+// creating one that reports nothing when there is no reporter.
+func (p *progressReporter) withCancel(cancel <-chan struct{}) *progressReporter {
+	if p == nil {
+		p = &progressReporter{}
+	}
+	p.cancel = cancel
+
+	return p
+}
+
+// cancellation returns the channel closed when the run is canceled, nil when it cannot be.
+func (p *progressReporter) cancellation() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+
+	return p.cancel
+}
+
+func (p *progressReporter) emit(e *progressEvent) {
+	if p.enc == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Progress reporting is best effort and never fails the upload.
+	p.enc.Encode(e)
+}
+
+// track starts a phase of an archive. The total is zero when it is not known in advance.
+func (p *progressReporter) track(archive, phase string, total int64) *progressTracker {
+	if p == nil {
+		return nil
+	}
+
+	t := &progressTracker{reporter: p, archive: archive, phase: phase, total: total, start: time.Now()}
+	t.report(false)
+	return t
+}
+
+// progressTracker counts the bytes processed in a phase. A nil tracker ignores them.
+type progressTracker struct {
+	reporter *progressReporter
+	archive  string
+	phase    string
+	total    int64
+	start    time.Time
+
+	// pausesBetweenParts is set when the uploader waits for uploadPause before every part itself,
+	// so that the reads of the parts in flight are not held.
+	pausesBetweenParts bool
+
+	mu    sync.Mutex
+	bytes int64
+	last  time.Time
+	// shown is the time of the last progress log line.
+	shown time.Time
+}
+
+func (t *progressTracker) report(done bool) {
+	e := &progressEvent{Time: time.Now().UTC(), Archive: t.archive, Phase: t.phase, Bytes: t.bytes, Total: t.total, Done: done}
+	if t.total > 0 {
+		bytes := t.bytes
+		if bytes > t.total {
+			// Retried requests read some data more than once.
+			bytes = t.total
+		}
+		e.Percent = float64(bytes) * 100 / float64(t.total)
+
+		elapsed := time.Since(t.start).Seconds()
+		if bytes > 0 && !done {
+			e.ETA = elapsed * float64(t.total-bytes) / float64(bytes)
+		}
+	}
+	if elapsed := time.Since(t.start).Seconds(); elapsed > 0 {
+		e.Rate = float64(t.bytes) / elapsed
+	}
+
+	t.last = e.Time
+	t.reporter.emit(e)
+	t.show(e)
+}
+
+func (t *progressTracker) add(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.bytes += int64(n)
+	if time.Since(t.last) >= progressInterval {
+		t.report(false)
+	}
+}
+
+// finish reports the end of the phase.
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.report(true)
+}
+
+// writer counts the bytes written through w.
+func (t *progressTracker) writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+
+	return &progressWriter{w: w, t: t}
+}
+
+// readerAt counts the bytes read through r.
+func (t *progressTracker) readerAt(r io.ReaderAt) io.ReaderAt {
+	if t == nil {
+		return r
+	}
+
+	return &progressReaderAt{r: r, t: t}
+}
+
+type progressWriter struct {
+	w io.Writer
+	t *progressTracker
+}
+
+// waitIfPaused holds the transfer phases while the uploads are paused, returning errCanceled once the run is canceled.
+func (t *progressTracker) waitIfPaused() error {
+	if (t.phase == "upload" && !t.pausesBetweenParts) || t.phase == "stream" {
+		return uploadPause.wait(t.reporter.cancel)
+	}
+
+	return nil
+}
+
+// canceled reports whether the run of the tracker was canceled.
+func (t *progressTracker) canceled() bool {
+	select {
+	case <-t.reporter.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if p.t.canceled() {
+		return 0, errCanceled
+	}
+	if err := p.t.waitIfPaused(); err != nil {
+		return 0, err
+	}
+
+	n, err := p.w.Write(b)
+	p.t.add(n)
+	return n, err
+}
+
+type progressReaderAt struct {
+	r io.ReaderAt
+	t *progressTracker
+}
+
+func (p *progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if p.t.canceled() {
+		return 0, errCanceled
+	}
+	if err := p.t.waitIfPaused(); err != nil {
+		return 0, err
+	}
+	chaos.stall(p.t)
+
+	n, err := p.r.ReadAt(b, off)
+	p.t.add(n)
+	return n, err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Modes of --progress, the human-readable progress next to the --progress-json side channel.
+const (
+	progressNone = "none"
+	progressLog  = "log"
+	progressBar  = "bar"
+)
+
+var progressModes = []string{progressNone, progressLog, progressBar}
+
+// progressLogInterval throttles the progress log lines of a phase.
+const progressLogInterval = 10 * time.Second
+
+// progressBarWidth is the number of characters of the progress bar.
+const progressBarWidth = 30
+
+var progressPhaseNames = map[string]string{
+	"archive": "Archiving",
+	"upload":  "Uploading",
+	"stream":  "Streaming",
+}
+
+// show displays a progress event of the tracker in the mode of the reporter.
+func (t *progressTracker) show(e *progressEvent) {
+	switch t.reporter.display {
+	case progressLog:
+		if !e.Done && e.Time.Sub(t.shown) < progressLogInterval {
+			return
+		}
+		t.shown = e.Time
+		klog.Infoln(describeProgress(e))
+	case progressBar:
+		line := describeProgress(e)
+		if e.Total > 0 {
+			filled := int(e.Percent * progressBarWidth / 100)
+			if filled > progressBarWidth {
+				filled = progressBarWidth
+			}
+			line = "[" + strings.Repeat("#", filled) + strings.Repeat(" ", progressBarWidth-filled) + "] " + line
+		}
+		// Pad over the rest of a longer previous line.
+		fmt.Fprintf(os.Stderr, "\r%-100s", line)
+		if e.Done {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+// describeProgress formats an event, e.g. "Uploading must-gather.tar.gz: 1.2 GiB of 3.4 GiB (35%), 25.3 MiB/s, ETA 1m30s".
+func describeProgress(e *progressEvent) string {
+	phase := progressPhaseNames[e.Phase]
+	if phase == "" {
+		phase = e.Phase
+	}
+
+	msg := fmt.Sprintf("%s %s: %s", phase, e.Archive, formatByteSize(e.Bytes))
+	if e.Total > 0 {
+		msg += fmt.Sprintf(" of %s (%.0f%%)", formatByteSize(e.Total), e.Percent)
+	}
+	if e.Rate > 0 {
+		msg += fmt.Sprintf(", %s/s", formatByteSize(int64(e.Rate)))
+	}
+	if e.Done {
+		msg += ", done"
+	} else if e.ETA > 0 {
+		msg += fmt.Sprintf(", ETA %s", time.Duration(e.ETA*float64(time.Second)).Round(time.Second))
+	}
+
+	return msg
+}
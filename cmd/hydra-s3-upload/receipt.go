@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// receiptFilePattern matches the upload receipts written into source directories,
+// which are never archived themselves.
+const receiptFilePattern = ".uploaded-*.json"
+
+// receiptFile is the content of an upload receipt stored in the source directory.
+type receiptFile struct {
+	uploadReceipt
+	UploadedAt time.Time `json:"uploadedAt"`
+	// ContentHash identifies the uploaded content of the directory, see dirContentHash.
+	ContentHash string `json:"contentHash,omitempty"`
+	// ContentHashAlgorithm is the algorithm of the content hash, SHA-256 when empty.
+	ContentHashAlgorithm string `json:"contentHashAlgorithm,omitempty"`
+}
+
+// receiptWriter stores upload receipts in the source directory,
+// so that anyone looking at it later knows it was already submitted.
+type receiptWriter struct {
+	dir         string
+	contentHash string
+	hash        string
+}
+
+func (w *receiptWriter) notify(r *uploadReceipt) error {
+	now := time.Now().UTC()
+	content, err := json.MarshalIndent(&receiptFile{uploadReceipt: *r, UploadedAt: now, ContentHash: w.contentHash, ContentHashAlgorithm: w.hash}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := ".uploaded-" + fileTimestamp(now) + ".json"
+	return ioutil.WriteFile(filepath.Join(w.dir, name), append(content, '\n'), 0644)
+}
+
+// isReceiptFile reports whether a path relative to the source directory is an upload receipt.
+func isReceiptFile(name string) bool {
+	matched, _ := path.Match(receiptFilePattern, name)
+	return matched
+}
+
+// withReceipt returns options that also write the upload receipt into srcDir when requested.
+func (o *options) withReceipt(srcDir string) *options {
+	if !o.writeReceipt || srcDir == "" {
+		return o
+	}
+
+	contentHash, err := dirContentHash(srcDir, o.hash)
+	if err != nil {
+		klog.Warningln("Unable to hash the content of", srcDir, "--", err)
+	}
+
+	withReceipt := *o
+	withReceipt.notifiers = append(append([]notifier(nil), o.notifiers...), &receiptWriter{dir: srcDir, contentHash: contentHash, hash: o.hash})
+	return &withReceipt
+}
+
+// dirContentHash hashes the names and contents of the files in dir, upload receipts aside.
+// Unlike the archive checksum, it does not depend on the compression or the archiving time.
+func dirContentHash(dir, algorithm string) (string, error) {
+	var lines []string
+	err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, fullPath)
+		if err != nil || !info.Mode().IsRegular() || isReceiptFile(filepath.ToSlash(relPath)) {
+			return nil
+		}
+
+		checksum, err := fileChecksum(fullPath, algorithm)
+		if err != nil {
+			return err
+		}
+
+		lines = append(lines, filepath.ToSlash(relPath)+"\x00"+checksum)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+	hash, err := newChecksummer(algorithm)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		fmt.Fprintln(hash, line)
+	}
+
+	return hash.checksum()
+}
+
+// alreadyUploaded reports whether dir holds an upload receipt matching its current content.
+func alreadyUploaded(dir string) (bool, error) {
+	receipts, err := filepath.Glob(filepath.Join(dir, receiptFilePattern))
+	if err != nil || len(receipts) == 0 {
+		return false, err
+	}
+
+	// The content is hashed once per algorithm used by the receipts.
+	contentHashes := map[string]string{}
+	for _, receiptPath := range receipts {
+		content, err := ioutil.ReadFile(receiptPath)
+		if err != nil {
+			return false, err
+		}
+
+		receipt := &receiptFile{}
+		if json.Unmarshal(content, receipt) != nil || receipt.ContentHash == "" {
+			continue
+		}
+
+		algorithm := receipt.ContentHashAlgorithm
+		if algorithm == "" {
+			algorithm = hashSHA256
+		}
+		if _, ok := contentHashes[algorithm]; !ok {
+			contentHashes[algorithm], err = dirContentHash(dir, algorithm)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if contentHashes[algorithm] == receipt.ContentHash {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// skipUploaded reports whether the upload of dir is skipped because it was already submitted.
+func (o *options) skipUploaded(dir string) bool {
+	if o.force || dir == "" {
+		return false
+	}
+
+	uploaded, err := alreadyUploaded(dir)
+	if err != nil {
+		klog.Warningln("Unable to check the upload receipts of", dir, "--", err)
+		return false
+	}
+	if uploaded {
+		klog.Infoln("Skipping", dir, "which was already uploaded with its current content, use --force to upload it again")
+	}
+
+	return uploaded
+}
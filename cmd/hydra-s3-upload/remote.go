@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// remoteSource is a set of files on a pod or node that a command streams to stdout as an uncompressed tar.
+type remoteSource struct {
+	// prefix is the path under which the remote files are stored in the archive.
+	prefix string
+	// program is the command to run.
+	program string
+	// args are the program arguments producing the tar stream.
+	args []string
+}
+
+// splitRemoteSpec splits a "target:path" specification, defaulting the path to the given value.
+func splitRemoteSpec(spec, defaultPath string) (string, string) {
+	target, remotePath := spec, defaultPath
+	if i := strings.Index(spec, ":"); i >= 0 {
+		target, remotePath = spec[:i], spec[i+1:]
+	}
+
+	return target, path.Clean("/" + remotePath)
+}
+
+// remoteTarCmd builds the command archiving remotePath relative to the filesystem root,
+// so that the entries keep their absolute location inside the archive.
+func remoteTarCmd(remotePath string) []string {
+	return []string{"tar", "cf", "-", "-C", "/", strings.TrimPrefix(remotePath, "/")}
+}
+
+// parsePodSource parses a "namespace/pod[/container][:path]" specification.
+func parsePodSource(spec, oc string) (*remoteSource, error) {
+	target, remotePath := splitRemoteSpec(spec, "/")
+
+	parts := strings.Split(target, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Invalid pod source %q, expected namespace/pod[/container][:path]", spec)
+	}
+
+	args := []string{"exec", "--namespace", parts[0], parts[1]}
+	if len(parts) == 3 {
+		args = append(args, "--container", parts[2])
+	}
+	args = append(args, "--")
+	args = append(args, remoteTarCmd(remotePath)...)
+
+	return &remoteSource{
+		prefix:  path.Join("pods", parts[0], parts[1]),
+		program: oc,
+		args:    args,
+	}, nil
+}
+
+// parseNodeSource parses a "node[:path]" specification. The files are read from the host
+// filesystem through a debug pod.
+func parseNodeSource(spec, oc string) (*remoteSource, error) {
+	node, remotePath := splitRemoteSpec(spec, "/var/log")
+	if node == "" || strings.Contains(node, "/") {
+		return nil, fmt.Errorf("Invalid node source %q, expected node[:path]", spec)
+	}
+
+	args := []string{"debug", "node/" + node, "--", "chroot", "/host"}
+	args = append(args, remoteTarCmd(remotePath)...)
+
+	return &remoteSource{
+		prefix:  path.Join("nodes", node),
+		program: oc,
+		args:    args,
+	}, nil
+}
+
+func (r *remoteSource) name() string {
+	return r.prefix
+}
+
+// writeTo runs the command and streams the produced tar entries into the archive.
+func (r *remoteSource) writeTo(archive *tarArchive) error {
+	cmd := exec.Command(r.program, r.args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	err = archive.addTarStream(stdout, r.prefix)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(lastLines(stderr.String(), 5)))
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"k8s.io/klog"
+)
+
+// inventoryGroup aggregates the objects sharing a case.
+type inventoryGroup struct {
+	name   string
+	count  int
+	size   int64
+	oldest time.Time
+	newest time.Time
+}
+
+func (g *inventoryGroup) add(obj *s3.Object) {
+	modified := aws.TimeValue(obj.LastModified)
+	if g.count == 0 || modified.Before(g.oldest) {
+		g.oldest = modified
+	}
+	if g.count == 0 || modified.After(g.newest) {
+		g.newest = modified
+	}
+
+	g.count++
+	g.size += aws.Int64Value(obj.Size)
+}
+
+// caseOfKey returns the first path segment of the key below the prefix, which the
+// attachment layout uses for the case, or "-" for objects stored directly under the prefix.
+func caseOfKey(key, prefix string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	if i := strings.Index(rel, "/"); i >= 0 {
+		return rel[:i]
+	}
+
+	return "-"
+}
+
+func runReport(args []string) {
+	flags := flag.NewFlagSet(commandName()+" report", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "Prefix to summarize (defaults to the prefix of the key granted by Hydra)")
+	role := addAssumeRoleFlags(flags)
+	addAuthFlags(flags)
+	flags.Parse(args)
+
+	err := applyTLSConfig()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	// Listing only reads the existing objects, so no attachment is registered for it.
+	klog.Infoln("Requesting AWS S3 read credentials from Hydra...")
+	creds, err := requestReadCreds(*prefix)
+	if err != nil {
+		klog.Fatalln("Credentials request failed --", err)
+	} else {
+		klog.Infoln("S3 credentials received")
+	}
+
+	if *prefix == "" {
+		if dir := path.Dir(creds.Key); dir != "." {
+			*prefix = dir + "/"
+		}
+	}
+
+	s, err := creds.createSession(role)
+	if err != nil {
+		klog.Fatalln("Unable to create AWS session --", err)
+	}
+
+	total := &inventoryGroup{name: "TOTAL"}
+	cases := map[string]*inventoryGroup{}
+
+	klog.Infof("Listing objects under %q...", *prefix)
+	err = s3.New(s).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(creds.BucketName),
+		Prefix: aws.String(*prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			name := caseOfKey(aws.StringValue(obj.Key), *prefix)
+			if cases[name] == nil {
+				cases[name] = &inventoryGroup{name: name}
+			}
+
+			cases[name].add(obj)
+			total.add(obj)
+		}
+
+		return true
+	})
+	if err != nil {
+		klog.Fatalln("Unable to list objects --", err)
+	}
+
+	// Largest cases first.
+	groups := make([]*inventoryGroup, 0, len(cases))
+	for _, g := range cases {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].size > groups[j].size
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Bucket:\t%s\nPrefix:\t%s\n\n", creds.BucketName, *prefix)
+	fmt.Fprintln(w, "CASE\tOBJECTS\tSIZE\tOLDEST\tNEWEST")
+	for _, g := range append(groups, total) {
+		if g.count == 0 {
+			fmt.Fprintf(w, "%s\t0\t-\t-\t-\n", g.name)
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", g.name, g.count, formatByteSize(g.size),
+			displayTime(g.oldest), displayTime(g.newest))
+	}
+	w.Flush()
+}
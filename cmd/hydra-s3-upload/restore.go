@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"k8s.io/klog"
+)
+
+var storageClasses = []string{
+	s3.StorageClassStandard,
+	s3.StorageClassReducedRedundancy,
+	s3.StorageClassStandardIa,
+	s3.StorageClassOnezoneIa,
+	s3.StorageClassIntelligentTiering,
+	s3.StorageClassGlacier,
+	s3.StorageClassDeepArchive,
+}
+
+var restoreTiers = []string{
+	s3.TierStandard,
+	s3.TierBulk,
+	s3.TierExpedited,
+}
+
+func isValidStorageClass(class string) bool {
+	return containsString(storageClasses, class)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restoreOngoing reports whether the x-amz-restore header value describes a restore job still in progress.
+func restoreOngoing(restore string) bool {
+	return strings.Contains(restore, `ongoing-request="true"`)
+}
+
+// restoreClient returns an S3 client and the bucket of the object at key. A restore reads an existing object,
+// so the client uses read credentials, which unlike the upload credentials do not register a new attachment.
+func restoreClient(key string, role *assumeRoleOptions) (*s3.S3, string, error) {
+	creds, err := requestReadCreds(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("Credentials request failed -- %w", err)
+	}
+	klog.Infoln("S3 credentials received")
+
+	s, err := creds.createSession(role)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to create AWS session -- %w", err)
+	}
+
+	return s3.New(s), creds.BucketName, nil
+}
+
+func runRestore(args []string) {
+	flags := flag.NewFlagSet(commandName()+" restore", flag.ExitOnError)
+	key := flags.String("key", "", "Key of the archived object to restore (required)")
+	tier := flags.String("tier", s3.TierStandard, "Restore tier: "+strings.Join(restoreTiers, ", "))
+	days := flags.Int64("days", 7, "Number of days the restored copy stays available")
+	wait := flags.Bool("wait", true, "Poll until the restored copy is available")
+	pollInterval := flags.Duration("poll-interval", 5*time.Minute, "Delay between restore status checks")
+	role := addAssumeRoleFlags(flags)
+	addAuthFlags(flags)
+	flags.Parse(args)
+
+	err := applyTLSConfig()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	if *key == "" {
+		klog.Fatalln("Missing required --key flag")
+	}
+
+	if !containsString(restoreTiers, *tier) {
+		klog.Fatalln("Unsupported restore tier --", *tier)
+	}
+
+	klog.Infoln("Requesting AWS S3 read credentials from Hydra...")
+	client, bucket, err := restoreClient(*key, role)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	klog.Infof("Initiating %s restore of %q...", *tier, *key)
+	_, err = client.RestoreObject(&s3.RestoreObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(*key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(*days),
+			GlacierJobParameters: &s3.GlacierJobParameters{
+				Tier: aws.String(*tier),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "RestoreAlreadyInProgress" {
+		klog.Infoln("Restore is already in progress")
+	} else if err != nil {
+		klog.Fatalln("Unable to initiate restore --", err)
+	} else {
+		klog.Infoln("Restore initiated")
+	}
+
+	if !*wait {
+		return
+	}
+
+	for {
+		head, err := client.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(*key),
+		})
+		if err != nil {
+			klog.Fatalln("Unable to check restore status --", err)
+		}
+
+		restore := aws.StringValue(head.Restore)
+		if !restoreOngoing(restore) {
+			klog.Infoln("Restored copy is available --", restore)
+			return
+		}
+
+		klog.Infof("Restore still in progress, checking again in %s", *pollInterval)
+		time.Sleep(*pollInterval)
+	}
+}
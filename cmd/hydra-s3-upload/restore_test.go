@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"s3upload_test/pkg/hydra"
+)
+
+func TestRestoreClientUsesReadCreds(t *testing.T) {
+	oldHydra, oldDownload, oldCache := os.Getenv(envHydraURL), os.Getenv(envHydraDownloadURL), credsCacheDisabled
+	defer func() {
+		os.Setenv(envHydraURL, oldHydra)
+		os.Setenv(envHydraDownloadURL, oldDownload)
+		credsCacheDisabled = oldCache
+	}()
+	credsCacheDisabled = true
+
+	// The upload credentials endpoint must not be asked, it would register a new attachment.
+	uploads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Requested upload credentials for a restore")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer uploads.Close()
+	os.Setenv(envHydraURL, uploads.URL)
+
+	var requested hydra.ReadRequest
+	downloads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&requested)
+		json.NewEncoder(w).Encode(&hydra.Credentials{BucketName: "bucket", AccessKey: "AK", SecretKey: "secret", Region: "us-east-1", Key: requested.Key})
+	}))
+	defer downloads.Close()
+	os.Setenv(envHydraDownloadURL, downloads.URL)
+
+	client, bucket, err := restoreClient("attachments/must-gather.tar.gz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == nil || bucket != "bucket" || requested.Key != "attachments/must-gather.tar.gz" {
+		t.Errorf("Unexpected request %+v or bucket %q", requested, bucket)
+	}
+}
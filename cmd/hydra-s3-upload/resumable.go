@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"k8s.io/klog"
+)
+
+// maxUploadParts is the S3 limit of parts in a multipart upload.
+const maxUploadParts = 10000
+
+// uploadState is the checkpoint of a resumable multipart upload, stored after every completed part.
+type uploadState struct {
+	// FileName is the attachment name the credentials were requested for, requested again on resume.
+	FileName string `json:"fileName,omitempty"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	UploadID string `json:"uploadId"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"partSize"`
+	// Parts maps the completed part numbers to their ETags.
+	Parts map[int64]string `json:"parts"`
+	// PartHashes maps the completed part numbers to the SHA-256 of their local data,
+	// which the parts are verified against before being skipped on resume.
+	PartHashes map[int64]string `json:"partHashes,omitempty"`
+}
+
+// uploadStatePath returns the state file of a piece of an archive. The state is bound to the archive
+// checksum, so that only an upload of the very same bytes is resumed.
+func uploadStatePath(checksum string, piece int64) string {
+	return filepath.Join(appDir(dirState, "uploads"), checksum+"-"+strconv.FormatInt(piece, 10)+".json")
+}
+
+func loadUploadState(statePath string) (*uploadState, error) {
+	content, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &uploadState{}
+	err = json.Unmarshal(content, state)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the upload state %s -- %w", statePath, err)
+	}
+
+	return state, nil
+}
+
+// save replaces the state file, so that an interruption never leaves it half-written.
+func (u *uploadState) save(statePath string) error {
+	content, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(statePath), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := statePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, statePath)
+}
+
+// abortStateUpload aborts the multipart upload recorded in the state file, e.g. before the file is evicted.
+func (o *options) abortStateUpload(statePath string) error {
+	state, err := loadUploadState(statePath)
+	if err != nil || state == nil || state.UploadID == "" {
+		return err
+	}
+	if o.credsSource == nil {
+		return errors.New("No credentials source")
+	}
+
+	name := state.FileName
+	if name == "" {
+		name = path.Base(state.Key)
+	}
+	creds, err := o.credsSource(name, 0, 1, state.Key)
+	if err != nil {
+		return fmt.Errorf("Credentials request failed -- %w", err)
+	}
+	if creds.presigned != nil {
+		return errors.New("The credentials response holds presigned URLs")
+	}
+	s, err := creds.createSession(o.assumeRole)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.New(s).AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String(state.Bucket), Key: aws.String(state.Key), UploadId: aws.String(state.UploadID)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+		return nil
+	}
+	if err == nil {
+		klog.Infof("Aborted the multipart upload of %s", state.Key)
+	}
+
+	return err
+}
+
+// resumedObject returns the attachment name and the object key of the upload recorded in the state file,
+// or name and no key when there is no upload to resume.
+func resumedObject(statePath, name string) (string, string) {
+	state, err := loadUploadState(statePath)
+	if err != nil || state == nil || state.FileName == "" {
+		return name, ""
+	}
+	if state.FileName != name {
+		klog.Infof("Resuming the upload started as %s", state.FileName)
+	}
+
+	return state.FileName, state.Key
+}
+
+func (u *uploadState) partCount() int64 {
+	return (u.Size + u.PartSize - 1) / u.PartSize
+}
+
+func (u *uploadState) partLength(number int64) int64 {
+	length := u.PartSize
+	if offset := (number - 1) * u.PartSize; offset+length > u.Size {
+		length = u.Size - offset
+	}
+
+	return length
+}
+
+// resumablePartSize returns the part size of an upload, raised when needed to stay within maxUploadParts.
+func resumablePartSize(size int64, opts *options) int64 {
+	partSize := opts.partSize
+	if partSize == 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	if minimum := (size + maxUploadParts - 1) / maxUploadParts; partSize < minimum {
+		partSize = minimum
+	}
+
+	return partSize
+}
+
+// uploadResumable uploads body as a multipart upload checkpointed in the state file at statePath.
+// A previous upload of the same piece is continued with the parts that S3 lists as completed,
+// and the state file is removed once the upload is complete.
+func (c *credsResponse) uploadResumable(body io.ReaderAt, size int64, metadata map[string]string, statePath, fileName string, opts *options) error {
+	if err := chaos.expiredToken(); err != nil {
+		return err
+	}
+
+	s, err := c.createSession(opts.assumeRole)
+	if err != nil {
+		return err
+	}
+	client := s3.New(s)
+
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		return err
+	}
+	if state != nil && (state.Bucket != c.BucketName || state.Key != c.Key) {
+		// The credentials are scoped to another object, the recorded upload can only be left to the bucket lifecycle.
+		klog.Warningf("The credentials are for %q rather than the recorded upload to %q, starting over", c.Key, state.Key)
+		state = nil
+	}
+	if state != nil {
+		err = state.listParts(client, body)
+		if err != nil {
+			klog.Warningf("Unable to resume the upload to %q, starting over -- %v", state.Key, err)
+			state = nil
+		}
+	}
+	if state != nil && state.Size != size {
+		klog.Warningf("The recorded upload to %q has a different size, starting over", state.Key)
+		client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String(state.Bucket), Key: aws.String(state.Key), UploadId: aws.String(state.UploadID)})
+		state = nil
+	}
+
+	if state == nil {
+		state, err = c.createMultipartUpload(client, size, metadata, opts)
+		if err != nil {
+			return err
+		}
+		state.FileName = fileName
+		err = state.save(statePath)
+		if err != nil {
+			return fmt.Errorf("Unable to save the upload state -- %w", err)
+		}
+	} else {
+		klog.Infof("Resuming the upload to %q with %d of %d parts already uploaded", state.Key, len(state.Parts), state.partCount())
+	}
+
+	err = state.uploadParts(client, body, statePath, opts.concurrency, opts.window, opts.progress.cancellation())
+	if err != nil {
+		return fmt.Errorf("%w (rerun to resume the upload from %s)", err, statePath)
+	}
+
+	completed := &s3.CompletedMultipartUpload{}
+	for number := int64(1); number <= state.partCount(); number++ {
+		completed.Parts = append(completed.Parts, &s3.CompletedPart{ETag: aws.String(state.Parts[number]), PartNumber: aws.Int64(number)})
+	}
+	_, err = client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(state.Bucket),
+		Key:             aws.String(state.Key),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: completed,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to complete the multipart upload -- %w", err)
+	}
+
+	os.Remove(statePath)
+	return nil
+}
+
+func (c *credsResponse) createMultipartUpload(client *s3.S3, size int64, metadata map[string]string, opts *options) (*uploadState, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(c.BucketName),
+		Key:      aws.String(c.Key),
+		Metadata: aws.StringMap(metadata),
+	}
+	if opts.storageClass != "" {
+		input.StorageClass = aws.String(opts.storageClass)
+	}
+	if len(opts.tags) > 0 {
+		tagging := url.Values{}
+		for k, v := range opts.tags {
+			tagging.Set(k, v)
+		}
+		input.Tagging = aws.String(tagging.Encode())
+	}
+
+	output, err := client.CreateMultipartUpload(input)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start the multipart upload -- %w", err)
+	}
+
+	return &uploadState{
+		Bucket:     c.BucketName,
+		Key:        c.Key,
+		UploadID:   aws.StringValue(output.UploadId),
+		Size:       size,
+		PartSize:   resumablePartSize(size, opts),
+		Parts:      map[int64]string{},
+		PartHashes: map[int64]string{},
+	}, nil
+}
+
+// listParts keeps the recorded parts that S3 still lists with their recorded ETag and whose local data
+// still has the recorded SHA-256, so that a resumed upload never stitches stale parts into the object.
+// The other parts are uploaded again.
+func (u *uploadState) listParts(client *s3.S3, body io.ReaderAt) error {
+	listed := map[int64]string{}
+	err := client.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(u.Bucket),
+		Key:      aws.String(u.Key),
+		UploadId: aws.String(u.UploadID),
+	}, func(page *s3.ListPartsOutput, last bool) bool {
+		for _, part := range page.Parts {
+			number := aws.Int64Value(part.PartNumber)
+			if number <= u.partCount() && aws.Int64Value(part.Size) == u.partLength(number) {
+				listed[number] = aws.StringValue(part.ETag)
+			}
+		}
+		return true
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+		return errors.New("The multipart upload no longer exists")
+	}
+	if err != nil {
+		return err
+	}
+
+	parts, hashes := map[int64]string{}, map[int64]string{}
+	for number, etag := range listed {
+		if etag != u.Parts[number] || u.PartHashes[number] == "" {
+			continue
+		}
+
+		data, err := u.readPart(body, number)
+		if err != nil {
+			return err
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != u.PartHashes[number] {
+			klog.Warningf("Part %d of the upload to %q does not match the local data, uploading it again", number, u.Key)
+			continue
+		}
+		parts[number], hashes[number] = etag, u.PartHashes[number]
+	}
+
+	u.Parts, u.PartHashes = parts, hashes
+	return nil
+}
+
+// readPart reads the local data of a part.
+func (u *uploadState) readPart(body io.ReaderAt, number int64) ([]byte, error) {
+	data := make([]byte, u.partLength(number))
+	_, err := io.ReadFull(io.NewSectionReader(body, (number-1)*u.PartSize, int64(len(data))), data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read part %d -- %w", number, err)
+	}
+
+	return data, nil
+}
+
+// uploadParts uploads the missing parts, up to concurrency at once, saving the state after every part.
+// Every part is buffered to record its SHA-256 and to have S3 check its MD5. No part is started outside
+// of the upload window, and no more parts are started once cancel is closed.
+func (u *uploadState) uploadParts(client *s3.S3, body io.ReaderAt, statePath string, concurrency int, window *uploadWindow, cancel <-chan struct{}) error {
+	if concurrency < 1 {
+		concurrency = s3manager.DefaultUploadConcurrency
+	}
+
+	var missing []int64
+	for number := int64(1); number <= u.partCount(); number++ {
+		if _, ok := u.Parts[number]; !ok {
+			missing = append(missing, number)
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, number := range missing {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		// Paused uploads let the parts in flight finish and start no new ones, nor do closed windows.
+		err := waitToTransfer(window, cancel)
+		if err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(number int64) {
+			defer func() { <-sem; wg.Done() }()
+
+			data, err := u.readPart(body, number)
+			var output *s3.UploadPartOutput
+			if err == nil {
+				md5Sum := md5.Sum(data)
+				output, err = client.UploadPart(&s3.UploadPartInput{
+					Bucket:        aws.String(u.Bucket),
+					Key:           aws.String(u.Key),
+					UploadId:      aws.String(u.UploadID),
+					PartNumber:    aws.Int64(number),
+					Body:          bytes.NewReader(data),
+					ContentLength: aws.Int64(int64(len(data))),
+					ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(md5Sum[:])),
+				})
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("Unable to upload part %d -- %w", number, err)
+				}
+				return
+			}
+			shaSum := sha256.Sum256(data)
+			u.Parts[number] = aws.StringValue(output.ETag)
+			u.PartHashes[number] = hex.EncodeToString(shaSum[:])
+			if err := u.save(statePath); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("Unable to save the upload state -- %w", err)
+			}
+		}(number)
+	}
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"s3upload_test/internal/s3test"
+	"s3upload_test/pkg/hydra"
+)
+
+// Environment of the helper process uploading the first attempt of an upload.
+const (
+	testArchiveEnv = "RESUMABLE_TEST_ARCHIVE"
+	testHydraEnv   = "RESUMABLE_TEST_HYDRA"
+	testS3Env      = "RESUMABLE_TEST_S3"
+)
+
+// uploadTestArchive uploads the archive resumably in parts of 1 KiB as name, with credentials from the fake Hydra
+// at hydraURL against the fake S3 at s3URL.
+func uploadTestArchive(t *testing.T, archivePath, name, hydraURL, s3URL string) error {
+	os.Setenv(envHydraURL, hydraURL)
+	credsCacheDisabled = true
+	retries = &retryPolicy{attempts: 1}
+
+	request := hydraCreds(hydra.Request{IsPrivate: "false"})
+	opts := &options{
+		hash:        "sha256",
+		resumable:   true,
+		partSize:    1024,
+		concurrency: 1,
+		objectName:  name,
+		credsSource: func(name string, piece, count int64, key string) (*credsResponse, error) {
+			c, err := request(name, piece, count, key)
+			if err == nil {
+				c.session = s3test.Session(s3URL, c.AWSCredentials())
+			}
+			return c, err
+		},
+	}
+
+	checksum, err := fileChecksum(archivePath, opts.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return uploadArchive(f, info.Size(), checksum, opts)
+}
+
+// setupResumableTest writes a random archive of five parts and keeps the state directory in a temporary directory.
+func setupResumableTest(t *testing.T) (dir, archivePath string, data []byte, cleanup func()) {
+	dir, err := ioutil.TempDir("", "resumable-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = make([]byte, 4*1024+904)
+	rand.New(rand.NewSource(1)).Read(data)
+	archivePath = filepath.Join(dir, "archive.tar.gz")
+	err = ioutil.WriteFile(archivePath, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldState, oldHydra, oldRetries, oldCache := os.Getenv("XDG_STATE_HOME"), os.Getenv(envHydraURL), retries, credsCacheDisabled
+	os.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+
+	return dir, archivePath, data, func() {
+		os.Setenv("XDG_STATE_HOME", oldState)
+		os.Setenv(envHydraURL, oldHydra)
+		retries, credsCacheDisabled = oldRetries, oldCache
+		os.RemoveAll(dir)
+	}
+}
+
+func testStatePath(t *testing.T, archivePath string) string {
+	checksum, err := fileChecksum(archivePath, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uploadStatePath(checksum, 0)
+}
+
+// TestResumableUploadHelperProcess uploads the first attempt of TestResumableUploadInNewProcess.
+func TestResumableUploadHelperProcess(t *testing.T) {
+	archivePath := os.Getenv(testArchiveEnv)
+	if archivePath == "" {
+		t.Skip("Runs as the helper process of TestResumableUploadInNewProcess")
+	}
+
+	err := uploadTestArchive(t, archivePath, "first.tar.gz", os.Getenv(testHydraEnv), os.Getenv(testS3Env))
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(3)
+	}
+	os.Exit(0)
+}
+
+func TestResumableUploadInNewProcess(t *testing.T) {
+	dir, archivePath, data, cleanup := setupResumableTest(t)
+	defer cleanup()
+
+	s3 := s3test.NewServer()
+	defer s3.Close()
+	h := newFakeHydra(s3)
+	defer h.Close()
+
+	// The first run fails at the fourth part and exits.
+	s3.FailPart = 4
+	cmd := exec.Command(os.Args[0], "-test.run=^TestResumableUploadHelperProcess$")
+	cmd.Env = append(os.Environ(), testArchiveEnv+"="+archivePath, testHydraEnv+"="+h.URL, testS3Env+"="+s3.URL,
+		"XDG_STATE_HOME="+filepath.Join(dir, "state"))
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("Expected the first run to fail uploading, got %v:\n%s", err, output)
+	}
+
+	state, err := loadUploadState(testStatePath(t, archivePath))
+	if err != nil || state == nil {
+		t.Fatalf("Expected the upload state of the first run, got %v, %v", state, err)
+	}
+	if state.FileName != "first.tar.gz" || state.Key != "attachments/first.tar.gz" || len(state.Parts) != 3 || len(state.PartHashes) != 3 {
+		t.Fatalf("Unexpected upload state %+v", state)
+	}
+
+	// The second run names the archive differently, as the timestamped default names do.
+	s3.FailPart = 0
+	s3.UploadedParts = nil
+	err = uploadTestArchive(t, archivePath, "second.tar.gz", h.URL, s3.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req := h.lastRequest(); req.FileName != "first.tar.gz" || req.Key != "attachments/first.tar.gz" {
+		t.Errorf("Expected the credentials of the resumed object, requested %+v", req)
+	}
+	if !reflect.DeepEqual(s3.UploadedParts, []int64{4, 5}) {
+		t.Errorf("Expected only the missing parts 4 and 5 to be uploaded, got %v", s3.UploadedParts)
+	}
+	if !bytes.Equal(s3.Object("bucket", "attachments/first.tar.gz"), data) {
+		t.Error("The resumed object does not match the archive")
+	}
+	if _, err := os.Stat(testStatePath(t, archivePath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the upload state to be removed, got %v", err)
+	}
+}
+
+func TestResumableUploadVerifiesParts(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(state *uploadState)
+		// uploaded are the parts uploaded on resume.
+		uploaded []int64
+	}{
+		{
+			name:     "matching parts are skipped",
+			tamper:   func(state *uploadState) {},
+			uploaded: []int64{3, 4, 5},
+		},
+		{
+			name:     "part hash differing from the local data",
+			tamper:   func(state *uploadState) { state.PartHashes[1] = state.PartHashes[2] },
+			uploaded: []int64{1, 3, 4, 5},
+		},
+		{
+			name:     "ETag differing from the listed part",
+			tamper:   func(state *uploadState) { state.Parts[2] = `"stale"` },
+			uploaded: []int64{2, 3, 4, 5},
+		},
+		{
+			name:     "state without part hashes",
+			tamper:   func(state *uploadState) { state.PartHashes = nil },
+			uploaded: []int64{1, 2, 3, 4, 5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, archivePath, data, cleanup := setupResumableTest(t)
+			defer cleanup()
+
+			s3 := s3test.NewServer()
+			defer s3.Close()
+			h := newFakeHydra(s3)
+			defer h.Close()
+
+			s3.FailPart = 3
+			err := uploadTestArchive(t, archivePath, "archive.tar.gz", h.URL, s3.URL)
+			if err == nil {
+				t.Fatal("Expected the first attempt to fail")
+			}
+
+			statePath := testStatePath(t, archivePath)
+			state, err := loadUploadState(statePath)
+			if err != nil || state == nil {
+				t.Fatalf("Expected the upload state, got %v, %v", state, err)
+			}
+			test.tamper(state)
+			err = state.save(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s3.FailPart = 0
+			s3.UploadedParts = nil
+			err = uploadTestArchive(t, archivePath, "archive.tar.gz", h.URL, s3.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s3.UploadedParts, test.uploaded) {
+				t.Errorf("Expected the parts %v to be uploaded, got %v", test.uploaded, s3.UploadedParts)
+			}
+			if !bytes.Equal(s3.Object("bucket", "attachments/archive.tar.gz"), data) {
+				t.Error("The resumed object does not match the archive")
+			}
+		})
+	}
+}
+
+func TestUploadPartsCanceledWhilePaused(t *testing.T) {
+	oldPause := uploadPause
+	defer func() { uploadPause = oldPause }()
+	uploadPause = &pauseGate{}
+	uploadPause.pause()
+
+	cancel := make(chan struct{})
+	state := &uploadState{Size: 2048, PartSize: 1024, Parts: map[int64]string{}, PartHashes: map[int64]string{}}
+	done := make(chan error, 1)
+	go func() {
+		// No part is started while paused, so no client is needed.
+		done <- state.uploadParts(nil, bytes.NewReader(make([]byte, 2048)), "", 1, nil, cancel)
+	}()
+
+	close(cancel)
+	err := <-done
+	if !errors.Is(err, errCanceled) || len(state.Parts) != 0 {
+		t.Errorf("Expected the paused upload canceled without parts, got %v with %d parts", err, len(state.Parts))
+	}
+}
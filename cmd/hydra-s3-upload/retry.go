@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"k8s.io/klog"
+)
+
+// maxRetryBackoff caps the doubling delay between attempts.
+const maxRetryBackoff = 2 * time.Minute
+
+// retryPolicy retries transient failures of the Hydra credentials requests and the S3 uploads,
+// on top of the retries of single requests done by the AWS SDK.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	// jitter is the fraction by which the delays are randomly shortened or extended.
+	jitter float64
+}
+
+// retries is the retry policy of the run, from --retries, --retry-backoff and --retry-jitter.
+var retries = &retryPolicy{attempts: 4, backoff: 2 * time.Second, jitter: 0.2}
+
+func addRetryFlags(flags *flag.FlagSet) {
+	flags.IntVar(&retries.attempts, "retries", retries.attempts, "Attempts of Hydra credentials requests and S3 uploads failing with network errors, server errors or expired credentials (1 disables retrying)")
+	flags.DurationVar(&retries.backoff, "retry-backoff", retries.backoff, "Delay before the second attempt, doubled for every further one up to 2m")
+	flags.Float64Var(&retries.jitter, "retry-jitter", retries.jitter, "Fraction of the retry delays that is randomized, so that concurrent uploads do not retry in lockstep")
+}
+
+func (p *retryPolicy) validate() error {
+	if p.attempts < 1 {
+		return errors.New("The --retries value must be at least 1")
+	}
+	if p.jitter < 0 || p.jitter > 1 {
+		return errors.New("The --retry-jitter value must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// delay returns the randomized delay after the given failed attempt.
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+
+	return time.Duration(float64(delay) * (1 + p.jitter*(2*rand.Float64()-1)))
+}
+
+// do runs fn until it succeeds, fails for good, or runs out of attempts. fn reports whether its failure is transient.
+func (p *retryPolicy) do(what string, fn func(attempt int) (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		transient, err := fn(attempt)
+		if err == nil || !transient || attempt >= p.attempts {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		klog.Warningf("%s failed (attempt %d of %d), retrying in %s -- %v", what, attempt, p.attempts, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+	}
+}
+
+// transientStatus reports whether a failed HTTP request is worth retrying.
+func transientStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// isExpiredToken reports whether S3 rejected the credentials for having expired.
+func isExpiredToken(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	switch aerr.Code() {
+	case "ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired":
+		return true
+	}
+
+	return false
+}
+
+// transientUploadError reports whether an upload failed for a reason that another attempt may not meet.
+func transientUploadError(err error) bool {
+	if errors.Is(err, errCanceled) || errors.Is(err, errMemoryPressure) {
+		return false
+	}
+	if isNetworkError(err) || isExpiredToken(err) {
+		return true
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return transientStatus(reqErr.StatusCode())
+	}
+
+	return false
+}
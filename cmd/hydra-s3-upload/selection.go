@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// requestedFiles selects the files of the source directory requested for a selective re-upload,
+// e.g. by support after reviewing a preview. A nil selection includes every file.
+type requestedFiles struct {
+	// patterns are the requested paths relative to the source directory, which also select
+	// everything below requested directories, and may contain path.Match wildcards.
+	patterns []string
+	matched  map[string]bool
+}
+
+// loadRequestedFiles reads the requested paths from a file with one path per line,
+// ignoring empty lines and # comments.
+func loadRequestedFiles(listPath string) (*requestedFiles, error) {
+	if listPath == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(listPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the requested file list -- %w", err)
+	}
+	defer f.Close()
+
+	r := &requestedFiles{matched: map[string]bool{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern := strings.Trim(path.Clean(strings.TrimPrefix(line, "./")), "/")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid requested path %q -- %w", line, err)
+		}
+		r.patterns = append(r.patterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read the requested file list -- %w", err)
+	}
+	if len(r.patterns) == 0 {
+		return nil, fmt.Errorf("The requested file list %s is empty", listPath)
+	}
+
+	return r, nil
+}
+
+// includes reports whether a file, named relative to the source directory, was requested.
+func (r *requestedFiles) includes(name string) bool {
+	if r == nil {
+		return true
+	}
+
+	elements := strings.Split(name, "/")
+	for _, pattern := range r.patterns {
+		for i := range elements {
+			if matched, _ := path.Match(pattern, strings.Join(elements[:i+1], "/")); matched {
+				r.matched[pattern] = true
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// warnUnmatched reports the requested paths that matched no file.
+func (r *requestedFiles) warnUnmatched() {
+	if r == nil {
+		return
+	}
+
+	for _, pattern := range r.patterns {
+		if !r.matched[pattern] {
+			klog.Warningln("No file matches the requested path", pattern)
+		}
+	}
+}
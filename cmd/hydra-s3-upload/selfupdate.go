@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// version is the release of this binary, set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// releasePublicKey is the base64 Ed25519 key verifying the release checksums, set at build time
+// with -ldflags "-X main.releasePublicKey=...".
+var releasePublicKey = ""
+
+const defaultReleaseURL = "https://api.github.com/repos/natiiix/hydra-s3-upload/releases/latest"
+
+// release is the subset of a GitHub release description used by self-update.
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+var releaseClient = &http.Client{Timeout: 10 * time.Minute}
+
+func fetchRelease(url string, v interface{}) ([]byte, error) {
+	resp, err := releaseClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected HTTP response status code for %s: %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if v != nil {
+		return body, json.Unmarshal(body, v)
+	}
+
+	return body, nil
+}
+
+// assetURL returns the download URL of the named release asset.
+func (r *release) assetURL(name string) (string, error) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, nil
+		}
+	}
+
+	return "", fmt.Errorf("Release %s has no %s asset", r.TagName, name)
+}
+
+// checksumOf looks a file up in the content of a sha256sum-style checksum file.
+func checksumOf(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", fmt.Errorf("No checksum listed for %s", name)
+}
+
+// signedReleaseContent returns the content signed for a release: its tag on a line of its own followed by
+// the checksum file, so that the checksums of an older release cannot be passed off as another release.
+func signedReleaseContent(tag string, sums []byte) []byte {
+	return append([]byte(tag+"\n"), sums...)
+}
+
+// verifyReleaseChecksums checks the signature of the checksum file of the release with the given tag.
+// The signature is raw or base64-encoded.
+func verifyReleaseChecksums(key ed25519.PublicKey, tag string, sums, sig []byte) error {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+	if !ed25519.Verify(key, signedReleaseContent(tag, sums), sig) {
+		return fmt.Errorf("Signature verification of the release %s checksums failed", tag)
+	}
+
+	return nil
+}
+
+// compareVersions compares two release tags such as v1.2.3, returning -1, 0 or 1 as a is older than,
+// the same as or newer than b.
+func compareVersions(a, b string) (int, error) {
+	parse := func(v string) ([]int, error) {
+		fields := strings.Split(strings.SplitN(strings.TrimPrefix(v, "v"), "-", 2)[0], ".")
+		numbers := make([]int, len(fields))
+		for i, field := range fields {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to compare the version %q, expected a tag such as v1.2.3", v)
+			}
+			numbers[i] = n
+		}
+		return numbers, nil
+	}
+
+	x, err := parse(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := parse(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var m, n int
+		if i < len(x) {
+			m = x[i]
+		}
+		if i < len(y) {
+			n = y[i]
+		}
+		switch {
+		case m < n:
+			return -1, nil
+		case m > n:
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// replaceExecutable atomically replaces the running binary with the new content.
+func replaceExecutable(content []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+
+	// The temporary file lives next to the binary, so that the rename stays within a filesystem.
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), "."+filepath.Base(exe)+".new-")
+	if err != nil {
+		return fmt.Errorf("Unable to create the new binary -- %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0755)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to write the new binary -- %w", err)
+	}
+
+	// Windows cannot replace a running binary, but it can rename it out of the way.
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		err = os.Rename(exe, old)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), exe)
+}
+
+func runSelfUpdate(args []string) {
+	flags := flag.NewFlagSet(commandName()+" self-update", flag.ExitOnError)
+	releaseURL := flags.String("release-url", defaultReleaseURL, "Release endpoint describing the latest release in the GitHub API format")
+	tag := flags.String("version", "", "Release tag to install, e.g. v1.2.3, instead of the latest release")
+	allowDowngrade := flags.Bool("allow-downgrade", false, "Install a release older than the running version")
+	publicKey := flags.String("public-key", releasePublicKey, "Base64 Ed25519 public key verifying the signature of the release tag and checksums")
+	force := flags.Bool("force", false, "Reinstall even when the latest release is the running version")
+	check := flags.Bool("check", false, "Only report whether an update is available")
+	flags.Parse(args)
+
+	key, err := base64.StdEncoding.DecodeString(*publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		klog.Fatalln("A valid --public-key is required to verify releases")
+	}
+
+	if *tag != "" {
+		*releaseURL = strings.TrimSuffix(*releaseURL, "/latest") + "/tags/" + url.PathEscape(*tag)
+	}
+	latest := &release{}
+	_, err = fetchRelease(*releaseURL, latest)
+	if err != nil {
+		klog.Fatalln("Unable to check the latest release --", err)
+	}
+	if *tag != "" && latest.TagName != *tag {
+		klog.Fatalf("Requested release %s, but the release endpoint described %s", *tag, latest.TagName)
+	}
+
+	// Development builds have no version to compare with.
+	if version != "dev" && !*allowDowngrade {
+		order, err := compareVersions(latest.TagName, version)
+		if err != nil {
+			klog.Fatalln(err, "-- rerun with --allow-downgrade to install it anyway")
+		}
+		if order < 0 {
+			klog.Fatalf("Release %s is older than the running %s, rerun with --allow-downgrade to install it", latest.TagName, version)
+		}
+	}
+
+	if latest.TagName == version && !*force {
+		klog.Infoln("Already running the latest release", version)
+		return
+	}
+	klog.Infof("Release %s is available, running %s", latest.TagName, version)
+	if *check {
+		return
+	}
+
+	// The checksum file is signed together with the release tag, and the binary is verified against it.
+	sumsURL, err := latest.assetURL("SHA256SUMS")
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	sigURL, err := latest.assetURL("SHA256SUMS.sig")
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	sums, err := fetchRelease(sumsURL, nil)
+	if err != nil {
+		klog.Fatalln("Unable to download release checksums --", err)
+	}
+	sig, err := fetchRelease(sigURL, nil)
+	if err != nil {
+		klog.Fatalln("Unable to download release checksums signature --", err)
+	}
+	err = verifyReleaseChecksums(ed25519.PublicKey(key), latest.TagName, sums, sig)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	name := fmt.Sprintf("hydra-s3-upload-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	expected, err := checksumOf(sums, name)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	binaryURL, err := latest.assetURL(name)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	klog.Infoln("Downloading", name, "...")
+	binary, err := fetchRelease(binaryURL, nil)
+	if err != nil {
+		klog.Fatalln("Unable to download the release binary --", err)
+	}
+	hash := sha256.Sum256(binary)
+	if hex.EncodeToString(hash[:]) != expected {
+		klog.Fatalln("Checksum of the downloaded binary does not match the signed checksums")
+	}
+
+	err = replaceExecutable(binary)
+	if err != nil {
+		klog.Fatalln("Unable to replace the binary --", err)
+	}
+	klog.Infoln("Updated to", latest.TagName)
+}
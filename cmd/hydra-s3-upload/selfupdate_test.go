@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyReleaseChecksums(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums := []byte("0123  hydra-s3-upload-linux-amd64\n")
+	sig := ed25519.Sign(private, signedReleaseContent("v1.2.0", sums))
+
+	if err := verifyReleaseChecksums(public, "v1.2.0", sums, sig); err != nil {
+		t.Errorf("Expected the raw signature verified, got %v", err)
+	}
+	if err := verifyReleaseChecksums(public, "v1.2.0", sums, []byte(base64.StdEncoding.EncodeToString(sig)+"\n")); err != nil {
+		t.Errorf("Expected the base64 signature verified, got %v", err)
+	}
+	// The checksums of an older release cannot be served as a newer one.
+	if err := verifyReleaseChecksums(public, "v1.3.0", sums, sig); err == nil {
+		t.Error("Expected the signature of another release tag to be rejected")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		order int
+		err   bool
+	}{
+		{a: "v1.2.3", b: "v1.2.3", order: 0},
+		{a: "v1.2.3", b: "v1.10.0", order: -1},
+		{a: "v2.0.0", b: "v1.9.9", order: 1},
+		{a: "v1.2", b: "v1.2.0", order: 0},
+		{a: "1.2.4", b: "v1.2.3", order: 1},
+		{a: "v1.2.3-rc1", b: "v1.2.2", order: 1},
+		{a: "dev", b: "v1.2.3", err: true},
+	}
+
+	for _, test := range tests {
+		order, err := compareVersions(test.a, test.b)
+		if (err != nil) != test.err || order != test.order {
+			t.Errorf("%s vs %s: expected %d (error: %v), got %d, %v", test.a, test.b, test.order, test.err, order, err)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Statuses of an upload job.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// uploadJob is an upload requested from the server, persisted in the job directory.
+type uploadJob struct {
+	ID         string         `json:"id"`
+	Dir        string         `json:"dir"`
+	Name       string         `json:"name,omitempty"`
+	Profile    string         `json:"profile,omitempty"`
+	Gather     string         `json:"gather,omitempty"`
+	Gathered   bool           `json:"gathered,omitempty"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	Progress   *progressEvent `json:"progress,omitempty"`
+	Attempts   int            `json:"attempts"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	StartedAt  time.Time      `json:"startedAt,omitempty"`
+	FinishedAt time.Time      `json:"finishedAt,omitempty"`
+
+	cancel chan struct{}
+}
+
+func (j *uploadJob) finished() bool {
+	return j.Status == jobSucceeded || j.Status == jobFailed || j.Status == jobCanceled
+}
+
+// jobQueue runs the upload jobs one at a time. Jobs are stored as JSON files,
+// so that the queue survives restarts and interrupted jobs are run again.
+type jobQueue struct {
+	dir  string
+	lock *fileLock
+	wake chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*uploadJob
+}
+
+func openJobQueue(dir string) (*jobQueue, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create job directory -- %w", err)
+	}
+
+	lock, err := lockFile(filepath.Join(dir, ".lock"), false)
+	if errors.Is(err, errLocked) {
+		return nil, fmt.Errorf("Job directory %s is in use by another server", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		lock.unlock()
+		return nil, err
+	}
+
+	q := &jobQueue{dir: dir, lock: lock, wake: make(chan struct{}, 1), jobs: map[string]*uploadJob{}}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			klog.Warningln("Unable to read job --", err)
+			continue
+		}
+
+		job := &uploadJob{}
+		err = json.Unmarshal(content, job)
+		if err != nil || job.ID == "" {
+			klog.Warningln("Ignoring invalid job file", file)
+			continue
+		}
+
+		if job.Status == jobRunning {
+			klog.Infoln("Resuming interrupted job", job.ID)
+			job.Status = jobQueued
+		}
+		if !job.finished() {
+			job.cancel = make(chan struct{})
+		}
+		q.jobs[job.ID] = job
+	}
+
+	q.notify()
+	return q, nil
+}
+
+// save persists the job, replacing its file atomically. The caller holds the lock.
+func (q *jobQueue) save(job *uploadJob) {
+	content, err := json.Marshal(job)
+	if err == nil {
+		jobPath := filepath.Join(q.dir, job.ID+".json")
+		err = ioutil.WriteFile(jobPath+".tmp", content, 0600)
+		if err == nil {
+			err = os.Rename(jobPath+".tmp", jobPath)
+		}
+	}
+	if err != nil {
+		klog.Warningln("Unable to persist job", job.ID, "--", err)
+	}
+}
+
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (q *jobQueue) add(dir, name string) *uploadJob {
+	return q.enqueue(&uploadJob{ID: newJobID(), Dir: dir, Name: name})
+}
+
+// addGather queues a job running the gather of the profile into a new directory of root before uploading it,
+// unless the profile is cooling down since its newest job. The cooldown is checked and the job queued under
+// the same lock, so that concurrent triggers queue a single gather. The creation time of the newest job
+// before is returned as well, and no job while cooling down.
+func (q *jobQueue) addGather(root, profileName string, profile *gatherProfile) (*uploadJob, time.Time) {
+	id := newJobID()
+	job := &uploadJob{
+		ID:      id,
+		Dir:     filepath.Join(root, "gather-"+sanitizeName(profileName)+"-"+id),
+		Name:    profile.Name,
+		Profile: profileName,
+		Gather:  profile.Gather,
+	}
+
+	q.mu.Lock()
+	last := q.lastTriggered(profileName)
+	if profile.cooldown > 0 && time.Since(last) < profile.cooldown {
+		q.mu.Unlock()
+		return nil, last
+	}
+	q.insert(job)
+	q.mu.Unlock()
+
+	q.notify()
+	return job, last
+}
+
+func (q *jobQueue) enqueue(job *uploadJob) *uploadJob {
+	q.mu.Lock()
+	q.insert(job)
+	q.mu.Unlock()
+
+	q.notify()
+	return job
+}
+
+// insert adds a new job to the queue, with q.mu held.
+func (q *jobQueue) insert(job *uploadJob) {
+	job.Status = jobQueued
+	job.CreatedAt = time.Now().UTC()
+	job.cancel = make(chan struct{})
+
+	q.jobs[job.ID] = job
+	q.save(job)
+}
+
+// list returns copies of the jobs, oldest first.
+func (q *jobQueue) list() []uploadJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]uploadJob, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	return jobs
+}
+
+func (q *jobQueue) get(id string) (uploadJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return uploadJob{}, false
+	}
+
+	return *job, true
+}
+
+// cancelJob cancels a queued job right away, and a running one as soon as its data flow notices.
+func (q *jobQueue) cancelJob(id string) (uploadJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return uploadJob{}, errors.New("Job not found")
+	}
+	if job.finished() {
+		return *job, fmt.Errorf("Job already %s", job.Status)
+	}
+
+	select {
+	case <-job.cancel:
+	default:
+		close(job.cancel)
+	}
+
+	if job.Status == jobQueued {
+		job.Status = jobCanceled
+		job.FinishedAt = time.Now().UTC()
+		q.save(job)
+	}
+
+	return *job, nil
+}
+
+// next waits for the oldest queued job and marks it as running.
+func (q *jobQueue) next() *uploadJob {
+	for {
+		q.mu.Lock()
+		var oldest *uploadJob
+		for _, job := range q.jobs {
+			if job.Status == jobQueued && (oldest == nil || job.CreatedAt.Before(oldest.CreatedAt)) {
+				oldest = job
+			}
+		}
+		if oldest != nil {
+			oldest.Status = jobRunning
+			oldest.Error = ""
+			oldest.Attempts++
+			oldest.StartedAt = time.Now().UTC()
+			q.save(oldest)
+			q.mu.Unlock()
+			return oldest
+		}
+		q.mu.Unlock()
+
+		<-q.wake
+	}
+}
+
+// jobProgress records the progress events of a running job.
+type jobProgress struct {
+	q   *jobQueue
+	job *uploadJob
+}
+
+func (p *jobProgress) Write(b []byte) (int, error) {
+	e := &progressEvent{}
+	if json.Unmarshal(b, e) == nil {
+		p.q.mu.Lock()
+		p.job.Progress = e
+		p.q.mu.Unlock()
+	}
+
+	return len(b), nil
+}
+
+// run uploads the queued jobs one at a time.
+func (q *jobQueue) run(opts *options) {
+	for {
+		job := q.next()
+		klog.Infoln("Starting job", job.ID, "uploading", job.Dir)
+
+		jobOpts := *opts
+		jobOpts.progress = &progressReporter{enc: json.NewEncoder(&jobProgress{q: q, job: job}), cancel: job.cancel}
+		if job.Name != "" {
+			jobOpts.objectName = objectNameWithExtension(job.Name, opts.archiveExtension())
+		} else {
+			jobOpts.objectName = defaultObjectName("oc", opts.archiveExtension())
+		}
+
+		var err error
+		if job.Gather != "" && !job.Gathered {
+			klog.Infoln("Running the gather of profile", job.Profile, "into", job.Dir)
+			err = job.gather()
+			if err == nil {
+				q.mu.Lock()
+				job.Gathered = true
+				q.save(job)
+				q.mu.Unlock()
+			}
+		}
+
+		if err == nil {
+			archivePath := filepath.Join(os.TempDir(), "hydra-s3-upload-"+job.ID+opts.archiveExtension())
+			err = uploadDir(job.Dir, archivePath, &jobOpts)
+		}
+
+		// Upload errors do not always wrap errCanceled, so the job itself tells about its cancellation.
+		canceled := false
+		select {
+		case <-job.cancel:
+			canceled = true
+		default:
+		}
+
+		q.mu.Lock()
+		job.FinishedAt = time.Now().UTC()
+		switch {
+		case canceled:
+			job.Status = jobCanceled
+		case err != nil:
+			job.Status = jobFailed
+			job.Error = err.Error()
+		default:
+			job.Status = jobSucceeded
+		}
+		q.save(job)
+		q.mu.Unlock()
+
+		klog.Infoln("Job", job.ID, job.Status)
+	}
+}
+
+// insideDir resolves dir relative to root, rejecting paths outside of root, also through symbolic links.
+// The returned path has its symbolic links resolved.
+func insideDir(root, dir string) (string, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("Unable to resolve %s -- %w", root, err)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("Unable to resolve %s -- %w", dir, err)
+	}
+
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Directory %s is outside of %s", dir, root)
+	}
+
+	return resolved, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// jobsHandler serves the job API:
+//
+//	GET  /jobs             lists the jobs
+//	POST /jobs             queues an upload of {"dir": ..., "name": ...}
+//	GET  /jobs/<id>        returns the status and progress of a job
+//	POST /jobs/<id>/cancel cancels a job, as does DELETE /jobs/<id>
+func (q *jobQueue) jobsHandler(root string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+
+		switch {
+		case parts[0] == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, q.list())
+
+		case parts[0] == "" && r.Method == http.MethodPost:
+			req := struct {
+				Dir  string `json:"dir"`
+				Name string `json:"name"`
+			}{}
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil || req.Dir == "" {
+				http.Error(w, "Expected a JSON body with a dir", http.StatusBadRequest)
+				return
+			}
+
+			dir, err := insideDir(root, req.Dir)
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, "Not a directory: "+req.Dir, http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				http.Error(w, "Not a directory: "+req.Dir, http.StatusBadRequest)
+				return
+			}
+
+			writeJSON(w, http.StatusAccepted, q.add(dir, req.Name))
+
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			job, ok := q.get(parts[0])
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+
+		case (len(parts) == 1 && r.Method == http.MethodDelete) || (len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost):
+			job, err := q.cancelJob(parts[0])
+			if err != nil && job.ID == "" {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+
+		default:
+			http.Error(w, "Unsupported request", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// requireToken rejects requests without the bearer token, when one is configured.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func runServe(args []string) {
+	flags := flag.NewFlagSet(commandName()+" serve", flag.ExitOnError)
+	uploadFlags := addUploadFlags(flags)
+	budget := addBudgetFlags(flags)
+	listen := flags.String("listen", "127.0.0.1:8080", "Address the job API listens on")
+	root := flags.String("root", ".", "Directory the uploaded directories must be located in")
+	jobDir := flags.String("job-dir", appDir(dirState, "jobs"), "Directory persisting the upload jobs")
+	token := flags.String("token", "", "Bearer token required by the job API (defaults to HSU_SERVE_TOKEN, which keeps it out of the process list)")
+	profilesFile := flags.String("profiles", "", "JSON file of the gather profiles triggered by POST /webhooks/<profile>, e.g. from Alertmanager")
+	flags.Parse(args)
+	// The token is not the flag default, which the usage would print.
+	if *token == "" {
+		*token = getenv(envServeToken)
+	}
+
+	profiles, err := loadProfiles(*profilesFile)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	opts, err := uploadFlags.options()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	opts.budget = budget
+
+	*root, err = filepath.Abs(*root)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	queue, err := openJobQueue(*jobDir)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	go queue.run(opts)
+
+	mux := http.NewServeMux()
+	handler := queue.jobsHandler(*root)
+	mux.Handle("/jobs", handler)
+	mux.Handle("/jobs/", handler)
+	mux.Handle("/webhooks/", queue.webhookHandler(*root, profiles))
+
+	if *token == "" {
+		klog.Warningln("The job API is not protected by a token, see --token")
+	}
+	klog.Infoln("Serving the job API on", *listen)
+	klog.Fatalln(http.ListenAndServe(*listen, requireToken(*token, mux)))
+}
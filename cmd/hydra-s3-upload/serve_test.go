@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInsideDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "serve-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(tmp, "root")
+	for _, dir := range []string{"root/gather", "outside"} {
+		err = os.MkdirAll(filepath.Join(tmp, dir), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink(filepath.Join(tmp, "outside"), filepath.Join(root, "escape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(filepath.Join(root, "gather"), filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(root, filepath.Join(tmp, "root-link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		root     string
+		dir      string
+		resolved string
+		err      bool
+	}{
+		{name: "relative", root: root, dir: "gather", resolved: filepath.Join(root, "gather")},
+		{name: "absolute", root: root, dir: filepath.Join(root, "gather"), resolved: filepath.Join(root, "gather")},
+		{name: "root itself", root: root, dir: ".", resolved: root},
+		{name: "parent", root: root, dir: "..", err: true},
+		{name: "parent in the path", root: root, dir: "gather/../../outside", err: true},
+		{name: "symbolic link outside", root: root, dir: "escape", err: true},
+		{name: "symbolic link inside", root: root, dir: "link", resolved: filepath.Join(root, "gather")},
+		{name: "symbolic link root", root: filepath.Join(tmp, "root-link"), dir: "gather", resolved: filepath.Join(root, "gather")},
+		{name: "missing", root: root, dir: "missing", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved, err := insideDir(test.root, test.dir)
+			if (err != nil) != test.err || resolved != test.resolved {
+				t.Errorf("Expected %q (error %v), got %q, %v", test.resolved, test.err, resolved, err)
+			}
+		})
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{name: "no token required", status: http.StatusOK},
+		{name: "matching token", token: "secret", authorization: "Bearer secret", status: http.StatusOK},
+		{name: "wrong token", token: "secret", authorization: "Bearer secreT", status: http.StatusUnauthorized},
+		{name: "token prefix", token: "secret", authorization: "Bearer secre", status: http.StatusUnauthorized},
+		{name: "missing token", token: "secret", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := requireToken(test.token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, w.Code)
+			}
+		})
+	}
+}
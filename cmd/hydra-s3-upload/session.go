@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// Defaults of the CSRF token of the session authentication.
+const (
+	defaultCSRFHeader = "X-CSRF-Token"
+	defaultCSRFCookie = "XSRF-TOKEN"
+)
+
+// hydraSession is the login session of gateways fronting Hydra with a session cookie and a CSRF token.
+// It is shared by all the requests of a run, and logged into again once the gateway rejects it.
+var hydraSession struct {
+	mu     sync.Mutex
+	client *http.Client
+	csrf   string
+}
+
+// sessionLogin logs into the gateway at HSU_HYDRA_LOGIN_URL with the Hydra credentials, sent as form fields,
+// and keeps the session cookies and the CSRF token. The token is read from the response header of its name,
+// falling back to the cookie named by HSU_HYDRA_CSRF_COOKIE.
+func sessionLogin(hydraURL string) error {
+	loginURL := getenv(envHydraLoginURL)
+	if loginURL == "" {
+		return fmt.Errorf("The %s authentication needs %s", authSession, envHydraLoginURL)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: hydraTransport(), Jar: jar}
+
+	username, password := hydraBasicCredentials(hydraURL)
+	resp, err := client.PostForm(loginURL, url.Values{"username": {username}, "password": {password}})
+	if err != nil {
+		return fmt.Errorf("Unable to log into the Hydra gateway -- %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("The Hydra gateway rejected the login: %s", resp.Status)
+	}
+
+	csrf := resp.Header.Get(envDefault(envHydraCSRFHeader, defaultCSRFHeader))
+	if csrf == "" {
+		cookieName := envDefault(envHydraCSRFCookie, defaultCSRFCookie)
+		if u, err := url.Parse(hydraURL); err == nil {
+			for _, cookie := range jar.Cookies(u) {
+				if cookie.Name == cookieName {
+					csrf = cookie.Value
+				}
+			}
+		}
+	}
+	if csrf == "" {
+		klog.Warningln("The Hydra gateway login returned no CSRF token, sending requests without it")
+	}
+
+	hydraSession.client, hydraSession.csrf = client, csrf
+	return nil
+}
+
+// sessionRequest sends the request with the session cookies and the CSRF header,
+// logging in first when there is no session or the gateway no longer accepts it.
+func sessionRequest(method, hydraURL string, body []byte) (*http.Response, error) {
+	hydraSession.mu.Lock()
+	defer hydraSession.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if hydraSession.client == nil {
+			err := sessionLogin(hydraURL)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := newHydraRequest(method, hydraURL, body)
+		if err != nil {
+			return nil, err
+		}
+		if hydraSession.csrf != "" {
+			req.Header.Set(envDefault(envHydraCSRFHeader, defaultCSRFHeader), hydraSession.csrf)
+		}
+
+		resp, err := hydraSession.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if (resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden) || attempt > 0 {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		klog.V(1).Infoln("The Hydra gateway session expired, logging in again")
+		hydraSession.client = nil
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// sidecarExtension returns the file name suffix of the checksum sidecar, the one of the standard tool of the algorithm.
+func sidecarExtension(algorithm string) string {
+	if algorithm == hashBLAKE3 {
+		return ".b3"
+	}
+
+	return "." + algorithm
+}
+
+// uploadChecksumSidecar uploads the checksum of the named archive next to the uploaded object, in the
+// format of sha256sum and its siblings, so that recipients can verify the archive with standard tools.
+// Hydra scopes its credentials to a single object, so the sidecar is uploaded as an attachment of its own,
+// with credentials requested for the sidecar name. The direct mode writes it next to the object key.
+func (c *credsResponse) uploadChecksumSidecar(name, checksum string, opts *options) error {
+	sidecarName := name + sidecarExtension(opts.hash)
+	var sidecar *credsResponse
+	if opts.usesHydra() {
+		var err error
+		sidecar, err = opts.credsSource(sidecarName, 0, 1, "")
+		if err != nil {
+			return fmt.Errorf("Credentials request for the checksum sidecar failed -- %w", err)
+		}
+	} else {
+		object := *c
+		object.Key += sidecarExtension(opts.hash)
+		sidecar = &object
+	}
+
+	_, err := sidecar.uploadFile(strings.NewReader(checksum+"  "+name+"\n"), nil, opts)
+	if err != nil {
+		return fmt.Errorf("Unable to upload the checksum sidecar -- %w", err)
+	}
+	klog.Infoln("Checksum sidecar uploaded to", sidecar.Key)
+
+	return nil
+}
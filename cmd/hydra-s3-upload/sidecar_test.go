@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"s3upload_test/internal/s3test"
+)
+
+func TestUploadChecksumSidecar(t *testing.T) {
+	oldHydra, oldCache, oldRetries := os.Getenv(envHydraURL), credsCacheDisabled, retries
+	defer func() {
+		os.Setenv(envHydraURL, oldHydra)
+		credsCacheDisabled, retries = oldCache, oldRetries
+	}()
+	credsCacheDisabled = true
+	retries = &retryPolicy{attempts: 1}
+
+	s3 := s3test.NewServer()
+	defer s3.Close()
+	h := newFakeHydra(s3)
+	defer h.Close()
+	os.Setenv(envHydraURL, h.URL)
+
+	opts := streamTestOptions(s3)
+	creds, err := opts.credsSource("gather.tar.gz", 0, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The credentials of the archive are scoped to its object, so the sidecar gets credentials of its own.
+	err = creds.uploadChecksumSidecar("gather.tar.gz", "abc123", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := h.lastRequest(); req.FileName != "gather.tar.gz.sha256" {
+		t.Errorf("Expected credentials requested for gather.tar.gz.sha256, got %+v", req)
+	}
+	if sidecar := string(s3.Object("bucket", "attachments/gather.tar.gz.sha256")); sidecar != "abc123  gather.tar.gz\n" {
+		t.Errorf("Unexpected sidecar %q", sidecar)
+	}
+
+	// A sidecar that cannot be stored fails loudly.
+	os.Setenv(envHydraURL, "http://127.0.0.1:0")
+	if err := creds.uploadChecksumSidecar("gather.tar.gz", "abc123", opts); err == nil {
+		t.Error("Expected an error without sidecar credentials")
+	}
+}
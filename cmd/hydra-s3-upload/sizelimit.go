@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"k8s.io/klog"
+)
+
+// defaultAutoSplitSize splits archives rejected without a reported limit into objects
+// of the largest size S3 accepts in a single PUT.
+const defaultAutoSplitSize = 5 * 1000 * 1000 * 1000
+
+// maxSizePattern finds the limit S3 reports in the body of EntityTooLarge errors.
+var maxSizePattern = regexp.MustCompile(`<MaxSizeAllowed>(\d+)</MaxSizeAllowed>`)
+
+// sizeLimitError is an upload rejected for exceeding an attachment or object size limit.
+type sizeLimitError struct {
+	// limit is the maximum accepted size, or 0 when it was not reported.
+	limit int64
+	err   error
+}
+
+func (e *sizeLimitError) Error() string {
+	if e.limit > 0 {
+		return fmt.Sprintf("Size limit of %s exceeded -- %v", formatByteSize(e.limit), e.err)
+	}
+
+	return fmt.Sprintf("Size limit exceeded -- %v", e.err)
+}
+
+func (e *sizeLimitError) Unwrap() error {
+	return e.err
+}
+
+// newSizeLimitError returns a sizeLimitError for a rejected HTTP response, or nil for other responses.
+func newSizeLimitError(statusCode int, body string, err error) *sizeLimitError {
+	match := maxSizePattern.FindStringSubmatch(body)
+	if statusCode != http.StatusRequestEntityTooLarge && match == nil && !strings.Contains(body, "EntityTooLarge") {
+		return nil
+	}
+
+	e := &sizeLimitError{err: err}
+	if match != nil {
+		e.limit, _ = strconv.ParseInt(match[1], 10, 64)
+	}
+
+	return e
+}
+
+// asSizeLimit finds a size limit rejection in the error chain, including the errors wrapped by the AWS SDK.
+func asSizeLimit(err error) (*sizeLimitError, bool) {
+	for err != nil {
+		var limitErr *sizeLimitError
+		if errors.As(err, &limitErr) {
+			return limitErr, true
+		}
+
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) {
+			return nil, false
+		}
+
+		if aerr.Code() == "EntityTooLarge" {
+			return &sizeLimitError{err: err}, true
+		}
+		if reqErr, ok := aerr.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusRequestEntityTooLarge {
+			return &sizeLimitError{err: err}, true
+		}
+
+		err = aerr.OrigErr()
+	}
+
+	return nil, false
+}
+
+// handleSizeLimit retries an archive rejected for its size in the split-archive mode when --auto-split
+// allows it, and otherwise explains the limit and how to get the archive through.
+func (o *options) handleSizeLimit(f *os.File, size int64, checksum string, limitErr *sizeLimitError) error {
+	splitSize := limitErr.limit
+	if splitSize == 0 {
+		splitSize = defaultAutoSplitSize
+	}
+
+	if o.splitSize > 0 || o.presigned != nil || splitSize >= size {
+		return fmt.Errorf("The archive (%s) was rejected for its size -- %w", formatByteSize(size), limitErr)
+	}
+
+	if !o.autoSplit {
+		return fmt.Errorf("The archive (%s) exceeds the attachment size limit, rerun with --split-size %d or --auto-split to upload it in several objects -- %w",
+			formatByteSize(size), splitSize, limitErr)
+	}
+
+	klog.Warningf("The archive (%s) exceeds the attachment size limit, uploading it in %d objects of at most %s",
+		formatByteSize(size), (size+splitSize-1)/splitSize, formatByteSize(splitSize))
+	split := *o
+	split.splitSize = splitSize
+
+	return uploadArchive(f, size, checksum, &split)
+}
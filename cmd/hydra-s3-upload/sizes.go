@@ -0,0 +1,139 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// compressionSampleSize is the part of every file compressed to estimate its compressibility.
+const compressionSampleSize = 64 * 1024
+
+// sizeEntry is a file of a gather with its estimated compressed size.
+type sizeEntry struct {
+	name       string
+	size       int64
+	compressed int64
+}
+
+// compressionRatio estimates how well a file compresses by gzipping its beginning.
+func compressionRatio(filePath string) (float64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	counter := &byteCounter{}
+	gz := gzip.NewWriter(counter)
+	n, err := io.Copy(gz, io.LimitReader(f, compressionSampleSize))
+	if err != nil {
+		return 0, err
+	}
+	gz.Close()
+
+	if n == 0 {
+		return 1, nil
+	}
+
+	ratio := float64(counter.n) / float64(n)
+	if ratio > 1 {
+		ratio = 1
+	}
+
+	return ratio, nil
+}
+
+// scanSizes lists the regular files of dir that are not excluded, with their estimated compressed sizes.
+// Without compression the estimate is the file size.
+func scanSizes(dir string, excluded func(name string) bool, compressed bool) ([]sizeEntry, error) {
+	var entries []sizeEntry
+	err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, fullPath)
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(relPath)
+
+		if excluded != nil && name != "." && excluded(name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		entry := sizeEntry{name: name, size: info.Size(), compressed: info.Size()}
+		if compressed {
+			ratio, err := compressionRatio(fullPath)
+			if err != nil {
+				return err
+			}
+			entry.compressed = int64(float64(info.Size()) * ratio)
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+
+	return entries, err
+}
+
+// sizeGroup sums the sizes of the files below a directory.
+type sizeGroup struct {
+	name       string
+	files      int
+	size       int64
+	compressed int64
+}
+
+// groupSizes sums the entries by their leading path elements, up to depth of them,
+// and returns the groups sorted from the largest estimated compressed size.
+func groupSizes(entries []sizeEntry, depth int) []*sizeGroup {
+	groups := map[string]*sizeGroup{}
+	for _, entry := range entries {
+		elements := strings.Split(entry.name, "/")
+		if len(elements) > depth {
+			elements = elements[:depth]
+		}
+		name := path.Join(elements...)
+
+		g := groups[name]
+		if g == nil {
+			g = &sizeGroup{name: name}
+			groups[name] = g
+		}
+		g.files++
+		g.size += entry.size
+		g.compressed += entry.compressed
+	}
+
+	sorted := make([]*sizeGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].compressed > sorted[j].compressed
+	})
+
+	return sorted
+}
+
+func totalCompressed(entries []sizeEntry) int64 {
+	var total int64
+	for _, entry := range entries {
+		total += entry.compressed
+	}
+
+	return total
+}
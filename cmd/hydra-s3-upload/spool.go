@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// The sidecar file holding the checksum of a spooled archive is suffixed with the hash algorithm,
+// e.g. ".sha256". Archives without a checksum sidecar were interrupted while being written.
+
+// sourceSuffix marks the sidecar file holding the source directory of a spooled archive.
+const sourceSuffix = ".source"
+
+// spoolLockName is the lock file held by the process using the spool.
+const spoolLockName = ".lock"
+
+type spoolEntry struct {
+	path     string
+	source   string
+	checksum string
+	hash     string
+	attempts int
+	next     time.Time
+}
+
+// remove deletes the spooled archive and its sidecar files.
+func (e *spoolEntry) remove() {
+	os.Remove(e.path)
+	os.Remove(e.path + "." + e.hash)
+	os.Remove(e.path + sourceSuffix)
+}
+
+// isSidecar reports whether the spooled file is a sidecar file of an archive.
+func isSidecar(name string) bool {
+	if strings.HasSuffix(name, sourceSuffix) {
+		return true
+	}
+	for _, algorithm := range hashAlgorithms {
+		if strings.HasSuffix(name, "."+algorithm) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// spool is a bounded local queue of archives waiting to be uploaded,
+// retried with exponential backoff while Hydra or S3 are unreachable.
+type spool struct {
+	dir            string
+	maxSize        int64
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	entries        []*spoolEntry
+	lock           *fileLock
+	// filling is set while the spool is above diskUsageWarnRatio of its limit, so that it is reported once.
+	filling bool
+
+	// Alerts are raised once an archive has failed alertAfter upload attempts.
+	alerters   []alerter
+	alertAfter int
+
+	// claims is set when the watched directory is shared with other replicas.
+	claims *claims
+}
+
+// openSpool creates the spool directory or picks up the archives left in it by a previous run.
+func openSpool(dir string, maxSize int64, initialBackoff, maxBackoff time.Duration) (*spool, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create spool directory -- %w", err)
+	}
+
+	// Two watchers sharing a spool would upload its archives twice.
+	lock, err := lockFile(filepath.Join(dir, spoolLockName), false)
+	if errors.Is(err, errLocked) {
+		return nil, fmt.Errorf("Spool directory %s is in use by another process", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		lock.unlock()
+		return nil, fmt.Errorf("Unable to read spool directory -- %w", err)
+	}
+
+	s := &spool{dir: dir, maxSize: maxSize, initialBackoff: initialBackoff, maxBackoff: maxBackoff, lock: lock}
+	for _, file := range files {
+		filePath := filepath.Join(dir, file.Name())
+		if file.Name() == spoolLockName || isSidecar(file.Name()) {
+			continue
+		}
+
+		entry := &spoolEntry{path: filePath}
+		var content []byte
+		for _, algorithm := range hashAlgorithms {
+			content, err = ioutil.ReadFile(filePath + "." + algorithm)
+			if err == nil {
+				entry.hash = algorithm
+				break
+			}
+		}
+		if entry.hash == "" {
+			klog.Warningln("Removing incomplete spooled archive", filePath)
+			entry.remove()
+			continue
+		}
+
+		fields := strings.Fields(string(content))
+		if len(fields) == 0 {
+			klog.Warningln("Removing spooled archive with an empty checksum file", filePath)
+			entry.remove()
+			continue
+		}
+		entry.checksum = fields[0]
+
+		source, _ := ioutil.ReadFile(filePath + sourceSuffix)
+		entry.source = string(source)
+		s.entries = append(s.entries, entry)
+	}
+
+	if len(s.entries) > 0 {
+		klog.Infof("Resuming %d spooled archive(s)", len(s.entries))
+	}
+
+	return s, nil
+}
+
+// size returns the total size of the spooled archives.
+func (s *spool) size() int64 {
+	var total int64
+	for _, entry := range s.entries {
+		info, err := os.Stat(entry.path)
+		if err == nil {
+			total += info.Size()
+		}
+	}
+
+	return total
+}
+
+// full reports whether the spool has reached its size limit, and warns once it starts filling up.
+// The spooled archives are never evicted, new directories wait for the queue to drain instead.
+func (s *spool) full() bool {
+	if s.maxSize <= 0 {
+		return false
+	}
+
+	size := s.size()
+	filling := float64(size) > diskUsageWarnRatio*float64(s.maxSize)
+	if filling && !s.filling {
+		klog.Warningf("The spool directory %s uses %s of its %s limit", s.dir, formatByteSize(size), formatByteSize(s.maxSize))
+	}
+	s.filling = filling
+
+	return size >= s.maxSize
+}
+
+// add archives srcDir into the spool.
+func (s *spool) add(srcDir string, opts *options) error {
+	if opts.skipUploaded(srcDir) {
+		return nil
+	}
+
+	archivePath := filepath.Join(s.dir, filepath.Base(srcDir)+opts.archiveExtension())
+	checksum, err := archiveDir(srcDir, archivePath, opts)
+	if err != nil {
+		os.Remove(archivePath)
+		return err
+	}
+
+	err = ioutil.WriteFile(archivePath+sourceSuffix, []byte(srcDir), 0600)
+	if err != nil {
+		os.Remove(archivePath)
+		return fmt.Errorf("Unable to write spooled archive source -- %w", err)
+	}
+
+	// The checksum is written last, marking the spooled archive as complete.
+	err = ioutil.WriteFile(archivePath+"."+opts.hash, []byte(checksum+"  "+filepath.Base(archivePath)+"\n"), 0600)
+	if err != nil {
+		os.Remove(archivePath)
+		return fmt.Errorf("Unable to write spooled archive checksum -- %w", err)
+	}
+
+	s.entries = append(s.entries, &spoolEntry{path: archivePath, source: srcDir, checksum: checksum, hash: opts.hash})
+	return nil
+}
+
+func (s *spool) backoff(attempts int) time.Duration {
+	delay := s.initialBackoff
+	for i := 1; i < attempts && delay < s.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.maxBackoff {
+		delay = s.maxBackoff
+	}
+
+	return delay
+}
+
+// flush uploads the spooled archives that are due. It stops at the first failure,
+// since the following uploads would most likely fail the same way.
+func (s *spool) flush(opts *options) {
+	remaining := s.entries[:0]
+	failed := false
+	for _, entry := range s.entries {
+		if failed || time.Now().Before(entry.next) {
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		// Archives spooled by a previous run may have been checksummed with another algorithm.
+		entryOpts := *opts
+		entryOpts.hash = entry.hash
+
+		err := uploadArchiveFile(entry.path, entry.checksum, entryOpts.withReceipt(entry.source))
+		if err != nil {
+			entry.attempts++
+			delay := s.backoff(entry.attempts)
+			entry.next = time.Now().Add(delay)
+			klog.Warningf("Upload of %s failed (attempt %d), retrying in %s -- %v", entry.path, entry.attempts, delay, err)
+			if entry.attempts == diagnoseAfter && isNetworkError(err) {
+				logConnectivityReport(connectivityEndpoints())
+			}
+			if entry.attempts == s.alertAfter {
+				host, _ := os.Hostname()
+				raiseAlert(s.alerters, "hydra-s3-upload/"+host+"/"+filepath.Base(entry.path),
+					fmt.Sprintf("Upload of %s on %s failed %d times", filepath.Base(entry.path), host, entry.attempts),
+					err.Error())
+			}
+			remaining = append(remaining, entry)
+			failed = true
+			continue
+		}
+
+		klog.Infoln("Uploaded", entry.path)
+		if s.claims != nil {
+			s.claims.done(entry.source)
+		}
+		entry.remove()
+	}
+
+	s.entries = remaining
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/upload"
+)
+
+// streamPartSize is the default part size of streamed uploads. The uploader cannot pick a part size for a body
+// of unknown length, and with the S3 limit of 10,000 parts the 5 MiB default stops at about 48.8 GiB,
+// while 64 MiB parts allow archives of up to 625 GiB.
+const streamPartSize = 64 * 1024 * 1024
+
+// copyObjectMaxSize is the largest object copied in a single request when replacing the metadata,
+// smaller in the tests.
+var copyObjectMaxSize int64 = upload.CopyObjectMaxSize
+
+// canStream reports whether the options allow uploading an archive of unknown length.
+// Split objects, presigned parts, budgets, and verification all need the archive size or
+// the archive content after the upload. The Hydra credentials are scoped to writing the object,
+// so they cannot copy it to store the checksum in its metadata, and a streamed upload through Hydra
+// needs the checksum sidecar.
+func (o *options) canStream() bool {
+	if o.usesHydra() && !o.checksumSidecar {
+		return false
+	}
+
+	// The audit head stored with the object must cover all of the archived files,
+	// and the archive size limit of the export policy needs the size up front.
+	// Resumable uploads are bound to the checksum of an archive file, and an upload window
+	// needs the archive written before the transfer is deferred.
+	return o.presigned == nil && !o.resumable && o.window == nil && o.splitSize == 0 && o.budget == nil && o.audit == nil &&
+		(o.policy == nil || o.policy.maxArchiveSize == 0) && (o.verify == "" || o.verify == verifyNone)
+}
+
+// streamDirWithRetries streams the archive of srcDir with the retry policy of the run, archiving the directory
+// again for every attempt, since a stream cannot be rewound. Expired credentials are replaced with fresh ones.
+func streamDirWithRetries(srcDir, name string, opts *options) error {
+	var creds *credsResponse
+	return retries.do("Streaming upload", func(int) (bool, error) {
+		if creds == nil {
+			klog.Infoln(tr("Requesting AWS S3 credentials..."))
+			var err error
+			creds, err = opts.credsSource(name, 0, 1, "")
+			if err != nil {
+				// The credentials request retries on its own.
+				return false, fmt.Errorf("Credentials request failed -- %w", err)
+			}
+			klog.Infoln(tr("S3 credentials received"))
+		}
+
+		err := streamDir(srcDir, name, creds, opts)
+		if err == nil {
+			return false, nil
+		}
+
+		if isExpiredToken(err) {
+			klog.Warningln("The S3 credentials expired, requesting fresh ones")
+			creds.forgetCached()
+			creds = nil
+		}
+		return transientUploadError(err), err
+	})
+}
+
+// streamDir archives srcDir straight into the upload, without a temporary archive file.
+// The checksum is only known once the upload completes. Through Hydra it is stored in the checksum sidecar,
+// otherwise in the object metadata by copying the object onto itself, or in the sidecar when that fails.
+func streamDir(srcDir, name string, creds *credsResponse, opts *options) (err error) {
+	defer opts.telemetry.record("stream", time.Now(), &err)
+
+	if opts.partSize == 0 {
+		streamOpts := *opts
+		streamOpts.partSize = streamPartSize
+		opts = &streamOpts
+	}
+
+	if creds.presigned != nil {
+		return fmt.Errorf("The credentials response holds presigned URLs, which need the archive size up front")
+	}
+
+	metadata := map[string]string{}
+	if opts.keyWrapper != nil {
+		metadata["envelope-provider"] = opts.keyWrapper.provider()
+		metadata["envelope-key-id"] = opts.keyWrapper.keyID()
+	}
+
+	hash, err := newChecksummer(opts.hash)
+	if err != nil {
+		return err
+	}
+	defer hash.checksum()
+
+	pr, pw := io.Pipe()
+	counter := &byteCounter{}
+	tracker := opts.progress.track(name, "stream", 0)
+	archived := make(chan error, 1)
+	go func() {
+		err := writeArchive(srcDir, tracker.writer(io.MultiWriter(pw, hash, counter)), opts)
+		pw.CloseWithError(err)
+		archived <- err
+	}()
+
+	klog.Infoln("Streaming Must-Gather archive into the upload...")
+	_, err = creds.uploadFile(pr, metadata, opts)
+	// Stop the archiving when the upload gave up early.
+	pr.CloseWithError(fmt.Errorf("Upload stopped"))
+	if archiveErr := <-archived; archiveErr != nil && err == nil {
+		err = archiveErr
+	}
+	if err != nil {
+		return fmt.Errorf("Could not upload file -- %w", err)
+	}
+
+	checksum, err := hash.checksum()
+	if err != nil {
+		return fmt.Errorf("Unable to compute archive checksum -- %w", err)
+	}
+	klog.Infoln(tr("Must-Gather archive uploaded"), hashName(opts.hash)+":", checksum)
+	err = creds.storeStreamChecksum(name, checksum, counter.n, metadata, opts)
+	if err != nil {
+		return err
+	}
+	if opts.index != nil {
+		creds.uploadIndexSidecar(opts.index, opts)
+	}
+	tracker.finish()
+
+	receipt := &uploadReceipt{Case: opts.caseID, Keys: []string{creds.Key}, Checksum: checksum, Algorithm: opts.hash, Size: counter.n}
+	if receipt.Case == "" {
+		receipt.Case = "unknown"
+	}
+	err = opts.completeUpload(name, receipt)
+	if err != nil {
+		return err
+	}
+	opts.notifyUploaded(receipt)
+
+	return nil
+}
+
+// byteCounter counts the bytes written into it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// replaceMetadata replaces the metadata of the uploaded object by copying the object onto itself,
+// which S3 does without the data being uploaded again. The tags and the storage class are kept.
+func (c *credsResponse) replaceMetadata(size int64, metadata map[string]string, opts *options) error {
+	s, err := c.createSession(opts.assumeRole)
+	if err != nil {
+		return err
+	}
+
+	uploader := &upload.Uploader{
+		Session:           s,
+		Bucket:            c.BucketName,
+		Key:               c.Key,
+		StorageClass:      opts.storageClass,
+		Tags:              opts.tags,
+		CopyObjectMaxSize: copyObjectMaxSize,
+	}
+	return uploader.ReplaceMetadata(size, metadata)
+}
+
+// storeStreamChecksum stores the checksum of a streamed archive of the given size. The Hydra credentials
+// cannot read the object to copy it, so the checksum goes to the sidecar, which must succeed.
+func (c *credsResponse) storeStreamChecksum(name, checksum string, size int64, metadata map[string]string, opts *options) error {
+	if opts.usesHydra() {
+		return c.uploadChecksumSidecar(name, checksum, opts)
+	}
+
+	metadata[opts.hash] = checksum
+	metadataErr := c.replaceMetadata(size, metadata, opts)
+	if metadataErr != nil {
+		klog.Warningln("Unable to store the checksum in the object metadata, downloads cannot verify the archive without the checksum sidecar --", metadataErr)
+	}
+	if !opts.checksumSidecar && metadataErr == nil {
+		return nil
+	}
+
+	err := c.uploadChecksumSidecar(name, checksum, opts)
+	if err != nil && !opts.checksumSidecar {
+		klog.Warningln("The checksum is neither in the object metadata nor in a sidecar --", err)
+		return nil
+	}
+	return err
+}
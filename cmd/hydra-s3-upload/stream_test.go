@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+
+	"s3upload_test/internal/s3test"
+	"s3upload_test/pkg/archive"
+	"s3upload_test/pkg/hydra"
+)
+
+// streamTestOptions streams tar.gz archives with credentials from the fake Hydra in HSU_HYDRA_URL against the fake S3,
+// storing the checksum in the sidecar.
+func streamTestOptions(s3 *s3test.Server) *options {
+	request := hydraCreds(hydra.Request{IsPrivate: "false"})
+	opts := &options{
+		hash:            "sha256",
+		concurrency:     1,
+		checksumSidecar: true,
+		credsSource: func(name string, piece, count int64, key string) (*credsResponse, error) {
+			c, err := request(name, piece, count, key)
+			if err == nil {
+				c.session = s3test.Session(s3.URL, c.AWSCredentials())
+			}
+			return c, err
+		},
+	}
+	opts.format, _ = archive.LookupFormat("tar")
+	opts.codec, _ = archive.LookupCodec("gzip")
+
+	return opts
+}
+
+// writeStreamTestDir writes a directory to stream.
+func writeStreamTestDir(t *testing.T) string {
+	srcDir, err := ioutil.TempDir("", "stream-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(srcDir, "node.log"), []byte(strings.Repeat("kubelet started\n", 1000)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srcDir
+}
+
+// streamedChecksum returns the checksum of the archive streamed to the key of the fake S3.
+func streamedChecksum(t *testing.T, s3 *s3test.Server, key string) string {
+	object := s3.Object("bucket", key)
+	if len(object) == 0 {
+		t.Fatal("The archive was not uploaded")
+	}
+	f, err := ioutil.TempFile("", "stream-test-*.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	err = ioutil.WriteFile(f.Name(), object, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := fileChecksum(f.Name(), "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return checksum
+}
+
+func TestStreamDirStoresChecksumMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		// copyMaxSize is the largest object copied in a single request.
+		copyMaxSize int64
+	}{
+		{name: "single copy", copyMaxSize: 5 << 30},
+		{name: "multipart copy", copyMaxSize: 0},
+	}
+
+	oldCopyMax := copyObjectMaxSize
+	defer func() {
+		copyObjectMaxSize = oldCopyMax
+	}()
+
+	srcDir := writeStreamTestDir(t)
+	defer os.RemoveAll(srcDir)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s3 := s3test.NewServer()
+			defer s3.Close()
+			copyObjectMaxSize = test.copyMaxSize
+
+			// The credentials of the direct mode may copy the object onto itself.
+			s3.ScopeReadWrite("direct", "attachments/stream.tar.gz")
+			opts := streamTestOptions(s3)
+			opts.direct, opts.checksumSidecar = true, false
+			creds := &credsResponse{
+				Credentials: hydra.Credentials{BucketName: "bucket", Key: "attachments/stream.tar.gz"},
+				session:     s3test.Session(s3.URL, credentials.NewStaticCredentials("direct", "secret", "")),
+			}
+			err := streamDir(srcDir, "stream.tar.gz", creds, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checksum := streamedChecksum(t, s3, "attachments/stream.tar.gz")
+			if metadata := s3.ObjectMetadata("bucket", "attachments/stream.tar.gz"); metadata["sha256"] != checksum {
+				t.Errorf("Expected the checksum %s in the object metadata, got %v", checksum, metadata)
+			}
+		})
+	}
+}
+
+func TestStreamDirThroughHydraStoresChecksumSidecar(t *testing.T) {
+	oldHydra, oldCache, oldRetries := os.Getenv(envHydraURL), credsCacheDisabled, retries
+	defer func() {
+		os.Setenv(envHydraURL, oldHydra)
+		credsCacheDisabled, retries = oldCache, oldRetries
+	}()
+	credsCacheDisabled = true
+	retries = &retryPolicy{attempts: 1}
+
+	srcDir := writeStreamTestDir(t)
+	defer os.RemoveAll(srcDir)
+
+	s3 := s3test.NewServer()
+	defer s3.Close()
+	h := newFakeHydra(s3)
+	defer h.Close()
+	os.Setenv(envHydraURL, h.URL)
+
+	// Without the sidecar, a Hydra upload writes an archive file, whose checksum is known up front.
+	opts := streamTestOptions(s3)
+	opts.checksumSidecar = false
+	if opts.canStream() {
+		t.Error("Expected no streaming through Hydra without the checksum sidecar")
+	}
+
+	opts.checksumSidecar = true
+	if !opts.canStream() {
+		t.Fatal("Expected streaming through Hydra with the checksum sidecar")
+	}
+	creds, err := opts.credsSource("stream.tar.gz", 0, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = streamDir(srcDir, "stream.tar.gz", creds, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum := streamedChecksum(t, s3, "attachments/stream.tar.gz")
+	if sidecar := string(s3.Object("bucket", "attachments/stream.tar.gz.sha256")); sidecar != checksum+"  stream.tar.gz\n" {
+		t.Errorf("Expected the checksum %s in the sidecar, got %q", checksum, sidecar)
+	}
+
+	// The scoped credentials cannot store the sidecar without Hydra, which fails the upload.
+	os.Setenv(envHydraURL, "http://127.0.0.1:0")
+	if err := streamDir(srcDir, "stream.tar.gz", creds, opts); err == nil {
+		t.Error("Expected an error when the checksum cannot be stored")
+	}
+}
+
+func TestStreamDirWithRetriesReplacesExpiredCredentials(t *testing.T) {
+	oldHydra, oldCache, oldRetries := os.Getenv(envHydraURL), credsCacheDisabled, retries
+	defer func() {
+		os.Setenv(envHydraURL, oldHydra)
+		credsCacheDisabled, retries = oldCache, oldRetries
+	}()
+
+	srcDir := writeStreamTestDir(t)
+	defer os.RemoveAll(srcDir)
+
+	s3 := s3test.NewServer()
+	defer s3.Close()
+	h := newFakeHydra(s3)
+	defer h.Close()
+	os.Setenv(envHydraURL, h.URL)
+	credsCacheDisabled = true
+	retries = &retryPolicy{attempts: 3}
+
+	// The first credentials issued by the fake Hydra expire before the upload.
+	s3.ExpiredAccessKey = "AK1"
+	err := streamDirWithRetries(srcDir, "stream.tar.gz", streamTestOptions(s3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two for the archive, the expired and the fresh credentials, and one for the checksum sidecar.
+	if n := h.requestCount(); n != 3 {
+		t.Errorf("Expected fresh credentials after the expired ones, got %d credentials requests", n)
+	}
+	if len(s3.Object("bucket", "attachments/stream.tar.gz")) == 0 {
+		t.Error("The archive was not uploaded")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"k8s.io/klog"
+)
+
+// telemetryEvent is the anonymous outcome of a run phase. It deliberately carries no
+// paths, keys, host names, or error messages, only the category of a failure.
+type telemetryEvent struct {
+	Version  string  `json:"version"`
+	OS       string  `json:"os"`
+	Arch     string  `json:"arch"`
+	Command  string  `json:"command"`
+	Phase    string  `json:"phase"`
+	Duration float64 `json:"durationSeconds"`
+	Outcome  string  `json:"outcome"`
+}
+
+// telemetry reports phase outcomes to an endpoint the user explicitly opted into.
+// A nil telemetry reports nothing.
+type telemetry struct {
+	url     string
+	command string
+	client  *http.Client
+}
+
+func newTelemetry(url string) *telemetry {
+	if url == "" {
+		return nil
+	}
+
+	// Subcommands come first, the default upload command starts with its flags.
+	command := "upload"
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+	}
+
+	return &telemetry{url: url, command: command, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// errorCategory classifies an error without revealing its details.
+func errorCategory(err error) string {
+	if err == nil {
+		return "success"
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return "s3:" + aerr.Code()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "network"
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return "filesystem"
+	}
+
+	if strings.HasPrefix(err.Error(), "Credentials request failed") {
+		return "credentials"
+	}
+
+	return "other"
+}
+
+// record reports the outcome of a phase started at start. It is meant to be deferred
+// with a pointer to the named error result of the phase.
+func (t *telemetry) record(phase string, start time.Time, err *error) {
+	if t == nil {
+		return
+	}
+
+	body, _ := json.Marshal(&telemetryEvent{
+		Version:  version,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Command:  t.command,
+		Phase:    phase,
+		Duration: time.Since(start).Seconds(),
+		Outcome:  errorCategory(*err),
+	})
+
+	// Telemetry is best effort and never fails the run.
+	resp, postErr := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if postErr != nil {
+		klog.V(1).Infoln("Unable to send telemetry --", postErr)
+		return
+	}
+	resp.Body.Close()
+}
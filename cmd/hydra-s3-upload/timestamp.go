@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// The timestamps of the manifests and metadata are RFC 3339 in UTC, e.g. "2020-12-31T23:59:59Z", so that the
+// artifacts of teams in different timezones sort and correlate by them. The generated object names, which are
+// also the names of the downloaded files, and the names of local files and archive entries use the basic form
+// without colons, e.g. "20201231T235959Z", as Windows does not allow colons.
+const fileTimestampLayout = "20060102T150405Z"
+
+// timestamp formats a time stored in manifests and metadata.
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// fileTimestamp formats a time stored in the name of an object, a local file or an archive entry.
+func fileTimestamp(t time.Time) string {
+	return t.UTC().Format(fileTimestampLayout)
+}
+
+// displayZone is the timezone of the times shown to the user, UTC unless set by HSU_TIMEZONE or --timezone.
+var displayZone = &timezone{location: time.UTC}
+
+// timezone is a flag.Value parsing "UTC", "Local" or an IANA timezone name such as "Europe/Prague".
+type timezone struct {
+	location *time.Location
+}
+
+func (z *timezone) String() string {
+	if z == nil || z.location == nil {
+		return ""
+	}
+
+	return z.location.String()
+}
+
+func (z *timezone) Set(value string) error {
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return fmt.Errorf("Unknown timezone %q -- %w", value, err)
+	}
+	z.location = location
+
+	return nil
+}
+
+func addTimezoneFlag(flags *flag.FlagSet) {
+	if name := getenv(envTimezone); name != "" {
+		// The value is checked by validateEnv.
+		displayZone.Set(name)
+	}
+	flags.Var(displayZone, "timezone", "Timezone of the times shown in messages: UTC, Local or a name such as Europe/Prague (defaults to HSU_TIMEZONE, then UTC), the stored timestamps stay in UTC")
+}
+
+// displayTime formats a time shown to the user, as RFC 3339 in the display timezone.
+func displayTime(t time.Time) string {
+	return t.In(displayZone.location).Format(time.RFC3339)
+}
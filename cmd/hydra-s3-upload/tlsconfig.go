@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// tlsVersions are the supported values of --tls-min-version.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCipherSuites are the TLS 1.2 cipher suites that --tls-ciphers can allow. The TLS 1.3 suites
+// are all secure and not configurable.
+var tlsCipherSuites = map[string]uint16{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// TLS settings of the Hydra and S3 connections, from --tls-min-version and --tls-ciphers.
+var (
+	tlsMinVersion = "1.2"
+	tlsCiphers    stringList
+)
+
+func tlsCipherNames() []string {
+	names := make([]string, 0, len(tlsCipherSuites))
+	for name := range tlsCipherSuites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// newTLSConfig returns the TLS configuration of the Hydra and S3 connections. Hydra certificates
+// are not verified, they are commonly issued by internal authorities.
+func newTLSConfig(insecure bool) (*tls.Config, error) {
+	version, ok := tlsVersions[tlsMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported TLS version %q, expected 1.2 or 1.3", tlsMinVersion)
+	}
+
+	config := &tls.Config{MinVersion: version, InsecureSkipVerify: insecure}
+	for _, name := range tlsCiphers {
+		id, ok := tlsCipherSuites[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported TLS cipher suite %q, expected one of: %s", name, strings.Join(tlsCipherNames(), ", "))
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+
+	return config, nil
+}
+
+// hydraTLSConfig returns the TLS configuration of the Hydra connections, falling back to the defaults
+// when the flags are invalid, which applyTLSConfig already reported.
+func hydraTLSConfig() *tls.Config {
+	config, err := newTLSConfig(true)
+	if err != nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return config
+}
+
+// applyTLSConfig validates the TLS flags and applies them to the default HTTP client, used by the AWS SDK.
+func applyTLSConfig() error {
+	config, err := newTLSConfig(false)
+	if err != nil {
+		return err
+	}
+
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = config
+	}
+
+	return nil
+}
+
+// curlTLSArgs returns the curl arguments enforcing the minimum TLS version.
+func curlTLSArgs() []string {
+	if tlsMinVersion == "1.3" {
+		return []string{"--tlsv1.3"}
+	}
+
+	return []string{"--tlsv1.2"}
+}
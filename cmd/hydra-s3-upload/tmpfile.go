@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// partialSuffix marks archives that are still being written, or whose writing was interrupted.
+const partialSuffix = ".partial"
+
+// syncDir flushes the entries of a directory, making renames within it durable.
+// Directories cannot be synced everywhere, so this is best effort.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// tempArchive is the temporary archive file of an upload.
+type tempArchive struct {
+	*os.File
+	// named is set for named temporary files, which are removed on close.
+	named bool
+}
+
+// createTempArchive creates the temporary archive file for archivePath. Where the platform supports it,
+// the file is never linked into the directory, so that crashes cannot leave it behind. Elsewhere
+// it is a named temporary file next to archivePath, removed once closed.
+func createTempArchive(archivePath string) (*tempArchive, error) {
+	f, err := openUnlinked(archivePath)
+	if err == nil {
+		return &tempArchive{File: f}, nil
+	}
+
+	f, err = ioutil.TempFile(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &tempArchive{File: f, named: true}, nil
+}
+
+func (t *tempArchive) Close() error {
+	err := t.File.Close()
+	if t.named {
+		os.Remove(t.File.Name())
+	}
+
+	return err
+}
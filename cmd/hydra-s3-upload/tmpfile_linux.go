@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// oTmpfile is O_TMPFILE of open(2), which the syscall package does not define. The kernel defines it as
+// __O_TMPFILE, the same on every architecture Go supports, combined with O_DIRECTORY, which differs between
+// them, e.g. 0x10000 on amd64 but 0x4000 on arm64 and ppc64le.
+const oTmpfile = 0x400000 | syscall.O_DIRECTORY
+
+// openUnlinked opens an unnamed file in the directory of filePath, which disappears once closed.
+// The returned file is named filePath, although nothing is linked there.
+func openUnlinked(filePath string) (*os.File, error) {
+	fd, err := syscall.Open(filepath.Dir(filePath), oTmpfile|syscall.O_RDWR|syscall.O_CLOEXEC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), filePath), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOpenUnlinked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmpfile-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := openUnlinked(filepath.Join(dir, "must-gather.tar.gz"))
+	if err == syscall.EOPNOTSUPP || err == syscall.EISDIR {
+		t.Skip("The file system does not support O_TMPFILE")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString("archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/self/fd/%d", f.Fd()))
+	if err != nil || string(content) != "archive" {
+		t.Errorf("Expected the written archive, got %q, %v", content, err)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no file linked into the directory, got %d", len(entries))
+	}
+}
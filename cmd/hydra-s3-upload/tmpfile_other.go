@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// openUnlinked is only supported on Linux, elsewhere the temporary archive is a named file.
+func openUnlinked(filePath string) (*os.File, error) {
+	return nil, errors.New("Unnamed temporary files are not supported")
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// defaultTokenURL is the OpenID Connect token endpoint of the Red Hat SSO.
+const defaultTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+
+// tokenRefreshMargin renews access tokens this long before they expire.
+const tokenRefreshMargin = 30 * time.Second
+
+var errNoToken = errors.New("No cached Hydra token")
+
+// tokenCache is the session of a login, kept until its refresh token expires.
+// A zero RefreshExpiresAt marks an offline token, which does not expire by time.
+type tokenCache struct {
+	AccessToken      string    `json:"accessToken"`
+	RefreshToken     string    `json:"refreshToken"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt,omitempty"`
+	TokenURL         string    `json:"tokenURL"`
+	ClientID         string    `json:"clientID"`
+}
+
+// tokenResponse is the response of the OpenID Connect token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeToken requests tokens with the given grant and turns them into a token cache.
+func exchangeToken(tokenURL, clientID string, grant url.Values) (*tokenCache, error) {
+	grant.Set("client_id", clientID)
+
+	resp, err := http.PostForm(tokenURL, grant)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	token := &tokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(token)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("Unable to parse token response -- %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		if token.Error != "" {
+			return nil, fmt.Errorf("Token request rejected: %s %s", token.Error, token.ErrorDescription)
+		}
+		return nil, fmt.Errorf("Unexpected token response status code: %s", resp.Status)
+	}
+
+	now := time.Now().UTC()
+	cache := &tokenCache{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    now.Add(time.Duration(token.ExpiresIn) * time.Second),
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+	}
+	if token.RefreshExpiresIn > 0 {
+		cache.RefreshExpiresAt = now.Add(time.Duration(token.RefreshExpiresIn) * time.Second)
+	}
+
+	return cache, nil
+}
+
+// loginWithPassword exchanges a username and password for tokens.
+func loginWithPassword(tokenURL, clientID, username, password string) (*tokenCache, error) {
+	return exchangeToken(tokenURL, clientID, url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+	})
+}
+
+// loginWithOfflineToken exchanges an SSO offline token for tokens. The offline token
+// is kept as the refresh token when the endpoint does not rotate it.
+func loginWithOfflineToken(tokenURL, clientID, offlineToken string) (*tokenCache, error) {
+	cache, err := exchangeToken(tokenURL, clientID, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {offlineToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cache.RefreshToken == "" {
+		cache.RefreshToken = offlineToken
+	}
+
+	return cache, nil
+}
+
+func tokenAccount(host string) string {
+	return "token:" + host
+}
+
+func tokenCachePath(host string) string {
+	return filepath.Join(appDir(dirState, "tokens"), host+".json")
+}
+
+// saveTokenCache stores the token cache in the OS keyring, or in a private file
+// of the user cache directory when no keyring is available.
+func saveTokenCache(host string, cache *tokenCache) error {
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	err = keyringSet(tokenAccount(host), string(content))
+	if err == nil {
+		return nil
+	}
+	klog.Warningln("Unable to store the Hydra token in the OS keyring, caching it in a private file --", err)
+
+	cachePath := tokenCachePath(host)
+	err = os.MkdirAll(filepath.Dir(cachePath), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(cachePath, content, 0600)
+}
+
+func loadTokenCache(host string) (*tokenCache, error) {
+	content, err := keyringGet(tokenAccount(host))
+	if err != nil {
+		data, fileErr := ioutil.ReadFile(tokenCachePath(host))
+		if os.IsNotExist(fileErr) {
+			return nil, errNoToken
+		}
+		if fileErr != nil {
+			return nil, fileErr
+		}
+		content = string(data)
+	}
+
+	cache := &tokenCache{}
+	err = json.Unmarshal([]byte(content), cache)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse cached Hydra token -- %w", err)
+	}
+
+	return cache, nil
+}
+
+// deleteTokenCache removes the token cache, reporting whether there was one.
+func deleteTokenCache(host string) bool {
+	deleted := keyringDelete(tokenAccount(host)) == nil
+	if os.Remove(tokenCachePath(host)) == nil {
+		deleted = true
+	}
+
+	return deleted
+}
+
+// hydraAccessToken returns a valid access token of the cached login for the Hydra host,
+// refreshing it when it is about to expire.
+func hydraAccessToken(host string) (string, error) {
+	cache, err := loadTokenCache(host)
+	if err != nil {
+		return "", err
+	}
+
+	if time.Now().Add(tokenRefreshMargin).Before(cache.ExpiresAt) {
+		return cache.AccessToken, nil
+	}
+
+	// Concurrent runs refresh the token one at a time, since refresh tokens may be single-use.
+	lock, err := lockFile(tokenCachePath(host)+".lock", true)
+	if err != nil {
+		return "", err
+	}
+	defer lock.unlock()
+
+	cache, err = loadTokenCache(host)
+	if err != nil {
+		return "", err
+	}
+	if time.Now().Add(tokenRefreshMargin).Before(cache.ExpiresAt) {
+		return cache.AccessToken, nil
+	}
+
+	if cache.RefreshToken == "" || (!cache.RefreshExpiresAt.IsZero() && time.Now().After(cache.RefreshExpiresAt)) {
+		return "", fmt.Errorf("The Hydra login has expired, run `%s login` again", commandName())
+	}
+
+	refreshed, err := exchangeToken(cache.TokenURL, cache.ClientID, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {cache.RefreshToken},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Unable to refresh the Hydra login, run `%s login` again -- %w", commandName(), err)
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = cache.RefreshToken
+		refreshed.RefreshExpiresAt = cache.RefreshExpiresAt
+	}
+
+	err = saveTokenCache(host, refreshed)
+	if err != nil {
+		klog.Warningln("Unable to cache the refreshed Hydra token --", err)
+	}
+
+	return refreshed.AccessToken, nil
+}
+
+// setHydraAuth authenticates a request to Hydra with HSU_HYDRA_USER and HSU_HYDRA_PASS when set,
+// then with the token of a cached login, and otherwise with the stored username and password.
+func setHydraAuth(req *http.Request, hydraURL string) {
+	if getenv(envHydraUser) == "" && getenv(envHydraPass) == "" {
+		if u, err := url.Parse(hydraURL); err == nil {
+			token, err := hydraAccessToken(u.Hostname())
+			if err == nil {
+				req.Header.Set("Authorization", "Bearer "+token)
+				return
+			}
+			if !errors.Is(err, errNoToken) {
+				klog.Warningln(err)
+			}
+		}
+	}
+
+	req.SetBasicAuth(hydraBasicCredentials(hydraURL))
+}
+
+// tokenURLDefault returns the token endpoint from HSU_HYDRA_TOKEN_URL or the Red Hat SSO one.
+func tokenURLDefault() string {
+	if u := strings.TrimSpace(getenv(envHydraTokenURL)); u != "" {
+		return u
+	}
+
+	return defaultTokenURL
+}
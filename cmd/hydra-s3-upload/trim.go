@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// Trimming policies applied when the archive would exceed --max-archive-size.
+const (
+	trimTailLogs  = "tail-logs"
+	trimDropAudit = "drop-audit"
+)
+
+var trimPolicies = []string{trimTailLogs, trimDropAudit}
+
+// auditLogPatterns match the API server audit logs, usually the bulk of a gather.
+var auditLogPatterns = []string{"audit_logs", "audit.log*", "audit-*.log*"}
+
+// excludedName reports whether a relative archive path matches one of the patterns. A pattern
+// matches a path, any of its leading directories, or any single element of it, so that
+// "namespaces/openshift-etcd" and "audit_logs" exclude everything below those directories.
+func excludedName(patterns []string, name string) bool {
+	elements := strings.Split(name, "/")
+	for _, pattern := range patterns {
+		for i, element := range elements {
+			if matched, _ := path.Match(pattern, element); matched {
+				return true
+			}
+			if matched, _ := path.Match(pattern, strings.Join(elements[:i+1], "/")); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// tailFilter keeps only the end of log files, marking where they were cut.
+type tailFilter struct {
+	size int64
+}
+
+func isLogFile(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.Contains(path.Base(name), ".log.")
+}
+
+func (f *tailFilter) filter(name string, r io.Reader, w io.Writer) error {
+	if !isLogFile(name) {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	// Keep a window of up to twice the tail size, shifting it when it fills up.
+	buf := make([]byte, 0, 2*f.size)
+	truncated := false
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if int64(len(buf)) > 2*f.size {
+			buf = append(buf[:0], buf[int64(len(buf))-f.size:]...)
+			truncated = true
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if int64(len(buf)) > f.size {
+		buf = buf[int64(len(buf))-f.size:]
+		truncated = true
+	}
+	if truncated {
+		fmt.Fprintf(w, "[... truncated by hydra-s3-upload to the last %s ...]\n", formatByteSize(f.size))
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func printSizeBreakdown(groups []*sizeGroup, limit int) {
+	fmt.Fprintln(os.Stderr, "Largest contributors (estimated compressed size):")
+	for i, g := range groups {
+		if i == limit {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "  %10s  %s (%d files)\n", formatByteSize(g.compressed), g.name, g.files)
+	}
+}
+
+// withSizeBudget returns options fitting the archive of srcDir under the maximum archive size:
+// the configured trimming policies apply first, then the user is asked on a terminal to exclude
+// the largest contributors. It fails with a breakdown of them otherwise.
+func (o *options) withSizeBudget(srcDir string) (*options, error) {
+	trimmed := *o
+	trimmed.excludes = append([]string(nil), o.excludes...)
+	trimmed.filters = append([]fileFilter(nil), o.filters...)
+
+	compressed := o.codec.Extension() != ""
+	estimate := func() ([]sizeEntry, int64, error) {
+		entries, err := scanSizes(srcDir, func(name string) bool { return excludedName(trimmed.excludes, name) }, compressed)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Log files are stored trimmed to their tail.
+		if containsString(trimmed.trimPolicies, trimTailLogs) {
+			for i := range entries {
+				if isLogFile(entries[i].name) && entries[i].size > o.logTail {
+					entries[i].compressed = entries[i].compressed * o.logTail / entries[i].size
+				}
+			}
+		}
+
+		return entries, totalCompressed(entries), nil
+	}
+
+	entries, total, err := estimate()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to estimate archive size -- %w", err)
+	}
+	if total <= o.maxArchiveSize {
+		return o, nil
+	}
+	klog.Warningf("Estimated archive size %s exceeds the %s maximum", formatByteSize(total), formatByteSize(o.maxArchiveSize))
+
+	for _, policy := range o.trimPolicies {
+		switch policy {
+		case trimTailLogs:
+			trimmed.filters = append(trimmed.filters, &tailFilter{size: o.logTail})
+		case trimDropAudit:
+			trimmed.excludes = append(trimmed.excludes, auditLogPatterns...)
+		}
+		klog.Infoln("Applying trimming policy", policy)
+	}
+
+	entries, total, err = estimate()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to estimate archive size -- %w", err)
+	}
+
+	if total > o.maxArchiveSize && stdinIsTerminal() {
+		groups := groupSizes(entries, 3)
+		printSizeBreakdown(groups, 10)
+
+		in := bufio.NewReader(os.Stdin)
+		for _, g := range groups {
+			if total <= o.maxArchiveSize {
+				break
+			}
+
+			fmt.Fprintf(os.Stderr, "Exclude %s (~%s)? [y/N] ", g.name, formatByteSize(g.compressed))
+			answer, _ := in.ReadString('\n')
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+				trimmed.excludes = append(trimmed.excludes, g.name)
+				total -= g.compressed
+			}
+		}
+	}
+
+	if total > o.maxArchiveSize {
+		printSizeBreakdown(groupSizes(entries, 3), 10)
+		return nil, fmt.Errorf("Estimated archive size %s still exceeds the %s maximum", formatByteSize(total), formatByteSize(o.maxArchiveSize))
+	}
+
+	klog.Infof("Estimated archive size after trimming: %s", formatByteSize(total))
+	return &trimmed, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"k8s.io/klog"
+)
+
+// Verification modes of --verify.
+const (
+	verifyNone   = "none"
+	verifySample = "sample"
+	verifyFull   = "full"
+)
+
+var verifyModes = []string{verifyNone, verifySample, verifyFull}
+
+// Sampled verification compares this many ranges of this size.
+const (
+	verifySamples    = 8
+	verifySampleSize = 1024 * 1024
+)
+
+// verifyObject reads the uploaded object back and compares it with the local data it was uploaded from.
+// Credentials that are not permitted to read the object skip the verification with a warning.
+func (c *credsResponse) verifyObject(local *io.SectionReader, opts *options) error {
+	s, err := c.createSession(opts.assumeRole)
+	if err != nil {
+		return err
+	}
+	client := s3.New(s)
+
+	klog.Infof("Verifying uploaded object %q (%s)...", c.Key, opts.verify)
+	if opts.verify == verifyFull {
+		err = verifyFullObject(client, c.BucketName, c.Key, local, opts.hash)
+	} else {
+		err = verifySampledRanges(client, c.BucketName, c.Key, local)
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "AccessDenied":
+			klog.Warningln("Reading the uploaded object back is not permitted, skipping verification")
+			return nil
+		case "InvalidObjectState":
+			klog.Warningln("The uploaded object is archived and cannot be read back, skipping verification")
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	klog.Infoln("Uploaded object verified")
+
+	return nil
+}
+
+func verifyFullObject(client *s3.S3, bucket, key string, local *io.SectionReader, algorithm string) error {
+	obj, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	remoteHash, err := newChecksummer(algorithm)
+	if err != nil {
+		return err
+	}
+	defer remoteHash.checksum()
+	localHash, err := newChecksummer(algorithm)
+	if err != nil {
+		return err
+	}
+	defer localHash.checksum()
+
+	_, err = io.Copy(remoteHash, obj.Body)
+	if err != nil {
+		return fmt.Errorf("Unable to download uploaded object -- %w", err)
+	}
+	_, err = io.Copy(localHash, io.NewSectionReader(local, 0, local.Size()))
+	if err != nil {
+		return fmt.Errorf("Unable to read local archive -- %w", err)
+	}
+
+	remoteSum, err := remoteHash.checksum()
+	if err != nil {
+		return err
+	}
+	localSum, err := localHash.checksum()
+	if err != nil {
+		return err
+	}
+
+	if remoteSum != localSum {
+		return fmt.Errorf("Uploaded object %q does not match the local archive", key)
+	}
+
+	return nil
+}
+
+func verifySampledRanges(client *s3.S3, bucket, key string, local *io.SectionReader) error {
+	size := local.Size()
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for i := 0; i < verifySamples && size > 0; i++ {
+		length := int64(verifySampleSize)
+		if length > size {
+			length = size
+		}
+		// The first and last ranges always cover the ends of the object.
+		offset := int64(0)
+		switch {
+		case i == 1:
+			offset = size - length
+		case i > 1:
+			offset = random.Int63n(size - length + 1)
+		}
+
+		obj, err := client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+		})
+		if err != nil {
+			return err
+		}
+		remote, err := ioutil.ReadAll(obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Unable to download uploaded object range -- %w", err)
+		}
+
+		expected := make([]byte, length)
+		_, err = local.ReadAt(expected, offset)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("Unable to read local archive -- %w", err)
+		}
+
+		if !bytes.Equal(remote, expected) {
+			return fmt.Errorf("Uploaded object %q does not match the local archive at bytes %d-%d", key, offset, offset+length-1)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/klog"
+
+	"s3upload_test/pkg/archive"
+)
+
+// archiveChecksums computes the checksums of an archive file once per algorithm.
+type archiveChecksums struct {
+	path string
+	sums map[string]string
+}
+
+func (c *archiveChecksums) of(algorithm string) (string, error) {
+	if sum, ok := c.sums[algorithm]; ok {
+		return sum, nil
+	}
+
+	sum, err := fileChecksum(c.path, algorithm)
+	if err != nil {
+		return "", err
+	}
+	c.sums[algorithm] = sum
+
+	return sum, nil
+}
+
+// verifySidecar compares the archive with the checksum sidecar file, in the format of sha256sum and its siblings.
+func verifySidecar(sums *archiveChecksums, sidecarPath string) error {
+	algorithm := ""
+	for _, candidate := range hashAlgorithms {
+		if strings.HasSuffix(sidecarPath, sidecarExtension(candidate)) {
+			algorithm = candidate
+		}
+	}
+	if algorithm == "" {
+		return fmt.Errorf("Unable to tell the hash algorithm of %s from its extension", sidecarPath)
+	}
+
+	content, err := ioutil.ReadFile(sidecarPath)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return fmt.Errorf("The checksum file %s is empty", sidecarPath)
+	}
+
+	sum, err := sums.of(algorithm)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(fields[0], sum) {
+		return fmt.Errorf("The archive %s checksum %s does not match %s from %s", hashName(algorithm), sum, fields[0], sidecarPath)
+	}
+
+	return nil
+}
+
+// verifyUploadRecord finds the upload of the archive in the audit log, by its archive checksum, and returns
+// the hash of its upload-started record. A non-empty head, from the audit-head metadata of the uploaded object,
+// must be that record, so that the object is tied to the upload of this very archive.
+func verifyUploadRecord(sums *archiveChecksums, auditPath, head string) (string, error) {
+	last, err := verifyAuditLog(auditPath)
+	if err != nil {
+		return "", err
+	}
+	if last == nil {
+		return "", fmt.Errorf("The audit log %s is empty or missing", auditPath)
+	}
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	found := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record := &auditRecord{}
+		err = json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return "", err
+		}
+		if record.Action != auditUploadStarted || (head != "" && record.Hash != head) {
+			continue
+		}
+
+		for _, algorithm := range hashAlgorithms {
+			recorded := record.Details[algorithm]
+			if recorded == "" {
+				continue
+			}
+			sum, err := sums.of(algorithm)
+			if err != nil {
+				return "", err
+			}
+			if recorded == sum {
+				found = record.Hash
+			}
+		}
+		if head != "" && found == "" {
+			return "", fmt.Errorf("The audit head %s records the upload of another archive", head)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	switch {
+	case found != "":
+		return found, nil
+	case head != "":
+		return "", fmt.Errorf("The audit log %s holds no upload record %s", auditPath, head)
+	default:
+		return "", fmt.Errorf("The audit log %s records no upload of this archive", auditPath)
+	}
+}
+
+// openDecompressed returns the tar stream of an archive file, decompressed by the codec of its extension.
+func openDecompressed(archivePath string, r io.Reader) (io.ReadCloser, error) {
+	if strings.HasSuffix(archivePath, ".enc") {
+		return nil, fmt.Errorf("Encrypted archives must be decrypted with %s decrypt first", commandName())
+	}
+
+	for _, name := range archive.CodecNames() {
+		codec, _ := archive.LookupCodec(name)
+		if codec.Extension() == "" || !strings.HasSuffix(archivePath, codec.Extension()) {
+			continue
+		}
+
+		switch c := codec.(type) {
+		case archive.GzipCodec:
+			return gzip.NewReader(r)
+		case *archive.CommandCodec:
+			cmd := exec.Command(c.Program, "-d", "-c", "-q")
+			cmd.Stdin = r
+			out, err := cmd.StdoutPipe()
+			if err != nil {
+				return nil, err
+			}
+			err = cmd.Start()
+			if err != nil {
+				return nil, fmt.Errorf("Unable to start %s -- %w", c.Program, err)
+			}
+			return &commandReader{ReadCloser: out, cmd: cmd}, nil
+		}
+	}
+
+	return ioutil.NopCloser(r), nil
+}
+
+// commandReader reads the output of a decompressor, which is waited for on close.
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *commandReader) Close() error {
+	// Drain the output, so that the decompressor does not block on writing it.
+	io.Copy(ioutil.Discard, r.ReadCloser)
+	return r.cmd.Wait()
+}
+
+// verifyArchiveFiles compares the files in the archive with the manifest embedded in it by audited uploads,
+// and returns the number of verified files.
+func verifyArchiveFiles(archivePath string) (int, error) {
+	if base := filepath.Base(archivePath); strings.Contains(base, ".cpio") || strings.Contains(base, ".zip") {
+		return 0, errors.New("Only tar archives can be verified against a manifest")
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r, err := openDecompressed(archivePath, f)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	// The manifest is the last entry, so the checksums are compared once the whole archive is read.
+	var manifest *filesManifest
+	archived := map[string]string{}
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Unable to read the archive -- %w", err)
+		}
+		if header.Typeflag != tar.TypeReg || header.Name == manifestName || header.Name == previewManifestName {
+			continue
+		}
+
+		if header.Name == filesManifestName {
+			manifest = &filesManifest{}
+			err = json.NewDecoder(tarReader).Decode(manifest)
+			if err != nil {
+				return 0, fmt.Errorf("Unable to read the manifest of the archive -- %w", err)
+			}
+			continue
+		}
+
+		hash := sha256.New()
+		_, err = io.Copy(hash, tarReader)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to read %s from the archive -- %w", header.Name, err)
+		}
+		archived[header.Name] = hex.EncodeToString(hash.Sum(nil))
+	}
+	if manifest == nil {
+		return 0, fmt.Errorf("The archive holds no %s, only archives uploaded with --audit-log embed it", filesManifestName)
+	}
+
+	var problems []string
+	for name, sum := range archived {
+		expected, ok := manifest.Files[name]
+		switch {
+		case !ok:
+			problems = append(problems, name+": not in the manifest")
+		case expected != sum:
+			problems = append(problems, name+": checksum mismatch")
+		}
+	}
+	for name := range manifest.Files {
+		if _, ok := archived[name]; !ok {
+			problems = append(problems, name+": missing from the archive")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return 0, fmt.Errorf("The archive does not match the manifest:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return len(archived), nil
+}
+
+// runVerifyArchive validates an archive against its checksum sidecar and, with --manifest,
+// every archived file against the manifest embedded in the archive. With --audit-log, the archive
+// must also be the one whose upload the audit log records, at the --audit-head of the uploaded object.
+func runVerifyArchive(args []string) {
+	flags := flag.NewFlagSet(commandName()+" verify-archive", flag.ExitOnError)
+	checksumPath := flags.String("checksum", "", "Checksum sidecar file of the archive (defaults to the archive path with the .sha256, .sha512 or .b3 extension)")
+	manifest := flags.Bool("manifest", false, "Compare the archived files with the checksums of the manifest embedded in the archives uploaded with --audit-log")
+	auditPath := flags.String("audit-log", "", "Audit log of the upload, which must record the upload of this archive")
+	auditHead := flags.String("audit-head", "", "The audit-head metadata of the uploaded object, which must be the upload record of this archive in --audit-log")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		klog.Fatalln("Expected the archive file as the only argument")
+	}
+	if *auditHead != "" && *auditPath == "" {
+		klog.Fatalln("--audit-head needs the --audit-log recording the upload")
+	}
+	archivePath := flags.Arg(0)
+	sums := &archiveChecksums{path: archivePath, sums: map[string]string{}}
+
+	if *checksumPath == "" {
+		for _, algorithm := range hashAlgorithms {
+			if _, err := os.Stat(archivePath + sidecarExtension(algorithm)); err == nil {
+				*checksumPath = archivePath + sidecarExtension(algorithm)
+				break
+			}
+		}
+	}
+	if *checksumPath == "" && !*manifest && *auditPath == "" {
+		klog.Fatalln("No checksum sidecar found next to the archive, set --checksum, --manifest or --audit-log")
+	}
+
+	if *checksumPath != "" {
+		err := verifySidecar(sums, *checksumPath)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		fmt.Println("Archive checksum verified against", *checksumPath)
+	}
+
+	if *auditPath != "" {
+		head, err := verifyUploadRecord(sums, *auditPath, *auditHead)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		fmt.Printf("Archive upload verified against %s: record %s\n", *auditPath, head)
+	}
+
+	if *manifest {
+		count, err := verifyArchiveFiles(archivePath)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		fmt.Printf("Archive files verified against the embedded manifest: %d files\n", count)
+	}
+}
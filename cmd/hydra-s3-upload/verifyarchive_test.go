@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"s3upload_test/pkg/archive"
+)
+
+func TestVerifyArchiveAgainstEmbeddedManifest(t *testing.T) {
+	srcDir := writeStreamTestDir(t)
+	defer os.RemoveAll(srcDir)
+	dir, err := ioutil.TempDir("", "verify-archive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auditPath := filepath.Join(dir, "audit.log")
+	audit, err := openAuditLog(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.lock.unlock()
+	defer audit.f.Close()
+
+	opts := &options{audit: audit}
+	opts.format, _ = archive.LookupFormat("tar")
+	opts.codec, _ = archive.LookupCodec("gzip")
+	archivePath := filepath.Join(dir, "gather.tar.gz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dirToTar(srcDir, f, opts)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := verifyArchiveFiles(archivePath)
+	if err != nil || count != 1 {
+		t.Errorf("Expected the archived file verified, got %d -- %v", count, err)
+	}
+
+	// The audit head stored with the object must be the upload record of this archive.
+	sums := &archiveChecksums{path: archivePath, sums: map[string]string{}}
+	checksum, err := sums.of("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = audit.record(auditUploadStarted, map[string]string{"name": "gather.tar.gz", "sha256": checksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := audit.headHash()
+	err = audit.record(auditUploadStarted, map[string]string{"name": "other.tar.gz", "sha256": "0123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := verifyUploadRecord(sums, auditPath, head); err != nil || found != head {
+		t.Errorf("Expected the upload record %s, got %s -- %v", head, found, err)
+	}
+	if _, err := verifyUploadRecord(sums, auditPath, audit.headHash()); err == nil {
+		t.Error("Expected an error for the audit head of another archive")
+	}
+	if _, err := verifyUploadRecord(sums, auditPath, "unknown"); err == nil {
+		t.Error("Expected an error for an audit head missing from the log")
+	}
+}
+
+func TestVerifyArchiveFilesReportsMismatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify-archive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := map[string]string{
+		"node.log":        "kubelet started\n",
+		"extra.log":       "not in the manifest\n",
+		filesManifestName: `{"files": {"node.log": "0123", "gone.log": "4567"}}`,
+	}
+	archivePath := filepath.Join(dir, "gather.tar")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(f)
+	for _, name := range []string{"node.log", "extra.log", filesManifestName} {
+		err = w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(entries[name])), Mode: 0644})
+		if err == nil {
+			_, err = w.Write([]byte(entries[name]))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	f.Close()
+
+	_, err = verifyArchiveFiles(archivePath)
+	if err == nil {
+		t.Fatal("Expected the mismatches to be reported")
+	}
+	for _, problem := range []string{"node.log: checksum mismatch", "extra.log: not in the manifest", "gone.log: missing from the archive"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %v", problem, err)
+		}
+	}
+}
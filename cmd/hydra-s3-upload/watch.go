@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// latestModTime returns the most recent modification time within a directory tree.
+func latestModTime(dirPath string) (time.Time, error) {
+	var latest time.Time
+	err := filepath.Walk(dirPath, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return latest, err
+}
+
+// settledDirs returns the subdirectories of parent that have not been modified for the settle period.
+func settledDirs(parent string, settle time.Duration) ([]string, error) {
+	entries, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == claimsDirName {
+			continue
+		}
+
+		dir := filepath.Join(parent, entry.Name())
+		latest, err := latestModTime(dir)
+		if err != nil {
+			klog.Warningln("Unable to inspect", dir, "--", err)
+			continue
+		}
+
+		if time.Since(latest) >= settle {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs, nil
+}
+
+func runWatch(args []string) {
+	flags := flag.NewFlagSet(commandName()+" watch", flag.ExitOnError)
+	uploadFlags := addUploadFlags(flags)
+	budget := addBudgetFlags(flags)
+	parent := flags.String("dir", ".", "Parent directory watched for new gather directories")
+	interval := flags.Duration("interval", time.Minute, "Delay between scans of the watched directory")
+	settle := flags.Duration("settle", 2*time.Minute, "Time without modifications after which a gather directory is considered complete")
+	spoolDir := flags.String("spool-dir", appDir(dirState, "spool"), "Directory queueing the archives until they are uploaded")
+	spoolMax := byteSize(20 << 30)
+	flags.Var(&spoolMax, "spool-max-size", "Maximum total size of the queued archives, new directories wait while it is exceeded (0 = unlimited)")
+	retryInitial := flags.Duration("retry-initial", time.Minute, "Delay before the first retry of a failed upload")
+	retryMax := flags.Duration("retry-max", time.Hour, "Maximum delay between retries of a failed upload")
+	alertProviders := addAlertFlags(flags)
+	alertAfter := flags.Int("alert-after", 5, "Number of failed attempts to upload an archive after which an alert is raised")
+	includeExisting := flags.Bool("include-existing", false, "Also upload the directories present when watching starts")
+	shared := flags.Bool("shared", false, "Share the watched directory with other replicas, each gather being claimed and uploaded by one of them")
+	hostname, _ := os.Hostname()
+	replica := flags.String("replica-id", hostname, "Identity of this replica in the claims of --shared")
+	claimTTL := flags.Duration("claim-ttl", 10*time.Minute, "Time after which the gathers claimed by an unresponsive replica are taken over")
+	flags.Parse(args)
+
+	opts, err := uploadFlags.options()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	opts.budget = budget
+
+	queue, err := openSpool(*spoolDir, int64(spoolMax), *retryInitial, *retryMax)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	queue.alerters, err = newAlerters(*alertProviders)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	queue.alertAfter = *alertAfter
+
+	if *shared {
+		if *replica == "" {
+			klog.Fatalln("Missing required --replica-id flag")
+		}
+		queue.claims, err = newClaims(*parent, *replica, *claimTTL)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+
+		// Archives spooled before a restart are dropped when another replica took them over.
+		entries := queue.entries[:0]
+		for _, entry := range queue.entries {
+			if entry.source == "" || queue.claims.claim(entry.source) {
+				entries = append(entries, entry)
+				continue
+			}
+			klog.Warningln("Dropping spooled archive claimed by another replica", entry.path)
+			entry.remove()
+		}
+		queue.entries = entries
+	}
+
+	// Directories already handled, successfully or not.
+	seen := map[string]bool{}
+	if !*includeExisting {
+		entries, err := ioutil.ReadDir(*parent)
+		if err != nil {
+			klog.Fatalln("Unable to read watched directory --", err)
+		}
+		for _, entry := range entries {
+			dir := filepath.Join(*parent, entry.Name())
+			// Unfinished gathers of other replicas are taken over once their claims expire.
+			if queue.claims != nil && queue.claims.pending(dir) {
+				continue
+			}
+			seen[dir] = true
+		}
+	}
+
+	klog.Infoln("Watching", *parent, "for new gather directories")
+	for {
+		dirs, err := settledDirs(*parent, *settle)
+		if err != nil {
+			klog.Errorln("Unable to scan watched directory --", err)
+		}
+
+		for _, dir := range dirs {
+			if seen[dir] {
+				continue
+			}
+
+			// Leave the directory for a later scan until the queue drains.
+			if queue.full() {
+				klog.Warningln("Spool directory is full, deferring", dir)
+				break
+			}
+			if queue.claims != nil && !queue.claims.claim(dir) {
+				// Claimed by another replica, checked again on the next scan in case it expires.
+				continue
+			}
+			seen[dir] = true
+
+			klog.Infoln("Spooling new gather directory", dir)
+			err := queue.add(dir, opts)
+			if err != nil {
+				klog.Errorln("Unable to archive", dir, "--", err)
+				if queue.claims != nil {
+					queue.claims.release(dir)
+				}
+			}
+		}
+
+		opts.pruneState()
+		queue.flush(opts)
+		time.Sleep(*interval)
+	}
+}
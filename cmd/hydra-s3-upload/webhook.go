@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// gatherProfile is a pre-configured gather triggered through the webhook endpoint.
+type gatherProfile struct {
+	// Gather is a shell command collecting the data into $HSU_GATHER_DIR.
+	Gather string `json:"gather"`
+	// Name is the attachment name of the archives, defaulting to the generated one.
+	Name string `json:"name,omitempty"`
+	// Alerts restricts the Alertmanager notifications triggering the profile to these alert names.
+	Alerts []string `json:"alerts,omitempty"`
+	// Cooldown is the minimum delay between two triggered gathers, e.g. "1h".
+	Cooldown string `json:"cooldown,omitempty"`
+
+	cooldown time.Duration
+}
+
+// loadProfiles reads the gather profiles, a JSON object keyed by profile name,
+// defaulting to those of the central configuration.
+func loadProfiles(filePath string) (map[string]*gatherProfile, error) {
+	profiles := map[string]*gatherProfile{}
+	if filePath == "" && central != nil && central.Profiles != nil {
+		return central.Profiles, validateProfiles(central.Profiles)
+	}
+	if filePath == "" {
+		return profiles, nil
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read gather profiles -- %w", err)
+	}
+
+	err = json.Unmarshal(content, &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse gather profiles -- %w", err)
+	}
+
+	return profiles, validateProfiles(profiles)
+}
+
+// validateProfiles checks the gather profiles and parses their cooldowns.
+func validateProfiles(profiles map[string]*gatherProfile) error {
+	var err error
+	for name, profile := range profiles {
+		if profile.Gather == "" {
+			return fmt.Errorf("Gather profile %q has no gather command", name)
+		}
+		if profile.Cooldown != "" {
+			profile.cooldown, err = time.ParseDuration(profile.Cooldown)
+			if err != nil {
+				return fmt.Errorf("Invalid cooldown of gather profile %q -- %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// alertmanagerNotification is the part of an Alertmanager webhook notification deciding about the trigger.
+type alertmanagerNotification struct {
+	Status string `json:"status"`
+	Alerts []struct {
+		Status string            `json:"status"`
+		Labels map[string]string `json:"labels"`
+	} `json:"alerts"`
+}
+
+// triggers reports whether the webhook payload triggers the profile. Payloads other than
+// Alertmanager notifications always do, notifications do when a matching alert is firing.
+func (p *gatherProfile) triggers(payload []byte) bool {
+	n := &alertmanagerNotification{}
+	if json.Unmarshal(payload, n) != nil || n.Status == "" {
+		return true
+	}
+
+	for _, alert := range n.Alerts {
+		if alert.Status != "firing" {
+			continue
+		}
+		if len(p.Alerts) == 0 || containsString(p.Alerts, alert.Labels["alertname"]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gather runs the gather command of the job into its directory.
+func (j *uploadJob) gather() error {
+	os.RemoveAll(j.Dir)
+	err := os.MkdirAll(j.Dir, 0700)
+	if err != nil {
+		return fmt.Errorf("Unable to create gather directory -- %w", err)
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", j.Gather)
+	cmd.Env = append(os.Environ(), envGatherDir+"="+j.Dir)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Gather command failed -- %w: %s", err, strings.TrimSpace(lastLines(stderr.String(), 5)))
+	}
+
+	return nil
+}
+
+// lastTriggered returns the creation time of the newest job of the profile, with q.mu held.
+func (q *jobQueue) lastTriggered(profile string) time.Time {
+	var last time.Time
+	for _, job := range q.jobs {
+		if job.Profile == profile && job.CreatedAt.After(last) {
+			last = job.CreatedAt
+		}
+	}
+
+	return last
+}
+
+// webhookHandler triggers the gather profile named by the path, POST /webhooks/<profile>.
+func (q *jobQueue) webhookHandler(root string, profiles map[string]*gatherProfile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Unsupported request", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks"), "/")
+		profile, ok := profiles[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Unable to read the payload", http.StatusBadRequest)
+			return
+		}
+
+		if !profile.triggers(payload) {
+			writeJSON(w, http.StatusOK, map[string]string{"result": "ignored"})
+			return
+		}
+
+		job, last := q.addGather(root, name, profile)
+		if job == nil {
+			klog.Infof("Ignoring the trigger of gather profile %s, cooling down since %s", name, displayTime(last))
+			writeJSON(w, http.StatusOK, map[string]string{"result": "cooldown"})
+			return
+		}
+
+		klog.Infoln("Gather profile", name, "triggered")
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAddGatherCooldown(t *testing.T) {
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		triggers int
+		queued   int
+	}{
+		{name: "cooling down", cooldown: time.Hour, triggers: 20, queued: 1},
+		{name: "no cooldown", triggers: 20, queued: 20},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "webhook-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			q := &jobQueue{dir: dir, wake: make(chan struct{}, 1), jobs: map[string]*uploadJob{}}
+			profile := &gatherProfile{Gather: "true", cooldown: test.cooldown}
+
+			// Concurrent triggers must not all pass the cooldown.
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			queued := 0
+			for i := 0; i < test.triggers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					job, _ := q.addGather(dir, "alert", profile)
+					if job != nil {
+						mu.Lock()
+						queued++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if queued != test.queued || len(q.jobs) != test.queued {
+				t.Errorf("Expected %d queued gather(s), got %d and %d job(s)", test.queued, queued, len(q.jobs))
+			}
+		})
+	}
+}
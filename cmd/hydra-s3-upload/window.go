@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// uploadWindow is a daily time range of local time in which transfers may start, e.g. 22:00-06:00
+// for off-peak hours. A nil window is always open.
+type uploadWindow struct {
+	// start and end are minutes after midnight. A window whose end is before its start spans midnight.
+	start, end int
+}
+
+// parseUploadWindow parses a window in the HH:MM-HH:MM format.
+func parseUploadWindow(value string) (*uploadWindow, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	bounds := strings.Split(value, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Invalid upload window %q, expected HH:MM-HH:MM", value)
+	}
+
+	w := &uploadWindow{}
+	for i, bound := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid upload window %q, expected HH:MM-HH:MM -- %w", value, err)
+		}
+		minutes := t.Hour()*60 + t.Minute()
+		if i == 0 {
+			w.start = minutes
+		} else {
+			w.end = minutes
+		}
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("The upload window %q is empty", value)
+	}
+
+	return w, nil
+}
+
+func (w *uploadWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+// open reports whether the window includes the time t.
+func (w *uploadWindow) open(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minutes >= w.start && minutes < w.end
+	}
+
+	return minutes >= w.start || minutes < w.end
+}
+
+// nextOpening returns the next start of the window after t.
+func (w *uploadWindow) nextOpening(t time.Time) time.Time {
+	opening := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+	if !opening.After(t) {
+		opening = opening.AddDate(0, 0, 1)
+	}
+
+	return opening
+}
+
+// wait blocks until the window is open, returning errCanceled once cancel is closed.
+func (w *uploadWindow) wait(cancel <-chan struct{}) error {
+	if w == nil || w.open(time.Now()) {
+		return nil
+	}
+
+	opening := w.nextOpening(time.Now())
+	klog.Infof("Waiting for the upload window %s, opening at %s", w, displayTime(opening))
+	select {
+	case <-time.After(time.Until(opening)):
+		klog.Infoln("The upload window is open, starting the transfer")
+		return nil
+	case <-cancel:
+		return errCanceled
+	}
+}
+
+// waitToTransfer blocks until the uploads are not paused and the window is open, returning errCanceled
+// once cancel is closed. Uploads paused while waiting for the window are held again once it opens.
+func waitToTransfer(window *uploadWindow, cancel <-chan struct{}) error {
+	for {
+		err := uploadPause.wait(cancel)
+		if err == nil {
+			err = window.wait(cancel)
+		}
+		if err != nil || !uploadPause.paused() {
+			return err
+		}
+	}
+}
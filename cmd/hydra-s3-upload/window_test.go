@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseUploadWindow(t *testing.T) {
+	tests := []struct {
+		value  string
+		window *uploadWindow
+		err    bool
+	}{
+		{value: ""},
+		{value: "22:00-06:00", window: &uploadWindow{start: 22 * 60, end: 6 * 60}},
+		{value: " 09:30 - 17:15 ", window: &uploadWindow{start: 9*60 + 30, end: 17*60 + 15}},
+		{value: "22:00", err: true},
+		{value: "22:00-06:00-08:00", err: true},
+		{value: "25:00-06:00", err: true},
+		{value: "10:00-10:00", err: true},
+	}
+
+	for _, test := range tests {
+		w, err := parseUploadWindow(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("%q: expected an error: %v, got %v", test.value, test.err, err)
+			continue
+		}
+		if (w == nil) != (test.window == nil) || w != nil && *w != *test.window {
+			t.Errorf("%q: expected %v, got %v", test.value, test.window, w)
+		}
+	}
+}
+
+func TestUploadWindowOpen(t *testing.T) {
+	day := &uploadWindow{start: 9 * 60, end: 17 * 60}
+	night := &uploadWindow{start: 22 * 60, end: 6 * 60}
+	at := func(hour, minute int) time.Time { return time.Date(2020, 12, 31, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		window      *uploadWindow
+		t           time.Time
+		open        bool
+		nextOpening time.Time
+	}{
+		{window: day, t: at(8, 59), nextOpening: at(9, 0)},
+		{window: day, t: at(9, 0), open: true, nextOpening: at(9, 0).AddDate(0, 0, 1)},
+		{window: day, t: at(16, 59), open: true, nextOpening: at(9, 0).AddDate(0, 0, 1)},
+		{window: day, t: at(17, 0), nextOpening: at(9, 0).AddDate(0, 0, 1)},
+		{window: night, t: at(23, 30), open: true, nextOpening: at(22, 0).AddDate(0, 0, 1)},
+		{window: night, t: at(5, 59), open: true, nextOpening: at(22, 0)},
+		{window: night, t: at(6, 0), nextOpening: at(22, 0)},
+		{window: night, t: at(21, 59), nextOpening: at(22, 0)},
+	}
+
+	for _, test := range tests {
+		if open := test.window.open(test.t); open != test.open {
+			t.Errorf("%s at %s: expected open: %v, got %v", test.window, test.t.Format("15:04"), test.open, open)
+		}
+		if next := test.window.nextOpening(test.t); !next.Equal(test.nextOpening) {
+			t.Errorf("%s at %s: expected the next opening %s, got %s", test.window, test.t.Format("15:04"), test.nextOpening, next)
+		}
+	}
+}
+
+// closedWindow returns a window opening an hour from now.
+func closedWindow() *uploadWindow {
+	now := time.Now()
+	start := (now.Hour()*60 + now.Minute() + 60) % (24 * 60)
+	return &uploadWindow{start: start, end: (start + 60) % (24 * 60)}
+}
+
+func TestUploadPartsCanceledInClosedWindow(t *testing.T) {
+	cancel := make(chan struct{})
+	state := &uploadState{Size: 2048, PartSize: 1024, Parts: map[int64]string{}, PartHashes: map[int64]string{}}
+	done := make(chan error, 1)
+	go func() {
+		// No part is started outside of the window, so no client is needed.
+		done <- state.uploadParts(nil, bytes.NewReader(make([]byte, 2048)), "", 1, closedWindow(), cancel)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the upload to wait for the window, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(cancel)
+	err := <-done
+	if !errors.Is(err, errCanceled) || len(state.Parts) != 0 {
+		t.Errorf("Expected the upload canceled without parts, got %v with %d parts", err, len(state.Parts))
+	}
+}
+
+func TestWaitToTransferHoldsPausedUploads(t *testing.T) {
+	oldPause := uploadPause
+	defer func() { uploadPause = oldPause }()
+	uploadPause = &pauseGate{}
+	uploadPause.pause()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitToTransfer(nil, nil)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the paused upload to wait, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	uploadPause.resume()
+	if err := <-done; err != nil {
+		t.Errorf("Expected the resumed upload to start, got %v", err)
+	}
+}
@@ -5,8 +5,10 @@ go 1.13
 require (
 	github.com/aws/aws-sdk-go v1.25.44
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2 // indirect
 	golang.org/x/net v0.0.0-20190522155817-f3200d17e092 // indirect
 	k8s.io/klog v1.0.0
+	lukechampine.com/blake3 v1.1.7
 )
@@ -0,0 +1,236 @@
+// Package s3test serves a fake of the S3 API for the tests of the uploads.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Server serves the path-style multipart upload API of S3. Every access key is scoped to a single object key,
+// as the Hydra credentials are, and cannot read it to copy it unless allowed by ScopeReadWrite.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	scopes  map[string]string
+	readers map[string]bool
+	uploads map[string]map[int64][]byte
+	objects map[string][]byte
+	// metadata holds the user metadata of the uploads and the objects.
+	metadata map[string]map[string]string
+	// FailPart rejects the uploads of this part number and the following ones, zero to accept all.
+	FailPart int64
+	// ExpiredAccessKey is rejected as expired.
+	ExpiredAccessKey string
+	// UploadedParts lists the numbers of the accepted part uploads.
+	UploadedParts []int64
+	nextID        int
+}
+
+// NewServer starts a fake S3 without objects, which is stopped by Close.
+func NewServer() *Server {
+	f := &Server{scopes: map[string]string{}, readers: map[string]bool{}, uploads: map[string]map[int64][]byte{}, objects: map[string][]byte{}, metadata: map[string]map[string]string{}}
+	f.Server = httptest.NewServer(f)
+	return f
+}
+
+// Scope allows the access key to access the object key only.
+func (f *Server) Scope(accessKey, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scopes[accessKey] = key
+}
+
+// ScopeReadWrite allows the access key to access the object key only, also copying it.
+func (f *Server) ScopeReadWrite(accessKey, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scopes[accessKey] = key
+	f.readers[accessKey] = true
+}
+
+// Session returns a session of the given credentials against the fake S3 at endpoint.
+func Session(endpoint string, c *credentials.Credentials) *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      c,
+		MaxRetries:       aws.Int(0),
+	}))
+}
+
+// Object returns the data of an object, nil when it does not exist.
+func (f *Server) Object(bucket, key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[bucket+"/"+key]
+}
+
+// ObjectMetadata returns the user metadata of an object, with lowercase names.
+func (f *Server) ObjectMetadata(bucket, key string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.metadata[bucket+"/"+key]
+}
+
+// OpenUploads returns the number of the multipart uploads neither completed nor aborted.
+func (f *Server) OpenUploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+// userMetadata returns the user metadata sent in the request headers.
+func userMetadata(r *http.Request) map[string]string {
+	metadata := map[string]string{}
+	for name := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			metadata[strings.ToLower(name[len("x-amz-meta-"):])] = r.Header.Get(name)
+		}
+	}
+	return metadata
+}
+
+// copySource returns the object data the request copies, and whether it copies an object.
+func (f *Server) copySource(r *http.Request) ([]byte, bool) {
+	source := r.Header.Get("X-Amz-Copy-Source")
+	if source == "" {
+		return nil, false
+	}
+	source, _ = url.PathUnescape(source)
+	data := f.objects[source]
+	var start, end int
+	if n, _ := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); n == 2 {
+		data = data[start : end+1]
+	}
+	return data, true
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		s3Error(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	bucket, key := parts[0], parts[1]
+
+	auth := r.Header.Get("Authorization")
+	accessKey := ""
+	if i := strings.Index(auth, "Credential="); i >= 0 {
+		accessKey = strings.SplitN(auth[i+len("Credential="):], "/", 2)[0]
+	}
+	if accessKey != "" && accessKey == f.ExpiredAccessKey {
+		s3Error(w, http.StatusBadRequest, "ExpiredToken")
+		return
+	}
+	if f.scopes[accessKey] != key {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" && !f.readers[accessKey] {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		f.nextID++
+		id := "upload-" + strconv.Itoa(f.nextID)
+		f.uploads[id] = map[int64][]byte{}
+		f.metadata[id] = userMetadata(r)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+	case r.Method == http.MethodPut && uploadID == "":
+		data, copied := f.copySource(r)
+		if !copied {
+			data, _ = ioutil.ReadAll(r.Body)
+		}
+		f.objects[bucket+"/"+key] = data
+		if !copied || r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			f.metadata[bucket+"/"+key] = userMetadata(r)
+		}
+		sum := md5.Sum(data)
+		if copied {
+			fmt.Fprintf(w, `<CopyObjectResult><ETag>"%s"</ETag></CopyObjectResult>`, hex.EncodeToString(sum[:]))
+		} else {
+			w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		}
+	case f.uploads[uploadID] == nil:
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+	case r.Method == http.MethodPut:
+		number, _ := strconv.ParseInt(query.Get("partNumber"), 10, 64)
+		if f.FailPart > 0 && number >= f.FailPart {
+			s3Error(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		data, copied := f.copySource(r)
+		if !copied {
+			data, _ = ioutil.ReadAll(r.Body)
+		}
+		sum := md5.Sum(data)
+		if digest := r.Header.Get("Content-MD5"); digest != "" && digest != base64.StdEncoding.EncodeToString(sum[:]) {
+			s3Error(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		f.uploads[uploadID][number] = data
+		f.UploadedParts = append(f.UploadedParts, number)
+		if copied {
+			fmt.Fprintf(w, `<CopyPartResult><ETag>"%s"</ETag></CopyPartResult>`, hex.EncodeToString(sum[:]))
+		} else {
+			w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		}
+	case r.Method == http.MethodGet:
+		var numbers []int64
+		for number := range f.uploads[uploadID] {
+			numbers = append(numbers, number)
+		}
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+		var b strings.Builder
+		b.WriteString("<ListPartsResult><IsTruncated>false</IsTruncated>")
+		for _, number := range numbers {
+			sum := md5.Sum(f.uploads[uploadID][number])
+			fmt.Fprintf(&b, `<Part><PartNumber>%d</PartNumber><ETag>"%s"</ETag><Size>%d</Size></Part>`, number, hex.EncodeToString(sum[:]), len(f.uploads[uploadID][number]))
+		}
+		b.WriteString("</ListPartsResult>")
+		fmt.Fprint(w, b.String())
+	case r.Method == http.MethodPost:
+		var object bytes.Buffer
+		for number := int64(1); f.uploads[uploadID][number] != nil; number++ {
+			object.Write(f.uploads[uploadID][number])
+		}
+		f.objects[bucket+"/"+key] = object.Bytes()
+		f.metadata[bucket+"/"+key] = f.metadata[uploadID]
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>\"done\"</ETag></CompleteMultipartUploadResult>", bucket, key)
+	case r.Method == http.MethodDelete:
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Codec compresses the entries of an archive.
+type Codec interface {
+	// Extension is appended to the archive format extension, e.g. ".gz".
+	Extension() string
+	// NewWriter wraps w so that everything written is compressed into it.
+	// Closing the returned writer flushes the compressed stream but does not close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// codecs holds the registered compression codecs by name.
+var codecs = map[string]Codec{}
+
+// RegisterCodec makes a codec selectable by name, replacing any codec registered under the same name.
+func RegisterCodec(name string, codec Codec) {
+	codecs[name] = codec
+}
+
+// LookupCodec returns the codec registered under name.
+func LookupCodec(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported compression %q, expected one of: %s", name, strings.Join(CodecNames(), ", "))
+	}
+
+	return codec, nil
+}
+
+// CodecNames returns the names of the registered codecs in order.
+func CodecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	RegisterCodec("gzip", GzipCodec{})
+	RegisterCodec("none", NoneCodec{})
+	RegisterCodec("zstd", &CommandCodec{Program: "zstd", Args: []string{"-q", "-c", "-T0"}, Ext: ".zst"})
+	RegisterCodec("xz", &CommandCodec{Program: "xz", Args: []string{"-q", "-c", "-T0"}, Ext: ".xz"})
+}
+
+// GzipCodec compresses with compress/gzip.
+type GzipCodec struct{}
+
+func (GzipCodec) Extension() string {
+	return ".gz"
+}
+
+func (GzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// NoneCodec stores the tar stream uncompressed.
+type NoneCodec struct{}
+
+func (NoneCodec) Extension() string {
+	return ""
+}
+
+func (NoneCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// CommandCodec pipes the stream through an external compressor reading stdin and writing stdout.
+type CommandCodec struct {
+	Program string
+	Args    []string
+	Ext     string
+}
+
+func (c *CommandCodec) Extension() string {
+	return c.Ext
+}
+
+// SingleThreaded returns a copy of the codec that does not spread the compression over all CPUs.
+func (c *CommandCodec) SingleThreaded() *CommandCodec {
+	single := &CommandCodec{Program: c.Program, Ext: c.Ext}
+	for _, arg := range c.Args {
+		if arg == "-T0" {
+			arg = "-T1"
+		}
+		single.Args = append(single.Args, arg)
+	}
+
+	return single
+}
+
+func (c *CommandCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	cmd := exec.Command(c.Program, c.Args...)
+	cmd.Stdout = w
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start %s -- %w", c.Program, err)
+	}
+
+	return &commandWriter{cmd: cmd, stdin: stdin}, nil
+}
+
+type commandWriter struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func (w *commandWriter) Write(p []byte) (int, error) {
+	return w.stdin.Write(p)
+}
+
+// Close ends the input and waits for the compressor to write out the rest of the stream.
+func (w *commandWriter) Close() error {
+	err := w.stdin.Close()
+	if waitErr := w.cmd.Wait(); err == nil {
+		err = waitErr
+	}
+
+	return err
+}
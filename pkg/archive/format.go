@@ -0,0 +1,346 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Format is the container format holding the archived files, such as tar, cpio or zip.
+type Format interface {
+	// Extension starts the archive file name suffix, e.g. ".tar".
+	Extension() string
+	// NewWriter returns a writer of the entries into w. Closing it ends the archive but does not close w.
+	NewWriter(w io.Writer) EntryWriter
+	// HardLinks reports whether entries can link to the contents of earlier ones.
+	HardLinks() bool
+}
+
+// EntryWriter writes the entries of an archive, each header followed by the contents. tar.Writer implements it.
+type EntryWriter interface {
+	io.Writer
+	WriteHeader(header *tar.Header) error
+	// Flush finishes the current entry, which must have been written in full.
+	Flush() error
+	Close() error
+}
+
+// formats holds the registered archive formats by name.
+var formats = map[string]Format{}
+
+// RegisterFormat makes a format selectable by name, replacing any format registered under the same name.
+func RegisterFormat(name string, format Format) {
+	formats[name] = format
+}
+
+// LookupFormat returns the format registered under name.
+func LookupFormat(name string) (Format, error) {
+	format, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported archive format %q, expected one of: %s", name, strings.Join(FormatNames(), ", "))
+	}
+
+	return format, nil
+}
+
+// FormatNames returns the names of the registered formats in order.
+func FormatNames() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	RegisterFormat("tar", TarFormat{})
+	RegisterFormat("cpio", CpioFormat{})
+	RegisterFormat("zip", ZipFormat{})
+}
+
+// TarFormat writes tar archives in the formats of archive/tar.
+type TarFormat struct{}
+
+func (TarFormat) Extension() string {
+	return ".tar"
+}
+
+func (TarFormat) NewWriter(w io.Writer) EntryWriter {
+	return tar.NewWriter(w)
+}
+
+func (TarFormat) HardLinks() bool {
+	return true
+}
+
+// CpioFormat writes the portable ASCII (newc) cpio format, expected by initramfs and kdump analysis tooling.
+type CpioFormat struct{}
+
+func (CpioFormat) Extension() string {
+	return ".cpio"
+}
+
+func (CpioFormat) NewWriter(w io.Writer) EntryWriter {
+	return &cpioWriter{w: w}
+}
+
+func (CpioFormat) HardLinks() bool {
+	return false
+}
+
+// Limits and markers of the newc cpio format.
+const (
+	cpioMagic   = "070701"
+	cpioTrailer = "TRAILER!!!"
+	cpioMaxSize = 1<<32 - 1
+)
+
+// File type bits of the cpio entry modes.
+var cpioTypes = map[byte]int64{
+	tar.TypeReg:     0100000,
+	tar.TypeDir:     0040000,
+	tar.TypeSymlink: 0120000,
+	tar.TypeChar:    0020000,
+	tar.TypeBlock:   0060000,
+	tar.TypeFifo:    0010000,
+}
+
+// ErrCpioHardLink is returned for the hard links written into cpio archives.
+var ErrCpioHardLink = errors.New("Hard links cannot be stored in cpio archives")
+
+// cpioWriter writes newc cpio entries. Every entry gets its own inode number, so hard links are not supported.
+type cpioWriter struct {
+	w   io.Writer
+	ino int64
+	// remaining is the size of the current entry still to be written, followed by pad bytes of padding.
+	remaining int64
+	pad       int64
+}
+
+// WriteHeader finishes the current entry and starts a new one. Symbolic links are complete with their header.
+func (c *cpioWriter) WriteHeader(header *tar.Header) error {
+	err := c.Flush()
+	if err != nil {
+		return err
+	}
+
+	typeflag := header.Typeflag
+	if typeflag == tar.TypeRegA {
+		typeflag = tar.TypeReg
+	}
+	if typeflag == tar.TypeLink {
+		return fmt.Errorf("%w: %s", ErrCpioHardLink, header.Name)
+	}
+	fileType, ok := cpioTypes[typeflag]
+	if !ok {
+		return fmt.Errorf("Unsupported cpio entry type %q of %s", typeflag, header.Name)
+	}
+
+	size := header.Size
+	if typeflag == tar.TypeSymlink {
+		size = int64(len(header.Linkname))
+	} else if typeflag != tar.TypeReg {
+		size = 0
+	}
+	if size > cpioMaxSize {
+		return fmt.Errorf("%s is too large for a cpio archive, the limit is 4 GiB", header.Name)
+	}
+
+	nlink := int64(1)
+	if typeflag == tar.TypeDir {
+		nlink = 2
+	}
+
+	c.ino++
+	err = c.writeHeader(strings.TrimSuffix(header.Name, "/"), fileType|header.Mode&07777, nlink, header, size)
+	if err != nil {
+		return err
+	}
+
+	c.remaining, c.pad = size, cpioPadding(size)
+	if typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(c, header.Linkname)
+	}
+
+	return err
+}
+
+func (c *cpioWriter) writeHeader(name string, mode, nlink int64, header *tar.Header, size int64) error {
+	var mtime int64
+	if !header.ModTime.IsZero() {
+		mtime = header.ModTime.Unix()
+	}
+
+	fields := []int64{c.ino, mode, int64(header.Uid), int64(header.Gid), nlink, mtime, size,
+		0, 0, header.Devmajor, header.Devminor, int64(len(name) + 1), 0}
+	var b strings.Builder
+	b.WriteString(cpioMagic)
+	for _, field := range fields {
+		fmt.Fprintf(&b, "%08x", uint32(field))
+	}
+	b.WriteString(name)
+	b.WriteByte(0)
+	// The header and the name are padded to a multiple of four bytes.
+	b.WriteString(strings.Repeat("\x00", int(cpioPadding(int64(b.Len())))))
+
+	_, err := io.WriteString(c.w, b.String())
+	return err
+}
+
+func cpioPadding(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+func (c *cpioWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > c.remaining {
+		n, err := c.w.Write(p[:c.remaining])
+		c.remaining -= int64(n)
+		if err == nil {
+			err = tar.ErrWriteTooLong
+		}
+		return n, err
+	}
+
+	n, err := c.w.Write(p)
+	c.remaining -= int64(n)
+	return n, err
+}
+
+func (c *cpioWriter) Flush() error {
+	if c.remaining > 0 {
+		return fmt.Errorf("Missed writing %d bytes of the cpio entry", c.remaining)
+	}
+
+	if c.pad == 0 {
+		return nil
+	}
+
+	_, err := c.w.Write(make([]byte, c.pad))
+	c.pad = 0
+	return err
+}
+
+// Close finishes the current entry and writes the trailer ending the archive.
+func (c *cpioWriter) Close() error {
+	err := c.Flush()
+	if err != nil {
+		return err
+	}
+
+	c.ino = 0
+	return c.writeHeader(cpioTrailer, 0, 1, &tar.Header{}, 0)
+}
+
+// ZipFormat writes zip archives with deflated entries, for the recipients on Windows. The entries are compressed
+// on their own, so the archive is usually not compressed as a whole.
+type ZipFormat struct{}
+
+func (ZipFormat) Extension() string {
+	return ".zip"
+}
+
+func (ZipFormat) NewWriter(w io.Writer) EntryWriter {
+	return &zipWriter{w: zip.NewWriter(w), entry: ioutil.Discard}
+}
+
+func (ZipFormat) HardLinks() bool {
+	return false
+}
+
+// ErrZipHardLink is returned for the hard links written into zip archives.
+var ErrZipHardLink = errors.New("Hard links cannot be stored in zip archives")
+
+// zipWriter writes zip entries. Symbolic links are stored as entries holding the link target, as Info-ZIP does.
+type zipWriter struct {
+	w *zip.Writer
+	// entry is the writer of the current entry, discarding the contents between the entries.
+	entry io.Writer
+	// remaining is the size of the current entry still to be written.
+	remaining int64
+}
+
+// WriteHeader finishes the current entry and starts a new one. Symbolic links are complete with their header.
+func (z *zipWriter) WriteHeader(header *tar.Header) error {
+	err := z.Flush()
+	if err != nil {
+		return err
+	}
+
+	typeflag := header.Typeflag
+	if typeflag == tar.TypeRegA {
+		typeflag = tar.TypeReg
+	}
+	perm := os.FileMode(header.Mode) & os.ModePerm
+	fileHeader := &zip.FileHeader{Name: header.Name, Method: zip.Deflate, Modified: header.ModTime}
+	size := int64(0)
+	switch typeflag {
+	case tar.TypeReg:
+		fileHeader.SetMode(perm)
+		size = header.Size
+	case tar.TypeDir:
+		fileHeader.Name = strings.TrimSuffix(header.Name, "/") + "/"
+		fileHeader.Method = zip.Store
+		fileHeader.SetMode(os.ModeDir | perm)
+	case tar.TypeSymlink:
+		fileHeader.Method = zip.Store
+		fileHeader.SetMode(os.ModeSymlink | 0777)
+	case tar.TypeLink:
+		return fmt.Errorf("%w: %s", ErrZipHardLink, header.Name)
+	default:
+		return fmt.Errorf("Unsupported zip entry type %q of %s", typeflag, header.Name)
+	}
+
+	entry, err := z.w.CreateHeader(fileHeader)
+	if err != nil {
+		return err
+	}
+	if typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(entry, header.Linkname)
+		return err
+	}
+	z.entry, z.remaining = entry, size
+
+	return nil
+}
+
+func (z *zipWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > z.remaining {
+		n, err := z.entry.Write(p[:z.remaining])
+		z.remaining -= int64(n)
+		if err == nil {
+			err = tar.ErrWriteTooLong
+		}
+		return n, err
+	}
+
+	n, err := z.entry.Write(p)
+	z.remaining -= int64(n)
+	return n, err
+}
+
+func (z *zipWriter) Flush() error {
+	if z.remaining > 0 {
+		return fmt.Errorf("Missed writing %d bytes of the zip entry", z.remaining)
+	}
+	z.entry = ioutil.Discard
+
+	return nil
+}
+
+// Close finishes the current entry and writes the central directory ending the archive.
+func (z *zipWriter) Close() error {
+	err := z.Flush()
+	if err != nil {
+		return err
+	}
+
+	return z.w.Close()
+}
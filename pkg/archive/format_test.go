@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testEntry is an archive entry written and read back by the format tests.
+type testEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	content  string
+}
+
+var testEntries = []testEntry{
+	{name: "gather/", typeflag: tar.TypeDir, mode: 0755},
+	{name: "gather/node.log", typeflag: tar.TypeReg, mode: 0640, content: "kubelet started\n"},
+	{name: "gather/empty", typeflag: tar.TypeReg, mode: 0600},
+	{name: "gather/current.log", typeflag: tar.TypeSymlink, mode: 0777, content: "node.log"},
+}
+
+func writeTestEntries(t *testing.T, format Format, entries []testEntry) ([]byte, error) {
+	var b bytes.Buffer
+	w := format.NewWriter(&b)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: entry.mode, ModTime: time.Unix(1609459199, 0)}
+		switch entry.typeflag {
+		case tar.TypeReg:
+			header.Size = int64(len(entry.content))
+		case tar.TypeSymlink, tar.TypeLink:
+			header.Linkname = entry.content
+		}
+		err := w.WriteHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if entry.typeflag == tar.TypeReg {
+			_, err = io.WriteString(w, entry.content)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err := w.Close()
+
+	return b.Bytes(), err
+}
+
+func readTarEntries(t *testing.T, data []byte) []testEntry {
+	var entries []testEntry
+	r := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(r)
+		if header.Typeflag == tar.TypeSymlink {
+			content = []byte(header.Linkname)
+		}
+		entries = append(entries, testEntry{name: header.Name, typeflag: header.Typeflag, mode: header.Mode, content: string(content)})
+	}
+}
+
+// readCpioEntries parses a newc cpio archive.
+func readCpioEntries(t *testing.T, data []byte) []testEntry {
+	var entries []testEntry
+	for offset := 0; ; {
+		if len(data) < offset+110 || string(data[offset:offset+6]) != cpioMagic {
+			t.Fatalf("Expected a cpio header at %d", offset)
+		}
+		field := func(i int) int {
+			value, err := strconv.ParseUint(string(data[offset+6+8*i:offset+14+8*i]), 16, 32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return int(value)
+		}
+		mode, size, nameSize := field(1), field(6), field(11)
+		name := string(data[offset+110 : offset+110+nameSize-1])
+		offset += 110 + nameSize
+		offset += int(cpioPadding(int64(offset)))
+		if name == cpioTrailer {
+			if offset != len(data) {
+				t.Errorf("Expected the archive to end after the trailer at %d, got %d bytes", offset, len(data))
+			}
+			return entries
+		}
+		content := string(data[offset : offset+size])
+		offset += size
+		offset += int(cpioPadding(int64(offset)))
+
+		entry := testEntry{name: name, mode: int64(mode & 07777), content: content}
+		switch mode &^ 07777 {
+		case 0040000:
+			entry.typeflag, entry.name = tar.TypeDir, name+"/"
+		case 0100000:
+			entry.typeflag = tar.TypeReg
+		case 0120000:
+			entry.typeflag = tar.TypeSymlink
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func readZipEntries(t *testing.T, data []byte) []testEntry {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []testEntry
+	for _, file := range r.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry := testEntry{name: file.Name, mode: int64(file.Mode().Perm()), content: string(content)}
+		switch {
+		case file.Mode().IsDir():
+			entry.typeflag = tar.TypeDir
+		case file.Mode()&os.ModeSymlink != 0:
+			entry.typeflag = tar.TypeSymlink
+		default:
+			entry.typeflag = tar.TypeReg
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestArchiveFormats(t *testing.T) {
+	tests := []struct {
+		format    string
+		extension string
+		read      func(*testing.T, []byte) []testEntry
+		hardLinks bool
+	}{
+		{format: "tar", extension: ".tar", read: readTarEntries, hardLinks: true},
+		{format: "cpio", extension: ".cpio", read: readCpioEntries},
+		{format: "zip", extension: ".zip", read: readZipEntries},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			format, err := LookupFormat(test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format.Extension() != test.extension || format.HardLinks() != test.hardLinks {
+				t.Errorf("Unexpected extension %s or hard links %v", format.Extension(), format.HardLinks())
+			}
+
+			data, err := writeTestEntries(t, format, testEntries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entries := test.read(t, data); !reflect.DeepEqual(entries, testEntries) {
+				t.Errorf("Expected the entries\n%v\ngot\n%v", testEntries, entries)
+			}
+
+			link := append(testEntries[:2:2], testEntry{name: "gather/copy.log", typeflag: tar.TypeLink, content: "gather/node.log"})
+			_, err = writeTestEntries(t, format, link)
+			if test.hardLinks && err != nil {
+				t.Errorf("Expected the hard link to be stored, got %v", err)
+			}
+			if !test.hardLinks && !errors.Is(err, ErrCpioHardLink) && !errors.Is(err, ErrZipHardLink) {
+				t.Errorf("Expected the hard link to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestArchiveFormatsRejectOverlongEntries(t *testing.T) {
+	for _, name := range FormatNames() {
+		t.Run(name, func(t *testing.T) {
+			format, _ := LookupFormat(name)
+			w := format.NewWriter(ioutil.Discard)
+			err := w.WriteHeader(&tar.Header{Name: "node.log", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := io.WriteString(w, "kubelet")
+			if n != 4 || err != tar.ErrWriteTooLong {
+				t.Errorf("Expected 4 bytes written and %v, got %d, %v", tar.ErrWriteTooLong, n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package archive
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// IndexBlockSize is the amount of tar stream compressed into every independently decompressible block
+// of indexed and seekable archives. Smaller blocks extract single files faster but compress worse.
+const IndexBlockSize = 4 * 1024 * 1024
+
+// CommandBlockSize is the block size of the external compressors, which start a process for every block,
+// so that a large archive does not start thousands of them.
+const CommandBlockSize = 32 * 1024 * 1024
+
+// BlockSize returns the block size of archives compressed by codec.
+func BlockSize(codec Codec) int64 {
+	switch codec.Extension() {
+	case ".gz", "":
+		return IndexBlockSize
+	}
+
+	return CommandBlockSize
+}
+
+// Index locates the entries of an archive compressed in independent blocks, so that a single file
+// can be extracted by decompressing from the block it starts in. The blocks are consecutive gzip members, zstd frames
+// or xz streams, so that the archive still decompresses as a whole with the standard tools.
+type Index struct {
+	Compression string       `json:"compression"`
+	Entries     []IndexEntry `json:"entries"`
+}
+
+// IndexEntry is an archive entry, whose tar header starts Offset bytes into the decompressed block
+// that starts Block bytes into the archive.
+type IndexEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Block  int64  `json:"block"`
+	Offset int64  `json:"offset"`
+}
+
+// BlockWriter compresses a stream in independent blocks of a fixed size each.
+type BlockWriter struct {
+	codec Codec
+	size  int64
+	raw   *offsetWriter
+	// current compresses the current block, which started at the start offset of the archive,
+	// and received written bytes of the stream.
+	current io.WriteCloser
+	start   int64
+	written int64
+	// frames are the sizes of the finished blocks, written into the seek table of seekable archives.
+	seekable bool
+	frames   []SeekFrame
+}
+
+// NewBlockWriter returns a writer compressing into w with codec in blocks of size bytes. A seekable
+// writer ends the stream with the seek table of the zstd seekable format on Close.
+func NewBlockWriter(w io.Writer, codec Codec, size int64, seekable bool) *BlockWriter {
+	return &BlockWriter{codec: codec, size: size, raw: &offsetWriter{w: w}, seekable: seekable}
+}
+
+// offsetWriter counts the bytes written through it.
+type offsetWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (b *BlockWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if b.current == nil {
+			var err error
+			b.current, err = b.codec.NewWriter(b.raw)
+			if err != nil {
+				return total, err
+			}
+			b.start, b.written = b.raw.n, 0
+		}
+
+		chunk := p
+		if room := b.size - b.written; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := b.current.Write(chunk)
+		b.written += int64(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+
+		if b.written == b.size {
+			err = b.endBlock()
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+func (b *BlockWriter) endBlock() error {
+	err := b.current.Close()
+	b.current = nil
+	b.frames = append(b.frames, SeekFrame{Compressed: uint32(b.raw.n - b.start), Decompressed: uint32(b.written)})
+
+	return err
+}
+
+// Position returns where the next byte of the stream goes: the archive offset of its block,
+// and the offset within the decompressed block.
+func (b *BlockWriter) Position() (block, offset int64) {
+	if b.current == nil {
+		return b.raw.n, 0
+	}
+
+	return b.start, b.written
+}
+
+// Frames returns the sizes of the finished blocks.
+func (b *BlockWriter) Frames() []SeekFrame {
+	return b.frames
+}
+
+// Close ends the last block, and writes the seek table of seekable archives.
+func (b *BlockWriter) Close() error {
+	if b.current != nil {
+		err := b.endBlock()
+		if err != nil {
+			return err
+		}
+	}
+	if !b.seekable {
+		return nil
+	}
+
+	_, err := b.raw.Write(SeekTable(b.frames))
+	return err
+}
+
+// SeekFrame is an entry of the seek table of the zstd seekable format.
+type SeekFrame struct {
+	Compressed   uint32
+	Decompressed uint32
+}
+
+// Magic numbers of the zstd seekable format.
+const (
+	zstdSkippableMagic = 0x184D2A5E
+	zstdSeekableMagic  = 0x8F92EAB1
+)
+
+// SeekTable returns the skippable frame ending a seekable zstd archive, which lists the sizes of its frames
+// so that readers can map decompressed offsets to the frames holding them. Other zstd readers skip it.
+func SeekTable(frames []SeekFrame) []byte {
+	size := len(frames)*8 + 9
+	table := make([]byte, 8+size)
+	binary.LittleEndian.PutUint32(table, zstdSkippableMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(size))
+
+	entries := table[8:]
+	for i, frame := range frames {
+		binary.LittleEndian.PutUint32(entries[i*8:], frame.Compressed)
+		binary.LittleEndian.PutUint32(entries[i*8+4:], frame.Decompressed)
+	}
+
+	// The footer: the number of frames, a descriptor without checksums, and the seekable magic number.
+	footer := entries[len(frames)*8:]
+	binary.LittleEndian.PutUint32(footer, uint32(len(frames)))
+	footer[4] = 0
+	binary.LittleEndian.PutUint32(footer[5:], zstdSeekableMagic)
+
+	return table
+}
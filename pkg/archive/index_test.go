@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestBlockWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		writes   []int
+		seekable bool
+		frames   []uint32
+	}{
+		{name: "single block", writes: []int{7}, frames: []uint32{7}},
+		{name: "write spanning blocks", writes: []int{25}, frames: []uint32{10, 10, 5}},
+		{name: "writes filling a block", writes: []int{4, 6, 10}, frames: []uint32{10, 10}},
+		{name: "seekable", writes: []int{3, 12}, seekable: true, frames: []uint32{10, 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			b := NewBlockWriter(&out, GzipCodec{}, 10, test.seekable)
+			var data []byte
+			for i, n := range test.writes {
+				chunk := bytes.Repeat([]byte{byte('a' + i)}, n)
+				data = append(data, chunk...)
+				if _, err := b.Write(chunk); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var frames []uint32
+			var compressed int64
+			for _, frame := range b.Frames() {
+				frames = append(frames, frame.Decompressed)
+				compressed += int64(frame.Compressed)
+			}
+			if !reflect.DeepEqual(frames, test.frames) {
+				t.Errorf("Expected blocks of %v bytes, got %v", test.frames, frames)
+			}
+
+			archive := out.Bytes()
+			if test.seekable {
+				table := SeekTable(b.Frames())
+				if !bytes.HasSuffix(archive, table) {
+					t.Fatal("Expected the archive to end with the seek table")
+				}
+				archive = archive[:len(archive)-len(table)]
+			}
+			if int64(len(archive)) != compressed {
+				t.Errorf("Expected %d bytes of blocks, got %d", compressed, len(archive))
+			}
+			r, err := gzip.NewReader(bytes.NewReader(archive))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := ioutil.ReadAll(r)
+			if err != nil || !bytes.Equal(decompressed, data) {
+				t.Errorf("Expected the blocks to decompress to %q, got %q, %v", data, decompressed, err)
+			}
+		})
+	}
+}
+
+func TestSeekTable(t *testing.T) {
+	table := SeekTable([]SeekFrame{{Compressed: 100, Decompressed: 400}, {Compressed: 50, Decompressed: 70}})
+
+	expected := []uint32{zstdSkippableMagic, 25, 100, 400, 50, 70}
+	for i, value := range expected {
+		if got := binary.LittleEndian.Uint32(table[i*4:]); got != value {
+			t.Errorf("Expected %#x at %d, got %#x", value, i*4, got)
+		}
+	}
+	footer := table[len(table)-9:]
+	if binary.LittleEndian.Uint32(footer) != 2 || footer[4] != 0 || binary.LittleEndian.Uint32(footer[5:]) != zstdSeekableMagic {
+		t.Errorf("Unexpected footer %x", footer)
+	}
+}
+
+func TestBlockSize(t *testing.T) {
+	tests := []struct {
+		compression string
+		size        int64
+	}{
+		{compression: "gzip", size: IndexBlockSize},
+		{compression: "none", size: IndexBlockSize},
+		{compression: "zstd", size: CommandBlockSize},
+		{compression: "xz", size: CommandBlockSize},
+	}
+
+	for _, test := range tests {
+		codec, err := LookupCodec(test.compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := BlockSize(codec); size != test.size {
+			t.Errorf("Expected blocks of %d bytes for %s, got %d", test.size, test.compression, size)
+		}
+	}
+}
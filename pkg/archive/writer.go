@@ -0,0 +1,180 @@
+// Package archive writes the archives of Must-Gather directories: tar, cpio or zip entries compressed as a whole
+// with gzip, zstd or xz, or in independently decompressible blocks located by an Index.
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Writer writes the entries of an archive into a compressed stream. It is an EntryWriter, whose Close
+// also flushes the compressor.
+type Writer struct {
+	EntryWriter
+	compressor io.WriteCloser
+	// blocks compresses the indexed archives, whose entries are recorded into index when set.
+	blocks *BlockWriter
+	index  *Index
+}
+
+// NewWriter returns a writer of archive entries in format, compressed into w with codec.
+func NewWriter(w io.Writer, format Format, codec Codec) (*Writer, error) {
+	compressor, err := codec.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{EntryWriter: format.NewWriter(compressor), compressor: compressor}, nil
+}
+
+// NewIndexedWriter returns a writer of archive entries in format, compressed into w with codec in independent
+// blocks of BlockSize, recording the entries into index when set. A seekable archive ends with the seek table
+// of its blocks.
+func NewIndexedWriter(w io.Writer, format Format, codec Codec, index *Index, seekable bool) *Writer {
+	blocks := NewBlockWriter(w, codec, BlockSize(codec), seekable)
+	if index != nil {
+		index.Compression = strings.TrimPrefix(codec.Extension(), ".")
+		index.Entries = nil
+	}
+
+	return &Writer{EntryWriter: format.NewWriter(blocks), compressor: blocks, blocks: blocks, index: index}
+}
+
+// IndexEntry records where the entry about to be written starts, when the archive is indexed.
+func (w *Writer) IndexEntry(name string, size int64) error {
+	if w.index == nil {
+		return nil
+	}
+
+	// The padding of the previous entry comes first.
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	block, offset := w.blocks.Position()
+	w.index.Entries = append(w.index.Entries, IndexEntry{Name: name, Size: size, Block: block, Offset: offset})
+	return nil
+}
+
+// WriteEntry writes the header of an entry, recorded in the index, followed by the contents read from body.
+func (w *Writer) WriteEntry(header *tar.Header, body io.Reader) error {
+	err := w.IndexEntry(header.Name, header.Size)
+	if err != nil {
+		return err
+	}
+
+	err = w.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// DirHooks customize which files AddDir archives and how their entries are written. Every hook is optional.
+type DirHooks struct {
+	// Exclude leaves out the file at the slash-separated path relative to the directory, and the contents
+	// of an excluded directory.
+	Exclude func(relPath string) (bool, error)
+	// Include leaves out the files it returns false for. The directories left out are still descended into.
+	Include func(relPath string) bool
+	// Unsupported is called with the files other than regular files, directories and symbolic links,
+	// which are skipped.
+	Unsupported func(fullPath string)
+	// WriteEntry writes the entries instead of Writer.WriteEntry, e.g. to filter or record their contents.
+	WriteEntry func(header *tar.Header, body io.Reader) error
+}
+
+// AddDir appends the regular files, directories and symbolic links found in dirPath, naming them relative
+// to dirPath under the given prefix. Other files, such as sockets and devices, are skipped.
+func (w *Writer) AddDir(dirPath, prefix string) error {
+	return w.AddDirWith(dirPath, prefix, DirHooks{})
+}
+
+// AddDirWith appends the files found in dirPath like AddDir, as customized by the hooks.
+func (w *Writer) AddDirWith(dirPath, prefix string, hooks DirHooks) error {
+	writeEntry := hooks.WriteEntry
+	if writeEntry == nil {
+		writeEntry = w.WriteEntry
+	}
+
+	return filepath.Walk(dirPath, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dirPath, fullPath)
+		if err != nil || relPath == "." {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if hooks.Exclude != nil {
+			excluded, err := hooks.Exclude(relPath)
+			switch {
+			case err != nil:
+				return err
+			case excluded && info.IsDir():
+				return filepath.SkipDir
+			case excluded:
+				return nil
+			}
+		}
+		if hooks.Include != nil && !hooks.Include(relPath) {
+			return nil
+		}
+
+		// Store symbolic links as links rather than following them.
+		var link string
+		switch mode := info.Mode(); {
+		case mode&os.ModeSymlink != 0:
+			link, err = os.Readlink(fullPath)
+			if err != nil {
+				return err
+			}
+		case !mode.IsRegular() && !mode.IsDir():
+			if hooks.Unsupported != nil {
+				hooks.Unsupported(fullPath)
+			}
+			return nil
+		}
+
+		// Keep the permissions, the owner and the group.
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return writeEntry(header, strings.NewReader(""))
+		}
+
+		file, err := os.Open(fullPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return writeEntry(header, file)
+	})
+}
+
+// Close finishes the archive and flushes the compressor, but does not close the underlying writer.
+func (w *Writer) Close() error {
+	err := w.EntryWriter.Close()
+	if compressErr := w.compressor.Close(); err == nil {
+		err = compressErr
+	}
+
+	return err
+}
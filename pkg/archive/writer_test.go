@@ -0,0 +1,211 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeTestDir creates a gather directory with files large enough to span several blocks.
+func writeTestDir(t *testing.T) (dir string, contents map[string][]byte) {
+	dir, err := ioutil.TempDir("", "archive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	random := rand.New(rand.NewSource(1))
+	contents = map[string][]byte{}
+	for _, name := range []string{"namespaces/app/pods.yaml", "nodes/node1/kubelet.log", "nodes/node1/dmesg", "version"} {
+		data := make([]byte, random.Intn(3*IndexBlockSize))
+		random.Read(data)
+		contents[name] = data
+
+		fullPath := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(fullPath, data, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink("kubelet.log", filepath.Join(dir, "nodes", "node1", "current.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, contents
+}
+
+func TestWriterAddDir(t *testing.T) {
+	dir, contents := writeTestDir(t)
+	defer os.RemoveAll(dir)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, TarFormat{}, GzipCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.AddDir(dir, "gather")
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil || !bytes.Equal(data, contents[header.Name[len("gather/"):]]) {
+				t.Errorf("The contents of %s do not match, %v", header.Name, err)
+			}
+		case tar.TypeSymlink:
+			if header.Linkname != "kubelet.log" {
+				t.Errorf("Expected %s to link to kubelet.log, got %s", header.Name, header.Linkname)
+			}
+		}
+	}
+
+	expected := []string{"gather/namespaces/", "gather/namespaces/app/", "gather/namespaces/app/pods.yaml", "gather/nodes/",
+		"gather/nodes/node1/", "gather/nodes/node1/current.log", "gather/nodes/node1/dmesg", "gather/nodes/node1/kubelet.log", "gather/version"}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the entries %v, got %v", expected, names)
+	}
+}
+
+func TestIndexedWriter(t *testing.T) {
+	dir, contents := writeTestDir(t)
+	defer os.RemoveAll(dir)
+
+	var b bytes.Buffer
+	index := &Index{}
+	w := NewIndexedWriter(&b, TarFormat{}, GzipCodec{}, index, false)
+	err := w.AddDir(dir, "")
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if index.Compression != "gz" || len(index.Entries) != 9 {
+		t.Fatalf("Unexpected index %s with %d entries", index.Compression, len(index.Entries))
+	}
+
+	// Every entry is extracted by decompressing from its block alone.
+	archive := b.Bytes()
+	for _, entry := range index.Entries {
+		r, err := gzip.NewReader(bytes.NewReader(archive[entry.Block:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.CopyN(ioutil.Discard, r, entry.Offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := tar.NewReader(r).Next()
+		if err != nil || header.Name != entry.Name || header.Size != entry.Size {
+			t.Errorf("Expected the entry %s of %d bytes, got %+v, %v", entry.Name, entry.Size, header, err)
+		}
+		if data, ok := contents[entry.Name]; ok && entry.Size != int64(len(data)) {
+			t.Errorf("Expected %s to be indexed with %d bytes, got %d", entry.Name, len(data), entry.Size)
+		}
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	tests := []struct {
+		name      string
+		extension string
+		err       bool
+	}{
+		{name: "gzip", extension: ".gz"},
+		{name: "none", extension: ""},
+		{name: "zstd", extension: ".zst"},
+		{name: "xz", extension: ".xz"},
+		{name: "lz4", err: true},
+	}
+
+	for _, test := range tests {
+		codec, err := LookupCodec(test.name)
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected an error: %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if err == nil && codec.Extension() != test.extension {
+			t.Errorf("%s: expected the extension %q, got %q", test.name, test.extension, codec.Extension())
+		}
+	}
+}
+
+func TestSingleThreaded(t *testing.T) {
+	codec := &CommandCodec{Program: "zstd", Args: []string{"-q", "-c", "-T0"}, Ext: ".zst"}
+	single := codec.SingleThreaded()
+	if !reflect.DeepEqual(single.Args, []string{"-q", "-c", "-T1"}) || single.Program != "zstd" || single.Ext != ".zst" {
+		t.Errorf("Unexpected single-threaded codec %+v", single)
+	}
+	if !reflect.DeepEqual(codec.Args, []string{"-q", "-c", "-T0"}) {
+		t.Errorf("Expected the codec to be left as it was, got %v", codec.Args)
+	}
+}
+
+func TestWriterAddDirWith(t *testing.T) {
+	dir, _ := writeTestDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWriter(ioutil.Discard, TarFormat{}, NoneCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err = w.AddDirWith(dir, "gather", DirHooks{
+		Exclude: func(relPath string) (bool, error) {
+			return relPath == "namespaces" || relPath == "nodes/node1/dmesg", nil
+		},
+		Include: func(relPath string) bool {
+			return relPath != "nodes" && relPath != "version"
+		},
+		WriteEntry: func(header *tar.Header, body io.Reader) error {
+			names = append(names, header.Name)
+			return w.WriteEntry(header, body)
+		},
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"gather/nodes/node1/", "gather/nodes/node1/current.log", "gather/nodes/node1/kubelet.log"}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the entries %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,137 @@
+// Package hydra requests the temporary S3 credentials of support case attachments from Hydra, the attachment
+// service of the Red Hat Customer Portal. Every credentials response is scoped to a single object key.
+package hydra
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// Request is the body of a credentials request for a new attachment.
+type Request struct {
+	FileName string `json:"fileName,omitempty"`
+	// CaseNumber attaches the upload to a support case.
+	CaseNumber  string `json:"caseNumber,omitempty"`
+	Description string `json:"description,omitempty"`
+	// IsPrivate hides the attachment from the customer side of the case, "true" or "false".
+	IsPrivate string `json:"isPrivate"`
+	// Key asks for credentials to the object key of a resumed upload rather than a new object.
+	Key string `json:"key,omitempty"`
+}
+
+// ReadRequest is the body of a request for credentials reading existing objects. Unlike the upload
+// credentials, they do not register a new attachment.
+type ReadRequest struct {
+	// Key is the key of the object read, or the key prefix of the objects listed.
+	Key string `json:"key"`
+}
+
+// Credentials are the temporary S3 credentials of the object Key in the bucket BucketName.
+type Credentials struct {
+	BucketName   string `json:"bucketName"`
+	SecretKey    string `json:"secretKey"`
+	AccessKey    string `json:"accessKey"`
+	SessionToken string `json:"sessionToken"`
+	Region       string `json:"region"`
+	Key          string `json:"key"`
+	// Expiration is the RFC 3339 expiration time of the credentials, if reported.
+	Expiration string `json:"expiration,omitempty"`
+}
+
+// AWSCredentials returns the credentials for the AWS SDK.
+func (c *Credentials) AWSCredentials() *credentials.Credentials {
+	return credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, c.SessionToken)
+}
+
+// DecodeCredentials decodes a credentials response, failing when it holds no credentials.
+func DecodeCredentials(body []byte) (*Credentials, error) {
+	c := &Credentials{}
+	err := json.Unmarshal(body, c)
+	if err != nil {
+		return nil, err
+	}
+	if c.AccessKey == "" || c.SecretKey == "" || c.BucketName == "" || c.Key == "" {
+		return nil, errors.New("The Hydra response holds no S3 credentials")
+	}
+
+	return c, nil
+}
+
+// StatusError is returned for the Hydra responses with a status other than 200 OK.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "Unexpected HTTP response status code: " + e.Status
+}
+
+// Client requests credentials from the Hydra endpoint at URL.
+type Client struct {
+	URL string
+	// Send posts the JSON request body to url. When nil, the body is posted with HTTPClient, http.DefaultClient
+	// when nil, authenticated with the User and Password when User is set.
+	Send       func(url string, body []byte) (*http.Response, error)
+	HTTPClient *http.Client
+	User       string
+	Password   string
+}
+
+func (c *Client) send(body []byte) (*http.Response, error) {
+	if c.Send != nil {
+		return c.Send(c.URL, body)
+	}
+
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// Exchange posts the JSON request body reqData and returns the body of the response, a *StatusError when
+// Hydra rejects the request.
+func (c *Client) Exchange(reqData []byte) ([]byte, error) {
+	resp, err := c.send(reqData)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// RequestCredentials posts req, a Request or a ReadRequest, and returns the credentials of the response.
+func (c *Client) RequestCredentials(req interface{}) (*Credentials, error) {
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.Exchange(reqData)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to request S3 credentials from Hydra -- %w", err)
+	}
+
+	return DecodeCredentials(body)
+}
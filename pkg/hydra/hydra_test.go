@@ -0,0 +1,121 @@
+package hydra
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRequestCredentials(t *testing.T) {
+	issued := &Credentials{BucketName: "bucket", AccessKey: "AK", SecretKey: "secret", Region: "us-east-1", Key: "attachments/gather.tar.gz"}
+
+	tests := []struct {
+		name     string
+		req      interface{}
+		status   int
+		response interface{}
+		// body is the expected request body.
+		body        string
+		credentials *Credentials
+		statusCode  int
+		err         bool
+	}{
+		{
+			name:        "attachment",
+			req:         &Request{FileName: "gather.tar.gz", CaseNumber: "01234567", IsPrivate: "false"},
+			status:      http.StatusOK,
+			response:    issued,
+			body:        `{"fileName":"gather.tar.gz","caseNumber":"01234567","isPrivate":"false"}`,
+			credentials: issued,
+		},
+		{
+			name:        "read",
+			req:         &ReadRequest{Key: "attachments/gather.tar.gz"},
+			status:      http.StatusOK,
+			response:    issued,
+			body:        `{"key":"attachments/gather.tar.gz"}`,
+			credentials: issued,
+		},
+		{
+			name:       "rejected",
+			req:        &Request{FileName: "huge.tar.gz", IsPrivate: "false"},
+			status:     http.StatusRequestEntityTooLarge,
+			body:       `{"fileName":"huge.tar.gz","isPrivate":"false"}`,
+			statusCode: http.StatusRequestEntityTooLarge,
+			err:        true,
+		},
+		{
+			name:     "without credentials",
+			req:      &Request{FileName: "gather.tar.gz", IsPrivate: "true"},
+			status:   http.StatusOK,
+			response: map[string]string{"url": "https://bucket.s3.amazonaws.com/presigned"},
+			body:     `{"fileName":"gather.tar.gz","isPrivate":"true"}`,
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user, password, ok := r.BasicAuth()
+				if !ok || user != "user" || password != "pass" || r.Header.Get("Content-Type") != "application/json" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != test.body {
+					t.Errorf("Expected the request %s, got %s", test.body, body)
+				}
+				w.WriteHeader(test.status)
+				json.NewEncoder(w).Encode(test.response)
+			}))
+			defer server.Close()
+
+			client := &Client{URL: server.URL, User: "user", Password: "pass"}
+			c, err := client.RequestCredentials(test.req)
+			if (err != nil) != test.err {
+				t.Fatalf("Expected an error: %v, got %v", test.err, err)
+			}
+			if !reflect.DeepEqual(c, test.credentials) {
+				t.Errorf("Expected %+v, got %+v", test.credentials, c)
+			}
+
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) != (test.statusCode != 0) || test.statusCode != 0 && statusErr.StatusCode != test.statusCode {
+				t.Errorf("Expected the status %d, got %v", test.statusCode, err)
+			}
+		})
+	}
+}
+
+func TestClientSend(t *testing.T) {
+	var sent []byte
+	client := &Client{
+		URL: "https://hydra.example.com/attachments",
+		Send: func(url string, body []byte) (*http.Response, error) {
+			if url != "https://hydra.example.com/attachments" {
+				t.Errorf("Unexpected URL %s", url)
+			}
+			sent = body
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"accessKey":"AK"}`)))}, nil
+		},
+	}
+
+	body, err := client.Exchange([]byte(`{"key":"k"}`))
+	if err != nil || string(body) != `{"accessKey":"AK"}` || string(sent) != `{"key":"k"}` {
+		t.Errorf("Unexpected exchange of %s for %s, %v", sent, body, err)
+	}
+}
+
+func TestAWSCredentials(t *testing.T) {
+	c := &Credentials{AccessKey: "AK", SecretKey: "secret", SessionToken: "token"}
+	value, err := c.AWSCredentials().Get()
+	if err != nil || value.AccessKeyID != "AK" || value.SecretAccessKey != "secret" || value.SessionToken != "token" {
+		t.Errorf("Unexpected AWS credentials %+v, %v", value, err)
+	}
+}
@@ -0,0 +1,156 @@
+// Package upload uploads archives to the S3 object that a set of temporary credentials is scoped to, streaming
+// them in parts of unknown total length, and updates the metadata of the uploaded objects.
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// CopyObjectMaxSize is the largest object S3 copies in a single request, larger ones are copied in parts
+// of CopyPartSize.
+const (
+	CopyObjectMaxSize = 5 << 30
+	CopyPartSize      = 1 << 30
+)
+
+// copyPartSize is the part size of the multipart copies, smaller in the tests.
+var copyPartSize int64 = CopyPartSize
+
+// Uploader uploads to the object Key of Bucket with the credentials and region of Session.
+type Uploader struct {
+	Session *session.Session
+	Bucket  string
+	Key     string
+	// PartSize and Concurrency tune the multipart uploads, the s3manager defaults apply when zero.
+	PartSize    int64
+	Concurrency int
+	// StorageClass is left to the bucket default when empty.
+	StorageClass string
+	Tags         map[string]string
+	// CopyObjectMaxSize overrides the largest object copied in a single request by ReplaceMetadata when set.
+	CopyObjectMaxSize int64
+}
+
+// Tagging returns the object tags in the URL query format of S3, nil without tags.
+func Tagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	tagging := url.Values{}
+	for k, v := range tags {
+		tagging.Set(k, v)
+	}
+
+	return aws.String(tagging.Encode())
+}
+
+func (u *Uploader) storageClass() *string {
+	if u.StorageClass == "" {
+		return nil
+	}
+
+	return aws.String(u.StorageClass)
+}
+
+// Upload uploads everything read from body with the user metadata, in parts once the body exceeds a single part.
+// The body does not need to have a known length.
+func (u *Uploader) Upload(ctx context.Context, body io.Reader, metadata map[string]string) (*s3manager.UploadOutput, error) {
+	uploader := s3manager.NewUploader(u.Session, func(m *s3manager.Uploader) {
+		if u.PartSize > 0 {
+			m.PartSize = u.PartSize
+		}
+		if u.Concurrency > 0 {
+			m.Concurrency = u.Concurrency
+		}
+	})
+
+	return uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:       aws.String(u.Bucket),
+		Key:          aws.String(u.Key),
+		Body:         body,
+		Metadata:     aws.StringMap(metadata),
+		StorageClass: u.storageClass(),
+		Tagging:      Tagging(u.Tags),
+	})
+}
+
+// ReplaceMetadata replaces the user metadata of the uploaded object of size bytes by copying the object onto
+// itself, which S3 does without the data being uploaded again. The tags and the storage class are kept.
+// Objects larger than a single copy are copied in parts.
+func (u *Uploader) ReplaceMetadata(size int64, metadata map[string]string) error {
+	client := s3.New(u.Session)
+	source := aws.String(url.PathEscape(u.Bucket + "/" + u.Key))
+
+	maxSize := u.CopyObjectMaxSize
+	if maxSize == 0 {
+		maxSize = CopyObjectMaxSize
+	}
+	if size <= maxSize {
+		_, err := client.CopyObject(&s3.CopyObjectInput{
+			Bucket:            aws.String(u.Bucket),
+			Key:               aws.String(u.Key),
+			CopySource:        source,
+			Metadata:          aws.StringMap(metadata),
+			MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+			StorageClass:      u.storageClass(),
+		})
+		return err
+	}
+
+	upload, err := client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(u.Bucket),
+		Key:          aws.String(u.Key),
+		Metadata:     aws.StringMap(metadata),
+		StorageClass: u.storageClass(),
+		Tagging:      Tagging(u.Tags),
+	})
+	if err != nil {
+		return err
+	}
+	abort := func() {
+		client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String(u.Bucket), Key: aws.String(u.Key), UploadId: upload.UploadId})
+	}
+
+	var parts []*s3.CompletedPart
+	for offset := int64(0); offset < size; offset += copyPartSize {
+		end := offset + copyPartSize
+		if end > size {
+			end = size
+		}
+		number := aws.Int64(int64(len(parts) + 1))
+		output, err := client.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          aws.String(u.Bucket),
+			Key:             aws.String(u.Key),
+			UploadId:        upload.UploadId,
+			PartNumber:      number,
+			CopySource:      source,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end-1)),
+		})
+		if err != nil {
+			abort()
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: output.CopyPartResult.ETag, PartNumber: number})
+	}
+
+	_, err = client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.Bucket),
+		Key:             aws.String(u.Key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort()
+	}
+
+	return err
+}
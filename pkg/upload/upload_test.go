@@ -0,0 +1,140 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+
+	"s3upload_test/internal/s3test"
+)
+
+func newTestUploader(s3 *s3test.Server, key string) *Uploader {
+	s3.ScopeReadWrite("AK", key)
+	return &Uploader{
+		Session: s3test.Session(s3.URL, credentials.NewStaticCredentials("AK", "secret", "")),
+		Bucket:  "bucket",
+		Key:     key,
+	}
+}
+
+func TestUpload(t *testing.T) {
+	data := make([]byte, 12*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	tests := []struct {
+		name     string
+		partSize int64
+		parts    int
+	}{
+		{name: "single part", partSize: 16 * 1024 * 1024, parts: 0},
+		{name: "multipart", partSize: 5 * 1024 * 1024, parts: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s3 := s3test.NewServer()
+			defer s3.Close()
+
+			u := newTestUploader(s3, "attachments/gather.tar.gz")
+			u.PartSize = test.partSize
+			u.Concurrency = 2
+			// Hide the length of the body, as the streamed archives do.
+			_, err := u.Upload(context.Background(), struct{ *bytes.Reader }{bytes.NewReader(data)}, map[string]string{"sha256": "abc"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(s3.Object("bucket", u.Key), data) {
+				t.Error("The uploaded object does not match")
+			}
+			if metadata := s3.ObjectMetadata("bucket", u.Key); metadata["sha256"] != "abc" {
+				t.Errorf("Expected the sha256 metadata, got %v", metadata)
+			}
+			if len(s3.UploadedParts) != test.parts {
+				t.Errorf("Expected %d parts, got %v", test.parts, s3.UploadedParts)
+			}
+		})
+	}
+}
+
+func TestUploadOutsideScope(t *testing.T) {
+	s3 := s3test.NewServer()
+	defer s3.Close()
+
+	u := newTestUploader(s3, "attachments/gather.tar.gz")
+	u.Key = "attachments/other.tar.gz"
+	_, err := u.Upload(context.Background(), bytes.NewReader([]byte("data")), nil)
+	if err == nil {
+		t.Error("Expected the upload outside the credentials scope to fail")
+	}
+}
+
+func TestReplaceMetadata(t *testing.T) {
+	data := make([]byte, 3*1024*1024+17)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	oldPartSize := copyPartSize
+	defer func() { copyPartSize = oldPartSize }()
+	copyPartSize = 1024 * 1024
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		parts   int
+	}{
+		{name: "single copy"},
+		{name: "multipart copy", maxSize: 1024 * 1024, parts: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s3 := s3test.NewServer()
+			defer s3.Close()
+
+			u := newTestUploader(s3, "attachments/gather.tar.gz")
+			u.CopyObjectMaxSize = test.maxSize
+			_, err := u.Upload(context.Background(), bytes.NewReader(data), map[string]string{"case": "01234567"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s3.UploadedParts = nil
+
+			err = u.ReplaceMetadata(int64(len(data)), map[string]string{"case": "01234567", "sha256": "abc"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := map[string]string{"case": "01234567", "sha256": "abc"}
+			if metadata := s3.ObjectMetadata("bucket", u.Key); !reflect.DeepEqual(metadata, expected) {
+				t.Errorf("Expected the metadata %v, got %v", expected, metadata)
+			}
+			if !bytes.Equal(s3.Object("bucket", u.Key), data) {
+				t.Error("The copied object does not match")
+			}
+			if len(s3.UploadedParts) != test.parts || s3.OpenUploads() != 0 {
+				t.Errorf("Expected %d copied parts and no open uploads, got %v and %d", test.parts, s3.UploadedParts, s3.OpenUploads())
+			}
+		})
+	}
+}
+
+func TestTagging(t *testing.T) {
+	tests := []struct {
+		tags     map[string]string
+		expected *string
+	}{
+		{tags: nil, expected: nil},
+		{tags: map[string]string{"cluster": "prod east", "team": "sre"}, expected: aws.String("cluster=prod+east&team=sre")},
+	}
+
+	for _, test := range tests {
+		if tagging := Tagging(test.tags); !reflect.DeepEqual(tagging, test.expected) {
+			t.Errorf("Expected the tagging %v for %v, got %v", aws.StringValue(test.expected), test.tags, aws.StringValue(tagging))
+		}
+	}
+}